@@ -0,0 +1,290 @@
+package adfs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Acorn ADFS with the old map, as on the S, M and L floppies and the hard
+// discs of the BBC micro and Master: a free space map and a tree of
+// directories from $, the root. Paths separate their names with dots and
+// may start with $.
+
+// boot options, *OPT 4
+const (
+	BootNone = iota
+	BootLoad
+	BootRun
+	BootExec
+)
+
+// sizes of the floppy formats, in sectors
+const (
+	SmallSectors  = 640
+	MediumSectors = 1280
+	LargeSectors  = 2560
+)
+
+type FileSystem struct {
+	Device  Device
+	Sectors int
+	Free    []Extent
+	Boot    int
+	DiscID  uint16
+}
+
+func Open(device Device) (*FileSystem, error) {
+	fs := &FileSystem{Device: device}
+	if err := fs.readMap(); err != nil {
+		return nil, err
+	}
+	if fs.Sectors > device.GetSectorCount() {
+		return nil, fmt.Errorf("map of %d sectors on a disc of %d", fs.Sectors, device.GetSectorCount())
+	}
+	if _, err := fs.readDirectory(RootSector); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Writes an empty map and root directory over the whole device.
+func Format(device Device) (*FileSystem, error) {
+	sectors := device.GetSectorCount()
+	first := RootSector + DirectorySectors
+	if sectors <= first {
+		return nil, fmt.Errorf("%d sectors are too few for ADFS", sectors)
+	}
+	fs := &FileSystem{Device: device, Sectors: sectors, Free: []Extent{{first, sectors - first}}}
+	root := &Directory{Name: "$", Title: "$", Parent: RootSector, start: RootSector}
+	if err := fs.writeDirectory(root); err != nil {
+		return nil, err
+	}
+	return fs, fs.writeMap()
+}
+
+func (fs *FileSystem) SetBoot(option int) error {
+	if option < BootNone || option > BootExec {
+		return fmt.Errorf("bad boot option %d", option)
+	}
+	fs.Boot = option
+	return fs.writeMap()
+}
+
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+func checkName(name string) error {
+	if name == "" || len(name) > MaxNameSize {
+		return fmt.Errorf("bad name %s", name)
+	}
+	for _, character := range name {
+		if character <= ' ' || character >= 0x7F || strings.ContainsRune(".:*#$&@^%\\\"", character) {
+			return fmt.Errorf("bad name %s", name)
+		}
+	}
+	return nil
+}
+
+// Directory at the path.
+func (fs *FileSystem) ReadDirectory(path string) (*Directory, error) {
+	directory, err := fs.readDirectory(RootSector)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range splitPath(path) {
+		index, ok := directory.Find(name)
+		if !ok {
+			return nil, fmt.Errorf("%s not found", path)
+		}
+		entry := directory.Entries[index]
+		if !entry.Directory {
+			return nil, fmt.Errorf("%s is not a directory", path)
+		}
+		if directory, err = fs.readDirectory(entry.Start); err != nil {
+			return nil, err
+		}
+	}
+	return directory, nil
+}
+
+// Directory holding the last name of the path, and that name.
+func (fs *FileSystem) parent(path string) (*Directory, string, error) {
+	names := splitPath(path)
+	if len(names) == 0 {
+		return nil, "", fmt.Errorf("bad name %s", path)
+	}
+	directory, err := fs.ReadDirectory(strings.Join(names[:len(names)-1], "."))
+	return directory, names[len(names)-1], err
+}
+
+func (fs *FileSystem) Lookup(path string) (Entry, error) {
+	directory, name, err := fs.parent(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	index, ok := directory.Find(name)
+	if !ok {
+		return Entry{}, fmt.Errorf("%s not found", path)
+	}
+	return directory.Entries[index], nil
+}
+
+func (fs *FileSystem) ReadFile(path string) (Entry, []byte, error) {
+	entry, err := fs.Lookup(path)
+	if err != nil {
+		return Entry{}, nil, err
+	}
+	if entry.Directory {
+		return Entry{}, nil, fmt.Errorf("%s is a directory", path)
+	}
+	data := make([]byte, 0, entry.GetSectors()*256)
+	for sector := 0; sector < entry.GetSectors(); sector++ {
+		content, err := fs.Device.ReadSector(entry.Start + sector)
+		if err != nil {
+			return Entry{}, nil, err
+		}
+		data = append(data, content...)
+	}
+	return entry, data[:entry.Length], nil
+}
+
+// Saves a file, replacing an unlocked one of the same name.
+func (fs *FileSystem) WriteFile(path string, load, exec uint32, data []byte) error {
+	directory, name, err := fs.parent(path)
+	if err != nil {
+		return err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	entry := Entry{Name: name, Readable: true, Writable: true}
+	// put back when the new file cannot be stored
+	free, entries := append([]Extent{}, fs.Free...), append([]Entry{}, directory.Entries...)
+	if index, ok := directory.Find(name); ok {
+		old := directory.Entries[index]
+		if old.Directory {
+			return fmt.Errorf("%s is a directory", path)
+		}
+		if old.Locked {
+			return fmt.Errorf("%s locked", path)
+		}
+		entry = old
+		if err := fs.release(old.Start, old.GetSectors()); err != nil {
+			return err
+		}
+		directory.Entries = append(directory.Entries[:index], directory.Entries[index+1:]...)
+	} else if len(directory.Entries) >= MaxEntries {
+		return fmt.Errorf("directory full")
+	}
+	entry.Load, entry.Exec, entry.Length = load, exec, len(data)
+	if entry.Start, err = fs.allocate(entry.GetSectors()); err != nil {
+		fs.Free, directory.Entries = free, entries
+		return err
+	}
+	for sector := 0; sector < entry.GetSectors(); sector++ {
+		if err := fs.Device.WriteSector(entry.Start+sector, data[sector*256:]); err != nil {
+			fs.Free, directory.Entries = free, entries
+			return err
+		}
+	}
+	return fs.addEntry(directory, entry)
+}
+
+func (fs *FileSystem) addEntry(directory *Directory, entry Entry) error {
+	entry.Sequence = nextSequence(directory.Sequence)
+	directory.Entries = append(directory.Entries, entry)
+	if err := fs.writeDirectory(directory); err != nil {
+		return err
+	}
+	return fs.writeMap()
+}
+
+func (fs *FileSystem) MakeDirectory(path string) error {
+	directory, name, err := fs.parent(path)
+	if err != nil {
+		return err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if _, ok := directory.Find(name); ok {
+		return fmt.Errorf("%s exists", path)
+	}
+	if len(directory.Entries) >= MaxEntries {
+		return fmt.Errorf("directory full")
+	}
+	start, err := fs.allocate(DirectorySectors)
+	if err != nil {
+		return err
+	}
+	created := &Directory{Name: name, Title: name, Parent: directory.start, start: start}
+	if err := fs.writeDirectory(created); err != nil {
+		return err
+	}
+	return fs.addEntry(directory, Entry{
+		Name: name, Length: directorySize, Start: start,
+		Readable: true, Locked: true, Directory: true,
+	})
+}
+
+// Deletes a file or an empty directory.
+func (fs *FileSystem) Delete(path string) error {
+	directory, name, err := fs.parent(path)
+	if err != nil {
+		return err
+	}
+	index, ok := directory.Find(name)
+	if !ok {
+		return fmt.Errorf("%s not found", path)
+	}
+	entry := directory.Entries[index]
+	if entry.Locked {
+		return fmt.Errorf("%s locked", path)
+	}
+	if entry.Directory {
+		content, err := fs.readDirectory(entry.Start)
+		if err != nil {
+			return err
+		}
+		if len(content.Entries) > 0 {
+			return fmt.Errorf("%s is not empty", path)
+		}
+	}
+	if err := fs.release(entry.Start, entry.GetSectors()); err != nil {
+		return err
+	}
+	directory.Entries = append(directory.Entries[:index], directory.Entries[index+1:]...)
+	if err := fs.writeDirectory(directory); err != nil {
+		return err
+	}
+	return fs.writeMap()
+}
+
+// Sets the access of an entry, as *ACCESS with the letters L, W, R and E.
+func (fs *FileSystem) SetAccess(path string, access string) error {
+	directory, name, err := fs.parent(path)
+	if err != nil {
+		return err
+	}
+	index, ok := directory.Find(name)
+	if !ok {
+		return fmt.Errorf("%s not found", path)
+	}
+	entry := &directory.Entries[index]
+	access = strings.ToUpper(access)
+	if strings.Trim(access, "LWRE") != "" {
+		return fmt.Errorf("bad attribute %s", access)
+	}
+	entry.Locked = strings.Contains(access, "L")
+	if !entry.Directory {
+		entry.Writable = strings.Contains(access, "W")
+		entry.Readable = strings.Contains(access, "R")
+		entry.ExecuteOnly = strings.Contains(access, "E")
+	}
+	return fs.writeDirectory(directory)
+}
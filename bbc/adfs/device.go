@@ -0,0 +1,153 @@
+package adfs
+
+import (
+	"bbc/disc"
+	"fmt"
+	"os"
+)
+
+// Sectors of 256 bytes by logical number, from the start of the disc.
+type Device interface {
+	GetSectorCount() int
+	ReadSector(number int) ([]byte, error)
+	WriteSector(number int, data []byte) error
+}
+
+// ADFS floppy: logical sectors run through the tracks, alternating between
+// the sides of double sided discs.
+type Floppy struct {
+	disc *disc.Disc
+}
+
+func NewFloppy(image *disc.Disc) *Floppy {
+	return &Floppy{disc: image}
+}
+
+// Unformatted ADFS floppy of the S, M or L size, double sided for L.
+func NewFloppyDisc(sectors int) (*disc.Disc, error) {
+	sides, tracks := 1, 0
+	switch sectors {
+	case SmallSectors:
+		tracks = disc.Tracks40
+	case MediumSectors:
+		tracks = disc.Tracks80
+	case LargeSectors:
+		sides, tracks = 2, disc.Tracks80
+	default:
+		return nil, fmt.Errorf("no floppy format of %d sectors", sectors)
+	}
+	image := disc.New(sides, tracks)
+	for side := 0; side < sides; side++ {
+		for track := 0; track < tracks; track++ {
+			image.Tracks[side][track] = disc.NewTrack(byte(track), byte(side), disc.ADFSSectorsPerTrack, 0, disc.SectorSize, true)
+		}
+	}
+	return image, nil
+}
+
+func (floppy *Floppy) GetDisc() *disc.Disc {
+	return floppy.disc
+}
+
+func (floppy *Floppy) GetSectorCount() int {
+	return floppy.disc.GetSides() * floppy.disc.GetTrackCount() * disc.ADFSSectorsPerTrack
+}
+
+func (floppy *Floppy) locate(number int) (*disc.Sector, error) {
+	if number < 0 || number >= floppy.GetSectorCount() {
+		return nil, fmt.Errorf("sector %d is past the end of the disc", number)
+	}
+	logicalTrack := number / disc.ADFSSectorsPerTrack
+	side, track := logicalTrack%floppy.disc.GetSides(), logicalTrack/floppy.disc.GetSides()
+	sector := floppy.disc.GetTrack(side, track).Find(byte(track), byte(number%disc.ADFSSectorsPerTrack))
+	if sector == nil || len(sector.Data) != disc.SectorSize {
+		return nil, fmt.Errorf("sector %d is not an ADFS sector", number)
+	}
+	return sector, nil
+}
+
+func (floppy *Floppy) ReadSector(number int) ([]byte, error) {
+	sector, err := floppy.locate(number)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, sector.Data...), nil
+}
+
+func (floppy *Floppy) WriteSector(number int, data []byte) error {
+	sector, err := floppy.locate(number)
+	if err != nil {
+		return err
+	}
+	sector.Data = make([]byte, disc.SectorSize)
+	copy(sector.Data, data)
+	floppy.disc.MarkModified()
+	return nil
+}
+
+// Hard disc image holding the sectors one after the other, as the .dat
+// files of SCSI discs.
+type HardDisc struct {
+	data     []byte
+	path     string
+	modified bool
+}
+
+func NewHardDisc(sectors int) *HardDisc {
+	return &HardDisc{data: make([]byte, sectors*disc.SectorSize)}
+}
+
+// Loads an image, the disc is written back to it when flushed.
+func OpenHardDisc(path string) (*HardDisc, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%disc.SectorSize != 0 {
+		return nil, fmt.Errorf("%s: %d bytes is not a whole number of sectors", path, len(data))
+	}
+	return &HardDisc{data: data, path: path}, nil
+}
+
+func (hard *HardDisc) GetSectorCount() int {
+	return len(hard.data) / disc.SectorSize
+}
+
+func (hard *HardDisc) ReadSector(number int) ([]byte, error) {
+	if number < 0 || number >= hard.GetSectorCount() {
+		return nil, fmt.Errorf("sector %d is past the end of the disc", number)
+	}
+	offset := number * disc.SectorSize
+	return append([]byte{}, hard.data[offset:offset+disc.SectorSize]...), nil
+}
+
+func (hard *HardDisc) WriteSector(number int, data []byte) error {
+	if number < 0 || number >= hard.GetSectorCount() {
+		return fmt.Errorf("sector %d is past the end of the disc", number)
+	}
+	offset := number * disc.SectorSize
+	sector := hard.data[offset : offset+disc.SectorSize]
+	copy(sector, make([]byte, disc.SectorSize))
+	copy(sector, data)
+	hard.modified = true
+	return nil
+}
+
+// Writes the image back when it was modified.
+func (hard *HardDisc) Flush() error {
+	if !hard.modified || hard.path == "" {
+		return nil
+	}
+	if err := os.WriteFile(hard.path, hard.data, 0o644); err != nil {
+		return err
+	}
+	hard.modified = false
+	return nil
+}
+
+// Writes the image to a new file, flushed there from then on.
+func (hard *HardDisc) Save(path string) error {
+	hard.path = path
+	hard.modified = true
+	return hard.Flush()
+}
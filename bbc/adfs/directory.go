@@ -0,0 +1,215 @@
+package adfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Old directories: 5 sectors starting with a sequence number and "Hugo",
+// then up to 47 entries of 26 bytes sorted by name, and a tail with the
+// name, parent and title of the directory, ending with the sequence number
+// and "Hugo" again. The attributes are in the top bits of the first
+// characters of the names.
+
+const (
+	DirectorySectors = 5
+	MaxEntries       = 47
+	MaxNameSize      = 10
+	MaxTitle         = 19
+	RootSector       = 2
+
+	directorySize = DirectorySectors * 256
+	directoryID   = "Hugo"
+	entriesStart  = 5
+	entrySize     = 26
+	// tail
+	tailName     = 0x4CC
+	tailParent   = 0x4D6
+	tailTitle    = 0x4D9
+	tailSequence = 0x4FA
+	tailID       = 0x4FB
+)
+
+// attribute bits, in the name characters
+const (
+	attributeRead = iota
+	attributeWrite
+	attributeLocked
+	attributeDirectory
+	attributeExecute
+)
+
+type Entry struct {
+	Name       string
+	Load, Exec uint32
+	Length     int
+	Start      int
+	// sequence number of the directory when the entry was written
+	Sequence byte
+
+	Readable, Writable, Locked, Directory, ExecuteOnly bool
+}
+
+// Attributes as *INFO lists them.
+func (entry Entry) GetAttributes() string {
+	attributes := ""
+	for _, attribute := range []struct {
+		set    bool
+		letter string
+	}{
+		{entry.Directory, "D"}, {entry.Locked, "L"}, {entry.ExecuteOnly, "E"},
+		{entry.Writable, "W"}, {entry.Readable, "R"},
+	} {
+		if attribute.set {
+			attributes += attribute.letter
+		}
+	}
+	return attributes
+}
+
+func (entry Entry) GetSectors() int {
+	return (entry.Length + 255) / 256
+}
+
+type Directory struct {
+	Name     string
+	Title    string
+	Parent   int
+	Sequence byte
+	Entries  []Entry
+
+	start int
+}
+
+func (directory *Directory) GetStart() int {
+	return directory.start
+}
+
+// Entry with the name, names are not case sensitive.
+func (directory *Directory) Find(name string) (int, bool) {
+	for i, entry := range directory.Entries {
+		if strings.EqualFold(entry.Name, name) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Names end with CR or NUL when shorter than their field, the top bits may
+// hold attributes.
+func getName(data []byte) string {
+	name := []byte{}
+	for _, character := range data {
+		if character&0x7F < ' ' {
+			break
+		}
+		name = append(name, character&0x7F)
+	}
+	return string(name)
+}
+
+func putName(data []byte, name string, size int) {
+	for i := 0; i < size; i++ {
+		data[i] = 0
+	}
+	copy(data, name)
+	if len(name) < size {
+		data[len(name)] = '\r'
+	}
+}
+
+func getWord(data []byte) uint32 {
+	return uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+}
+
+func putWord(data []byte, value uint32) {
+	data[0], data[1], data[2], data[3] = byte(value), byte(value>>8), byte(value>>16), byte(value>>24)
+}
+
+func nextSequence(sequence byte) byte {
+	if sequence&0x0F == 9 {
+		sequence = (sequence + 0x10) & 0xF0
+	} else {
+		sequence++
+	}
+	if sequence >= 0xA0 {
+		return 0
+	}
+	return sequence
+}
+
+func (fs *FileSystem) readDirectory(start int) (*Directory, error) {
+	data := make([]byte, 0, directorySize)
+	for sector := 0; sector < DirectorySectors; sector++ {
+		content, err := fs.Device.ReadSector(start + sector)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, content...)
+	}
+	if string(data[1:5]) != directoryID || string(data[tailID:tailID+4]) != directoryID || data[0] != data[tailSequence] {
+		return nil, fmt.Errorf("broken directory at sector %d", start)
+	}
+	directory := &Directory{
+		Name:     getName(data[tailName : tailName+MaxNameSize]),
+		Title:    getName(data[tailTitle : tailTitle+MaxTitle]),
+		Parent:   getNumber(data[tailParent:]),
+		Sequence: data[0],
+		start:    start,
+	}
+	for offset := entriesStart; offset+entrySize <= tailName && data[offset] != 0; offset += entrySize {
+		fields := data[offset : offset+entrySize]
+		attribute := func(bit int) bool { return fields[bit]&0x80 != 0 }
+		directory.Entries = append(directory.Entries, Entry{
+			Name:        getName(fields[:MaxNameSize]),
+			Load:        getWord(fields[10:]),
+			Exec:        getWord(fields[14:]),
+			Length:      int(getWord(fields[18:])),
+			Start:       getNumber(fields[22:]),
+			Sequence:    fields[25],
+			Readable:    attribute(attributeRead),
+			Writable:    attribute(attributeWrite),
+			Locked:      attribute(attributeLocked),
+			Directory:   attribute(attributeDirectory),
+			ExecuteOnly: attribute(attributeExecute),
+		})
+	}
+	return directory, nil
+}
+
+// Writes the directory back with the next sequence number.
+func (fs *FileSystem) writeDirectory(directory *Directory) error {
+	sort.SliceStable(directory.Entries, func(i, j int) bool {
+		return strings.ToUpper(directory.Entries[i].Name) < strings.ToUpper(directory.Entries[j].Name)
+	})
+	directory.Sequence = nextSequence(directory.Sequence)
+	data := make([]byte, directorySize)
+	data[0] = directory.Sequence
+	copy(data[1:], directoryID)
+	for i, entry := range directory.Entries {
+		fields := data[entriesStart+i*entrySize:]
+		putName(fields, entry.Name, MaxNameSize)
+		for bit, set := range []bool{entry.Readable, entry.Writable, entry.Locked, entry.Directory, entry.ExecuteOnly} {
+			if set {
+				fields[bit] |= 0x80
+			}
+		}
+		putWord(fields[10:], entry.Load)
+		putWord(fields[14:], entry.Exec)
+		putWord(fields[18:], uint32(entry.Length))
+		putNumber(fields[22:], entry.Start)
+		fields[25] = entry.Sequence
+	}
+	putName(data[tailName:], directory.Name, MaxNameSize)
+	putNumber(data[tailParent:], directory.Parent)
+	putName(data[tailTitle:], directory.Title, MaxTitle)
+	data[tailSequence] = directory.Sequence
+	copy(data[tailID:], directoryID)
+	for sector := 0; sector < DirectorySectors; sector++ {
+		if err := fs.Device.WriteSector(directory.start+sector, data[sector*256:(sector+1)*256]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
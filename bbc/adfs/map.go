@@ -0,0 +1,149 @@
+package adfs
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Old map of the free space, in sectors 0 and 1: the start sectors of the
+// free areas in the first, their lengths in the second, 3 bytes each. The
+// first sector ends with the size of the disc, the second with the disc
+// identifier, the boot option and the length of the lists. Both end with a
+// checksum.
+
+const (
+	maxFreeAreas = 82
+	mapSectors   = 2
+	// sector 0
+	mapDiscSize = 0xFC
+	// sector 1
+	mapDiscID    = 0xFB
+	mapBoot      = 0xFD
+	mapListEnd   = 0xFE
+	mapChecksum  = 0xFF
+	sizeOfNumber = 3
+)
+
+type Extent struct {
+	Start, Length int
+}
+
+func getNumber(data []byte) int {
+	return int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+}
+
+func putNumber(data []byte, value int) {
+	data[0], data[1], data[2] = byte(value), byte(value>>8), byte(value>>16)
+}
+
+// Checksum of a map sector, adding the bytes from the end with the carry.
+func mapSum(sector []byte) byte {
+	sum := 255
+	for i := mapChecksum - 1; i >= 0; i-- {
+		if sum > 255 {
+			sum = (sum + 1) & 0xFF
+		}
+		sum += int(sector[i])
+	}
+	return byte(sum)
+}
+
+func (fs *FileSystem) readMap() error {
+	starts, err := fs.Device.ReadSector(0)
+	if err != nil {
+		return err
+	}
+	lengths, err := fs.Device.ReadSector(1)
+	if err != nil {
+		return err
+	}
+	if mapSum(starts) != starts[mapChecksum] || mapSum(lengths) != lengths[mapChecksum] {
+		return fmt.Errorf("bad free space map")
+	}
+	end := int(lengths[mapListEnd])
+	if end%sizeOfNumber != 0 || end > maxFreeAreas*sizeOfNumber {
+		return fmt.Errorf("bad free space map")
+	}
+	fs.Sectors = getNumber(starts[mapDiscSize:])
+	fs.DiscID = uint16(lengths[mapDiscID]) | uint16(lengths[mapDiscID+1])<<8
+	fs.Boot = int(lengths[mapBoot])
+	fs.Free = nil
+	for offset := 0; offset < end; offset += sizeOfNumber {
+		fs.Free = append(fs.Free, Extent{getNumber(starts[offset:]), getNumber(lengths[offset:])})
+	}
+	return nil
+}
+
+func (fs *FileSystem) writeMap() error {
+	starts := make([]byte, 256)
+	lengths := make([]byte, 256)
+	for i, free := range fs.Free {
+		putNumber(starts[i*sizeOfNumber:], free.Start)
+		putNumber(lengths[i*sizeOfNumber:], free.Length)
+	}
+	putNumber(starts[mapDiscSize:], fs.Sectors)
+	lengths[mapDiscID], lengths[mapDiscID+1] = byte(fs.DiscID), byte(fs.DiscID>>8)
+	lengths[mapBoot] = byte(fs.Boot)
+	lengths[mapListEnd] = byte(len(fs.Free) * sizeOfNumber)
+	starts[mapChecksum] = mapSum(starts)
+	lengths[mapChecksum] = mapSum(lengths)
+	if err := fs.Device.WriteSector(0, starts); err != nil {
+		return err
+	}
+	return fs.Device.WriteSector(1, lengths)
+}
+
+func (fs *FileSystem) GetFreeSectors() int {
+	free := 0
+	for _, area := range fs.Free {
+		free += area.Length
+	}
+	return free
+}
+
+// Takes the sectors from the first free area large enough.
+func (fs *FileSystem) allocate(sectors int) (int, error) {
+	if sectors == 0 {
+		return 0, nil
+	}
+	for i := range fs.Free {
+		area := &fs.Free[i]
+		if area.Length < sectors {
+			continue
+		}
+		start := area.Start
+		area.Start += sectors
+		area.Length -= sectors
+		if area.Length == 0 {
+			fs.Free = append(fs.Free[:i], fs.Free[i+1:]...)
+		}
+		return start, nil
+	}
+	if fs.GetFreeSectors() >= sectors {
+		return 0, fmt.Errorf("compaction required")
+	}
+	return 0, fmt.Errorf("disc full")
+}
+
+// Returns sectors to the map, merged with the free areas around them.
+func (fs *FileSystem) release(start, sectors int) error {
+	if sectors == 0 {
+		return nil
+	}
+	free := append(append([]Extent{}, fs.Free...), Extent{start, sectors})
+	sort.Slice(free, func(i, j int) bool { return free[i].Start < free[j].Start })
+	merged := free[:1]
+	for _, area := range free[1:] {
+		last := &merged[len(merged)-1]
+		if last.Start+last.Length == area.Start {
+			last.Length += area.Length
+		} else {
+			merged = append(merged, area)
+		}
+	}
+	if len(merged) > maxFreeAreas {
+		return fmt.Errorf("map full")
+	}
+	fs.Free = merged
+	return nil
+}
@@ -0,0 +1,120 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 16 bits mono PCM WAV files.
+
+const wavHeaderSize = 44
+
+type WAVWriter struct {
+	output     io.WriteSeeker
+	closer     io.Closer
+	sampleRate int
+	samples    uint32
+}
+
+func wavHeader(sampleRate int, samples uint32) []byte {
+	header := make([]byte, wavHeaderSize)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 36+samples*2)
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	// PCM, mono
+	binary.LittleEndian.PutUint16(header[20:], 1)
+	binary.LittleEndian.PutUint16(header[22:], 1)
+	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(sampleRate*2))
+	binary.LittleEndian.PutUint16(header[32:], 2)
+	binary.LittleEndian.PutUint16(header[34:], 16)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], samples*2)
+	return header
+}
+
+// The lengths in the header are written when the writer is closed.
+func NewWAVWriter(output io.WriteSeeker, sampleRate int) (*WAVWriter, error) {
+	if _, err := output.Write(wavHeader(sampleRate, 0)); err != nil {
+		return nil, err
+	}
+	return &WAVWriter{output: output, sampleRate: sampleRate}, nil
+}
+
+func CreateWAV(path string, sampleRate int) (*WAVWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := NewWAVWriter(file, sampleRate)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	writer.closer = file
+	return writer, nil
+}
+
+func (writer *WAVWriter) Write(samples []int16) error {
+	if err := binary.Write(writer.output, binary.LittleEndian, samples); err != nil {
+		return err
+	}
+	writer.samples += uint32(len(samples))
+	return nil
+}
+
+func (writer *WAVWriter) GetSampleRate() int {
+	return writer.sampleRate
+}
+
+// Completes the header, and closes the file opened by CreateWAV.
+func (writer *WAVWriter) Close() error {
+	if _, err := writer.output.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := writer.output.Write(wavHeader(writer.sampleRate, writer.samples)); err != nil {
+		return err
+	}
+	if _, err := writer.output.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if writer.closer != nil {
+		return writer.closer.Close()
+	}
+	return nil
+}
+
+// Reads a 16 bits mono PCM file, returning its sample rate and samples.
+func ReadWAV(input io.Reader) (int, []int16, error) {
+	header := make([]byte, wavHeaderSize)
+	if _, err := io.ReadFull(input, header); err != nil {
+		return 0, nil, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:16]) != "WAVEfmt " || string(header[36:40]) != "data" {
+		return 0, nil, fmt.Errorf("not a canonical WAV file")
+	}
+	format := binary.LittleEndian.Uint16(header[20:])
+	channels := binary.LittleEndian.Uint16(header[22:])
+	bits := binary.LittleEndian.Uint16(header[34:])
+	if format != 1 || channels != 1 || bits != 16 {
+		return 0, nil, fmt.Errorf("unsupported WAV format %d, %d channels of %d bits", format, channels, bits)
+	}
+	sampleRate := int(binary.LittleEndian.Uint32(header[24:]))
+	samples := make([]int16, binary.LittleEndian.Uint32(header[40:])/2)
+	if err := binary.Read(input, binary.LittleEndian, samples); err != nil {
+		return 0, nil, err
+	}
+	return sampleRate, samples, nil
+}
+
+func LoadWAV(path string) (int, []int16, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+	return ReadWAV(file)
+}
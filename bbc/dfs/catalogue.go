@@ -0,0 +1,414 @@
+package dfs
+
+import (
+	"bbc/disc"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Acorn DFS catalogue, in the first two sectors of each side. Sector 0 holds
+// the first 8 characters of the title and the names, sector 1 the rest of the
+// title, the cycle number, the boot option, the sectors of the side and the
+// addresses, lengths and start sectors of the files. Files are catalogued by
+// decreasing start sector.
+
+const (
+	MaxFiles    = 31
+	MaxNameSize = 7
+	MaxTitle    = 12
+	// the catalogue takes sectors 0 and 1
+	firstDataSector = 2
+	entrySize       = 8
+	lockedBit       = 0x80
+)
+
+// boot options, *OPT 4
+const (
+	BootNone = iota
+	BootLoad
+	BootRun
+	BootExec
+)
+
+var BootNames = [4]string{"none", "LOAD", "RUN", "EXEC"}
+
+type File struct {
+	Directory byte
+	Name      string
+	Locked    bool
+	// 18 bit addresses, the I/O processor ones from FF0000
+	Load, Exec uint32
+	Length     int
+	Start      int
+}
+
+func (file File) GetFullName() string {
+	return string(file.Directory) + "." + file.Name
+}
+
+func (file File) GetSectors() int {
+	return (file.Length + disc.SectorSize - 1) / disc.SectorSize
+}
+
+// The catalogue of one side of a disc.
+type Volume struct {
+	Title string
+	// binary coded decimal, counts the catalogue writes
+	Cycle   byte
+	Boot    int
+	Sectors int
+	Files   []File
+
+	disc *disc.Disc
+	side int
+}
+
+func ReadVolume(image *disc.Disc, side int) (*Volume, error) {
+	volume := &Volume{disc: image, side: side}
+	names, err := volume.readSector(0)
+	if err != nil {
+		return nil, err
+	}
+	details, err := volume.readSector(1)
+	if err != nil {
+		return nil, err
+	}
+	volume.Title = strings.TrimRight(string(names[:8])+string(details[:4]), " \x00")
+	volume.Cycle = details[4]
+	volume.Boot = int(details[6]>>4) & 0x03
+	volume.Sectors = int(details[6]&0x03)<<8 | int(details[7])
+	if details[5]%entrySize != 0 || int(details[5])/entrySize > MaxFiles {
+		return nil, fmt.Errorf("bad catalogue on side %d", side)
+	}
+	if volume.Sectors < firstDataSector || volume.Sectors > image.GetTrackCount()*disc.DFSSectorsPerTrack {
+		return nil, fmt.Errorf("bad catalogue on side %d: %d sectors", side, volume.Sectors)
+	}
+	for offset := entrySize; offset <= int(details[5]); offset += entrySize {
+		name := make([]byte, MaxNameSize)
+		for i := range name {
+			name[i] = names[offset+i] & 0x7F
+		}
+		mixed := details[offset+6]
+		volume.Files = append(volume.Files, File{
+			Directory: names[offset+7] & 0x7F,
+			Name:      strings.TrimRight(string(name), " \x00"),
+			Locked:    names[offset+7]&lockedBit != 0,
+			Load:      expandAddress(details[offset], details[offset+1], mixed>>2),
+			Exec:      expandAddress(details[offset+2], details[offset+3], mixed>>6),
+			Length:    int(mixed>>4&0x03)<<16 | int(details[offset+5])<<8 | int(details[offset+4]),
+			Start:     int(mixed&0x03)<<8 | int(details[offset+7]),
+		})
+	}
+	return volume, nil
+}
+
+// Addresses have two more bits in the catalogue, both set for FFxxxx.
+func expandAddress(low, high, top byte) uint32 {
+	address := uint32(high)<<8 | uint32(low)
+	if top&0x03 == 0x03 {
+		return 0xFF0000 | address
+	}
+	return uint32(top&0x03)<<16 | address
+}
+
+func addressTop(address uint32) byte {
+	if address >= 0xFF0000 {
+		return 0x03
+	}
+	return byte(address>>16) & 0x03
+}
+
+func (volume *Volume) GetSide() int {
+	return volume.side
+}
+
+// Sectors not taken by the catalogue and the files.
+func (volume *Volume) GetFreeSectors() int {
+	free := volume.Sectors - firstDataSector
+	for _, file := range volume.Files {
+		free -= file.GetSectors()
+	}
+	return free
+}
+
+func (volume *Volume) sector(number int) (*disc.Sector, error) {
+	track := number / disc.DFSSectorsPerTrack
+	sector := volume.disc.GetTrack(volume.side, track).Find(byte(track), byte(number%disc.DFSSectorsPerTrack))
+	if sector == nil || len(sector.Data) != disc.SectorSize {
+		return nil, fmt.Errorf("sector %d of side %d is not a DFS sector", number, volume.side)
+	}
+	return sector, nil
+}
+
+func (volume *Volume) readSector(number int) ([]byte, error) {
+	sector, err := volume.sector(number)
+	if err != nil {
+		return nil, err
+	}
+	return sector.Data, nil
+}
+
+func (volume *Volume) readSectors(start, length int) ([]byte, error) {
+	data := make([]byte, 0, length+disc.SectorSize)
+	for number := start; len(data) < length; number++ {
+		sector, err := volume.readSector(number)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sector...)
+	}
+	return data[:length], nil
+}
+
+// Whole sectors are written, the last one padded with zeros.
+func (volume *Volume) writeSectors(start int, data []byte) error {
+	for offset := 0; offset < len(data); offset += disc.SectorSize {
+		sector, err := volume.sector(start + offset/disc.SectorSize)
+		if err != nil {
+			return err
+		}
+		padded := make([]byte, disc.SectorSize)
+		copy(padded, data[offset:])
+		sector.Data = padded
+	}
+	volume.disc.MarkModified()
+	return nil
+}
+
+// Writes the catalogue back with the next cycle number.
+func (volume *Volume) writeCatalogue() error {
+	names := make([]byte, disc.SectorSize)
+	details := make([]byte, disc.SectorSize)
+	title := []byte(volume.Title + strings.Repeat(" ", MaxTitle))
+	copy(names, title[:8])
+	copy(details, title[8:MaxTitle])
+	if volume.Cycle&0x0F == 9 {
+		volume.Cycle = (volume.Cycle + 0x10) & 0xF0
+	} else {
+		volume.Cycle++
+	}
+	if volume.Cycle >= 0xA0 {
+		volume.Cycle = 0
+	}
+	details[4] = volume.Cycle
+	details[5] = byte(len(volume.Files) * entrySize)
+	details[6] = byte(volume.Boot&0x03)<<4 | byte(volume.Sectors>>8)&0x03
+	details[7] = byte(volume.Sectors)
+	for i, file := range volume.Files {
+		offset := (i + 1) * entrySize
+		copy(names[offset:], file.Name+strings.Repeat(" ", MaxNameSize))
+		names[offset+7] = file.Directory
+		if file.Locked {
+			names[offset+7] |= lockedBit
+		}
+		details[offset] = byte(file.Load)
+		details[offset+1] = byte(file.Load >> 8)
+		details[offset+2] = byte(file.Exec)
+		details[offset+3] = byte(file.Exec >> 8)
+		details[offset+4] = byte(file.Length)
+		details[offset+5] = byte(file.Length >> 8)
+		details[offset+6] = addressTop(file.Exec)<<6 | byte(file.Length>>16&0x03)<<4 | addressTop(file.Load)<<2 | byte(file.Start>>8&0x03)
+		details[offset+7] = byte(file.Start)
+	}
+	if err := volume.writeSectors(0, names); err != nil {
+		return err
+	}
+	return volume.writeSectors(1, details)
+}
+
+// Splits D.NAME, the directory is $ when not given.
+func ParseName(name string) (byte, string, error) {
+	directory := byte('$')
+	if len(name) > 2 && name[1] == '.' {
+		directory, name = name[0], name[2:]
+	}
+	if name == "" || len(name) > MaxNameSize || !isNameCharacter(directory) {
+		return 0, "", fmt.Errorf("bad name %s", name)
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameCharacter(name[i]) {
+			return 0, "", fmt.Errorf("bad name %s", name)
+		}
+	}
+	return directory, name, nil
+}
+
+func isNameCharacter(character byte) bool {
+	return character > ' ' && character < 0x7F && !strings.ContainsRune(".:\"#*", rune(character))
+}
+
+// Index of the file in the catalogue, names are not case sensitive.
+func (volume *Volume) find(directory byte, name string) int {
+	for i, file := range volume.Files {
+		if strings.EqualFold(string(file.Directory), string(directory)) && strings.EqualFold(file.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (volume *Volume) lookup(fullName string) (int, error) {
+	directory, name, err := ParseName(fullName)
+	if err != nil {
+		return -1, err
+	}
+	index := volume.find(directory, name)
+	if index < 0 {
+		return -1, fmt.Errorf("file %s not found", fullName)
+	}
+	return index, nil
+}
+
+func (volume *Volume) Find(fullName string) (File, bool) {
+	index, err := volume.lookup(fullName)
+	if err != nil {
+		return File{}, false
+	}
+	return volume.Files[index], true
+}
+
+func (volume *Volume) ReadFile(fullName string) (File, []byte, error) {
+	index, err := volume.lookup(fullName)
+	if err != nil {
+		return File{}, nil, err
+	}
+	file := volume.Files[index]
+	data, err := volume.readSectors(file.Start, file.Length)
+	return file, data, err
+}
+
+// Saves a file as *SAVE does: an unlocked file of the same name is replaced,
+// and the data goes in the first gap large enough. The replaced file keeps its
+// sectors until the new catalogue is written, so a failed save leaves it intact.
+func (volume *Volume) AddFile(file File, data []byte) error {
+	if _, _, err := ParseName(file.GetFullName()); err != nil {
+		return err
+	}
+	index := volume.find(file.Directory, file.Name)
+	if index >= 0 && volume.Files[index].Locked {
+		return fmt.Errorf("file %s locked", volume.Files[index].GetFullName())
+	}
+	files := volume.Files
+	if index >= 0 {
+		files = append(append([]File{}, files[:index]...), files[index+1:]...)
+	}
+	if len(files) >= MaxFiles {
+		return fmt.Errorf("catalogue full")
+	}
+	file.Length = len(data)
+	start, ok := freeSpace(volume.Files, volume.Sectors, file.GetSectors())
+	if !ok {
+		return fmt.Errorf("disc full")
+	}
+	file.Start = start
+	if err := volume.writeSectors(start, data); err != nil {
+		return err
+	}
+	previous := volume.Files
+	volume.Files = append(files, file)
+	volume.sortFiles()
+	if err := volume.writeCatalogue(); err != nil {
+		volume.Files = previous
+		return err
+	}
+	return nil
+}
+
+// First start sector with the room, from the start of the disc.
+func freeSpace(files []File, sectors, needed int) (int, bool) {
+	used := append([]File{}, files...)
+	sort.SliceStable(used, func(i, j int) bool { return used[i].Start < used[j].Start })
+	start := firstDataSector
+	for _, file := range used {
+		if file.Start-start >= needed {
+			return start, true
+		}
+		if end := file.Start + file.GetSectors(); end > start {
+			start = end
+		}
+	}
+	return start, sectors-start >= needed
+}
+
+func (volume *Volume) sortFiles() {
+	sort.SliceStable(volume.Files, func(i, j int) bool { return volume.Files[i].Start > volume.Files[j].Start })
+}
+
+func (volume *Volume) DeleteFile(fullName string) error {
+	index, err := volume.lookup(fullName)
+	if err != nil {
+		return err
+	}
+	if volume.Files[index].Locked {
+		return fmt.Errorf("file %s locked", fullName)
+	}
+	volume.Files = append(volume.Files[:index], volume.Files[index+1:]...)
+	return volume.writeCatalogue()
+}
+
+func (volume *Volume) RenameFile(from, to string) error {
+	index, err := volume.lookup(from)
+	if err != nil {
+		return err
+	}
+	if volume.Files[index].Locked {
+		return fmt.Errorf("file %s locked", from)
+	}
+	directory, name, err := ParseName(to)
+	if err != nil {
+		return err
+	}
+	if other := volume.find(directory, name); other >= 0 && other != index {
+		return fmt.Errorf("file %s exists", to)
+	}
+	volume.Files[index].Directory = directory
+	volume.Files[index].Name = name
+	return volume.writeCatalogue()
+}
+
+func (volume *Volume) SetLocked(fullName string, locked bool) error {
+	index, err := volume.lookup(fullName)
+	if err != nil {
+		return err
+	}
+	volume.Files[index].Locked = locked
+	return volume.writeCatalogue()
+}
+
+func (volume *Volume) SetBoot(option int) error {
+	if option < BootNone || option > BootExec {
+		return fmt.Errorf("bad boot option %d", option)
+	}
+	volume.Boot = option
+	return volume.writeCatalogue()
+}
+
+func (volume *Volume) SetTitle(title string) error {
+	if len(title) > MaxTitle {
+		return fmt.Errorf("title %s is longer than %d characters", title, MaxTitle)
+	}
+	volume.Title = title
+	return volume.writeCatalogue()
+}
+
+// Moves the files down to the start of the disc, leaving the free space
+// after them, as *COMPACT does.
+func (volume *Volume) Compact() error {
+	next := firstDataSector
+	for i := len(volume.Files) - 1; i >= 0; i-- {
+		file := &volume.Files[i]
+		if file.Start != next {
+			data, err := volume.readSectors(file.Start, file.GetSectors()*disc.SectorSize)
+			if err != nil {
+				return err
+			}
+			if err := volume.writeSectors(next, data); err != nil {
+				return err
+			}
+			file.Start = next
+		}
+		next += file.GetSectors()
+	}
+	return volume.writeCatalogue()
+}
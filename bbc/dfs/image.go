@@ -0,0 +1,49 @@
+package dfs
+
+import (
+	"bbc/disc"
+	"fmt"
+)
+
+// Disc images holding a DFS catalogue on each side, as the drives mount
+// them.
+type Image struct {
+	*disc.Disc
+}
+
+// Loads an image, the disc is written back to it when flushed.
+func Open(path string) (*Image, error) {
+	image, err := disc.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{image}, nil
+}
+
+// Freshly formatted disc with an empty catalogue on each side.
+func Blank(sides, tracks int) (*Image, error) {
+	if tracks != disc.Tracks40 && tracks != disc.Tracks80 {
+		return nil, fmt.Errorf("discs have 40 or 80 tracks, not %d", tracks)
+	}
+	image := &Image{disc.New(sides, tracks)}
+	for side := 0; side < sides; side++ {
+		for track := 0; track < tracks; track++ {
+			image.Tracks[side][track] = disc.NewTrack(byte(track), 0, disc.DFSSectorsPerTrack, 0, disc.SectorSize, false)
+		}
+		volume := &Volume{Sectors: tracks * disc.DFSSectorsPerTrack, disc: image.Disc, side: side}
+		// the first write brings the cycle number to 0
+		volume.Cycle = 0x99
+		if err := volume.writeCatalogue(); err != nil {
+			return nil, err
+		}
+	}
+	return image, nil
+}
+
+// Catalogue of a side.
+func (image *Image) Volume(side int) (*Volume, error) {
+	if side < 0 || side >= image.GetSides() {
+		return nil, fmt.Errorf("no side %d on the disc", side)
+	}
+	return ReadVolume(image.Disc, side)
+}
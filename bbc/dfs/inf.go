@@ -0,0 +1,72 @@
+package dfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// .inf sidecars of the files extracted from discs: the name, the load and
+// execution addresses and the length in hexadecimal, then L when the file
+// is locked.
+
+func FormatINF(file File) string {
+	inf := fmt.Sprintf("%s %06X %06X %06X", file.GetFullName(), file.Load, file.Exec, file.Length)
+	if file.Locked {
+		inf += " L"
+	}
+	return inf + "\n"
+}
+
+// Sidecar fields as written, shared with the other filing systems: their
+// names and addresses are not those of DFS.
+type INF struct {
+	Name   string
+	Load   uint32
+	Exec   uint32
+	Locked bool
+}
+
+func ReadINF(inf string) (INF, error) {
+	line, _, _ := strings.Cut(inf, "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return INF{}, fmt.Errorf("empty .inf")
+	}
+	result := INF{Name: fields[0]}
+	addresses := []*uint32{&result.Load, &result.Exec}
+	for _, field := range fields[1:] {
+		switch {
+		case strings.EqualFold(field, "L") || strings.EqualFold(field, "Locked"):
+			result.Locked = true
+		case strings.Contains(field, "="):
+			// CRC= and the like
+		case len(addresses) > 0:
+			value, err := strconv.ParseUint(field, 16, 32)
+			if err != nil {
+				return INF{}, fmt.Errorf("bad address %s in .inf", field)
+			}
+			*addresses[0] = uint32(value)
+			addresses = addresses[1:]
+		}
+	}
+	return result, nil
+}
+
+// Name, addresses and lock of a sidecar, the length is the data's.
+func ParseINF(inf string) (File, error) {
+	fields, err := ReadINF(inf)
+	if err != nil {
+		return File{}, err
+	}
+	directory, name, err := ParseName(fields.Name)
+	if err != nil {
+		return File{}, err
+	}
+	// 8 digit addresses are sign extended from 24 bits
+	return File{
+		Directory: directory, Name: name,
+		Load: fields.Load & 0xFFFFFF, Exec: fields.Exec & 0xFFFFFF,
+		Locked: fields.Locked,
+	}, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bbc/dfs"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const dfsUsage = `usage: bbc dfs <command> [-side n] <image> [arguments]
+
+commands:
+  create [-tracks 40|80] [-title title] <image>
+  cat <image>
+  boot <image> <0-3>
+  title <image> <title>
+  extract [-dir directory] <image> [names]
+  add <image> <files>
+  delete <image> <names>
+  rename <image> <from> <to>
+  lock <image> <names>
+  unlock <image> <names>
+  compact <image>`
+
+// Runs the dfs subcommand on the arguments following it.
+func runDFS(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(dfsUsage)
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("dfs "+command, flag.ContinueOnError)
+	side := flags.Int("side", 0, "side of a double sided image")
+	tracks := flags.Int("tracks", 80, "tracks of a new image")
+	title := flags.String("title", "", "title of a new image")
+	dir := flags.String("dir", ".", "directory the files are extracted to")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		return fmt.Errorf(dfsUsage)
+	}
+	path := args[0]
+	args = args[1:]
+
+	if command == "create" {
+		return createImage(path, *tracks, *title)
+	}
+	image, err := dfs.Open(path)
+	if err != nil {
+		return err
+	}
+	volume, err := image.Volume(*side)
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "cat":
+		printCatalogue(volume)
+		return nil
+	case "boot":
+		if len(args) != 1 {
+			return fmt.Errorf(dfsUsage)
+		}
+		option, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		err = volume.SetBoot(option)
+	case "title":
+		if len(args) != 1 {
+			return fmt.Errorf(dfsUsage)
+		}
+		err = volume.SetTitle(args[0])
+	case "extract":
+		return extractFiles(volume, *dir, args)
+	case "add":
+		for _, file := range args {
+			if err = addFile(volume, file); err != nil {
+				break
+			}
+		}
+	case "delete":
+		for _, name := range args {
+			if err = volume.DeleteFile(name); err != nil {
+				break
+			}
+		}
+	case "rename":
+		if len(args) != 2 {
+			return fmt.Errorf(dfsUsage)
+		}
+		err = volume.RenameFile(args[0], args[1])
+	case "lock", "unlock":
+		for _, name := range args {
+			if err = volume.SetLocked(name, command == "lock"); err != nil {
+				break
+			}
+		}
+	case "compact":
+		err = volume.Compact()
+	default:
+		return fmt.Errorf(dfsUsage)
+	}
+	// what was done before an error is kept
+	if flushErr := image.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// Single sided unless the image is a .dsd.
+func createImage(path string, tracks int, title string) error {
+	sides := 1
+	if strings.EqualFold(filepath.Ext(path), ".dsd") {
+		sides = 2
+	}
+	image, err := dfs.Blank(sides, tracks)
+	if err != nil {
+		return err
+	}
+	if title != "" {
+		for side := 0; side < sides; side++ {
+			volume, err := image.Volume(side)
+			if err != nil {
+				return err
+			}
+			if err := volume.SetTitle(title); err != nil {
+				return err
+			}
+		}
+	}
+	return image.Save(path)
+}
+
+func printCatalogue(volume *dfs.Volume) {
+	fmt.Printf("%-12s (%02X)  side %d\n", volume.Title, volume.Cycle, volume.GetSide())
+	fmt.Printf("Option %d (%s), %d sectors, %d free\n",
+		volume.Boot, dfs.BootNames[volume.Boot], volume.Sectors, volume.GetFreeSectors())
+	for _, file := range volume.Files {
+		lock := " "
+		if file.Locked {
+			lock = "L"
+		}
+		fmt.Printf("%-9s %s %06X %06X %06X %03X\n", file.GetFullName(), lock, file.Load, file.Exec, file.Length, file.Start)
+	}
+}
+
+// Characters of DFS names a host may not take in file names.
+var hostNames = strings.NewReplacer("/", "_", "\\", "_", "?", "_", "<", "_", ">", "_", "|", "_")
+
+// Files are written with a .inf sidecar, all of them when no name is given.
+func extractFiles(volume *dfs.Volume, dir string, names []string) error {
+	if len(names) == 0 {
+		for _, file := range volume.Files {
+			names = append(names, file.GetFullName())
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, name := range names {
+		file, data, err := volume.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, hostNames.Replace(file.GetFullName()))
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path+".inf", []byte(dfs.FormatINF(file)), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The name and addresses come from the .inf sidecar when there is one, or
+// from the file name.
+func addFile(volume *dfs.Volume, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file dfs.File
+	if inf, err := os.ReadFile(path + ".inf"); err == nil {
+		if file, err = dfs.ParseINF(string(inf)); err != nil {
+			return fmt.Errorf("%s.inf: %w", path, err)
+		}
+	} else {
+		if file.Directory, file.Name, err = dfs.ParseName(filepath.Base(path)); err != nil {
+			return err
+		}
+	}
+	return volume.AddFile(file, data)
+}
@@ -0,0 +1,18 @@
+package disc
+
+// ADF and ADL images of Acorn ADFS floppies: 16 double density sectors of
+// 256 bytes a track. ADF holds the single sided S and M formats, 40 and 80
+// tracks, ADL the double sided L format with the tracks of both sides
+// interleaved, the order of the ADFS logical sectors.
+
+const ADFSSectorsPerTrack = 16
+
+var (
+	ADF Format = sectorDump{"ADF", 1, ADFSSectorsPerTrack, true}
+	ADL Format = sectorDump{"ADL", 2, ADFSSectorsPerTrack, true}
+)
+
+func init() {
+	registerFormat(".adf", ADF, ADF.(sectorDump).decode)
+	registerFormat(".adl", ADL, ADL.(sectorDump).decode)
+}
@@ -0,0 +1,235 @@
+package disc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Floppy discs as the controllers see them: tracks of sectors, each with
+// the ID field written when it was formatted. Images are loaded into this
+// form and written back from it.
+
+const (
+	SectorSize = 256
+	// Acorn DFS single density layout
+	DFSSectorsPerTrack = 10
+	DFSTrackSize       = DFSSectorsPerTrack * SectorSize
+	// every image is laid out on 40 or 80 tracks
+	Tracks40 = 40
+	Tracks80 = 80
+	// fill byte of freshly formatted sectors
+	FormatFill = 0xE5
+)
+
+type Sector struct {
+	// ID field: logical track, head and sector number, size code of
+	// 128 << SizeCode bytes
+	Track, Head, Number, SizeCode byte
+	Data                          []byte
+	// written with a deleted data address mark
+	Deleted      bool
+	IDCRCError   bool
+	DataCRCError bool
+}
+
+// Bytes the ID field announces, the data read may be shorter or longer on
+// protected discs.
+func (sector *Sector) GetSize() int {
+	return 128 << (sector.SizeCode & 0x07)
+}
+
+type Track struct {
+	// in the order they pass the head from the index hole
+	Sectors       []*Sector
+	DoubleDensity bool
+}
+
+// Sectors are only found by their ID field.
+func (track *Track) Find(logicalTrack, number byte) *Sector {
+	if track == nil {
+		return nil
+	}
+	for _, sector := range track.Sectors {
+		if sector.Track == logicalTrack && sector.Number == number && !sector.IDCRCError {
+			return sector
+		}
+	}
+	return nil
+}
+
+// A standard track: sectors numbered from first, filled with the format
+// byte.
+func NewTrack(track, head byte, sectors int, first byte, size int, doubleDensity bool) *Track {
+	sizeCode := byte(0)
+	for 128<<sizeCode < size {
+		sizeCode++
+	}
+	result := &Track{DoubleDensity: doubleDensity}
+	for i := 0; i < sectors; i++ {
+		data := make([]byte, size)
+		for j := range data {
+			data[j] = FormatFill
+		}
+		result.Sectors = append(result.Sectors, &Sector{
+			Track: track, Head: head, Number: first + byte(i), SizeCode: sizeCode, Data: data,
+		})
+	}
+	return result
+}
+
+// Image formats, to write a disc back to its file.
+type Format interface {
+	GetName() string
+	Encode(disc *Disc) ([]byte, error)
+}
+
+type Disc struct {
+	// tracks by side
+	Tracks         [][]*Track
+	WriteProtected bool
+
+	path     string
+	format   Format
+	modified bool
+}
+
+func New(sides, tracks int) *Disc {
+	disc := &Disc{Tracks: make([][]*Track, sides)}
+	for side := range disc.Tracks {
+		disc.Tracks[side] = make([]*Track, tracks)
+	}
+	return disc
+}
+
+func (disc *Disc) GetSides() int {
+	return len(disc.Tracks)
+}
+
+func (disc *Disc) GetTrackCount() int {
+	if len(disc.Tracks) == 0 {
+		return 0
+	}
+	return len(disc.Tracks[0])
+}
+
+// Nil past the tracks of the disc or on an unformatted track.
+func (disc *Disc) GetTrack(side, track int) *Track {
+	if side < 0 || side >= len(disc.Tracks) || track < 0 || track >= len(disc.Tracks[side]) {
+		return nil
+	}
+	return disc.Tracks[side][track]
+}
+
+// Replaces a track, as formatting does.
+func (disc *Disc) SetTrack(side, track int, content *Track) error {
+	if side < 0 || side >= len(disc.Tracks) || track < 0 || track >= len(disc.Tracks[side]) {
+		return fmt.Errorf("no track %d on side %d", track, side)
+	}
+	disc.Tracks[side][track] = content
+	disc.modified = true
+	return nil
+}
+
+func (disc *Disc) MarkModified() {
+	disc.modified = true
+}
+
+func (disc *Disc) IsModified() bool {
+	return disc.modified
+}
+
+func (disc *Disc) GetPath() string {
+	return disc.path
+}
+
+func (disc *Disc) GetFormat() Format {
+	return disc.format
+}
+
+// The file and format the disc is written back to by Flush.
+func (disc *Disc) SetImage(path string, format Format) {
+	disc.path = path
+	disc.format = format
+}
+
+// Writes the disc back to its image file when it was modified.
+func (disc *Disc) Flush() error {
+	if !disc.modified || disc.path == "" || disc.format == nil {
+		return nil
+	}
+	image, err := disc.format.Encode(disc)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(disc.path, image, 0o644); err != nil {
+		return err
+	}
+	disc.modified = false
+	return nil
+}
+
+type imageFormat struct {
+	format Format
+	decode func(data []byte) (*Disc, error)
+}
+
+var formats = map[string]imageFormat{}
+
+// Registers an image format by file extension.
+func registerFormat(extension string, format Format, decode func(data []byte) (*Disc, error)) {
+	formats[extension] = imageFormat{format, decode}
+}
+
+// Format of the images with the extension.
+func GetFormat(extension string) (Format, error) {
+	registered, ok := formats[strings.ToLower(extension)]
+	if !ok {
+		return nil, fmt.Errorf("unknown disc image format %s", extension)
+	}
+	return registered.format, nil
+}
+
+// Decodes an image of the format given by its extension.
+func Decode(data []byte, extension string) (*Disc, error) {
+	registered, ok := formats[strings.ToLower(extension)]
+	if !ok {
+		return nil, fmt.Errorf("unknown disc image format %s", extension)
+	}
+	disc, err := registered.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	// formats may keep details of the image to write it back
+	if disc.format == nil {
+		disc.format = registered.format
+	}
+	return disc, nil
+}
+
+// Loads an image, the disc is written back to it when flushed.
+func Load(path string) (*Disc, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	disc, err := Decode(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	disc.path = path
+	return disc, nil
+}
+
+// Writes the disc to a new image file, in the format of its extension. The
+// disc is written back there from then on.
+func (disc *Disc) Save(path string) error {
+	format, err := GetFormat(filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	disc.SetImage(path, format)
+	disc.modified = true
+	return disc.Flush()
+}
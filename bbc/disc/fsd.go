@@ -0,0 +1,162 @@
+package disc
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// FSD images of protected discs, single sided and single density. After
+// "FSD", 5 bytes of creation details and a NUL terminated title come the
+// number of the last track, then for each track its number, its sector
+// count and, for formatted tracks, whether it could be read. Each sector
+// has its ID field and, on readable tracks, the size code of the data
+// actually recorded, an error code with the 8271 results and the data.
+
+const fsdMagic = "FSD"
+
+// error codes, as 8271 results
+const (
+	fsdIDCRCError   = 0x0C
+	fsdDataCRCError = 0x0E
+	fsdDeleted      = 0x20
+	fsdReadable     = 0xFF
+)
+
+type fsdImage struct {
+	details []byte
+	title   string
+}
+
+var FSD Format = fsdImage{details: make([]byte, 5)}
+
+func init() {
+	registerFormat(".fsd", FSD, decodeFSD)
+}
+
+func (format fsdImage) GetName() string {
+	return "FSD"
+}
+
+type fsdReader struct {
+	data     []byte
+	position int
+	err      error
+}
+
+func (reader *fsdReader) next(length int) []byte {
+	if reader.err != nil {
+		return make([]byte, length)
+	}
+	if reader.position+length > len(reader.data) {
+		reader.err = fmt.Errorf("FSD image cut at byte %d", len(reader.data))
+		return make([]byte, length)
+	}
+	bytes := reader.data[reader.position : reader.position+length]
+	reader.position += length
+	return bytes
+}
+
+func (reader *fsdReader) byte() byte {
+	return reader.next(1)[0]
+}
+
+func decodeFSD(data []byte) (*Disc, error) {
+	if !bytes.HasPrefix(data, []byte(fsdMagic)) {
+		return nil, fmt.Errorf("not an FSD image")
+	}
+	reader := &fsdReader{data: data, position: len(fsdMagic)}
+	format := fsdImage{details: append([]byte{}, reader.next(5)...)}
+	end := bytes.IndexByte(data[reader.position:], 0)
+	if end < 0 {
+		return nil, fmt.Errorf("FSD title not terminated")
+	}
+	format.title = string(reader.next(end + 1)[:end])
+	disc := New(1, int(reader.byte())+1)
+	for i := 0; i < disc.GetTrackCount() && reader.err == nil; i++ {
+		number := int(reader.byte())
+		sectors := int(reader.byte())
+		track := &Track{}
+		readable := sectors == 0 || reader.byte() == fsdReadable
+		for j := 0; j < sectors; j++ {
+			id := reader.next(4)
+			sector := &Sector{Track: id[0], Head: id[1], Number: id[2], SizeCode: id[3]}
+			if readable {
+				size := 128 << (reader.byte() & 0x07)
+				errors := reader.byte()
+				sector.Deleted = errors&fsdDeleted != 0
+				sector.IDCRCError = errors&0x0F == fsdIDCRCError
+				sector.DataCRCError = errors&0x0F == fsdDataCRCError
+				sector.Data = append([]byte{}, reader.next(size)...)
+			} else {
+				sector.DataCRCError = true
+			}
+			track.Sectors = append(track.Sectors, sector)
+		}
+		if number >= disc.GetTrackCount() {
+			return nil, fmt.Errorf("FSD track %d past the last one", number)
+		}
+		disc.Tracks[0][number] = track
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	disc.format = format
+	return disc, nil
+}
+
+func fsdSizeCode(length int) byte {
+	code := byte(0)
+	for 128<<code < length && code < 7 {
+		code++
+	}
+	return code
+}
+
+// Sectors without data are written as an unreadable track.
+func (format fsdImage) Encode(disc *Disc) ([]byte, error) {
+	if disc.GetSides() != 1 {
+		return nil, fmt.Errorf("FSD images have 1 side, not %d", disc.GetSides())
+	}
+	image := append([]byte(fsdMagic), format.details...)
+	image = append(append(image, format.title...), 0)
+	image = append(image, byte(disc.GetTrackCount()-1))
+	for number, track := range disc.Tracks[0] {
+		image = append(image, byte(number))
+		if track == nil || len(track.Sectors) == 0 {
+			image = append(image, 0)
+			continue
+		}
+		if track.DoubleDensity {
+			return nil, fmt.Errorf("track %d is double density, FSD images are single density", number)
+		}
+		readable := true
+		for _, sector := range track.Sectors {
+			readable = readable && sector.Data != nil
+		}
+		flag := byte(0)
+		if readable {
+			flag = fsdReadable
+		}
+		image = append(image, byte(len(track.Sectors)), flag)
+		for _, sector := range track.Sectors {
+			image = append(image, sector.Track, sector.Head, sector.Number, sector.SizeCode)
+			if !readable {
+				continue
+			}
+			errors := byte(0)
+			if sector.Deleted {
+				errors |= fsdDeleted
+			}
+			if sector.IDCRCError {
+				errors |= fsdIDCRCError
+			} else if sector.DataCRCError {
+				errors |= fsdDataCRCError
+			}
+			code := fsdSizeCode(len(sector.Data))
+			data := make([]byte, 128<<code)
+			copy(data, sector.Data)
+			image = append(append(image, code, errors), data...)
+		}
+	}
+	return image, nil
+}
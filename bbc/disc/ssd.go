@@ -0,0 +1,88 @@
+package disc
+
+import "fmt"
+
+// SSD and DSD images: the sectors of Acorn DFS single density tracks, one
+// after the other. DSD images interleave the tracks of both sides.
+
+type sectorDump struct {
+	name          string
+	sides         int
+	sectors       int
+	doubleDensity bool
+}
+
+var (
+	SSD Format = sectorDump{"SSD", 1, DFSSectorsPerTrack, false}
+	DSD Format = sectorDump{"DSD", 2, DFSSectorsPerTrack, false}
+)
+
+func init() {
+	registerFormat(".ssd", SSD, SSD.(sectorDump).decode)
+	registerFormat(".dsd", DSD, DSD.(sectorDump).decode)
+}
+
+func (format sectorDump) GetName() string {
+	return format.name
+}
+
+func (format sectorDump) trackSize() int {
+	return format.sectors * SectorSize
+}
+
+// Images have 40 or 80 tracks, depending on their size.
+func (format sectorDump) decode(data []byte) (*Disc, error) {
+	if len(data) > Tracks80*format.sides*format.trackSize() {
+		return nil, fmt.Errorf("image of %d bytes is larger than 80 tracks", len(data))
+	}
+	tracks := Tracks80
+	if len(data) <= Tracks40*format.sides*format.trackSize() {
+		tracks = Tracks40
+	}
+	disc := New(format.sides, tracks)
+	for track := 0; track < tracks; track++ {
+		for side := 0; side < format.sides; side++ {
+			// DFS formats both sides with head 0 in the ID fields
+			head := byte(0)
+			if format.doubleDensity {
+				head = byte(side)
+			}
+			content := NewTrack(byte(track), head, format.sectors, 0, SectorSize, format.doubleDensity)
+			offset := (track*format.sides + side) * format.trackSize()
+			for _, sector := range content.Sectors {
+				// images are usually cut after the last used sector
+				for i := range sector.Data {
+					sector.Data[i] = 0
+				}
+				if offset < len(data) {
+					copy(sector.Data, data[offset:])
+				}
+				offset += SectorSize
+			}
+			disc.Tracks[side][track] = content
+		}
+	}
+	return disc, nil
+}
+
+// Images always cover all the tracks of the disc.
+func (format sectorDump) Encode(disc *Disc) ([]byte, error) {
+	if disc.GetSides() != format.sides {
+		return nil, fmt.Errorf("%s images have %d sides, not %d", format.name, format.sides, disc.GetSides())
+	}
+	tracks := disc.GetTrackCount()
+	image := make([]byte, 0, tracks*format.sides*format.trackSize())
+	for track := 0; track < tracks; track++ {
+		for side := 0; side < format.sides; side++ {
+			content := disc.GetTrack(side, track)
+			for number := 0; number < format.sectors; number++ {
+				sector := content.Find(byte(track), byte(number))
+				if sector == nil || len(sector.Data) != SectorSize || content.DoubleDensity != format.doubleDensity {
+					return nil, fmt.Errorf("track %d side %d sector %d cannot be stored in a %s image", track, side, number, format.name)
+				}
+				image = append(image, sector.Data...)
+			}
+		}
+	}
+	return image, nil
+}
@@ -0,0 +1,284 @@
+package econet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Acorn Universal Networking: Econet transfers carried in UDP datagrams
+// with a header of 8 bytes, the type, the port, the control byte without
+// its top bit, a padding byte and a sequence number, little endian. Each
+// station of net 0 listens on 127.0.0.1 at a base port plus its number, so
+// that several emulators talk on the same host.
+//
+// The bridge stands for the rest of the network on the Econet side: the
+// scout and the final acknowledge of the four way handshake are exchanged
+// with the ADLC, the data and its acknowledge go over AUN. Immediate
+// operations, on port 0, are not bridged.
+
+const (
+	DefaultBasePort = 32768
+	// station of the file server stand-in
+	FileServerStation = 254
+
+	aunBroadcast = 1
+	aunData      = 2
+	aunAck       = 3
+	aunNak       = 4
+	aunImmediate = 5
+
+	aunHeaderSize = 8
+	broadcast     = 0xFF
+	// a transfer not acknowledged within the time is given up
+	transferTimeout = 2 * time.Second
+	// data beyond the transfers waiting is dropped, the senders retry
+	aunQueueSize = 16
+)
+
+type packet struct {
+	kind     byte
+	port     byte
+	control  byte
+	sequence uint32
+	data     []byte
+}
+
+func (p packet) encode() []byte {
+	header := []byte{p.kind, p.port, p.control & 0x7F, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(header[4:], p.sequence)
+	return append(header, p.data...)
+}
+
+func decodePacket(datagram []byte) (packet, bool) {
+	if len(datagram) < aunHeaderSize {
+		return packet{}, false
+	}
+	return packet{
+		kind:     datagram[0],
+		port:     datagram[1],
+		control:  datagram[2] | 0x80,
+		sequence: binary.LittleEndian.Uint32(datagram[4:]),
+		data:     append([]byte{}, datagram[aunHeaderSize:]...),
+	}, true
+}
+
+// Address of a station of net 0.
+func stationAddress(basePort int, station byte) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: basePort + int(station)}
+}
+
+// Station sending from the address, 0 if it is none.
+func addressStation(basePort int, addr *net.UDPAddr) byte {
+	station := addr.Port - basePort
+	if !addr.IP.IsLoopback() || station < 1 || station > 254 {
+		return 0
+	}
+	return byte(station)
+}
+
+// Takes the frames for the ADLC.
+type Receiver interface {
+	Receive(frame []byte)
+}
+
+// One transfer of the handshake, from or to the emulated station.
+type transfer struct {
+	station byte
+	port    byte
+	control byte
+	// sequence of the AUN packet, data sent or to be received
+	sequence uint32
+	data     []byte
+	// the data frame is sent, or delivered
+	dataSent bool
+	started  time.Time
+}
+
+type Bridge struct {
+	station  byte
+	basePort int
+	conn     *net.UDPConn
+	adlc     Receiver
+
+	lock     sync.Mutex
+	sequence uint32
+	outgoing *transfer
+	incoming *transfer
+	queue    []*transfer
+}
+
+// Listens for the station, the frames received go to the ADLC.
+func NewBridge(station byte, basePort int, adlc Receiver) (*Bridge, error) {
+	if station == 0 || station >= broadcast {
+		return nil, fmt.Errorf("invalid Econet station %d", station)
+	}
+	conn, err := net.ListenUDP("udp4", stationAddress(basePort, station))
+	if err != nil {
+		return nil, err
+	}
+	bridge := &Bridge{station: station, basePort: basePort, conn: conn, adlc: adlc}
+	go bridge.serve()
+	return bridge, nil
+}
+
+func (bridge *Bridge) Close() error {
+	return bridge.conn.Close()
+}
+
+func (bridge *Bridge) send(station byte, p packet) {
+	bridge.conn.WriteToUDP(p.encode(), stationAddress(bridge.basePort, station))
+}
+
+// Frame to the emulated station from the station, on net 0.
+func (bridge *Bridge) frame(station byte, data ...byte) []byte {
+	return append([]byte{bridge.station, 0, station, 0}, data...)
+}
+
+// Frame transmitted by the ADLC.
+func (bridge *Bridge) Transmit(frame []byte) {
+	if len(frame) < 4 {
+		return
+	}
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	bridge.expire()
+	destination, data := frame[0], frame[4:]
+
+	// acknowledges of the transfer delivered
+	if incoming := bridge.incoming; incoming != nil && len(data) == 0 && destination == incoming.station {
+		if !incoming.dataSent {
+			incoming.dataSent = true
+			bridge.adlc.Receive(bridge.frame(incoming.station, incoming.data...))
+			return
+		}
+		bridge.send(incoming.station, packet{kind: aunAck, port: incoming.port, control: incoming.control,
+			sequence: incoming.sequence})
+		bridge.incoming = nil
+		bridge.deliverNext()
+		return
+	}
+
+	if outgoing := bridge.outgoing; outgoing != nil && !outgoing.dataSent && destination == outgoing.station {
+		outgoing.dataSent = true
+		outgoing.started = time.Now()
+		bridge.sequence += 4
+		outgoing.sequence = bridge.sequence
+		bridge.send(outgoing.station, packet{kind: aunData, port: outgoing.port, control: outgoing.control,
+			sequence: outgoing.sequence, data: data})
+		return
+	}
+
+	// a scout
+	if len(data) < 2 {
+		return
+	}
+	control, port := data[0], data[1]
+	if destination == broadcast {
+		bridge.sequence += 4
+		p := packet{kind: aunBroadcast, port: port, control: control, sequence: bridge.sequence, data: data[2:]}
+		for station := 1; station < broadcast; station++ {
+			if byte(station) != bridge.station {
+				bridge.send(byte(station), p)
+			}
+		}
+		return
+	}
+	bridge.outgoing = nil
+	if port == 0 {
+		return
+	}
+	bridge.outgoing = &transfer{station: destination, port: port, control: control, started: time.Now()}
+	bridge.adlc.Receive(bridge.frame(destination))
+}
+
+// Gives up the transfers not acknowledged in time.
+func (bridge *Bridge) expire() {
+	now := time.Now()
+	if bridge.outgoing != nil && now.Sub(bridge.outgoing.started) > transferTimeout {
+		bridge.outgoing = nil
+	}
+	if bridge.incoming != nil && now.Sub(bridge.incoming.started) > transferTimeout {
+		bridge.incoming = nil
+		bridge.deliverNext()
+	}
+}
+
+// Sends the scout of the next transfer waiting.
+func (bridge *Bridge) deliverNext() {
+	if bridge.incoming != nil || len(bridge.queue) == 0 {
+		return
+	}
+	next := bridge.queue[0]
+	bridge.queue = bridge.queue[1:]
+	next.started = time.Now()
+	bridge.incoming = next
+	bridge.adlc.Receive(bridge.frame(next.station, next.control, next.port))
+}
+
+// A retransmitted packet is already delivered or waiting.
+func (bridge *Bridge) isPending(station byte, sequence uint32) bool {
+	if incoming := bridge.incoming; incoming != nil && incoming.station == station && incoming.sequence == sequence {
+		return true
+	}
+	for _, queued := range bridge.queue {
+		if queued.station == station && queued.sequence == sequence {
+			return true
+		}
+	}
+	return false
+}
+
+func (bridge *Bridge) serve() {
+	buffer := make([]byte, 0x10000)
+	for {
+		bridge.conn.SetReadDeadline(time.Now().Add(transferTimeout / 4))
+		n, addr, err := bridge.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if timeout, ok := err.(net.Error); ok && timeout.Timeout() {
+				bridge.lock.Lock()
+				bridge.expire()
+				bridge.lock.Unlock()
+				continue
+			}
+			return
+		}
+		station := addressStation(bridge.basePort, addr)
+		p, ok := decodePacket(buffer[:n])
+		if station == 0 || station == bridge.station || !ok {
+			continue
+		}
+		bridge.lock.Lock()
+		bridge.expire()
+		bridge.receive(station, p)
+		bridge.lock.Unlock()
+	}
+}
+
+func (bridge *Bridge) receive(station byte, p packet) {
+	switch p.kind {
+	case aunBroadcast:
+		frame := append([]byte{broadcast, broadcast, station, 0, p.control, p.port}, p.data...)
+		bridge.adlc.Receive(frame)
+	case aunData:
+		if bridge.isPending(station, p.sequence) || len(bridge.queue) >= aunQueueSize {
+			return
+		}
+		bridge.queue = append(bridge.queue, &transfer{station: station, port: p.port, control: p.control,
+			sequence: p.sequence, data: p.data})
+		bridge.deliverNext()
+	case aunAck, aunNak:
+		outgoing := bridge.outgoing
+		if outgoing == nil || !outgoing.dataSent || outgoing.station != station || outgoing.sequence != p.sequence {
+			return
+		}
+		bridge.outgoing = nil
+		if p.kind == aunAck {
+			bridge.adlc.Receive(bridge.frame(station))
+		}
+	case aunImmediate:
+		// not bridged, the sender times out
+	}
+}
@@ -0,0 +1,402 @@
+package econet
+
+import (
+	"bbc/dfs"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// File server stand-in, enough of the NetFS protocol for a station to log
+// on with *I AM and list the files with *CAT, *EX and *INFO. It serves a
+// single read only directory, $, with no passwords. Requests come on port
+// 99, starting with the reply port, the function and the handles of the
+// user root, current and library directories. Replies start with a command
+// code for the client and a return code, an error number followed by its
+// message when not 0.
+
+const (
+	FileServerPort = 0x99
+
+	// handles of $ given at logon, as the user root, current and library
+	// directories
+	rootHandle    = 1
+	currentHandle = 2
+	libraryHandle = 3
+
+	discName = "STANDIN"
+)
+
+// functions
+const (
+	fsCommandLine     = 0
+	fsExamine         = 3
+	fsReadDiscs       = 14
+	fsReadObjectInfo  = 18
+	fsReadEnvironment = 21
+	fsLogOff          = 23
+	fsReadVersion     = 25
+)
+
+// command codes of the command line replies
+const (
+	codeNone = 0
+	codeCat  = 3
+	codeInfo = 4
+	codeIAm  = 5
+	codeDir  = 7
+	codeLib  = 9
+)
+
+// access byte
+const (
+	accessPublicRead = 0x01
+	accessOwnerRead  = 0x04
+	accessOwnerWrite = 0x08
+	accessLocked     = 0x10
+)
+
+type fsError struct {
+	number  byte
+	message string
+}
+
+var (
+	errBadCommand = fsError{0xFE, "Bad command"}
+	errWhoAreYou  = fsError{0xBF, "Who are you ?"}
+	errNotFound   = fsError{0xD6, "Not found"}
+	errBadName    = fsError{0xCC, "Bad file name"}
+)
+
+type File struct {
+	Name   string
+	Load   uint32
+	Exec   uint32
+	Data   []byte
+	Locked bool
+}
+
+type FileServer struct {
+	files []File
+
+	lock  sync.Mutex
+	users map[byte]string
+
+	conn     *net.UDPConn
+	basePort int
+}
+
+func NewFileServer(files []File) *FileServer {
+	sorted := append([]File{}, files...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.ToUpper(sorted[i].Name) < strings.ToUpper(sorted[j].Name)
+	})
+	return &FileServer{files: sorted, users: map[byte]string{}}
+}
+
+// Files of a host directory, with their addresses from the NAME.inf files
+// next to them when there are.
+func LoadFiles(dir string) ([]File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []File{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(strings.ToLower(name), ".inf") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		file := File{Name: name, Data: data}
+		if inf, err := os.ReadFile(filepath.Join(dir, name+".inf")); err == nil {
+			attributes, err := dfs.ReadINF(string(inf))
+			if err != nil {
+				return nil, fmt.Errorf("%s.inf: %w", name, err)
+			}
+			file.Load, file.Exec, file.Locked = attributes.Load, attributes.Exec, attributes.Locked
+		}
+		if len(file.Name) > 10 {
+			file.Name = file.Name[:10]
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Serves the stations over AUN, as the station of net 0 on the base port.
+func (fs *FileServer) Listen(station byte, basePort int) error {
+	conn, err := net.ListenUDP("udp4", stationAddress(basePort, station))
+	if err != nil {
+		return err
+	}
+	fs.conn, fs.basePort = conn, basePort
+	go fs.serve()
+	return nil
+}
+
+func (fs *FileServer) Close() error {
+	if fs.conn == nil {
+		return nil
+	}
+	return fs.conn.Close()
+}
+
+// The requests are acknowledged, then answered once.
+func (fs *FileServer) serve() {
+	buffer := make([]byte, 0x10000)
+	sequence := uint32(0)
+	for {
+		n, addr, err := fs.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		station := addressStation(fs.basePort, addr)
+		p, ok := decodePacket(buffer[:n])
+		if station == 0 || !ok || p.kind != aunData {
+			continue
+		}
+		ack := packet{kind: aunAck, port: p.port, control: p.control, sequence: p.sequence}
+		fs.conn.WriteToUDP(ack.encode(), addr)
+		if p.port != FileServerPort {
+			continue
+		}
+		replyPort, reply, ok := fs.Handle(station, p.data)
+		if !ok {
+			continue
+		}
+		sequence += 4
+		answer := packet{kind: aunData, port: replyPort, control: 0x80, sequence: sequence, data: reply}
+		fs.conn.WriteToUDP(answer.encode(), addr)
+	}
+}
+
+// Answers a request from the station, returns the port of the reply.
+func (fs *FileServer) Handle(station byte, request []byte) (byte, []byte, bool) {
+	if len(request) < 5 {
+		return 0, nil, false
+	}
+	replyPort, function, data := request[0], request[1], request[5:]
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	reply, err := fs.function(station, function, data)
+	if err != nil {
+		return replyPort, append([]byte{codeNone, err.number}, append([]byte(err.message), '\r')...), true
+	}
+	return replyPort, reply, true
+}
+
+func (fs *FileServer) function(station byte, function byte, data []byte) ([]byte, *fsError) {
+	if function == fsCommandLine {
+		return fs.commandLine(station, text(data))
+	}
+	if _, ok := fs.users[station]; !ok {
+		return nil, &errWhoAreYou
+	}
+	switch function {
+	case fsExamine:
+		if len(data) < 3 {
+			return nil, &errBadCommand
+		}
+		return fs.examine(data[0], int(data[1]), int(data[2]), text(data[3:]))
+	case fsReadDiscs:
+		name := fmt.Sprintf("%-16s", discName)
+		return append([]byte{codeNone, 0, 1, 0}, name...), nil
+	case fsReadObjectInfo:
+		if len(data) < 1 {
+			return nil, &errBadCommand
+		}
+		return fs.objectInfo(data[0], text(data[1:]))
+	case fsReadEnvironment:
+		reply := []byte{codeNone, 0, 16}
+		reply = append(reply, fmt.Sprintf("%-16s%-10s%-10s", discName, "$", "$")...)
+		return reply, nil
+	case fsLogOff:
+		delete(fs.users, station)
+		return []byte{codeNone, 0}, nil
+	case fsReadVersion:
+		return append([]byte{codeNone, 0}, "Stand-in 1.00\r"...), nil
+	}
+	return nil, &errBadCommand
+}
+
+// Text up to the CR.
+func text(data []byte) string {
+	if end := bytes.IndexByte(data, '\r'); end >= 0 {
+		data = data[:end]
+	}
+	return string(data)
+}
+
+// Commands are matched in full or abbreviated with a full stop.
+func command(line string, name string) (string, bool) {
+	line = strings.TrimLeft(line, " *")
+	if strings.HasPrefix(strings.ToUpper(line), name) && (len(line) == len(name) || line[len(name)] == ' ') {
+		return strings.TrimSpace(line[len(name):]), true
+	}
+	word, rest, _ := strings.Cut(line, " ")
+	abbreviation, ok := strings.CutSuffix(strings.ToUpper(word), ".")
+	if ok && abbreviation != "" && strings.HasPrefix(name, abbreviation) {
+		return strings.TrimSpace(rest), true
+	}
+	return "", false
+}
+
+func (fs *FileServer) commandLine(station byte, line string) ([]byte, *fsError) {
+	if rest, ok := command(line, "I AM"); ok {
+		user, _, _ := strings.Cut(rest, " ")
+		if user == "" {
+			return nil, &errBadCommand
+		}
+		fs.users[station] = strings.ToUpper(user)
+		return []byte{codeIAm, 0, rootHandle, currentHandle, libraryHandle, 0}, nil
+	}
+	if _, ok := command(line, "BYE"); ok {
+		delete(fs.users, station)
+		return []byte{codeNone, 0}, nil
+	}
+	if _, ok := fs.users[station]; !ok {
+		return nil, &errWhoAreYou
+	}
+	if rest, ok := command(line, "CAT"); ok {
+		return append([]byte{codeCat, 0}, rest+"\r"...), nil
+	}
+	if rest, ok := command(line, "INFO"); ok {
+		file := fs.find(rest)
+		if file == nil {
+			return nil, &errNotFound
+		}
+		return append([]byte{codeInfo, 0}, info(file)+"\r"...), nil
+	}
+	if rest, ok := command(line, "DIR"); ok {
+		if rest != "" && rest != "$" {
+			return nil, &errNotFound
+		}
+		return []byte{codeDir, 0, currentHandle}, nil
+	}
+	if rest, ok := command(line, "LIB"); ok {
+		if rest != "" && rest != "$" {
+			return nil, &errNotFound
+		}
+		return []byte{codeLib, 0, libraryHandle}, nil
+	}
+	return nil, &errBadCommand
+}
+
+func (fs *FileServer) find(name string) *File {
+	name = strings.TrimPrefix(strings.ToUpper(name), "$.")
+	for i := range fs.files {
+		if strings.ToUpper(fs.files[i].Name) == name {
+			return &fs.files[i]
+		}
+	}
+	return nil
+}
+
+func access(file *File) byte {
+	value := byte(accessOwnerRead | accessOwnerWrite | accessPublicRead)
+	if file.Locked {
+		value |= accessLocked
+	}
+	return value
+}
+
+func accessString(file *File) string {
+	if file.Locked {
+		return "LWR/r"
+	}
+	return "WR/r"
+}
+
+func info(file *File) string {
+	return fmt.Sprintf("%-10s %08X %08X   %06X %-6s", file.Name, file.Load, file.Exec,
+		len(file.Data), accessString(file))
+}
+
+func isRoot(dir string) bool {
+	return dir == "" || dir == "$" || dir == "&" || dir == "@"
+}
+
+// Entries of the directory from the start: all their information
+// machine readable or as text, their names, or their names and access.
+func (fs *FileServer) examine(kind byte, start, count int, dir string) ([]byte, *fsError) {
+	if !isRoot(dir) {
+		return nil, &errNotFound
+	}
+	entries := []byte{}
+	returned := 0
+	for i := start; i < len(fs.files) && returned < count; i++ {
+		file := &fs.files[i]
+		switch kind {
+		case 0:
+			entry := []byte(fmt.Sprintf("%-10s", file.Name))
+			entry = append(entry, le(file.Load, 4)...)
+			entry = append(entry, le(file.Exec, 4)...)
+			entry = append(entry, access(file), 0, 0, 0, 0, 0)
+			entry = append(entry, le(uint32(len(file.Data)), 3)...)
+			entries = append(entries, entry...)
+		case 1:
+			entries = append(append(entries, info(file)...), 0)
+		case 2:
+			entries = append(append(entries, 10), fmt.Sprintf("%-10s", file.Name)...)
+		case 3:
+			entries = append(append(entries, fmt.Sprintf("%-10s %-6s", file.Name, accessString(file))...), 0)
+		default:
+			return nil, &errBadCommand
+		}
+		returned++
+	}
+	reply := append([]byte{codeNone, 0, byte(returned), 0}, entries...)
+	return append(reply, 0x80), nil
+}
+
+func (fs *FileServer) objectInfo(kind byte, name string) ([]byte, *fsError) {
+	if kind == 6 {
+		if !isRoot(name) {
+			return nil, &errNotFound
+		}
+		reply := []byte{codeNone, 0, 0, 10}
+		reply = append(reply, fmt.Sprintf("%-10s", "$")...)
+		return append(reply, 0, 0), nil
+	}
+	file := fs.find(name)
+	if file == nil {
+		// object type 0, not found
+		return []byte{codeNone, 0, 0}, nil
+	}
+	reply := []byte{codeNone, 0, 1}
+	switch kind {
+	case 1:
+		reply = append(reply, 0, 0)
+	case 2:
+		reply = append(append(reply, le(file.Load, 4)...), le(file.Exec, 4)...)
+	case 3:
+		reply = append(reply, le(uint32(len(file.Data)), 3)...)
+	case 4:
+		reply = append(reply, access(file))
+	case 5:
+		reply = append(append(reply, le(file.Load, 4)...), le(file.Exec, 4)...)
+		reply = append(reply, le(uint32(len(file.Data)), 3)...)
+		reply = append(reply, access(file), 0, 0)
+	default:
+		return nil, &errBadName
+	}
+	return reply, nil
+}
+
+// Little endian value in the number of bytes.
+func le(value uint32, size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(value >> (8 * i))
+	}
+	return data
+}
@@ -0,0 +1,386 @@
+package hardware
+
+import (
+	"bbc/utils"
+)
+
+// Motorola MC6850 ACIA, the serial interface of the cassette and RS423
+// ports. The control and status registers are at even addresses, the data
+// registers at odd ones. Its transmit and receive clocks come from the
+// Serial ULA, divided by 1, 16 or 64 to the bit rate.
+
+// status register
+const (
+	ACIAReceiveFull  = 0x01
+	ACIATransmitFree = 0x02
+	ACIACarrierLost  = 0x04
+	ACIANotClear     = 0x08
+	ACIAFraming      = 0x10
+	ACIAOverrun      = 0x20
+	ACIAParity       = 0x40
+	ACIAInterrupt    = 0x80
+)
+
+// control register
+const (
+	aciaDivideMask     = 0x03
+	aciaMasterReset    = 0x03
+	aciaWordMask       = 0x1C
+	aciaTransmitMask   = 0x60
+	aciaTransmitIRQ    = 0x20
+	aciaRTSHigh        = 0x40
+	aciaTransmitBreak  = 0x60
+	aciaReceiveIRQ     = 0x80
+	aciaDefaultControl = aciaMasterReset
+)
+
+var aciaDividers = [3]int{1, 16, 64}
+
+// data bits, parity and stop bits of the word select values
+type aciaWord struct {
+	bits   int
+	parity byte
+	stop   int
+}
+
+var aciaWords = [8]aciaWord{
+	{7, 'E', 2}, {7, 'O', 2}, {7, 'E', 1}, {7, 'O', 1},
+	{8, 'N', 2}, {8, 'N', 1}, {8, 'E', 1}, {8, 'O', 1},
+}
+
+type ACIA struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+
+	control  byte
+	status   byte
+	receive  byte
+	transmit byte
+
+	// DCD and CTS input pins, high when the carrier is lost or sending is
+	// not allowed
+	dcd bool
+	cts bool
+	// carrier loss is latched until the status then data registers are read
+	dcdLatched bool
+	dcdRead    bool
+
+	// receiver: clocks to the middle of the next bit, -1 is the start bit
+	rxActive  bool
+	rxCount   int
+	rxBit     int
+	rxShift   uint16
+	rxLastRxD bool
+
+	// transmitter: bits left to send, from the least significant
+	txCount   int
+	txBits    int
+	txShift   uint32
+	txd       bool
+	irqRaised bool
+}
+
+func (acia *ACIA) GetName() string            { return acia.name }
+func (acia *ACIA) PlugToBus(bus *Bus)         { acia.bus = bus }
+func (acia *ACIA) IsWritable() bool           { return true }
+func (acia *ACIA) IsReadable() bool           { return true }
+func (acia *ACIA) GetSegment() *utils.Segment { return acia.segment }
+func (acia *ACIA) GetBusDomain() string       { return DomainPeripheral }
+
+func (acia *ACIA) Start() error {
+	return nil
+}
+
+func (acia *ACIA) Reset() error {
+	acia.control = aciaDefaultControl
+	acia.masterReset()
+	return nil
+}
+
+func (acia *ACIA) Stop() error {
+	return nil
+}
+
+func (acia *ACIA) masterReset() {
+	acia.status = ACIATransmitFree
+	acia.dcdLatched = false
+	acia.dcdRead = false
+	acia.rxActive = false
+	acia.txBits = 0
+	acia.txd = true
+	acia.updateIRQ()
+}
+
+func (acia *ACIA) divider() int {
+	return aciaDividers[acia.control&aciaDivideMask]
+}
+
+func (acia *ACIA) word() aciaWord {
+	return aciaWords[(acia.control&aciaWordMask)>>2]
+}
+
+func (acia *ACIA) GetStatus() byte {
+	status := acia.status
+	if acia.dcdLatched || acia.dcd {
+		status |= ACIACarrierLost
+	}
+	if acia.cts {
+		status |= ACIANotClear
+		// the transmit register looks full while sending is not allowed
+		status &^= ACIATransmitFree
+	}
+	if acia.interrupt() {
+		status |= ACIAInterrupt
+	}
+	return status
+}
+
+func (acia *ACIA) GetControl() byte {
+	return acia.control
+}
+
+func (acia *ACIA) interrupt() bool {
+	if acia.control&aciaReceiveIRQ != 0 && (acia.status&(ACIAReceiveFull|ACIAOverrun) != 0 || acia.dcdLatched) {
+		return true
+	}
+	return acia.control&aciaTransmitMask == aciaTransmitIRQ && acia.status&ACIATransmitFree != 0 && !acia.cts
+}
+
+func (acia *ACIA) updateIRQ() {
+	irq := acia.interrupt()
+	if irq != acia.irqRaised && acia.bus != nil {
+		acia.bus.IRQ.Set(acia.name, irq)
+	}
+	acia.irqRaised = irq
+}
+
+// RTS output, low when the ACIA is ready.
+func (acia *ACIA) GetRTS() bool {
+	return acia.control&aciaTransmitMask == aciaRTSHigh
+}
+
+// Data carrier detect input, a rising edge latches the carrier lost status.
+func (acia *ACIA) SetDCD(level bool) {
+	if level && !acia.dcd {
+		acia.dcdLatched = true
+		acia.dcdRead = false
+	}
+	acia.dcd = level
+	acia.updateIRQ()
+}
+
+// Clear to send input, high inhibits the transmitter.
+func (acia *ACIA) SetCTS(level bool) {
+	acia.cts = level
+	acia.updateIRQ()
+}
+
+// One receive clock edge, sampling the RxD level.
+func (acia *ACIA) ReceiveClock(rxd bool) {
+	if acia.control&aciaDivideMask == aciaMasterReset {
+		return
+	}
+	falling := acia.rxLastRxD && !rxd
+	acia.rxLastRxD = rxd
+	divider := acia.divider()
+	if !acia.rxActive {
+		// a falling edge starts a frame, bits are sampled in their middle
+		if !falling {
+			return
+		}
+		acia.rxActive = true
+		acia.rxBit = -1
+		acia.rxShift = 0
+		acia.rxCount = divider / 2
+		if acia.rxCount > 0 {
+			return
+		}
+	} else {
+		acia.rxCount--
+		if acia.rxCount > 0 {
+			return
+		}
+	}
+	acia.rxCount = divider
+	acia.sampleBit(rxd)
+}
+
+func (acia *ACIA) sampleBit(rxd bool) {
+	word := acia.word()
+	if acia.rxBit < 0 {
+		// false start bits are ignored
+		if rxd {
+			acia.rxActive = false
+		}
+		acia.rxBit = 0
+		return
+	}
+	frameBits := word.bits
+	if word.parity != 'N' {
+		frameBits++
+	}
+	if acia.rxBit < frameBits {
+		if rxd {
+			acia.rxShift |= 1 << acia.rxBit
+		}
+		acia.rxBit++
+		return
+	}
+
+	// first stop bit, the others are not checked
+	acia.rxActive = false
+	data := byte(acia.rxShift & (1<<word.bits - 1))
+	if acia.status&ACIAReceiveFull != 0 {
+		acia.status |= ACIAOverrun
+		acia.updateIRQ()
+		return
+	}
+	acia.receive = data
+	acia.status |= ACIAReceiveFull
+	acia.status &^= ACIAFraming | ACIAParity
+	if !rxd {
+		acia.status |= ACIAFraming
+	}
+	if word.parity != 'N' {
+		ones := 0
+		for shift := acia.rxShift; shift != 0; shift >>= 1 {
+			ones += int(shift & 1)
+		}
+		// the parity bit is counted with the data
+		if (word.parity == 'E') != (ones%2 == 0) {
+			acia.status |= ACIAParity
+		}
+	}
+	acia.updateIRQ()
+}
+
+// One transmit clock edge, returns the TxD level.
+func (acia *ACIA) TransmitClock() bool {
+	if acia.control&aciaDivideMask == aciaMasterReset {
+		return true
+	}
+	if acia.txCount > 1 {
+		acia.txCount--
+		return acia.txd
+	}
+	acia.txCount = acia.divider()
+
+	if acia.txBits == 0 && acia.status&ACIATransmitFree == 0 && !acia.cts {
+		acia.loadTransmitter()
+	}
+	switch {
+	case acia.control&aciaTransmitMask == aciaTransmitBreak:
+		acia.txd = false
+	case acia.txBits > 0:
+		acia.txd = acia.txShift&1 != 0
+		acia.txShift >>= 1
+		acia.txBits--
+	default:
+		acia.txd = true
+	}
+	return acia.txd
+}
+
+// Start bit, data, parity and stop bits of a frame, from the least
+// significant.
+func (word aciaWord) frame(value byte) (uint32, int) {
+	value &= 1<<word.bits - 1
+	shift := uint32(value) << 1
+	bits := 1 + word.bits
+	if word.parity != 'N' {
+		ones := 0
+		for rest := value; rest != 0; rest >>= 1 {
+			ones += int(rest & 1)
+		}
+		if (word.parity == 'E') == (ones%2 == 1) {
+			shift |= 1 << bits
+		}
+		bits++
+	}
+	for i := 0; i < word.stop; i++ {
+		shift |= 1 << bits
+		bits++
+	}
+	return shift, bits
+}
+
+func (acia *ACIA) loadTransmitter() {
+	acia.txShift, acia.txBits = acia.word().frame(acia.transmit)
+	acia.status |= ACIATransmitFree
+	acia.updateIRQ()
+}
+
+// The transmit clock is halfway through a bit.
+func (acia *ACIA) isMidBit() bool {
+	return acia.control&aciaDivideMask != aciaMasterReset && acia.txCount == (acia.divider()+1)/2
+}
+
+// True while a frame is being sent or waits in the transmit register.
+func (acia *ACIA) IsTransmitting() bool {
+	return acia.txBits > 0 || acia.status&ACIATransmitFree == 0
+}
+
+func (acia *ACIA) read(addr uint16) byte {
+	if addr&1 == 0 {
+		acia.dcdRead = acia.dcdLatched
+		return acia.GetStatus()
+	}
+	if acia.dcdRead {
+		acia.dcdLatched = false
+		acia.dcdRead = false
+	}
+	acia.status &^= ACIAReceiveFull | ACIAOverrun
+	acia.updateIRQ()
+	return acia.receive
+}
+
+func (acia *ACIA) write(value byte, addr uint16) {
+	if addr&1 == 0 {
+		acia.control = value
+		if value&aciaDivideMask == aciaMasterReset {
+			acia.masterReset()
+		}
+		acia.updateIRQ()
+		return
+	}
+	acia.transmit = value
+	acia.status &^= ACIATransmitFree
+	acia.updateIRQ()
+}
+
+func (acia *ACIA) DirectRead(addr uint16) (byte, error) {
+	return acia.read(addr), nil
+}
+
+func (acia *ACIA) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := acia.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func (acia *ACIA) DirectWrite(value byte, addr uint16) error {
+	acia.write(value, addr)
+	return nil
+}
+
+func (acia *ACIA) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := acia.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+func NewACIA(name string, segment *utils.Segment) *ACIA {
+	acia := &ACIA{
+		name:      name,
+		segment:   segment,
+		rxLastRxD: true,
+	}
+	acia.Reset()
+	return acia
+}
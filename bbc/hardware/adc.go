@@ -0,0 +1,211 @@
+package hardware
+
+import (
+	"bbc/utils"
+)
+
+// NEC uPD7002 analogue to digital converter at FEC0, reading the analogue
+// port where the joysticks plug. Writing FEC0 starts a conversion of a
+// channel, its end is signalled on the System VIA CB1, active low. The
+// status is read from FEC0 and the result from FEC1 and FEC2, left
+// justified. The fire buttons of the port go to the System VIA PB4 and PB5.
+
+// status register
+const (
+	UPD7002Channel   = 0x03
+	UPD7002Flag      = 0x04
+	UPD7002TenBit    = 0x08
+	UPD7002ResultMSB = 0x30
+	UPD7002NotBusy   = 0x40
+	// set until the conversion ends
+	UPD7002NotEOC = 0x80
+)
+
+const (
+	// microseconds of a conversion
+	upd7002EightBitTime = 4000
+	upd7002TenBitTime   = 10000
+
+	upd7002FireButton0 = 0x10
+	upd7002FireButton1 = 0x20
+)
+
+type UPD7002 struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+	via     *VIA
+
+	inputs  [4]uint16
+	buttons byte
+
+	status    byte
+	result    uint16
+	remaining uint64
+}
+
+func (adc *UPD7002) GetName() string            { return adc.name }
+func (adc *UPD7002) PlugToBus(bus *Bus)         { adc.bus = bus }
+func (adc *UPD7002) IsWritable() bool           { return true }
+func (adc *UPD7002) IsReadable() bool           { return true }
+func (adc *UPD7002) GetSegment() *utils.Segment { return adc.segment }
+func (adc *UPD7002) GetDomain() string          { return DomainPeripheral }
+func (adc *UPD7002) GetBusDomain() string       { return DomainPeripheral }
+
+func (adc *UPD7002) Start() error {
+	return nil
+}
+
+func (adc *UPD7002) Reset() error {
+	adc.status = UPD7002NotBusy
+	adc.result = 0
+	adc.remaining = 0
+	adc.via.SetCB1(true)
+	return nil
+}
+
+func (adc *UPD7002) Stop() error {
+	return nil
+}
+
+// Voltage on a channel, from 0 for 0V to FFFF for the reference.
+func (adc *UPD7002) SetChannel(channel int, value uint16) {
+	adc.inputs[channel&UPD7002Channel] = value
+}
+
+func (adc *UPD7002) GetChannel(channel int) uint16 {
+	return adc.inputs[channel&UPD7002Channel]
+}
+
+// Joystick 0 is on channels 0 and 1, joystick 1 on 2 and 3. Positions go
+// from -1 to 1, left to right and down to up: the values fall to the
+// right and rise upwards.
+func (adc *UPD7002) SetJoystick(joystick int, x, y float64) {
+	channel := (joystick & 0x01) * 2
+	adc.SetChannel(channel, axisValue(-x))
+	adc.SetChannel(channel+1, axisValue(y))
+}
+
+func axisValue(position float64) uint16 {
+	if position < -1 {
+		position = -1
+	} else if position > 1 {
+		position = 1
+	}
+	return uint16((position + 1) / 2 * 0xFFFF)
+}
+
+func (adc *UPD7002) SetFireButton(joystick int, pressed bool) {
+	button := byte(upd7002FireButton0)
+	if joystick&0x01 != 0 {
+		button = upd7002FireButton1
+	}
+	if pressed {
+		adc.buttons |= button
+	} else {
+		adc.buttons &^= button
+	}
+	adc.via.UpdateInputs()
+}
+
+func (adc *UPD7002) IsFireButtonPressed(joystick int) bool {
+	if joystick&0x01 != 0 {
+		return adc.buttons&upd7002FireButton1 != 0
+	}
+	return adc.buttons&upd7002FireButton0 != 0
+}
+
+// The buttons pull their port B lines low.
+func (adc *UPD7002) ReadPort() byte {
+	return ^adc.buttons
+}
+
+func (adc *UPD7002) WritePort(value, ddr byte) {}
+
+func (adc *UPD7002) IsConverting() bool {
+	return adc.remaining > 0
+}
+
+func (adc *UPD7002) startConversion(value byte) {
+	adc.status = value&(UPD7002Channel|UPD7002Flag|UPD7002TenBit) | UPD7002NotEOC
+	adc.remaining = upd7002EightBitTime
+	if value&UPD7002TenBit != 0 {
+		adc.remaining = upd7002TenBitTime
+	}
+	adc.via.SetCB1(true)
+}
+
+func (adc *UPD7002) endConversion() {
+	mask := uint16(0xFF00)
+	if adc.status&UPD7002TenBit != 0 {
+		mask = 0xFFC0
+	}
+	adc.result = adc.inputs[adc.status&UPD7002Channel] & mask
+	adc.status = adc.status&(UPD7002Channel|UPD7002Flag|UPD7002TenBit) | UPD7002NotBusy | byte(adc.result>>10)&UPD7002ResultMSB
+	adc.via.SetCB1(false)
+}
+
+func (adc *UPD7002) Step(ticks uint64) error {
+	if adc.remaining == 0 {
+		return nil
+	}
+	if ticks < adc.remaining {
+		adc.remaining -= ticks
+		return nil
+	}
+	adc.remaining = 0
+	adc.endConversion()
+	return nil
+}
+
+func (adc *UPD7002) read(addr uint16) byte {
+	switch addr & 0x03 {
+	case 0:
+		return adc.status
+	case 1:
+		return byte(adc.result >> 8)
+	case 2:
+		return byte(adc.result)
+	}
+	return 0
+}
+
+func (adc *UPD7002) DirectRead(addr uint16) (byte, error) {
+	return adc.read(addr), nil
+}
+
+func (adc *UPD7002) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := adc.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+// Only the writes to FEC0 do anything.
+func (adc *UPD7002) DirectWrite(value byte, addr uint16) error {
+	if addr&0x03 == 0 {
+		adc.startConversion(value)
+	}
+	return nil
+}
+
+func (adc *UPD7002) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := adc.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+// Inputs rest at the middle, as centred joysticks.
+func NewUPD7002(name string, segment *utils.Segment, via *VIA) *UPD7002 {
+	adc := &UPD7002{name: name, segment: segment, via: via}
+	for channel := range adc.inputs {
+		adc.inputs[channel] = 0x8000
+	}
+	via.ConnectPortB(adc)
+	adc.Reset()
+	return adc
+}
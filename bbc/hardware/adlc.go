@@ -0,0 +1,547 @@
+package hardware
+
+import (
+	"bbc/utils"
+	"sync"
+)
+
+// Motorola MC68B54 ADLC at FEA0, the Econet interface. Frames go to and
+// come from a network a byte at a time at the rate of the Econet clock,
+// through FIFOs of 3 bytes. The status registers are read from FEA0 and
+// FEA1, the receive FIFO from FEA2 and FEA3. CR1 is written at FEA0, CR2
+// or CR3 at FEA1 and the transmit FIFO at FEA2, terminating the frame or
+// CR4 at FEA3. The interrupt goes to the NMI, gated by the station links
+// at FE18: reading them disables it, INTOFF, reading FE20 enables it,
+// INTON.
+//
+// Without a network there is no clock: CTS and DCD stay high.
+
+// status register 1
+const (
+	ADLCReceiveAvailable  = 0x01
+	ADLCStatus2Request    = 0x02
+	ADLCLoop              = 0x04
+	ADLCFlagDetected      = 0x08
+	ADLCNotClear          = 0x10
+	ADLCTransmitUnderrun  = 0x20
+	ADLCTransmitAvailable = 0x40
+	ADLCInterrupt         = 0x80
+)
+
+// status register 2
+const (
+	ADLCAddressPresent = 0x01
+	ADLCFrameValid     = 0x02
+	ADLCReceiveIdle    = 0x04
+	ADLCAbort          = 0x08
+	ADLCFCSError       = 0x10
+	ADLCNoCarrier      = 0x20
+	ADLCOverrun        = 0x40
+	ADLCDataAvailable  = 0x80
+)
+
+// control register 1
+const (
+	adlcAddressControl   = 0x01
+	adlcReceiveIRQ       = 0x02
+	adlcTransmitIRQ      = 0x04
+	adlcFrameDiscontinue = 0x20
+	adlcReceiveReset     = 0x40
+	adlcTransmitReset    = 0x80
+)
+
+// control register 2
+const (
+	adlcTwoByte        = 0x02
+	adlcFrameComplete  = 0x08
+	adlcTransmitLast   = 0x10
+	adlcClearReceive   = 0x20
+	adlcClearTransmit  = 0x40
+	adlcSelfClearingCR = adlcTransmitLast | adlcClearReceive | adlcClearTransmit
+)
+
+const (
+	adlcFIFOSize = 3
+	// microseconds per byte with a 125kHz Econet clock
+	adlcByteTime = 64
+	// frames waiting for the receiver, more are dropped
+	adlcQueueSize = 16
+)
+
+// Where the ADLC sends the frames it transmits, the network answers
+// through Receive.
+type EconetNetwork interface {
+	Transmit(frame []byte)
+}
+
+type adlcByte struct {
+	value byte
+	// first byte of a frame, its last one
+	address bool
+	last    bool
+}
+
+type ADLC struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+
+	network EconetNetwork
+	cr1     byte
+	cr2     byte
+	cr3     byte
+	cr4     byte
+	clock   int
+
+	transmit  []adlcByte
+	txFrame   []byte
+	underrun  bool
+	completed bool
+
+	receive    []adlcByte
+	rxFrame    []byte
+	rxPos      int
+	frameValid bool
+	overrun    bool
+
+	// frames from the network, received by its goroutines
+	lock     sync.Mutex
+	incoming [][]byte
+
+	irq        bool
+	nmiEnabled bool
+}
+
+func (adlc *ADLC) GetName() string            { return adlc.name }
+func (adlc *ADLC) PlugToBus(bus *Bus)         { adlc.bus = bus }
+func (adlc *ADLC) IsWritable() bool           { return true }
+func (adlc *ADLC) IsReadable() bool           { return true }
+func (adlc *ADLC) GetSegment() *utils.Segment { return adlc.segment }
+func (adlc *ADLC) GetDomain() string          { return DomainPeripheral }
+func (adlc *ADLC) GetBusDomain() string       { return DomainPeripheral }
+
+func (adlc *ADLC) Start() error {
+	return nil
+}
+
+// Reset holds both the transmitter and the receiver in reset.
+func (adlc *ADLC) Reset() error {
+	adlc.cr1 = adlcTransmitReset | adlcReceiveReset
+	adlc.cr2, adlc.cr3, adlc.cr4 = 0, 0, 0
+	adlc.clock = 0
+	adlc.resetTransmitter()
+	adlc.resetReceiver()
+	adlc.nmiEnabled = false
+	adlc.updateIRQ()
+	return nil
+}
+
+func (adlc *ADLC) Stop() error {
+	return nil
+}
+
+// The network clocks the ADLC and takes its frames, nil disconnects it.
+func (adlc *ADLC) Connect(network EconetNetwork) {
+	adlc.network = network
+	adlc.updateIRQ()
+}
+
+func (adlc *ADLC) IsConnected() bool {
+	return adlc.network != nil
+}
+
+// Queues a frame from the network, it is received once the ADLC is done
+// with the previous ones and is not transmitting.
+func (adlc *ADLC) Receive(frame []byte) {
+	adlc.lock.Lock()
+	defer adlc.lock.Unlock()
+	if len(adlc.incoming) < adlcQueueSize {
+		adlc.incoming = append(adlc.incoming, append([]byte{}, frame...))
+	}
+}
+
+// The INTON and INTOFF flip-flop in front of the NMI.
+func (adlc *ADLC) EnableNMI(enabled bool) {
+	adlc.nmiEnabled = enabled
+	adlc.updateNMI()
+}
+
+func (adlc *ADLC) resetTransmitter() {
+	adlc.transmit = nil
+	adlc.txFrame = nil
+	adlc.underrun, adlc.completed = false, false
+}
+
+func (adlc *ADLC) resetReceiver() {
+	adlc.receive = nil
+	adlc.rxFrame = nil
+	adlc.frameValid, adlc.overrun = false, false
+}
+
+func (adlc *ADLC) isTwoByte() bool {
+	return adlc.cr2&adlcTwoByte != 0
+}
+
+func (adlc *ADLC) isTransmitting() bool {
+	return len(adlc.transmit) > 0 || len(adlc.txFrame) > 0
+}
+
+func (adlc *ADLC) isReceiving() bool {
+	return adlc.rxFrame != nil
+}
+
+func (adlc *ADLC) receiveAvailable() bool {
+	if len(adlc.receive) == 0 {
+		return false
+	}
+	if !adlc.isTwoByte() || len(adlc.receive) >= 2 {
+		return true
+	}
+	return adlc.receive[0].last
+}
+
+func (adlc *ADLC) transmitAvailable() bool {
+	if adlc.cr1&adlcTransmitReset != 0 || adlc.network == nil {
+		return false
+	}
+	room := 1
+	if adlc.isTwoByte() {
+		room = 2
+	}
+	return adlcFIFOSize-len(adlc.transmit) >= room
+}
+
+func (adlc *ADLC) GetStatus2() byte {
+	status := byte(0)
+	if len(adlc.receive) > 0 && adlc.receive[0].address {
+		status |= ADLCAddressPresent
+	}
+	if adlc.frameValid {
+		status |= ADLCFrameValid
+	}
+	if !adlc.isReceiving() {
+		status |= ADLCReceiveIdle
+	}
+	if adlc.network == nil {
+		status |= ADLCNoCarrier
+	}
+	if adlc.overrun {
+		status |= ADLCOverrun
+	}
+	if adlc.receiveAvailable() {
+		status |= ADLCDataAvailable
+	}
+	return status
+}
+
+func (adlc *ADLC) GetStatus1() byte {
+	status := byte(0)
+	if adlc.receiveAvailable() {
+		status |= ADLCReceiveAvailable
+	}
+	// the idle line and the missing carrier do not request a read of
+	// status 2, they are only reported by it
+	if adlc.GetStatus2()&(ADLCAddressPresent|ADLCFrameValid|ADLCOverrun) != 0 {
+		status |= ADLCStatus2Request
+	}
+	if adlc.network == nil {
+		status |= ADLCNotClear
+	}
+	if adlc.underrun {
+		status |= ADLCTransmitUnderrun
+	}
+	if adlc.cr2&adlcFrameComplete != 0 {
+		if adlc.completed {
+			status |= ADLCTransmitAvailable
+		}
+	} else if adlc.transmitAvailable() {
+		status |= ADLCTransmitAvailable
+	}
+	if adlc.irq {
+		status |= ADLCInterrupt
+	}
+	return status
+}
+
+func (adlc *ADLC) interrupt() bool {
+	status := adlc.GetStatus1()
+	if adlc.cr1&adlcReceiveIRQ != 0 && status&(ADLCReceiveAvailable|ADLCStatus2Request) != 0 {
+		return true
+	}
+	return adlc.cr1&adlcTransmitIRQ != 0 && status&(ADLCTransmitAvailable|ADLCTransmitUnderrun) != 0
+}
+
+func (adlc *ADLC) updateIRQ() {
+	adlc.irq = adlc.interrupt()
+	adlc.updateNMI()
+}
+
+func (adlc *ADLC) updateNMI() {
+	if adlc.bus != nil {
+		adlc.bus.NMI.Set(adlc.name, adlc.irq && adlc.nmiEnabled)
+	}
+}
+
+func (adlc *ADLC) writeCR1(value byte) {
+	adlc.cr1 = value
+	if value&adlcTransmitReset != 0 {
+		adlc.resetTransmitter()
+	}
+	if value&adlcReceiveReset != 0 {
+		adlc.resetReceiver()
+	}
+	if value&adlcFrameDiscontinue != 0 {
+		adlc.rxFrame, adlc.receive = nil, nil
+		adlc.cr1 &^= adlcFrameDiscontinue
+	}
+}
+
+func (adlc *ADLC) writeCR2(value byte) {
+	adlc.cr2 = value &^ adlcSelfClearingCR
+	if value&adlcClearReceive != 0 {
+		adlc.frameValid, adlc.overrun = false, false
+	}
+	if value&adlcClearTransmit != 0 {
+		adlc.underrun, adlc.completed = false, false
+	}
+	if value&adlcTransmitLast != 0 {
+		adlc.terminateFrame()
+	}
+}
+
+// The last byte written ends the frame.
+func (adlc *ADLC) terminateFrame() {
+	if n := len(adlc.transmit); n > 0 {
+		adlc.transmit[n-1].last = true
+	} else if len(adlc.txFrame) > 0 {
+		adlc.sendFrame()
+	}
+}
+
+func (adlc *ADLC) writeFIFO(value byte, last bool) {
+	if adlc.network == nil || adlc.cr1&adlcTransmitReset != 0 || len(adlc.transmit) >= adlcFIFOSize {
+		return
+	}
+	adlc.transmit = append(adlc.transmit, adlcByte{value: value, last: last})
+}
+
+func (adlc *ADLC) readFIFO() byte {
+	if len(adlc.receive) == 0 {
+		return 0
+	}
+	value := adlc.receive[0].value
+	adlc.receive = adlc.receive[1:]
+	return value
+}
+
+// Frame valid is latched once the last byte is next to be read, or the
+// one after in two byte mode.
+func (adlc *ADLC) latchFrameValid() {
+	for i, entry := range adlc.receive {
+		if i > 0 && !adlc.isTwoByte() || i > 1 {
+			return
+		}
+		if entry.last {
+			adlc.frameValid = true
+		}
+	}
+}
+
+func (adlc *ADLC) sendFrame() {
+	frame := adlc.txFrame
+	adlc.txFrame = nil
+	adlc.completed = true
+	if adlc.network != nil {
+		adlc.network.Transmit(frame)
+	}
+}
+
+// One byte time of the transmitter, then of the receiver.
+func (adlc *ADLC) byteClock() {
+	if adlc.cr1&adlcTransmitReset == 0 {
+		if len(adlc.transmit) > 0 {
+			next := adlc.transmit[0]
+			adlc.transmit = adlc.transmit[1:]
+			adlc.txFrame = append(adlc.txFrame, next.value)
+			if next.last {
+				adlc.sendFrame()
+			}
+		} else if len(adlc.txFrame) > 0 {
+			// the FIFO ran dry in the middle of a frame, it is aborted
+			adlc.txFrame = nil
+			adlc.underrun = true
+		}
+	}
+
+	if adlc.cr1&adlcReceiveReset != 0 {
+		return
+	}
+	if !adlc.isReceiving() {
+		// frames are a byte time apart and wait for the previous one to be
+		// read
+		if adlc.isTransmitting() || len(adlc.receive) > 0 || !adlc.nextFrame() {
+			return
+		}
+	}
+	if len(adlc.receive) >= adlcFIFOSize {
+		adlc.overrun = true
+		adlc.rxFrame = nil
+		return
+	}
+	last := adlc.rxPos == len(adlc.rxFrame)-1
+	adlc.receive = append(adlc.receive, adlcByte{
+		value:   adlc.rxFrame[adlc.rxPos],
+		address: adlc.rxPos == 0,
+		last:    last,
+	})
+	adlc.rxPos++
+	if last {
+		adlc.rxFrame = nil
+	}
+}
+
+func (adlc *ADLC) nextFrame() bool {
+	adlc.lock.Lock()
+	defer adlc.lock.Unlock()
+	for len(adlc.incoming) > 0 {
+		frame := adlc.incoming[0]
+		adlc.incoming = adlc.incoming[1:]
+		if len(frame) > 0 {
+			adlc.rxFrame, adlc.rxPos = frame, 0
+			return true
+		}
+	}
+	return false
+}
+
+func (adlc *ADLC) Step(ticks uint64) error {
+	if adlc.network == nil {
+		return nil
+	}
+	for adlc.clock += int(ticks); adlc.clock >= adlcByteTime; adlc.clock -= adlcByteTime {
+		adlc.byteClock()
+		adlc.latchFrameValid()
+		adlc.updateIRQ()
+	}
+	return nil
+}
+
+func (adlc *ADLC) read(addr uint16) byte {
+	var value byte
+	switch addr & 0x03 {
+	case 0:
+		value = adlc.GetStatus1()
+	case 1:
+		value = adlc.GetStatus2()
+	default:
+		value = adlc.readFIFO()
+		adlc.latchFrameValid()
+	}
+	adlc.updateIRQ()
+	return value
+}
+
+func (adlc *ADLC) write(value byte, addr uint16) {
+	addressControl := adlc.cr1&adlcAddressControl != 0
+	switch addr & 0x03 {
+	case 0:
+		adlc.writeCR1(value)
+	case 1:
+		if addressControl {
+			adlc.cr3 = value
+		} else {
+			adlc.writeCR2(value)
+		}
+	case 2:
+		adlc.writeFIFO(value, false)
+	case 3:
+		if addressControl {
+			adlc.cr4 = value
+		} else {
+			adlc.writeFIFO(value, true)
+		}
+	}
+	adlc.updateIRQ()
+}
+
+func (adlc *ADLC) DirectRead(addr uint16) (byte, error) {
+	return adlc.read(addr), nil
+}
+
+func (adlc *ADLC) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := adlc.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func (adlc *ADLC) DirectWrite(value byte, addr uint16) error {
+	adlc.write(value, addr)
+	return nil
+}
+
+func (adlc *ADLC) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := adlc.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+func NewADLC(name string, segment *utils.Segment) *ADLC {
+	adlc := &ADLC{name: name, segment: segment}
+	adlc.Reset()
+	return adlc
+}
+
+// Station number links at FE18, reading them is also INTOFF.
+type EconetStation struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+	adlc    *ADLC
+	station byte
+}
+
+func (links *EconetStation) GetName() string            { return links.name }
+func (links *EconetStation) PlugToBus(bus *Bus)         { links.bus = bus }
+func (links *EconetStation) IsWritable() bool           { return false }
+func (links *EconetStation) IsReadable() bool           { return true }
+func (links *EconetStation) GetSegment() *utils.Segment { return links.segment }
+func (links *EconetStation) GetBusDomain() string       { return DomainPeripheral }
+
+func (links *EconetStation) Start() error {
+	return nil
+}
+
+func (links *EconetStation) Reset() error {
+	return nil
+}
+
+func (links *EconetStation) Stop() error {
+	return nil
+}
+
+func (links *EconetStation) GetStation() byte {
+	return links.station
+}
+
+func (links *EconetStation) DirectRead(addr uint16) (byte, error) {
+	links.adlc.EnableNMI(false)
+	return links.station, nil
+}
+
+func (links *EconetStation) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := links.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func NewEconetStation(name string, segment *utils.Segment, adlc *ADLC, station byte) *EconetStation {
+	return &EconetStation{name: name, segment: segment, adlc: adlc, station: station}
+}
@@ -8,8 +8,23 @@ import (
 
 type Bus struct {
 	ClockHandler
+	IRQ *InterruptLine
+	NMI *InterruptLine
+	// the CPU is held while RESET is asserted, and restarts once released
+	RESET *InterruptLine
+
 	watchers     map[string]Component
 	addressables map[string]AddressableComponent
+	clocked      []*clockedEntry
+	stretched    map[string]*ClockDomain
+	// unmapped addresses read high and ignore writes instead of failing
+	openBus bool
+}
+
+type clockedEntry struct {
+	component ClockedComponent
+	domain    *ClockDomain
+	lastTicks uint64
 }
 
 type Component interface {
@@ -39,6 +54,19 @@ type WritableComponent interface {
 	OffsetWrite(byte, uint16, uint8) (uint16, error)
 }
 
+// Clocked components are stepped by the bus as their domain ticks.
+type ClockedComponent interface {
+	Component
+	GetDomain() string
+	Step(ticks uint64) error
+}
+
+// Accesses to stretched components are synchronised with a slower domain.
+type StretchedComponent interface {
+	AddressableComponent
+	GetBusDomain() string
+}
+
 func (bus *Bus) componentReadAt(addr uint16) ReadableComponent {
 	for _, component := range bus.addressables {
 		if !component.IsReadable() {
@@ -68,6 +96,12 @@ func (bus *Bus) componentWriteAt(addr uint16) WritableComponent {
 
 func (bus *Bus) Reset() {
 	bus.Clock.Reset()
+	bus.IRQ.Reset()
+	bus.NMI.Reset()
+	bus.RESET.Reset()
+	for _, clocked := range bus.clocked {
+		clocked.lastTicks = 0
+	}
 	for _, component := range bus.watchers {
 		component.Reset()
 	}
@@ -77,16 +111,50 @@ func (bus *Bus) Reset() {
 }
 
 func (bus *Bus) Tick() error {
-	return bus.Clock.Tick()
+	if err := bus.Clock.Tick(); err != nil {
+		return err
+	}
+	for _, clocked := range bus.clocked {
+		ticks := clocked.domain.GetCycles()
+		if ticks == clocked.lastTicks {
+			continue
+		}
+		if err := clocked.component.Step(ticks - clocked.lastTicks); err != nil {
+			return err
+		}
+		clocked.lastTicks = ticks
+	}
+	return bus.RunDueEvents()
+}
+
+// 1 cycle, more if the component is stretched
+func (bus *Bus) accessTick(component AddressableComponent) error {
+	cycles := uint64(1)
+	if domain, ok := bus.stretched[component.GetName()]; ok {
+		cycles = domain.StretchCycles()
+	}
+	for ; cycles > 0; cycles-- {
+		if err := bus.Tick(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bus *Bus) SetOpenBus(openBus bool) {
+	bus.openBus = openBus
 }
 
 // 1 cycle
 func (bus *Bus) DirectRead(addr uint16) (byte, error) {
 	readComponent := bus.componentReadAt(addr)
+	if readComponent == nil && bus.openBus {
+		return 0xFF, bus.Tick()
+	}
 	if readComponent == nil {
 		return 0, fmt.Errorf("reading garbage as no component answer for this address %x", addr)
 	}
-	if err := bus.Tick(); err != nil {
+	if err := bus.accessTick(readComponent); err != nil {
 		return 0, err
 	}
 	return readComponent.DirectRead(addr)
@@ -95,10 +163,13 @@ func (bus *Bus) DirectRead(addr uint16) (byte, error) {
 // 1 cycle, +1 if page crossed or forced
 func (bus *Bus) OffsetRead(addr uint16, offset uint8, forceTick bool) (byte, uint16, error) {
 	readComponent := bus.componentReadAt(addr)
+	if readComponent == nil && bus.openBus {
+		return 0xFF, addr + uint16(offset), bus.Tick()
+	}
 	if readComponent == nil {
 		return 0, 0, fmt.Errorf("reading garbage as no component answer for this address %x", addr)
 	}
-	if err := bus.Tick(); err != nil {
+	if err := bus.accessTick(readComponent); err != nil {
 		return 0, 0, err
 	}
 	if forceTick || utils.IsPageCrossed(addr, offset) {
@@ -112,10 +183,13 @@ func (bus *Bus) OffsetRead(addr uint16, offset uint8, forceTick bool) (byte, uin
 // 1 cycle
 func (bus *Bus) DirectWrite(value byte, addr uint16) error {
 	writeComponent := bus.componentWriteAt(addr)
+	if writeComponent == nil && bus.openBus {
+		return bus.Tick()
+	}
 	if writeComponent == nil {
 		return fmt.Errorf("writing in void as no component answer for this address %x", addr)
 	}
-	if err := bus.Tick(); err != nil {
+	if err := bus.accessTick(writeComponent); err != nil {
 		return err
 	}
 	return writeComponent.DirectWrite(value, addr)
@@ -124,10 +198,13 @@ func (bus *Bus) DirectWrite(value byte, addr uint16) error {
 // 1 cycle, +1 if page crossed or forced
 func (bus Bus) OffsetWrite(value byte, addr uint16, offset uint8, forceTick bool) (uint16, error) {
 	writeComponent := bus.componentWriteAt(addr)
+	if writeComponent == nil && bus.openBus {
+		return addr + uint16(offset), bus.Tick()
+	}
 	if writeComponent == nil {
 		return 0, fmt.Errorf("writing in void as no component answer for this address %x", addr)
 	}
-	if err := bus.Tick(); err != nil {
+	if err := bus.accessTick(writeComponent); err != nil {
 		return 0, err
 	}
 	if forceTick || utils.IsPageCrossed(addr, offset) {
@@ -160,8 +237,26 @@ func (bus *Bus) AddComponent(component Component) error {
 				return fmt.Errorf("component already registered with name %s", component.GetName())
 			}
 		}
+		if stretched, ok := component.(StretchedComponent); ok {
+			domain := bus.GetDomain(stretched.GetBusDomain())
+			if domain == nil {
+				return fmt.Errorf("unknown bus domain %s for component %s", stretched.GetBusDomain(), component.GetName())
+			}
+			bus.stretched[component.GetName()] = domain
+		}
 		bus.addressables[component.GetName()] = addrComponent
 	}
+	if clocked, ok := component.(ClockedComponent); ok {
+		domain := bus.GetDomain(clocked.GetDomain())
+		if domain == nil {
+			return fmt.Errorf("unknown clock domain %s for component %s", clocked.GetDomain(), component.GetName())
+		}
+		bus.clocked = append(bus.clocked, &clockedEntry{
+			component: clocked,
+			domain:    domain,
+			lastTicks: domain.GetCycles(),
+		})
+	}
 	component.PlugToBus(bus)
 	return nil
 }
@@ -177,7 +272,7 @@ func (bus *Bus) GetComponent(name string) Component {
 }
 
 func (bus *Bus) WriteMultiple(values []byte, start uint16) error {
-	if len(values)+int(start) > int(logical.AdressableSegment.End) {
+	if len(values)+int(start) > int(logical.AdressableSegment.Size()) {
 		return fmt.Errorf("cannot write outside memory bound")
 	}
 	addr := start
@@ -193,8 +288,13 @@ func (bus *Bus) WriteMultiple(values []byte, start uint16) error {
 func NewBus(clock *Clock, components ...Component) (*Bus, error) {
 	bus := Bus{
 		ClockHandler: ClockHandler{Clock: clock},
+		IRQ:          NewInterruptLine(),
+		NMI:          NewInterruptLine(),
+		RESET:        NewInterruptLine(),
 		watchers:     map[string]Component{},
 		addressables: map[string]AddressableComponent{},
+		clocked:      []*clockedEntry{},
+		stretched:    map[string]*ClockDomain{},
 	}
 	for _, component := range components {
 		if err := bus.AddComponent(component); err != nil {
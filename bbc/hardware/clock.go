@@ -2,20 +2,77 @@ package hardware
 
 import (
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+type ClockState uint8
+
+const (
+	ClockStopped ClockState = iota
+	ClockRunning
+	ClockPaused
+)
+
+const (
+	// wall time is only checked once per batch of emulated cycles
+	pacingBatch = time.Millisecond
+	// when lagging behind more than this, give up catching up
+	maxPacingLag = 100 * time.Millisecond
+	// statistics are sampled once per (emulated, when virtual) second
+	statsInterval = time.Second
+	// the virtual clock never syncs with the wall clock
+	noSync = ^uint64(0)
+)
+
+type ClockStats struct {
+	Cycles                uint64
+	Instructions          uint64
+	CyclesPerSecond       float64
+	InstructionsPerSecond float64
+	// emulated speed relative to the nominal frequency, 0 with a virtual
+	// clock as there is no wall time to compare with
+	RealTimePercent float64
+}
+
+type StatsFn func(ClockStats)
+
 type Clock struct {
-	Frequency uint64
+	*Scheduler
+	Frequency       uint64
+	MasterFrequency uint64
+
+	cpuDivider uint64
+	domains    map[string]*ClockDomain
+
+	cycles       atomic.Uint64
+	instructions atomic.Uint64
+	virtual      bool
+	stopChannel  chan bool
+
+	statsLock     sync.Mutex
+	stats         ClockStats
+	statsCallback StatsFn
+	statsEvent    *Event
+	statsCycles   uint64
+	// previous sample, shared by the stats goroutine and Reset/Start
+	lastCycles       uint64
+	lastInstructions uint64
+	lastStatsTime    time.Time
 
-	cycles           atomic.Uint64
-	stopChannel      chan bool
-	freqTimer        *time.Ticker
-	lastTicks        uint64
-	status           bool
-	lastTickTime     time.Time
-	waitBetweenTicks time.Duration
+	stateLock sync.Mutex
+	stateCond *sync.Cond
+	state     ClockState
+
+	pacingLock  sync.Mutex
+	speed       float64
+	turbo       bool
+	batchCycles uint64
+	// read by Tick without the lock
+	nextSync   atomic.Uint64
+	syncCycles uint64
+	syncTime   time.Time
 }
 
 type ClockHandler struct {
@@ -23,60 +80,313 @@ type ClockHandler struct {
 }
 
 func (clock *Clock) Tick() error {
-	time.Sleep(clock.waitBetweenTicks - time.Since(clock.lastTickTime))
-	if clock.cycles.Add(1) == 0 {
+	cycles := clock.cycles.Add(1)
+	if cycles == 0 {
 		return fmt.Errorf("clock cycles count wrap")
 	}
+	if cycles >= clock.nextSync.Load() {
+		clock.pace(cycles)
+	}
 	return nil
 }
 
-func (clock Clock) GetCycles() uint64 {
+// Sleep until wall time catches up with the emulated time of the batch.
+func (clock *Clock) pace(cycles uint64) {
+	clock.pacingLock.Lock()
+	clock.nextSync.Store(cycles + clock.batchCycles)
+	if clock.turbo || clock.GetState() != ClockRunning {
+		clock.pacingLock.Unlock()
+		return
+	}
+	emulated := float64(cycles-clock.syncCycles) / (float64(clock.Frequency) * clock.speed)
+	target := clock.syncTime.Add(time.Duration(emulated * float64(time.Second)))
+	ahead := time.Until(target)
+	if ahead < -maxPacingLag {
+		clock.rebase(cycles)
+	}
+	clock.pacingLock.Unlock()
+
+	if ahead > 0 {
+		time.Sleep(ahead)
+	}
+}
+
+// Must be called with the pacing lock held.
+func (clock *Clock) rebase(cycles uint64) {
+	if clock.virtual {
+		clock.nextSync.Store(noSync)
+		return
+	}
+	clock.syncCycles = cycles
+	clock.syncTime = time.Now()
+	clock.nextSync.Store(cycles + clock.batchCycles)
+}
+
+func (clock *Clock) GetCycles() uint64 {
 	return clock.cycles.Load()
 }
 
+// Schedules (or moves) the event delay cycles from now.
+func (clock *Clock) ScheduleIn(event *Event, delay uint64) {
+	clock.ScheduleAt(event, clock.GetCycles()+delay)
+}
+
+func (clock *Clock) RunDueEvents() error {
+	return clock.RunUntil(clock.GetCycles())
+}
+
+// Called by the CPU once per executed instruction.
+func (clock *Clock) CountInstruction() {
+	clock.instructions.Add(1)
+}
+
+func (clock *Clock) GetInstructions() uint64 {
+	return clock.instructions.Load()
+}
+
+func (clock *Clock) IsVirtual() bool {
+	return clock.virtual
+}
+
+// Last sampled statistics, with up to date totals.
+func (clock *Clock) GetStats() ClockStats {
+	clock.statsLock.Lock()
+	stats := clock.stats
+	clock.statsLock.Unlock()
+	stats.Cycles = clock.GetCycles()
+	stats.Instructions = clock.GetInstructions()
+	return stats
+}
+
+// The callback is invoked each time statistics are sampled while running.
+func (clock *Clock) OnStats(callback StatsFn) {
+	clock.statsLock.Lock()
+	defer clock.statsLock.Unlock()
+	clock.statsCallback = callback
+}
+
+// Wall time since the last sample, taken as the start of the next one.
+func (clock *Clock) statsElapsed(now time.Time) float64 {
+	clock.statsLock.Lock()
+	defer clock.statsLock.Unlock()
+	seconds := now.Sub(clock.lastStatsTime).Seconds()
+	clock.lastStatsTime = now
+	return seconds
+}
+
+func (clock *Clock) updateStats(seconds float64) {
+	if seconds <= 0 {
+		return
+	}
+	clock.statsLock.Lock()
+	cycles := clock.GetCycles()
+	instructions := clock.GetInstructions()
+	stats := ClockStats{
+		Cycles:                cycles,
+		Instructions:          instructions,
+		CyclesPerSecond:       float64(cycles-clock.lastCycles) / seconds,
+		InstructionsPerSecond: float64(instructions-clock.lastInstructions) / seconds,
+	}
+	if !clock.virtual {
+		stats.RealTimePercent = stats.CyclesPerSecond / float64(clock.Frequency) * 100
+	}
+	clock.lastCycles = cycles
+	clock.lastInstructions = instructions
+	clock.stats = stats
+	callback := clock.statsCallback
+	clock.statsLock.Unlock()
+	if callback != nil {
+		callback(stats)
+	}
+}
+
 func (clock *Clock) Reset() {
+	// the stats goroutine must not sample between the counters and their
+	// last samples being reset
+	clock.statsLock.Lock()
 	clock.cycles.Store(0)
+	clock.instructions.Store(0)
+	clock.lastCycles = 0
+	clock.lastInstructions = 0
+	clock.lastStatsTime = time.Now()
+	clock.statsLock.Unlock()
+	clock.Clear()
+	for _, domain := range clock.domains {
+		domain.reset()
+	}
+	clock.pacingLock.Lock()
+	clock.rebase(0)
+	clock.pacingLock.Unlock()
+	if clock.virtual && clock.GetState() != ClockStopped {
+		clock.ScheduleIn(clock.statsEvent, clock.statsCycles)
+	}
+}
+
+// Multiplier applied to the nominal frequency when throttled.
+func (clock *Clock) SetSpeed(speed float64) error {
+	if speed <= 0 {
+		return fmt.Errorf("invalid clock speed multiplier %f", speed)
+	}
+	clock.pacingLock.Lock()
+	defer clock.pacingLock.Unlock()
+	clock.speed = speed
+	clock.rebase(clock.GetCycles())
+	return nil
+}
+
+func (clock *Clock) GetSpeed() float64 {
+	clock.pacingLock.Lock()
+	defer clock.pacingLock.Unlock()
+	return clock.speed
+}
+
+// In turbo mode the clock runs as fast as the host allows.
+func (clock *Clock) SetTurbo(turbo bool) {
+	clock.pacingLock.Lock()
+	defer clock.pacingLock.Unlock()
+	clock.turbo = turbo
+	clock.rebase(clock.GetCycles())
+}
+
+func (clock *Clock) IsTurbo() bool {
+	clock.pacingLock.Lock()
+	defer clock.pacingLock.Unlock()
+	return clock.turbo
+}
+
+func (clock *Clock) GetState() ClockState {
+	clock.stateLock.Lock()
+	defer clock.stateLock.Unlock()
+	return clock.state
+}
+
+func (clock *Clock) setState(state ClockState) {
+	clock.stateLock.Lock()
+	clock.state = state
+	clock.stateLock.Unlock()
+	clock.stateCond.Broadcast()
+}
+
+// Blocks while the clock is paused, returns false once it is stopped.
+func (clock *Clock) WaitRunning() bool {
+	clock.stateLock.Lock()
+	defer clock.stateLock.Unlock()
+	for clock.state == ClockPaused {
+		clock.stateCond.Wait()
+	}
+	return clock.state == ClockRunning
 }
 
 func (clock *Clock) Start() error {
-	if clock.status {
+	if clock.GetState() != ClockStopped {
 		return fmt.Errorf("clock already started")
 	}
-	clock.status = true
+	clock.setState(ClockRunning)
+	if clock.virtual {
+		clock.ScheduleIn(clock.statsEvent, clock.statsCycles)
+		return nil
+	}
+
+	clock.pacingLock.Lock()
+	clock.rebase(clock.GetCycles())
+	clock.pacingLock.Unlock()
+	clock.statsLock.Lock()
+	clock.lastCycles = clock.GetCycles()
+	clock.lastInstructions = clock.GetInstructions()
+	clock.lastStatsTime = time.Now()
+	clock.statsLock.Unlock()
+	clock.stopChannel = make(chan bool)
 
-	go func() {
+	go func(stopChannel chan bool) {
+		statsTimer := time.NewTicker(statsInterval)
+		defer statsTimer.Stop()
 		for {
 			select {
-			case <-clock.stopChannel:
-				clock.status = false
+			case <-stopChannel:
 				return
-			case currentTime := <-clock.freqTimer.C:
-				currentTicks := clock.GetCycles()
-				simulatedFrequency := uint64(float64(currentTicks-clock.lastTicks) / currentTime.Sub(clock.lastTickTime).Seconds())
-				fmt.Printf("Simulated frequency: %d Hz\n", simulatedFrequency)
-				clock.lastTicks = currentTicks
-				clock.lastTickTime = currentTime
+			case currentTime := <-statsTimer.C:
+				clock.updateStats(clock.statsElapsed(currentTime))
 			}
 		}
-	}()
+	}(clock.stopChannel)
 
 	return nil
 }
 
-func (clock *Clock) Pause() {
+func (clock *Clock) Pause() error {
+	if clock.GetState() != ClockRunning {
+		return fmt.Errorf("clock is not running")
+	}
+	clock.setState(ClockPaused)
+	return nil
+}
 
+func (clock *Clock) Resume() error {
+	if clock.GetState() != ClockPaused {
+		return fmt.Errorf("clock is not paused")
+	}
+	// time spent paused must not be caught up
+	clock.pacingLock.Lock()
+	clock.rebase(clock.GetCycles())
+	clock.pacingLock.Unlock()
+	clock.setState(ClockRunning)
+	return nil
 }
 
-func NewClock(frequency uint64) *Clock {
-	return &Clock{
-		Frequency: frequency,
+func (clock *Clock) Stop() error {
+	if clock.GetState() == ClockStopped {
+		return fmt.Errorf("clock already stopped")
+	}
+	if clock.virtual {
+		clock.Cancel(clock.statsEvent)
+	} else {
+		close(clock.stopChannel)
+	}
+	clock.setState(ClockStopped)
+	return nil
+}
+
+func newClock(frequency uint64, virtual bool) *Clock {
+	batchCycles := uint64(float64(frequency) * pacingBatch.Seconds())
+	if batchCycles == 0 {
+		batchCycles = 1
+	}
+	clock := &Clock{
+		Scheduler:       NewScheduler(),
+		Frequency:       frequency,
+		MasterFrequency: frequency,
+
+		cpuDivider: 1,
+		domains:    map[string]*ClockDomain{},
 
-		cycles:           atomic.Uint64{},
-		stopChannel:      make(chan bool),
-		freqTimer:        time.NewTicker(time.Second),
-		lastTicks:        0,
-		status:           false,
-		lastTickTime:     time.Now(),
-		waitBetweenTicks: time.Duration(1. / float64(frequency) * float64(time.Second)),
+		cycles:      atomic.Uint64{},
+		virtual:     virtual,
+		state:       ClockStopped,
+		speed:       1,
+		turbo:       false,
+		batchCycles: batchCycles,
+		statsCycles: uint64(float64(frequency) * statsInterval.Seconds()),
 	}
+	clock.stateCond = sync.NewCond(&clock.stateLock)
+	clock.NewDomain(DomainCPU, 1, 0)
+	clock.statsEvent = clock.NewEvent("clock statistics", func(cycle uint64) error {
+		clock.updateStats(statsInterval.Seconds())
+		clock.ScheduleAt(clock.statsEvent, cycle+clock.statsCycles)
+		return nil
+	})
+	clock.pacingLock.Lock()
+	clock.rebase(0)
+	clock.pacingLock.Unlock()
+	return clock
+}
+
+// Clock paced against the wall clock.
+func NewClock(frequency uint64) *Clock {
+	return newClock(frequency, false)
+}
+
+// Clock never consulting the wall clock nor starting goroutines, runs are
+// reproducible and statistics are sampled every emulated second.
+func NewVirtualClock(frequency uint64) *Clock {
+	return newClock(frequency, true)
 }
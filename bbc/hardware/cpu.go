@@ -19,6 +19,8 @@ type CPU struct {
 	StackPointer   uint8
 	ProgramCounter uint16
 
+	inReset bool
+
 	instructionSet      map[string]*logical.Instruction
 	instructionByOpcode map[logical.Opcode]*logical.Instruction
 
@@ -82,7 +84,8 @@ func (cpu *CPU) SetRegister(value byte, register logical.Register) {
 	case logical.RegisterStack:
 		cpu.StackPointer = value
 	case logical.RegisterStatus:
-		cpu.Status = bitmap.FromBytes([]byte{value})
+		// bitmaps are decoded from 64 bits words
+		cpu.Status = bitmap.FromBytes([]byte{value, 0, 0, 0, 0, 0, 0, 0})
 	}
 }
 
@@ -118,7 +121,71 @@ func (cpu *CPU) GetStatus(flag logical.StatusFlag) bool {
 	return cpu.Status.Contains(uint32(flag))
 }
 
+// 7 cycles
+func (cpu *CPU) serviceInterrupt(vectorAddr0, vectorAddr1 uint16) error {
+	// the opcode fetch is discarded and PC not incremented
+	for i := 0; i < 2; i++ {
+		if err := cpu.Tick(); err != nil {
+			return err
+		}
+	}
+	return logical.Interrupt(cpu, vectorAddr0, vectorAddr1, false)
+}
+
+// 7 cycles
+func (cpu *CPU) serviceReset() error {
+	// same sequence as an interrupt, with the stack writes turned into reads
+	for i := 0; i < 5; i++ {
+		if err := cpu.Tick(); err != nil {
+			return err
+		}
+	}
+	cpu.StackPointer -= 3
+	cpu.SetStatus(true, logical.InterruptDisableFlagBit)
+	pcl, err := cpu.bus.DirectRead(logical.ResetVectorAddr0)
+	if err != nil {
+		return err
+	}
+	pch, err := cpu.bus.DirectRead(logical.ResetVectorAddr1)
+	if err != nil {
+		return err
+	}
+	cpu.ProgramCounter = utils.AddressFromNibbles(pch, pcl)
+	return nil
+}
+
+// The reset sequence runs before the next instruction.
+func (cpu *CPU) RequestReset() {
+	cpu.inReset = true
+}
+
+// RESET holds the CPU until released, NMI is edge triggered, IRQ level
+// triggered and masked by the I flag.
+func (cpu *CPU) pollInterrupts() (bool, error) {
+	if cpu.bus.RESET.IsAsserted() {
+		cpu.inReset = true
+		return true, cpu.Tick()
+	}
+	if cpu.inReset {
+		cpu.inReset = false
+		// interrupts raised before the reset are forgotten
+		cpu.bus.NMI.TakeEdge()
+		return true, cpu.serviceReset()
+	}
+	if cpu.bus.NMI.TakeEdge() {
+		return true, cpu.serviceInterrupt(logical.NMIVectorAddr0, logical.NMIVectorAddr1)
+	}
+	if cpu.bus.IRQ.IsAsserted() && !cpu.GetStatus(logical.InterruptDisableFlagBit) {
+		return true, cpu.serviceInterrupt(logical.IRQVectorAddr0, logical.IRQVectorAddr1)
+	}
+	return false, nil
+}
+
 func (cpu *CPU) ExecuteNext() error {
+	cpu.checkBus()
+	if serviced, err := cpu.pollInterrupts(); serviced || err != nil {
+		return err
+	}
 	opcode, err := cpu.NextByte()
 	if err != nil {
 		return err
@@ -126,9 +193,16 @@ func (cpu *CPU) ExecuteNext() error {
 	if err := cpu.executeOpcode(logical.Opcode(opcode)); err != nil {
 		return err
 	}
+	cpu.CountInstruction()
 	return nil
 }
 
+// Internal cycles go through the bus so scheduled events keep running.
+func (cpu *CPU) Tick() error {
+	cpu.checkBus()
+	return cpu.bus.Tick()
+}
+
 func (cpu *CPU) GetPC() uint16 { return cpu.ProgramCounter }
 func (cpu *CPU) SetPC(pc uint16) {
 	cpu.ProgramCounter = pc
@@ -176,13 +250,15 @@ func (cpu *CPU) NextWord() (uint16, error) {
 
 func (cpu *CPU) GetName() string { return "CPU" }
 
+// Runs until the clock is stopped, waiting while it is paused.
 func (cpu *CPU) Start() error {
 	cpu.checkBus()
-	for {
+	for cpu.WaitRunning() {
 		if err := cpu.ExecuteNext(); err != nil {
 			return err
 		}
 	}
+	return nil
 }
 
 func (cpu *CPU) Reset() error {
@@ -0,0 +1,505 @@
+package hardware
+
+import (
+	"bbc/utils"
+)
+
+// Motorola 6845 CRT controller. It is clocked by the character clock and
+// generates the display memory address, the raster line within a character
+// row and the sync signals. The address register is at even addresses, the
+// selected register at odd ones. The characters are generated in batches:
+// up to each register access and to the end of each scan line, an event
+// scheduled on the bus clock, where vsync starts and ends.
+
+const (
+	CRTCHorizontalTotal = iota
+	CRTCHorizontalDisplayed
+	CRTCHSyncPosition
+	CRTCSyncWidth
+	CRTCVerticalTotal
+	CRTCVerticalAdjust
+	CRTCVerticalDisplayed
+	CRTCVSyncPosition
+	CRTCInterlace
+	CRTCMaxScanLine
+	CRTCCursorStart
+	CRTCCursorEnd
+	CRTCStartAddressHigh
+	CRTCStartAddressLow
+	CRTCCursorHigh
+	CRTCCursorLow
+	CRTCLightPenHigh
+	CRTCLightPenLow
+	crtcRegisters
+)
+
+// significant bits of each register
+var crtcMasks = [crtcRegisters]byte{
+	0xFF, 0xFF, 0xFF, 0xFF, 0x7F, 0x1F, 0x7F, 0x7F,
+	0xF3, 0x1F, 0x7F, 0x1F, 0x3F, 0xFF, 0x3F, 0xFF,
+	0x3F, 0xFF,
+}
+
+const (
+	// R8 interlace modes and skews
+	crtcInterlaceSync  = 0x01
+	crtcInterlaceVideo = 0x03
+	crtcSkewDisabled   = 0x03
+	// R10 cursor blink modes
+	crtcCursorBlinkMask   = 0x60
+	crtcCursorSteady      = 0x00
+	crtcCursorOff         = 0x20
+	crtcCursorBlinkFast   = 0x40
+	crtcCursorBlinkSlow   = 0x60
+	crtcDefaultVSyncWidth = 16
+)
+
+// Outputs of the CRTC for one character clock.
+type CRTCSignals struct {
+	// 14 bits refresh memory address
+	Address uint16
+	// raster line in the character row
+	Raster  uint8
+	Display bool
+	Cursor  bool
+	HSync   bool
+	VSync   bool
+}
+
+type CRTCObserver func(signals CRTCSignals)
+
+type CRTC struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+	via     *VIA
+	domain  *ClockDomain
+	// character clock ticks generated so far
+	ticks     uint64
+	syncing   bool
+	lineEvent *Event
+
+	address   byte
+	registers [crtcRegisters]byte
+
+	// horizontal character, scan line and character row counters
+	column       byte
+	scanLine     byte
+	row          byte
+	adjust       byte
+	inAdjust     bool
+	memory       uint16
+	rowStart     uint16
+	nextRowStart uint16
+
+	hDisplay     bool
+	vDisplay     bool
+	hSyncCounter byte
+	vSyncCounter byte
+	hSync        bool
+	vSync        bool
+	// display and cursor enables, delayed by the R8 skews
+	displayDelay byte
+	cursorDelay  byte
+
+	frame    uint64
+	oddField bool
+	lightPen bool
+
+	signals   CRTCSignals
+	observers []CRTCObserver
+}
+
+func (crtc *CRTC) GetName() string            { return crtc.name }
+func (crtc *CRTC) IsWritable() bool           { return true }
+func (crtc *CRTC) IsReadable() bool           { return true }
+func (crtc *CRTC) GetSegment() *utils.Segment { return crtc.segment }
+func (crtc *CRTC) GetBusDomain() string       { return DomainPeripheral }
+
+func (crtc *CRTC) PlugToBus(bus *Bus) {
+	crtc.bus = bus
+	crtc.domain = bus.GetDomain(DomainCharacter)
+	crtc.ticks = crtc.domain.GetCycles()
+	crtc.lineEvent = bus.NewEvent(crtc.name+" line end", func(uint64) error {
+		crtc.Sync()
+		return nil
+	})
+	crtc.scheduleLineEnd()
+}
+
+func (crtc *CRTC) Start() error {
+	return nil
+}
+
+// Registers are left untouched, only the counters restart.
+func (crtc *CRTC) Reset() error {
+	crtc.Sync()
+	crtc.column, crtc.scanLine, crtc.row = 0, 0, 0
+	crtc.adjust, crtc.inAdjust = 0, false
+	crtc.hSyncCounter, crtc.vSyncCounter = 0, 0
+	crtc.hSync = false
+	crtc.setVSync(false)
+	crtc.displayDelay, crtc.cursorDelay = 0, 0
+	crtc.newFrame()
+	crtc.frame, crtc.oddField = 0, false
+	crtc.memory = crtc.rowStart
+	crtc.hDisplay = crtc.registers[CRTCHorizontalDisplayed] != 0
+	crtc.scheduleLineEnd()
+	return nil
+}
+
+func (crtc *CRTC) Stop() error {
+	return nil
+}
+
+// Vsync is wired to the System VIA CA1 for the MOS frame interrupt.
+func (crtc *CRTC) setVSync(level bool) {
+	if crtc.vSync == level {
+		return
+	}
+	crtc.vSync = level
+	if crtc.via != nil {
+		crtc.via.SetCA1(level)
+	}
+}
+
+func (crtc *CRTC) GetRegister(register int) byte {
+	return crtc.registers[register]
+}
+
+func (crtc *CRTC) SetRegister(register int, value byte) {
+	if register >= CRTCLightPenHigh {
+		return
+	}
+	crtc.Sync()
+	crtc.registers[register] = value & crtcMasks[register]
+	crtc.scheduleLineEnd()
+}
+
+func (crtc *CRTC) startAddress() uint16 {
+	return uint16(crtc.registers[CRTCStartAddressHigh])<<8 | uint16(crtc.registers[CRTCStartAddressLow])
+}
+
+func (crtc *CRTC) cursorAddress() uint16 {
+	return uint16(crtc.registers[CRTCCursorHigh])<<8 | uint16(crtc.registers[CRTCCursorLow])
+}
+
+func (crtc *CRTC) interlaceMode() byte { return crtc.registers[CRTCInterlace] & 0x03 }
+func (crtc *CRTC) displaySkew() byte   { return (crtc.registers[CRTCInterlace] >> 4) & 0x03 }
+func (crtc *CRTC) cursorSkew() byte    { return (crtc.registers[CRTCInterlace] >> 6) & 0x03 }
+
+// In interlaced sync and video mode each field shows every other scan line.
+func (crtc *CRTC) IsInterlacedVideo() bool {
+	return crtc.interlaceMode() == crtcInterlaceVideo
+}
+
+func (crtc *CRTC) IsInterlaced() bool {
+	return crtc.interlaceMode()&crtcInterlaceSync != 0
+}
+
+func (crtc *CRTC) IsOddField() bool { return crtc.oddField }
+
+func (crtc *CRTC) GetFrame() uint64 {
+	crtc.Sync()
+	return crtc.frame
+}
+
+func (crtc *CRTC) IsVSync() bool {
+	crtc.Sync()
+	return crtc.vSync
+}
+
+func (crtc *CRTC) IsHSync() bool {
+	crtc.Sync()
+	return crtc.hSync
+}
+
+func (crtc *CRTC) IsDisplaying() bool {
+	crtc.Sync()
+	return crtc.hDisplay && crtc.vDisplay
+}
+
+// Signals output on the last character clock.
+func (crtc *CRTC) GetSignals() CRTCSignals {
+	crtc.Sync()
+	return crtc.signals
+}
+
+// Observers are called with each character clock output, in batches no
+// later than the end of its scan line.
+func (crtc *CRTC) Observe(observer CRTCObserver) {
+	crtc.Sync()
+	crtc.observers = append(crtc.observers, observer)
+}
+
+// Generates the characters up to the current character clock tick. Changes
+// to what the characters depend on outside the CRTC, like the clock rate,
+// are made after a sync.
+func (crtc *CRTC) Sync() {
+	if crtc.domain == nil || crtc.syncing {
+		return
+	}
+	crtc.syncing = true
+	now := crtc.domain.GetCycles()
+	if now < crtc.ticks {
+		// the clock was reset
+		crtc.ticks = now
+	}
+	for ; crtc.ticks < now; crtc.ticks++ {
+		crtc.stepCharacter()
+	}
+	crtc.syncing = false
+	crtc.scheduleLineEnd()
+}
+
+// The character counter wraps at 256 when the total is set below it.
+func (crtc *CRTC) scheduleLineEnd() {
+	if crtc.lineEvent == nil {
+		return
+	}
+	characters := uint64(crtc.registers[CRTCHorizontalTotal]) + 1 - uint64(crtc.column)
+	if crtc.column > crtc.registers[CRTCHorizontalTotal] {
+		characters += 256
+	}
+	crtc.bus.ScheduleIn(crtc.lineEvent, crtc.domain.CyclesUntil(characters))
+}
+
+func (crtc *CRTC) read(addr uint16) byte {
+	if addr&0x01 == 0 {
+		// the address register is write only
+		return 0
+	}
+	switch crtc.address {
+	case CRTCCursorHigh, CRTCCursorLow, CRTCLightPenHigh, CRTCLightPenLow:
+		return crtc.registers[crtc.address]
+	}
+	return 0
+}
+
+func (crtc *CRTC) write(value byte, addr uint16) {
+	if addr&0x01 == 0 {
+		crtc.address = value & 0x1F
+		return
+	}
+	if crtc.address < crtcRegisters {
+		crtc.SetRegister(int(crtc.address), value)
+	}
+}
+
+func (crtc *CRTC) DirectRead(addr uint16) (byte, error) {
+	return crtc.read(addr), nil
+}
+
+func (crtc *CRTC) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := crtc.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func (crtc *CRTC) DirectWrite(value byte, addr uint16) error {
+	crtc.write(value, addr)
+	return nil
+}
+
+func (crtc *CRTC) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := crtc.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+// A rising edge on the light pen strobe latches the current address.
+func (crtc *CRTC) SetLightPen(level bool) {
+	crtc.Sync()
+	if level && !crtc.lightPen {
+		crtc.registers[CRTCLightPenHigh] = byte(crtc.memory>>8) & crtcMasks[CRTCLightPenHigh]
+		crtc.registers[CRTCLightPenLow] = byte(crtc.memory)
+	}
+	crtc.lightPen = level
+}
+
+func (crtc *CRTC) cursorVisible() bool {
+	switch crtc.registers[CRTCCursorStart] & crtcCursorBlinkMask {
+	case crtcCursorOff:
+		return false
+	case crtcCursorBlinkFast:
+		// 1/16 of the field rate
+		return crtc.frame&0x08 != 0
+	case crtcCursorBlinkSlow:
+		return crtc.frame&0x10 != 0
+	}
+	return true
+}
+
+func (crtc *CRTC) cursorLine() bool {
+	start := crtc.registers[CRTCCursorStart] & 0x1F
+	end := crtc.registers[CRTCCursorEnd]
+	line := crtc.scanLine
+	return start <= line && line <= end
+}
+
+// Delays a signal by the skew in characters, a skew of 3 disables it.
+func skewed(history *byte, level bool, skew byte) bool {
+	*history <<= 1
+	if level {
+		*history |= 1
+	}
+	if skew == crtcSkewDisabled {
+		return false
+	}
+	return *history&(1<<skew) != 0
+}
+
+func (crtc *CRTC) output() {
+	display := crtc.hDisplay && crtc.vDisplay
+	cursor := display && crtc.memory == crtc.cursorAddress() && crtc.cursorLine() && crtc.cursorVisible()
+
+	crtc.signals = CRTCSignals{
+		Address: crtc.memory & 0x3FFF,
+		Raster:  crtc.scanLine,
+		Display: skewed(&crtc.displayDelay, display, crtc.displaySkew()),
+		Cursor:  skewed(&crtc.cursorDelay, cursor, crtc.cursorSkew()),
+		HSync:   crtc.hSync,
+		VSync:   crtc.vSync,
+	}
+	for _, observer := range crtc.observers {
+		observer(crtc.signals)
+	}
+}
+
+func (crtc *CRTC) newFrame() {
+	crtc.row, crtc.scanLine = 0, 0
+	crtc.adjust, crtc.inAdjust = 0, false
+	crtc.frame++
+	if crtc.IsInterlaced() {
+		crtc.oddField = !crtc.oddField
+	} else {
+		crtc.oddField = false
+	}
+	if crtc.IsInterlacedVideo() && crtc.oddField {
+		crtc.scanLine = 1
+	}
+	crtc.rowStart = crtc.startAddress()
+	crtc.nextRowStart = crtc.rowStart
+	crtc.vDisplay = crtc.registers[CRTCVerticalDisplayed] != 0
+}
+
+// Last scan line of a character row, interlaced video counts lines by two.
+func (crtc *CRTC) isRowEnd() bool {
+	maxScanLine := crtc.registers[CRTCMaxScanLine]
+	if crtc.IsInterlacedVideo() {
+		return crtc.scanLine>>1 >= maxScanLine>>1
+	}
+	return crtc.scanLine >= maxScanLine
+}
+
+func (crtc *CRTC) nextRow() {
+	crtc.scanLine = 0
+	if crtc.IsInterlacedVideo() && crtc.oddField {
+		crtc.scanLine = 1
+	}
+	crtc.rowStart = crtc.nextRowStart
+	if crtc.row == crtc.registers[CRTCVerticalTotal] {
+		if crtc.registers[CRTCVerticalAdjust] == 0 {
+			crtc.newFrame()
+		} else {
+			crtc.inAdjust = true
+			crtc.adjust = 0
+			crtc.row++
+		}
+		return
+	}
+	crtc.row = (crtc.row + 1) & 0x7F
+}
+
+func (crtc *CRTC) endOfLine() {
+	if crtc.vSync {
+		crtc.vSyncCounter--
+		if crtc.vSyncCounter == 0 {
+			crtc.setVSync(false)
+		}
+	}
+
+	switch {
+	case crtc.inAdjust:
+		crtc.adjust++
+		crtc.scanLine++
+		if crtc.adjust >= crtc.registers[CRTCVerticalAdjust] {
+			crtc.newFrame()
+		}
+	case crtc.isRowEnd():
+		crtc.nextRow()
+	case crtc.IsInterlacedVideo():
+		crtc.scanLine += 2
+	default:
+		crtc.scanLine = (crtc.scanLine + 1) & 0x1F
+	}
+
+	if crtc.row == crtc.registers[CRTCVerticalDisplayed] {
+		crtc.vDisplay = false
+	}
+	if !crtc.inAdjust && crtc.row == crtc.registers[CRTCVSyncPosition] && crtc.scanLine == crtc.firstScanLine() && !crtc.vSync {
+		crtc.vSyncCounter = crtc.registers[CRTCSyncWidth] >> 4
+		if crtc.vSyncCounter == 0 {
+			crtc.vSyncCounter = crtcDefaultVSyncWidth
+		}
+		crtc.setVSync(true)
+	}
+	crtc.memory = crtc.rowStart
+}
+
+func (crtc *CRTC) firstScanLine() byte {
+	if crtc.IsInterlacedVideo() && crtc.oddField {
+		return 1
+	}
+	return 0
+}
+
+func (crtc *CRTC) stepCharacter() {
+	crtc.output()
+
+	if crtc.hSync {
+		crtc.hSyncCounter--
+		if crtc.hSyncCounter == 0 {
+			crtc.hSync = false
+		}
+	}
+	if crtc.column == crtc.registers[CRTCHorizontalTotal] {
+		crtc.column = 0
+		crtc.hDisplay = crtc.registers[CRTCHorizontalDisplayed] != 0
+		crtc.endOfLine()
+	} else {
+		crtc.column++
+		crtc.memory = (crtc.memory + 1) & 0x3FFF
+	}
+
+	if crtc.column == crtc.registers[CRTCHorizontalDisplayed] {
+		crtc.hDisplay = false
+		if crtc.isRowEnd() {
+			crtc.nextRowStart = crtc.memory
+		}
+	}
+	if crtc.column == crtc.registers[CRTCHSyncPosition] && !crtc.hSync {
+		crtc.hSyncCounter = crtc.registers[CRTCSyncWidth] & 0x0F
+		crtc.hSync = crtc.hSyncCounter != 0
+	}
+}
+
+// The CRTC drives the vsync input of the given VIA, which may be nil.
+func NewCRTC(name string, segment *utils.Segment, via *VIA) *CRTC {
+	crtc := &CRTC{
+		name:      name,
+		segment:   segment,
+		via:       via,
+		observers: []CRTCObserver{},
+	}
+	if via != nil {
+		via.SetCA1(false)
+	}
+	crtc.Reset()
+	return crtc
+}
@@ -0,0 +1,141 @@
+package hardware
+
+import (
+	"bbc/disc"
+	"fmt"
+)
+
+const (
+	// 300 rpm, in microseconds
+	DiscRevolution = 200000
+	indexPulseTime = 2000
+	// from the index hole to the first ID field, and through an ID field
+	// and the gap before its data
+	indexGapTime = 1000
+	idFieldTime  = 500
+)
+
+// Floppy drive shared by the disc controllers: the head position, the
+// rotation and the disc inserted.
+type DiscDrive struct {
+	disc   *disc.Disc
+	tracks int
+	head   int
+}
+
+// Drives have 40 or 80 tracks.
+func NewDiscDrive(tracks int) *DiscDrive {
+	return &DiscDrive{tracks: tracks}
+}
+
+func (drive *DiscDrive) GetTracks() int {
+	return drive.tracks
+}
+
+// The disc previously inserted is written back. An 80 track disc does not
+// fit a 40 track drive.
+func (drive *DiscDrive) Insert(inserted *disc.Disc) error {
+	if inserted.GetTrackCount() > drive.tracks {
+		return fmt.Errorf("disc of %d tracks in a %d track drive", inserted.GetTrackCount(), drive.tracks)
+	}
+	if err := drive.Eject(); err != nil {
+		return err
+	}
+	drive.disc = inserted
+	return nil
+}
+
+func (drive *DiscDrive) Eject() error {
+	if drive.disc == nil {
+		return nil
+	}
+	err := drive.disc.Flush()
+	drive.disc = nil
+	return err
+}
+
+func (drive *DiscDrive) GetDisc() *disc.Disc {
+	return drive.disc
+}
+
+func (drive *DiscDrive) Flush() error {
+	if drive.disc == nil {
+		return nil
+	}
+	return drive.disc.Flush()
+}
+
+func (drive *DiscDrive) IsReady() bool {
+	return drive.disc != nil
+}
+
+func (drive *DiscDrive) IsWriteProtected() bool {
+	return drive.disc == nil || drive.disc.WriteProtected
+}
+
+func (drive *DiscDrive) IsTrack0() bool {
+	return drive.head == 0
+}
+
+func (drive *DiscDrive) GetHead() int {
+	return drive.head
+}
+
+// Moves the head a track in or out, it stops at the ends.
+func (drive *DiscDrive) Step(in bool) {
+	if in && drive.head < drive.tracks-1 {
+		drive.head++
+	} else if !in && drive.head > 0 {
+		drive.head--
+	}
+}
+
+// Track under the head, nil without a disc or formatted track.
+func (drive *DiscDrive) GetTrack(side int) *disc.Track {
+	if drive.disc == nil {
+		return nil
+	}
+	return drive.disc.GetTrack(side, drive.head)
+}
+
+func (drive *DiscDrive) IsIndex(now uint64) bool {
+	return drive.disc != nil && now%DiscRevolution < indexPulseTime
+}
+
+func (drive *DiscDrive) UntilIndex(now uint64) uint64 {
+	return DiscRevolution - now%DiscRevolution
+}
+
+// Microseconds from the index hole to the end of the ID field of a sector,
+// spread evenly around the track.
+func sectorTime(track *disc.Track, index int) uint64 {
+	return indexGapTime + uint64(index)*(DiscRevolution-indexGapTime)/uint64(len(track.Sectors)) + idFieldTime
+}
+
+// First sector matching whose ID field passes the head within the given
+// revolutions, and the microseconds until it has.
+func (drive *DiscDrive) FindSector(side int, now uint64, revolutions int, match func(*disc.Sector) bool) (*disc.Sector, uint64, bool) {
+	track := drive.GetTrack(side)
+	if track == nil || len(track.Sectors) == 0 {
+		return nil, uint64(revolutions) * DiscRevolution, false
+	}
+	start := now % DiscRevolution
+	best, bestWait := -1, uint64(0)
+	for i, sector := range track.Sectors {
+		if !match(sector) {
+			continue
+		}
+		at := sectorTime(track, i)
+		wait := (at + DiscRevolution - start) % DiscRevolution
+		if wait == 0 {
+			wait = DiscRevolution
+		}
+		if best < 0 || wait < bestWait {
+			best, bestWait = i, wait
+		}
+	}
+	if best < 0 {
+		return nil, uint64(revolutions) * DiscRevolution, false
+	}
+	return track.Sectors[best], bestWait, true
+}
@@ -0,0 +1,163 @@
+package hardware
+
+import "fmt"
+
+const (
+	DomainCPU = "cpu"
+	// 1MHz peripheral bus (VIAs, ACIA, ADC, FDC...)
+	DomainPeripheral = "1MHz"
+	// 6845 character clock, 2MHz or 1MHz depending on the video ULA
+	DomainCharacter = "character"
+	// video ULA pixel clock, 16, 8, 4 or 2MHz
+	DomainPixel = "pixel"
+	// SN76489 4MHz clock divided by 16
+	DomainSound = "sound"
+
+	BBCMasterFrequency = 16e6
+)
+
+// A clock domain is derived from the master clock by an integer divider, it
+// ticks on master cycles congruent to its phase.
+type ClockDomain struct {
+	Name string
+
+	divider uint64
+	phase   uint64
+	clock   *Clock
+	// master cycle and domain ticks when the divider was last changed
+	baseMaster uint64
+	baseTicks  uint64
+}
+
+// Counts edges up to a master cycle, only differences are meaningful.
+func (domain *ClockDomain) edgesUntil(master uint64) uint64 {
+	return (master + domain.divider - domain.phase) / domain.divider
+}
+
+func (domain *ClockDomain) ticksAt(master uint64) uint64 {
+	return domain.baseTicks + domain.edgesUntil(master) - domain.edgesUntil(domain.baseMaster)
+}
+
+func (domain *ClockDomain) GetDivider() uint64 { return domain.divider }
+func (domain *ClockDomain) GetPhase() uint64   { return domain.phase }
+
+func (domain *ClockDomain) GetFrequency() uint64 {
+	return domain.clock.MasterFrequency / domain.divider
+}
+
+// Domain ticks elapsed since the clock reset.
+func (domain *ClockDomain) GetCycles() uint64 {
+	return domain.ticksAt(domain.clock.GetMasterCycles())
+}
+
+// Changes the divider (and phase) without losing the elapsed ticks.
+func (domain *ClockDomain) SetDivider(divider, phase uint64) error {
+	if divider == 0 || phase >= divider {
+		return fmt.Errorf("invalid divider %d and phase %d for domain %s", divider, phase, domain.Name)
+	}
+	master := domain.clock.GetMasterCycles()
+	domain.baseTicks = domain.ticksAt(master)
+	domain.baseMaster = master
+	domain.divider = divider
+	domain.phase = phase
+	return nil
+}
+
+// CPU cycles an access synchronised on this domain lasts: the CPU is held
+// until the next domain edge, then for a whole domain period.
+func (domain *ClockDomain) StretchCycles() uint64 {
+	cpuDivider := domain.clock.cpuDivider
+	master := domain.clock.GetMasterCycles()
+	align := (domain.phase + domain.divider - master%domain.divider) % domain.divider
+	return (align+cpuDivider-1)/cpuDivider + (domain.divider+cpuDivider-1)/cpuDivider
+}
+
+// Converts a number of domain ticks to CPU cycles, rounded up.
+func (domain *ClockDomain) ToCPUCycles(ticks uint64) uint64 {
+	cpuDivider := domain.clock.cpuDivider
+	return (ticks*domain.divider + cpuDivider - 1) / cpuDivider
+}
+
+// CPU cycles until the domain has ticked n more times, to schedule events
+// counted in domain ticks.
+func (domain *ClockDomain) CyclesUntil(ticks uint64) uint64 {
+	if ticks == 0 {
+		return 0
+	}
+	cpuDivider := domain.clock.cpuDivider
+	master := domain.clock.GetMasterCycles()
+	edges := domain.ticksAt(master) + ticks - domain.baseTicks + domain.edgesUntil(domain.baseMaster)
+	// first master cycle reaching that many edges
+	target := (edges-1)*domain.divider + domain.phase
+	return (target+cpuDivider-1)/cpuDivider - master/cpuDivider
+}
+
+func (domain *ClockDomain) reset() {
+	domain.baseMaster = 0
+	domain.baseTicks = 0
+}
+
+func (clock *Clock) GetMasterCycles() uint64 {
+	return clock.GetCycles() * clock.cpuDivider
+}
+
+// The CPU frequency is derived from the master one, which must be a multiple.
+func (clock *Clock) SetMasterFrequency(master uint64) error {
+	if master < clock.Frequency || master%clock.Frequency != 0 {
+		return fmt.Errorf("master frequency %d is not a multiple of %d", master, clock.Frequency)
+	}
+	if clock.GetCycles() != 0 {
+		return fmt.Errorf("cannot change master frequency of a running clock")
+	}
+	clock.MasterFrequency = master
+	clock.cpuDivider = master / clock.Frequency
+	return clock.domains[DomainCPU].SetDivider(clock.cpuDivider, 0)
+}
+
+func (clock *Clock) NewDomain(name string, divider, phase uint64) (*ClockDomain, error) {
+	if _, ok := clock.domains[name]; ok {
+		return nil, fmt.Errorf("clock domain %s already exists", name)
+	}
+	if divider == 0 || phase >= divider {
+		return nil, fmt.Errorf("invalid divider %d and phase %d for domain %s", divider, phase, name)
+	}
+	domain := &ClockDomain{
+		Name:       name,
+		divider:    divider,
+		phase:      phase,
+		clock:      clock,
+		baseMaster: clock.GetMasterCycles(),
+	}
+	clock.domains[name] = domain
+	return domain, nil
+}
+
+func (clock *Clock) GetDomain(name string) *ClockDomain {
+	domain, ok := clock.domains[name]
+	if !ok {
+		return nil
+	}
+	return domain
+}
+
+// Derives the BBC micro domains from its 16MHz master clock, the clock
+// frequency must be the 2MHz CPU one.
+func (clock *Clock) AddBBCDomains() error {
+	if err := clock.SetMasterFrequency(BBCMasterFrequency); err != nil {
+		return err
+	}
+	// the 1MHz bus is high on even CPU cycles
+	if _, err := clock.NewDomain(DomainPeripheral, 16, 0); err != nil {
+		return err
+	}
+	if _, err := clock.NewDomain(DomainCharacter, 8, 0); err != nil {
+		return err
+	}
+	if _, err := clock.NewDomain(DomainPixel, 1, 0); err != nil {
+		return err
+	}
+	if _, err := clock.NewDomain(DomainSound, 64, 0); err != nil {
+		return err
+	}
+	return nil
+}
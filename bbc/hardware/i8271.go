@@ -0,0 +1,698 @@
+package hardware
+
+import (
+	"bbc/disc"
+	"bbc/utils"
+)
+
+// Intel 8271 floppy disc controller at FE80, the Model B disc interface. It
+// runs in non-DMA mode: each data byte is requested with an interrupt, wired
+// to the NMI, and moved through the data register at FE84. Commands are
+// written to FE80 followed by their parameters at FE81, results are read
+// from FE81. Each step of a command is an event scheduled on the bus clock.
+
+// status register
+const (
+	I8271Busy          = 0x80
+	I8271CommandFull   = 0x40
+	I8271ParameterFull = 0x20
+	I8271ResultFull    = 0x10
+	I8271Interrupt     = 0x08
+	I8271DataRequest   = 0x04
+)
+
+// results
+const (
+	I8271ResultOK             = 0x00
+	I8271ResultClockError     = 0x08
+	I8271ResultLateDMA        = 0x0A
+	I8271ResultIDCRCError     = 0x0C
+	I8271ResultDataCRCError   = 0x0E
+	I8271ResultNotReady       = 0x10
+	I8271ResultWriteProtected = 0x12
+	I8271ResultTrack0NotFound = 0x14
+	I8271ResultWriteFault     = 0x16
+	I8271ResultSectorNotFound = 0x18
+	// set with the others when a deleted data mark was met
+	I8271ResultDeletedData = 0x20
+)
+
+// commands, drive selects in bits 6 and 7
+const (
+	i8271ScanData        = 0x00
+	i8271ScanDeleted     = 0x04
+	i8271WriteData128    = 0x0A
+	i8271WriteData       = 0x0B
+	i8271WriteDeleted128 = 0x0E
+	i8271WriteDeleted    = 0x0F
+	i8271ReadData128     = 0x12
+	i8271ReadData        = 0x13
+	i8271ReadDeleted128  = 0x16
+	i8271ReadDeleted     = 0x17
+	i8271ReadID          = 0x1B
+	i8271Verify128       = 0x1E
+	i8271Verify          = 0x1F
+	i8271Format          = 0x23
+	i8271Seek            = 0x29
+	i8271DriveStatus     = 0x2C
+	i8271Specify         = 0x35
+	i8271WriteSpecial    = 0x3A
+	i8271ReadSpecial     = 0x3D
+)
+
+// special registers
+const (
+	I8271ScanSector       = 0x06
+	I8271StepRate         = 0x0D
+	I8271HeadSettle       = 0x0E
+	I8271HeadLoad         = 0x0F
+	I8271BadTracksDrive0  = 0x10
+	I8271TrackDrive0      = 0x12
+	I8271Mode             = 0x17
+	I8271BadTracksDrive1  = 0x18
+	I8271TrackDrive1      = 0x1A
+	I8271DriveInput       = 0x22
+	I8271DriveOutput      = 0x23
+	i8271SpecialRegisters = 0x40
+)
+
+// drive control output and input bits
+const (
+	I8271OutputSide    = 0x20
+	I8271OutputSelect0 = 0x40
+	I8271OutputSelect1 = 0x80
+
+	I8271InputCount   = 0x01
+	I8271InputTrack0  = 0x02
+	I8271InputReady0  = 0x04
+	I8271InputProtect = 0x08
+	I8271InputIndex   = 0x10
+	I8271InputReady1  = 0x40
+)
+
+var i8271Parameters = map[byte]int{
+	i8271ScanData: 5, i8271ScanDeleted: 5,
+	i8271WriteData128: 2, i8271WriteData: 3, i8271WriteDeleted128: 2, i8271WriteDeleted: 3,
+	i8271ReadData128: 2, i8271ReadData: 3, i8271ReadDeleted128: 2, i8271ReadDeleted: 3,
+	i8271ReadID: 3, i8271Verify128: 2, i8271Verify: 3,
+	i8271Format: 5, i8271Seek: 1, i8271DriveStatus: 0, i8271Specify: 4,
+	i8271WriteSpecial: 2, i8271ReadSpecial: 1,
+}
+
+const (
+	// single density, 125kbit/s
+	singleDensityByteTime = 64
+	// step and settle times count 2ms
+	i8271StepUnit = 2000
+)
+
+type I8271 struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+	drives  [2]*DiscDrive
+
+	status     byte
+	result     byte
+	data       byte
+	command    byte
+	parameters []byte
+	registers  [i8271SpecialRegisters]byte
+
+	// 1MHz ticks count the microseconds of the disc rotation
+	domain *ClockDomain
+	// next step of the command running
+	event *Event
+	next  func()
+
+	// sector transfers
+	track     byte
+	sector    byte
+	count     int
+	size      int
+	deleted   bool
+	modified  bool
+	buffer    []byte
+	position  int
+	formatted []*disc.Sector
+}
+
+func (fdc *I8271) GetName() string            { return fdc.name }
+func (fdc *I8271) IsWritable() bool           { return true }
+func (fdc *I8271) IsReadable() bool           { return true }
+func (fdc *I8271) GetSegment() *utils.Segment { return fdc.segment }
+func (fdc *I8271) GetBusDomain() string       { return DomainPeripheral }
+
+func (fdc *I8271) PlugToBus(bus *Bus) {
+	fdc.bus = bus
+	fdc.domain = bus.GetDomain(DomainPeripheral)
+	fdc.event = bus.NewEvent(fdc.name, func(uint64) error {
+		next := fdc.next
+		fdc.next = nil
+		if next != nil {
+			next()
+		}
+		return nil
+	})
+}
+
+func (fdc *I8271) Start() error {
+	return nil
+}
+
+func (fdc *I8271) Reset() error {
+	fdc.status = 0
+	fdc.result = 0
+	fdc.parameters = nil
+	fdc.cancel()
+	fdc.registers[I8271DriveOutput] = 0
+	fdc.updateNMI()
+	return nil
+}
+
+func (fdc *I8271) Stop() error {
+	for _, drive := range fdc.drives {
+		if drive != nil {
+			if err := drive.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (fdc *I8271) GetDrive(index int) *DiscDrive {
+	return fdc.drives[index]
+}
+
+func (fdc *I8271) GetStatus() byte {
+	return fdc.status
+}
+
+func (fdc *I8271) GetSpecialRegister(register byte) byte {
+	if register == I8271DriveInput {
+		return fdc.driveInput()
+	}
+	return fdc.registers[register%i8271SpecialRegisters]
+}
+
+func (fdc *I8271) updateNMI() {
+	if fdc.bus != nil {
+		fdc.bus.NMI.Set(fdc.name, fdc.status&I8271Interrupt != 0)
+	}
+}
+
+// Microseconds since the bus was reset.
+func (fdc *I8271) now() uint64 {
+	if fdc.domain == nil {
+		return 0
+	}
+	return fdc.domain.GetCycles()
+}
+
+// Runs the next step of the command after some microseconds, at least one.
+func (fdc *I8271) after(microseconds uint64, next func()) {
+	if microseconds == 0 {
+		microseconds = 1
+	}
+	fdc.next = next
+	fdc.bus.ScheduleIn(fdc.event, fdc.domain.CyclesUntil(microseconds))
+}
+
+func (fdc *I8271) cancel() {
+	fdc.next = nil
+	if fdc.event != nil {
+		fdc.bus.Cancel(fdc.event)
+	}
+}
+
+// Selected drive, 0 or 1, -1 when none or both are.
+func (fdc *I8271) selected() int {
+	switch fdc.registers[I8271DriveOutput] & (I8271OutputSelect0 | I8271OutputSelect1) {
+	case I8271OutputSelect0:
+		return 0
+	case I8271OutputSelect1:
+		return 1
+	}
+	return -1
+}
+
+func (fdc *I8271) drive() *DiscDrive {
+	if selected := fdc.selected(); selected >= 0 {
+		return fdc.drives[selected]
+	}
+	return nil
+}
+
+func (fdc *I8271) side() int {
+	if fdc.registers[I8271DriveOutput]&I8271OutputSide != 0 {
+		return 1
+	}
+	return 0
+}
+
+func (fdc *I8271) driveInput() byte {
+	input := byte(0)
+	if fdc.drives[0] != nil && fdc.drives[0].IsReady() {
+		input |= I8271InputReady0
+	}
+	if fdc.drives[1] != nil && fdc.drives[1].IsReady() {
+		input |= I8271InputReady1
+	}
+	if drive := fdc.drive(); drive != nil {
+		if drive.IsTrack0() {
+			input |= I8271InputTrack0
+		}
+		if drive.IsWriteProtected() {
+			input |= I8271InputProtect
+		}
+		if drive.IsIndex(fdc.now()) {
+			input |= I8271InputIndex
+		}
+	}
+	return input
+}
+
+func (fdc *I8271) writeCommand(value byte) {
+	if fdc.status&I8271Busy != 0 {
+		return
+	}
+	fdc.command = value
+	fdc.parameters = nil
+	fdc.status = I8271Busy | I8271CommandFull
+	if i8271Parameters[value&0x3F] == 0 {
+		fdc.execute()
+	}
+}
+
+func (fdc *I8271) writeParameter(value byte) {
+	if fdc.status&I8271Busy == 0 || len(fdc.parameters) >= i8271Parameters[fdc.command&0x3F] {
+		return
+	}
+	fdc.parameters = append(fdc.parameters, value)
+	if len(fdc.parameters) == i8271Parameters[fdc.command&0x3F] {
+		fdc.execute()
+	}
+}
+
+func (fdc *I8271) finish(result byte) {
+	fdc.cancel()
+	// a disc that could not be written back to its image stays modified and
+	// is written again when stopped or ejected
+	if fdc.modified {
+		fdc.modified = false
+		if drive := fdc.drive(); drive != nil {
+			if err := drive.Flush(); err != nil {
+				result = I8271ResultWriteFault
+			}
+		}
+	}
+	fdc.result = result
+	fdc.status = I8271ResultFull | I8271Interrupt
+	fdc.updateNMI()
+}
+
+// Ends commands without an interrupt.
+func (fdc *I8271) finishQuietly(result byte, hasResult bool) {
+	fdc.cancel()
+	fdc.status = 0
+	if hasResult {
+		fdc.result = result
+		fdc.status = I8271ResultFull
+	}
+	fdc.updateNMI()
+}
+
+func (fdc *I8271) execute() {
+	fdc.status &^= I8271CommandFull | I8271ParameterFull
+	command := fdc.command & 0x3F
+	parameters := fdc.parameters
+
+	// the command selects the drive
+	if selects := fdc.command & (I8271OutputSelect0 | I8271OutputSelect1); selects != 0 {
+		fdc.registers[I8271DriveOutput] = fdc.registers[I8271DriveOutput]&^(I8271OutputSelect0|I8271OutputSelect1) | selects
+	}
+
+	switch command {
+	case i8271DriveStatus:
+		fdc.finishQuietly(fdc.driveInput(), true)
+		return
+	case i8271Specify:
+		fdc.registers[parameters[0]%i8271SpecialRegisters] = parameters[1]
+		fdc.registers[(parameters[0]+1)%i8271SpecialRegisters] = parameters[2]
+		fdc.registers[(parameters[0]+2)%i8271SpecialRegisters] = parameters[3]
+		fdc.finishQuietly(0, false)
+		return
+	case i8271WriteSpecial:
+		fdc.registers[parameters[0]%i8271SpecialRegisters] = parameters[1]
+		fdc.finishQuietly(0, false)
+		return
+	case i8271ReadSpecial:
+		fdc.finishQuietly(fdc.GetSpecialRegister(parameters[0]), true)
+		return
+	}
+
+	drive := fdc.drive()
+	if drive == nil || !drive.IsReady() {
+		fdc.after(1, func() { fdc.finish(I8271ResultNotReady) })
+		return
+	}
+	if len(parameters) > 0 {
+		fdc.track = parameters[0]
+	}
+	fdc.deleted = false
+	fdc.modified = false
+	switch command {
+	case i8271Seek:
+		fdc.seek(func() { fdc.finish(I8271ResultOK) })
+	case i8271ReadData128, i8271ReadData, i8271ReadDeleted128, i8271ReadDeleted, i8271Verify128, i8271Verify:
+		fdc.setSectors(parameters)
+		fdc.seek(fdc.searchSector)
+	case i8271WriteData128, i8271WriteData, i8271WriteDeleted128, i8271WriteDeleted, i8271Format:
+		if drive.IsWriteProtected() {
+			fdc.after(1, func() { fdc.finish(I8271ResultWriteProtected) })
+			return
+		}
+		if command == i8271Format {
+			fdc.setFormat(parameters)
+			fdc.seek(func() { fdc.after(drive.UntilIndex(fdc.now()), fdc.formatSector) })
+			return
+		}
+		fdc.setSectors(parameters)
+		fdc.seek(fdc.searchSector)
+	case i8271ReadID:
+		fdc.count = int(parameters[2])
+		fdc.seek(fdc.readID)
+	default:
+		// scans are not emulated, they never match
+		fdc.after(2*DiscRevolution, func() { fdc.finish(I8271ResultSectorNotFound) })
+	}
+}
+
+func (fdc *I8271) setSectors(parameters []byte) {
+	fdc.sector = parameters[1]
+	fdc.count, fdc.size = 1, 128
+	if len(parameters) > 2 {
+		fdc.count = int(parameters[2] & 0x1F)
+		fdc.size = 128 << (parameters[2] >> 5)
+	}
+}
+
+func (fdc *I8271) setFormat(parameters []byte) {
+	fdc.count = int(parameters[2] & 0x1F)
+	fdc.size = 128 << (parameters[2] >> 5)
+	fdc.formatted = nil
+}
+
+// Physical track of a logical one, skipping the bad tracks of the drive.
+func (fdc *I8271) physicalTrack(logical byte) int {
+	badTracks := I8271BadTracksDrive0
+	if fdc.selected() == 1 {
+		badTracks = I8271BadTracksDrive1
+	}
+	physical := int(logical)
+	first, second := fdc.registers[badTracks], fdc.registers[badTracks+1]
+	if first > second {
+		first, second = second, first
+	}
+	for _, bad := range []byte{first, second} {
+		if bad != 0 && bad != 0xFF && physical >= int(bad) {
+			physical++
+		}
+	}
+	return physical
+}
+
+// Steps to the logical track, track 0 is found with the drive sensor.
+func (fdc *I8271) seek(then func()) {
+	drive := fdc.drive()
+	trackRegister := I8271TrackDrive0
+	if fdc.selected() == 1 {
+		trackRegister = I8271TrackDrive1
+	}
+	current := int(fdc.registers[trackRegister])
+	target := fdc.physicalTrack(fdc.track)
+	steps := 0
+	if target == 0 {
+		for ; !drive.IsTrack0() && steps < 255; steps++ {
+			drive.Step(false)
+		}
+		if !drive.IsTrack0() {
+			fdc.after(uint64(steps)*uint64(fdc.registers[I8271StepRate])*i8271StepUnit+1, func() {
+				fdc.finish(I8271ResultTrack0NotFound)
+			})
+			return
+		}
+	} else {
+		for ; current != target; steps++ {
+			drive.Step(target > current)
+			if target > current {
+				current++
+			} else {
+				current--
+			}
+		}
+	}
+	fdc.registers[trackRegister] = byte(target)
+	wait := uint64(steps) * uint64(fdc.registers[I8271StepRate]) * i8271StepUnit
+	if steps > 0 {
+		wait += uint64(fdc.registers[I8271HeadSettle]) * i8271StepUnit
+	}
+	fdc.after(wait+1, then)
+}
+
+func (fdc *I8271) isSingleDensity() bool {
+	track := fdc.drive().GetTrack(fdc.side())
+	return track == nil || !track.DoubleDensity
+}
+
+func (fdc *I8271) searchSector() {
+	drive := fdc.drive()
+	found, wait, ok := drive.FindSector(fdc.side(), fdc.now(), 2, func(sector *disc.Sector) bool {
+		return sector.Track == fdc.track && sector.Number == fdc.sector
+	})
+	if !ok || !fdc.isSingleDensity() {
+		fdc.after(wait, func() { fdc.finish(I8271ResultSectorNotFound) })
+		return
+	}
+	fdc.after(wait, func() {
+		if found.IDCRCError {
+			fdc.finish(I8271ResultIDCRCError)
+			return
+		}
+		fdc.transferSector(found)
+	})
+}
+
+func (fdc *I8271) transferSector(sector *disc.Sector) {
+	command := fdc.command & 0x3F
+	switch command {
+	case i8271WriteData128, i8271WriteData, i8271WriteDeleted128, i8271WriteDeleted:
+		fdc.collect(fdc.size, func(data []byte) {
+			sector.Data = data
+			sector.Deleted = command == i8271WriteDeleted128 || command == i8271WriteDeleted
+			sector.DataCRCError = false
+			fdc.drive().GetDisc().MarkModified()
+			fdc.modified = true
+			fdc.nextSector(sector)
+		})
+	case i8271Verify128, i8271Verify:
+		fdc.after(uint64(fdc.size)*singleDensityByteTime, func() { fdc.nextSector(sector) })
+	default:
+		// the length asked for is read, whatever the sector size
+		data := make([]byte, fdc.size)
+		copy(data, sector.Data)
+		fdc.stream(data, func() { fdc.nextSector(sector) })
+	}
+}
+
+// The CRC is met where the data recorded ends, a length asked for that
+// differs gives a CRC error.
+func (fdc *I8271) nextSector(sector *disc.Sector) {
+	if sector.DataCRCError || len(sector.Data) != fdc.size {
+		fdc.finish(I8271ResultDataCRCError)
+		return
+	}
+	command := fdc.command & 0x3F
+	// reported when the data mark is read, writes replace it
+	reading := command&0x10 != 0
+	if sector.Deleted && reading {
+		fdc.deleted = true
+		if command == i8271ReadData128 || command == i8271ReadData {
+			fdc.finish(I8271ResultDeletedData)
+			return
+		}
+	}
+	fdc.count--
+	if fdc.count <= 0 {
+		result := byte(I8271ResultOK)
+		if fdc.deleted {
+			result |= I8271ResultDeletedData
+		}
+		fdc.finish(result)
+		return
+	}
+	fdc.sector++
+	fdc.searchSector()
+}
+
+func (fdc *I8271) readID() {
+	drive := fdc.drive()
+	found, wait, ok := drive.FindSector(fdc.side(), fdc.now(), 2, func(*disc.Sector) bool { return true })
+	if !ok || !fdc.isSingleDensity() {
+		fdc.after(wait, func() { fdc.finish(I8271ResultSectorNotFound) })
+		return
+	}
+	fdc.after(wait, func() {
+		fdc.stream([]byte{found.Track, found.Head, found.Number, found.SizeCode}, func() {
+			fdc.count--
+			if fdc.count <= 0 {
+				fdc.finish(I8271ResultOK)
+				return
+			}
+			fdc.readID()
+		})
+	})
+}
+
+// Formats a sector at a time from the index, the CPU supplies the ID fields.
+func (fdc *I8271) formatSector() {
+	fdc.collect(4, func(id []byte) {
+		data := make([]byte, fdc.size)
+		for i := range data {
+			data[i] = disc.FormatFill
+		}
+		sector := &disc.Sector{Track: id[0], Head: id[1], Number: id[2], SizeCode: id[3], Data: data}
+		fdc.formatted = append(fdc.formatted, sector)
+		if len(fdc.formatted) < fdc.count {
+			fdc.after(DiscRevolution/uint64(fdc.count)-4*singleDensityByteTime, fdc.formatSector)
+			return
+		}
+		drive := fdc.drive()
+		track := &disc.Track{Sectors: fdc.formatted}
+		fdc.formatted = nil
+		if err := drive.GetDisc().SetTrack(fdc.side(), drive.GetHead(), track); err != nil {
+			fdc.finish(I8271ResultWriteFault)
+			return
+		}
+		fdc.modified = true
+		fdc.after(drive.UntilIndex(fdc.now()), func() { fdc.finish(I8271ResultOK) })
+	})
+}
+
+// Hands bytes to the CPU at the disc rate, a byte not taken in time ends the
+// command.
+func (fdc *I8271) stream(data []byte, done func()) {
+	fdc.buffer, fdc.position = data, 0
+	var next func()
+	next = func() {
+		if fdc.status&I8271DataRequest != 0 {
+			fdc.finish(I8271ResultLateDMA)
+			return
+		}
+		if fdc.position == len(fdc.buffer) {
+			done()
+			return
+		}
+		fdc.data = fdc.buffer[fdc.position]
+		fdc.position++
+		fdc.status |= I8271DataRequest | I8271Interrupt
+		fdc.updateNMI()
+		fdc.after(singleDensityByteTime, next)
+	}
+	next()
+}
+
+// Takes bytes from the CPU at the disc rate.
+func (fdc *I8271) collect(length int, done func([]byte)) {
+	data := make([]byte, 0, length)
+	request := func() {
+		fdc.status |= I8271DataRequest | I8271Interrupt
+		fdc.updateNMI()
+	}
+	var next func()
+	next = func() {
+		if fdc.status&I8271DataRequest != 0 {
+			fdc.finish(I8271ResultLateDMA)
+			return
+		}
+		data = append(data, fdc.data)
+		if len(data) == length {
+			done(data)
+			return
+		}
+		request()
+		fdc.after(singleDensityByteTime, next)
+	}
+	request()
+	fdc.after(singleDensityByteTime, next)
+}
+
+func (fdc *I8271) read(addr uint16) byte {
+	switch addr & 0x07 {
+	case 0:
+		return fdc.status
+	case 1:
+		fdc.status &^= I8271ResultFull | I8271Interrupt
+		fdc.updateNMI()
+		return fdc.result
+	case 4, 5, 6, 7:
+		fdc.status &^= I8271DataRequest | I8271Interrupt
+		fdc.updateNMI()
+		return fdc.data
+	}
+	return 0xFF
+}
+
+func (fdc *I8271) write(value byte, addr uint16) {
+	switch addr & 0x07 {
+	case 0:
+		fdc.writeCommand(value)
+	case 1:
+		fdc.writeParameter(value)
+	case 2:
+		if value&0x01 != 0 {
+			fdc.Reset()
+		}
+	case 4, 5, 6, 7:
+		fdc.data = value
+		fdc.status &^= I8271DataRequest | I8271Interrupt
+		fdc.updateNMI()
+	}
+}
+
+func (fdc *I8271) DirectRead(addr uint16) (byte, error) {
+	return fdc.read(addr), nil
+}
+
+func (fdc *I8271) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := fdc.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func (fdc *I8271) DirectWrite(value byte, addr uint16) error {
+	fdc.write(value, addr)
+	return nil
+}
+
+func (fdc *I8271) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := fdc.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+func NewI8271(name string, segment *utils.Segment, drive0, drive1 *DiscDrive) *I8271 {
+	fdc := &I8271{
+		name:    name,
+		segment: segment,
+		drives:  [2]*DiscDrive{drive0, drive1},
+	}
+	fdc.Reset()
+	return fdc
+}
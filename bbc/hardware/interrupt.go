@@ -0,0 +1,50 @@
+package hardware
+
+// Wired-OR interrupt line, asserted while any of its sources is.
+type InterruptLine struct {
+	sources  map[string]bool
+	asserted int
+	edge     bool
+}
+
+func (line *InterruptLine) Set(source string, asserted bool) {
+	if line.sources[source] == asserted {
+		return
+	}
+	line.sources[source] = asserted
+	if asserted {
+		if line.asserted == 0 {
+			line.edge = true
+		}
+		line.asserted++
+	} else {
+		line.asserted--
+	}
+}
+
+func (line *InterruptLine) IsAsserted() bool {
+	return line.asserted > 0
+}
+
+func (line *InterruptLine) IsAssertedBy(source string) bool {
+	return line.sources[source]
+}
+
+// Reports (and acknowledges) a rising edge since the last call.
+func (line *InterruptLine) TakeEdge() bool {
+	edge := line.edge
+	line.edge = false
+	return edge
+}
+
+func (line *InterruptLine) Reset() {
+	line.sources = map[string]bool{}
+	line.asserted = 0
+	line.edge = false
+}
+
+func NewInterruptLine() *InterruptLine {
+	return &InterruptLine{
+		sources: map[string]bool{},
+	}
+}
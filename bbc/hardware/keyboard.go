@@ -0,0 +1,286 @@
+package hardware
+
+import (
+	"fmt"
+	"sync"
+)
+
+// BBC keyboard matrix, read through the System VIA port A. While the latch
+// keyboard write enable is high a counter scans the columns and CA2 is raised
+// for any key pressed outside row 0. Otherwise PA0-3 select the column,
+// PA4-6 the row and PA7 reads the key.
+type Keyboard struct {
+	name string
+	via  *VIA
+	bus  *Bus
+
+	// host facing state, keys may be pressed from any goroutine
+	lock         sync.Mutex
+	matrix       [16]byte
+	startupLinks byte
+	breakPressed bool
+	logicalShift int
+	physical     PhysicalKeyMap
+	logical      LogicalKeyMap
+
+	autoScan      bool
+	column        uint8
+	row           uint8
+	ca2           bool
+	resetAsserted bool
+}
+
+func (keyboard *Keyboard) GetName() string    { return keyboard.name }
+func (keyboard *Keyboard) PlugToBus(bus *Bus) { keyboard.bus = bus }
+func (keyboard *Keyboard) GetDomain() string  { return DomainPeripheral }
+
+func (keyboard *Keyboard) Start() error {
+	return nil
+}
+
+func (keyboard *Keyboard) Reset() error {
+	keyboard.column = 0
+	keyboard.ca2 = false
+	keyboard.resetAsserted = false
+	keyboard.via.SetCA2(false)
+	return nil
+}
+
+func (keyboard *Keyboard) Stop() error {
+	return nil
+}
+
+// Startup options links, bit n is read in row 0 column 9-n.
+func (keyboard *Keyboard) SetStartupLinks(links byte) {
+	keyboard.lock.Lock()
+	defer keyboard.lock.Unlock()
+	keyboard.startupLinks = links
+	for bit := 0; bit < 8; bit++ {
+		column := 9 - bit
+		keyboard.matrix[column] &^= 0x01
+		if links&(1<<bit) != 0 {
+			keyboard.matrix[column] |= 0x01
+		}
+	}
+}
+
+func (keyboard *Keyboard) GetStartupLinks() byte {
+	keyboard.lock.Lock()
+	defer keyboard.lock.Unlock()
+	return keyboard.startupLinks
+}
+
+func (keyboard *Keyboard) setKey(name string, pressed bool) error {
+	keyboard.lock.Lock()
+	defer keyboard.lock.Unlock()
+	if name == KeyBreak {
+		keyboard.breakPressed = pressed
+		return nil
+	}
+	position, ok := keyPositions[name]
+	if !ok {
+		return fmt.Errorf("unknown BBC key %s", name)
+	}
+	row, column := position>>4, position&0x0F
+	if pressed {
+		keyboard.matrix[column] |= 1 << row
+	} else {
+		keyboard.matrix[column] &^= 1 << row
+	}
+	return nil
+}
+
+// Presses a key by its BBC name, BREAK holds the CPU in reset.
+func (keyboard *Keyboard) Press(name string) error {
+	return keyboard.setKey(name, true)
+}
+
+func (keyboard *Keyboard) Release(name string) error {
+	return keyboard.setKey(name, false)
+}
+
+func (keyboard *Keyboard) IsPressed(name string) bool {
+	keyboard.lock.Lock()
+	defer keyboard.lock.Unlock()
+	if name == KeyBreak {
+		return keyboard.breakPressed
+	}
+	position, ok := keyPositions[name]
+	if !ok {
+		return false
+	}
+	return keyboard.matrix[position&0x0F]&(1<<(position>>4)) != 0
+}
+
+func (keyboard *Keyboard) ReleaseAll() {
+	keyboard.lock.Lock()
+	defer keyboard.lock.Unlock()
+	// row 0 of columns 2-9 holds the startup links, SHIFT and CTRL are
+	// row 0 of columns 0 and 1
+	for column := range keyboard.matrix {
+		if column >= 2 && column <= 9 {
+			keyboard.matrix[column] &= 0x01
+		} else {
+			keyboard.matrix[column] = 0
+		}
+	}
+	keyboard.breakPressed = false
+	keyboard.logicalShift = 0
+}
+
+func (keyboard *Keyboard) SetPhysicalKeyMap(keyMap PhysicalKeyMap) {
+	keyboard.lock.Lock()
+	defer keyboard.lock.Unlock()
+	keyboard.physical = keyMap
+}
+
+func (keyboard *Keyboard) SetLogicalKeyMap(keyMap LogicalKeyMap) {
+	keyboard.lock.Lock()
+	defer keyboard.lock.Unlock()
+	keyboard.logical = keyMap
+}
+
+func (keyboard *Keyboard) hostKey(code string) (string, error) {
+	keyboard.lock.Lock()
+	defer keyboard.lock.Unlock()
+	name, ok := keyboard.physical[code]
+	if !ok {
+		return "", fmt.Errorf("host key %s is not mapped", code)
+	}
+	return name, nil
+}
+
+// Presses the BBC key at the position of a host key code.
+func (keyboard *Keyboard) PressHost(code string) error {
+	name, err := keyboard.hostKey(code)
+	if err != nil {
+		return err
+	}
+	return keyboard.Press(name)
+}
+
+func (keyboard *Keyboard) ReleaseHost(code string) error {
+	name, err := keyboard.hostKey(code)
+	if err != nil {
+		return err
+	}
+	return keyboard.Release(name)
+}
+
+func (keyboard *Keyboard) stroke(char rune) (KeyStroke, error) {
+	keyboard.lock.Lock()
+	defer keyboard.lock.Unlock()
+	stroke, ok := keyboard.logical[char]
+	if !ok {
+		return KeyStroke{}, fmt.Errorf("character %q is not mapped", char)
+	}
+	return stroke, nil
+}
+
+// Presses the keys typing a character, along with SHIFT when needed.
+func (keyboard *Keyboard) PressRune(char rune) error {
+	stroke, err := keyboard.stroke(char)
+	if err != nil {
+		return err
+	}
+	if stroke.Shift {
+		keyboard.lock.Lock()
+		keyboard.logicalShift++
+		keyboard.lock.Unlock()
+		if err := keyboard.Press(KeyShift); err != nil {
+			return err
+		}
+	}
+	return keyboard.Press(stroke.Key)
+}
+
+func (keyboard *Keyboard) ReleaseRune(char rune) error {
+	stroke, err := keyboard.stroke(char)
+	if err != nil {
+		return err
+	}
+	if err := keyboard.Release(stroke.Key); err != nil {
+		return err
+	}
+	if stroke.Shift {
+		keyboard.lock.Lock()
+		keyboard.logicalShift--
+		release := keyboard.logicalShift == 0
+		keyboard.lock.Unlock()
+		if release {
+			return keyboard.Release(KeyShift)
+		}
+	}
+	return nil
+}
+
+func (keyboard *Keyboard) ReadPort() byte {
+	if keyboard.autoScan {
+		return 0xFF
+	}
+	keyboard.lock.Lock()
+	pressed := keyboard.matrix[keyboard.column]&(1<<keyboard.row) != 0
+	keyboard.lock.Unlock()
+	if pressed {
+		return 0xFF
+	}
+	return 0x7F
+}
+
+func (keyboard *Keyboard) WritePort(value, ddr byte) {
+	if keyboard.autoScan {
+		return
+	}
+	keyboard.column = value & 0x0F
+	keyboard.row = (value >> 4) & 0x07
+	keyboard.updateCA2()
+}
+
+// CA2 is raised by keys pressed in the current column, row 0 excepted.
+func (keyboard *Keyboard) updateCA2() {
+	keyboard.lock.Lock()
+	ca2 := keyboard.matrix[keyboard.column]&0xFE != 0
+	keyboard.lock.Unlock()
+	if ca2 != keyboard.ca2 {
+		keyboard.ca2 = ca2
+		keyboard.via.SetCA2(ca2)
+	}
+}
+
+func (keyboard *Keyboard) Step(ticks uint64) error {
+	keyboard.lock.Lock()
+	breakPressed := keyboard.breakPressed
+	keyboard.lock.Unlock()
+	if breakPressed != keyboard.resetAsserted && keyboard.bus != nil {
+		keyboard.resetAsserted = breakPressed
+		keyboard.bus.RESET.Set(KeyBreak, breakPressed)
+	}
+
+	if !keyboard.autoScan {
+		return nil
+	}
+	for ; ticks > 0; ticks-- {
+		keyboard.column = (keyboard.column + 1) & 0x0F
+		keyboard.updateCA2()
+	}
+	return nil
+}
+
+// The keyboard connects itself to the System VIA port A and the latch.
+func NewKeyboard(name string, via *VIA, latch *AddressableLatch) *Keyboard {
+	keyboard := &Keyboard{
+		name:     name,
+		via:      via,
+		physical: DefaultPhysicalKeyMap,
+		logical:  DefaultLogicalKeyMap,
+		autoScan: latch.IsKeyboardAutoScan(),
+	}
+	via.ConnectPortA(keyboard)
+	via.SetCA2(keyboard.ca2)
+	latch.Observe(func(output LatchOutput, level bool) {
+		if output == LatchKeyboardWrite {
+			keyboard.autoScan = level
+		}
+	})
+	return keyboard
+}
@@ -0,0 +1,96 @@
+package hardware
+
+const (
+	KeyShift     = "SHIFT"
+	KeyCtrl      = "CTRL"
+	KeyBreak     = "BREAK"
+	KeyCapsLock  = "CAPS LOCK"
+	KeyShiftLock = "SHIFT LOCK"
+)
+
+// BBC keys position in the matrix, as row << 4 | column. Row 0 columns 2 to
+// 9 are the startup links.
+var keyPositions = map[string]uint8{
+	KeyShift: 0x00, KeyCtrl: 0x01,
+
+	"Q": 0x10, "3": 0x11, "4": 0x12, "5": 0x13, "F4": 0x14,
+	"8": 0x15, "F7": 0x16, "-": 0x17, "^": 0x18, "LEFT": 0x19,
+
+	"F0": 0x20, "W": 0x21, "E": 0x22, "T": 0x23, "7": 0x24,
+	"I": 0x25, "9": 0x26, "0": 0x27, "_": 0x28, "DOWN": 0x29,
+
+	"1": 0x30, "2": 0x31, "D": 0x32, "R": 0x33, "6": 0x34,
+	"U": 0x35, "O": 0x36, "P": 0x37, "[": 0x38, "UP": 0x39,
+
+	KeyCapsLock: 0x40, "A": 0x41, "X": 0x42, "F": 0x43, "Y": 0x44,
+	"J": 0x45, "K": 0x46, "@": 0x47, ":": 0x48, "RETURN": 0x49,
+
+	KeyShiftLock: 0x50, "S": 0x51, "C": 0x52, "G": 0x53, "H": 0x54,
+	"N": 0x55, "L": 0x56, ";": 0x57, "]": 0x58, "DELETE": 0x59,
+
+	"TAB": 0x60, "Z": 0x61, "SPACE": 0x62, "V": 0x63, "B": 0x64,
+	"M": 0x65, ",": 0x66, ".": 0x67, "/": 0x68, "COPY": 0x69,
+
+	"ESCAPE": 0x70, "F1": 0x71, "F2": 0x72, "F3": 0x73, "F5": 0x74,
+	"F6": 0x75, "F8": 0x76, "F9": 0x77, "\\": 0x78, "RIGHT": 0x79,
+}
+
+// Host keys, named after their physical position on a PC keyboard, mapped
+// to the BBC key at the same place.
+type PhysicalKeyMap map[string]string
+
+// BBC key stroke producing a character.
+type KeyStroke struct {
+	Key   string
+	Shift bool
+}
+
+// Characters mapped to the BBC key stroke typing them. Letters assume the
+// CAPS LOCK default: shifted letters are lower case.
+type LogicalKeyMap map[rune]KeyStroke
+
+// Position based mapping using W3C key codes, the BBC symbol keys are the
+// ones closest to the PC keys.
+var DefaultPhysicalKeyMap = PhysicalKeyMap{
+	"Escape": "ESCAPE", "Tab": "TAB", "CapsLock": KeyCapsLock,
+	"ShiftLeft": KeyShift, "ShiftRight": KeyShift,
+	"ControlLeft": KeyCtrl, "ControlRight": KeyCtrl,
+	"AltLeft": KeyShiftLock, "Enter": "RETURN", "Backspace": "DELETE",
+	"End": "COPY", "Space": "SPACE", "Pause": KeyBreak, "F12": KeyBreak,
+	"ArrowLeft": "LEFT", "ArrowRight": "RIGHT", "ArrowUp": "UP", "ArrowDown": "DOWN",
+
+	"Minus": "-", "Equal": "^", "Backquote": "_", "Backslash": "\\",
+	"BracketLeft": "@", "BracketRight": "[", "Semicolon": ";",
+	"Quote": ":", "IntlBackslash": "]", "Comma": ",", "Period": ".", "Slash": "/",
+
+	"F10": "F0", "F1": "F1", "F2": "F2", "F3": "F3", "F4": "F4",
+	"F5": "F5", "F6": "F6", "F7": "F7", "F8": "F8", "F9": "F9",
+}
+
+// Character based mapping for the UK BBC keyboard.
+var DefaultLogicalKeyMap = LogicalKeyMap{
+	' ': {"SPACE", false}, '\r': {"RETURN", false}, '\n': {"RETURN", false},
+	'\t': {"TAB", false}, '\b': {"DELETE", false}, 0x1B: {"ESCAPE", false},
+
+	'!': {"1", true}, '"': {"2", true}, '#': {"3", true}, '$': {"4", true},
+	'%': {"5", true}, '&': {"6", true}, '\'': {"7", true}, '(': {"8", true},
+	')': {"9", true}, '=': {"-", true}, '~': {"^", true}, '|': {"\\", true},
+	'£': {"_", true}, '{': {"[", true}, '}': {"]", true}, '+': {";", true},
+	'*': {":", true}, '<': {",", true}, '>': {".", true}, '?': {"/", true},
+
+	'-': {"-", false}, '^': {"^", false}, '\\': {"\\", false}, '_': {"_", false},
+	'@': {"@", false}, '[': {"[", false}, ']': {"]", false}, ';': {";", false},
+	':': {":", false}, ',': {",", false}, '.': {".", false}, '/': {"/", false},
+}
+
+func init() {
+	for c := '0'; c <= '9'; c++ {
+		DefaultLogicalKeyMap[c] = KeyStroke{string(c), false}
+		DefaultPhysicalKeyMap["Digit"+string(c)] = string(c)
+	}
+	for c := 'A'; c <= 'Z'; c++ {
+		DefaultLogicalKeyMap[c] = KeyStroke{string(c), false}
+		DefaultLogicalKeyMap[c-'A'+'a'] = KeyStroke{string(c), true}
+		DefaultPhysicalKeyMap["Key"+string(c)] = string(c)
+	}
+}
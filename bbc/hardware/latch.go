@@ -0,0 +1,104 @@
+package hardware
+
+// 74LS259 addressable latch (IC32) driven by the System VIA port B: bits 0-2
+// select an output, bit 3 is the value it latches.
+
+type LatchOutput uint8
+
+const (
+	// active low
+	LatchSoundWrite LatchOutput = iota
+	LatchSpeechRead
+	LatchSpeechWrite
+	// active low, the keyboard autoscans while high
+	LatchKeyboardWrite
+	// screen wraparound size
+	LatchScreenC0
+	LatchScreenC1
+	// active low
+	LatchCapsLockLED
+	// active low
+	LatchShiftLockLED
+)
+
+type LatchObserver func(output LatchOutput, level bool)
+
+type AddressableLatch struct {
+	name      string
+	value     byte
+	observers []LatchObserver
+	bus       *Bus
+}
+
+func (latch *AddressableLatch) GetName() string    { return latch.name }
+func (latch *AddressableLatch) PlugToBus(bus *Bus) { latch.bus = bus }
+
+func (latch *AddressableLatch) Start() error {
+	return nil
+}
+
+// The latch is cleared by the power on reset.
+func (latch *AddressableLatch) Reset() error {
+	for output := LatchSoundWrite; output <= LatchShiftLockLED; output++ {
+		latch.Set(output, false)
+	}
+	return nil
+}
+
+func (latch *AddressableLatch) Stop() error {
+	return nil
+}
+
+// The latch never drives the port.
+func (latch *AddressableLatch) ReadPort() byte {
+	return 0xFF
+}
+
+func (latch *AddressableLatch) WritePort(value, ddr byte) {
+	// address and data lines must be outputs
+	if ddr&0x0F != 0x0F {
+		return
+	}
+	latch.Set(LatchOutput(value&0x07), value&0x08 != 0)
+}
+
+func (latch *AddressableLatch) Set(output LatchOutput, level bool) {
+	mask := byte(1) << output
+	if (latch.value&mask != 0) == level {
+		return
+	}
+	latch.value ^= mask
+	for _, observer := range latch.observers {
+		observer(output, level)
+	}
+}
+
+func (latch *AddressableLatch) Get(output LatchOutput) bool {
+	return latch.value&(1<<output) != 0
+}
+
+func (latch *AddressableLatch) GetValue() byte {
+	return latch.value
+}
+
+// Observers are called each time an output changes.
+func (latch *AddressableLatch) Observe(observer LatchObserver) {
+	latch.observers = append(latch.observers, observer)
+}
+
+func (latch *AddressableLatch) IsSoundWriteEnabled() bool { return !latch.Get(LatchSoundWrite) }
+func (latch *AddressableLatch) IsKeyboardAutoScan() bool  { return latch.Get(LatchKeyboardWrite) }
+func (latch *AddressableLatch) IsCapsLockLED() bool       { return !latch.Get(LatchCapsLockLED) }
+func (latch *AddressableLatch) IsShiftLockLED() bool      { return !latch.Get(LatchShiftLockLED) }
+
+// Screen size selected by C0 and C1, as an index in the wraparound table.
+func (latch *AddressableLatch) GetScreenSize() byte {
+	return (latch.value >> LatchScreenC0) & 0x03
+}
+
+func NewAddressableLatch(name string) *AddressableLatch {
+	return &AddressableLatch{
+		name:      name,
+		observers: []LatchObserver{},
+	}
+}
@@ -0,0 +1,116 @@
+package hardware
+
+// Centronics printer on the user port: User VIA port A carries the data,
+// CA2 the strobe and CA1 the acknowledge. The byte on the port is taken on
+// the falling edge of the strobe, the acknowledge pulses low once the
+// printer is ready for the next one.
+
+const (
+	// microseconds from the strobe to the acknowledge, as an Epson FX-80
+	// with room in its buffer
+	printerBusyTime = 10
+	printerAckWidth = 5
+)
+
+type PrintObserver func(value byte)
+
+type Printer struct {
+	name string
+	bus  *Bus
+	via  *VIA
+
+	data   byte
+	strobe bool
+	// microseconds until the acknowledge starts, then until it ends
+	busy uint64
+	ack  uint64
+
+	online    bool
+	observers []PrintObserver
+}
+
+func (printer *Printer) GetName() string    { return printer.name }
+func (printer *Printer) PlugToBus(bus *Bus) { printer.bus = bus }
+func (printer *Printer) GetDomain() string  { return DomainPeripheral }
+
+func (printer *Printer) Start() error {
+	return nil
+}
+
+func (printer *Printer) Reset() error {
+	printer.busy, printer.ack = 0, 0
+	printer.strobe = true
+	printer.via.SetCA1(true)
+	return nil
+}
+
+func (printer *Printer) Stop() error {
+	return nil
+}
+
+// An offline printer takes nothing and never acknowledges.
+func (printer *Printer) SetOnline(online bool) {
+	printer.online = online
+}
+
+func (printer *Printer) IsOnline() bool {
+	return printer.online
+}
+
+// Called with each byte printed.
+func (printer *Printer) OnPrint(observer PrintObserver) {
+	printer.observers = append(printer.observers, observer)
+}
+
+func (printer *Printer) IsBusy() bool {
+	return printer.busy > 0 || printer.ack > 0
+}
+
+// The printer never drives the data lines.
+func (printer *Printer) ReadPort() byte {
+	return 0xFF
+}
+
+func (printer *Printer) WritePort(value, ddr byte) {
+	printer.data = value | ^ddr
+}
+
+func (printer *Printer) WriteControl(line VIAControlLine, level bool) {
+	if line != VIAControl2 || level == printer.strobe {
+		return
+	}
+	printer.strobe = level
+	if level || !printer.online || printer.IsBusy() {
+		return
+	}
+	for _, observer := range printer.observers {
+		observer(printer.data)
+	}
+	printer.busy = printerBusyTime
+}
+
+func (printer *Printer) Step(ticks uint64) error {
+	for ; ticks > 0 && printer.IsBusy(); ticks-- {
+		if printer.busy > 0 {
+			printer.busy--
+			if printer.busy == 0 {
+				printer.ack = printerAckWidth
+				printer.via.SetCA1(false)
+			}
+			continue
+		}
+		printer.ack--
+		if printer.ack == 0 {
+			printer.via.SetCA1(true)
+		}
+	}
+	return nil
+}
+
+// The printer connects itself to port A of the VIA, online.
+func NewPrinter(name string, via *VIA) *Printer {
+	printer := &Printer{name: name, via: via, online: true}
+	via.ConnectPortA(printer)
+	printer.Reset()
+	return printer
+}
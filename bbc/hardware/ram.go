@@ -6,8 +6,9 @@ import (
 )
 
 type RAM struct {
-	memory []byte
-	bus    *Bus
+	memory  []byte
+	segment *utils.Segment
+	bus     *Bus
 }
 
 func (ram *RAM) GetName() string    { return "RAM" }
@@ -15,7 +16,7 @@ func (ram *RAM) PlugToBus(bus *Bus) { ram.bus = bus }
 func (ram *RAM) IsWritable() bool   { return true }
 func (ram *RAM) IsReadable() bool   { return true }
 func (ram *RAM) GetSegment() *utils.Segment {
-	return logical.AdressableSegment
+	return ram.segment
 }
 
 func (ram *RAM) Start() error {
@@ -31,7 +32,7 @@ func (ram *RAM) Stop() error {
 }
 
 func (ram *RAM) DirectRead(addr uint16) (byte, error) {
-	return ram.memory[addr], nil
+	return ram.memory[addr-ram.segment.Start], nil
 }
 
 func (ram *RAM) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
@@ -44,7 +45,7 @@ func (ram *RAM) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
 }
 
 func (ram *RAM) DirectWrite(value byte, addr uint16) error {
-	ram.memory[addr] = value
+	ram.memory[addr-ram.segment.Start] = value
 	return nil
 }
 
@@ -57,13 +58,19 @@ func (ram *RAM) OffsetWrite(value byte, base uint16, offset uint8) (uint16, erro
 }
 
 func (ram *RAM) Clear() {
-	for i := uint16(0); i <= ram.GetSegment().End; i++ {
+	for i := range ram.memory {
 		ram.memory[i] = 0
 	}
 }
 
+// RAM covering the whole address space.
 func NewRAM() *RAM {
+	return NewRAMSegment(logical.AdressableSegment)
+}
+
+func NewRAMSegment(segment *utils.Segment) *RAM {
 	return &RAM{
-		memory: make([]byte, logical.AdressableSegment.Size()),
+		memory:  make([]byte, segment.Size()),
+		segment: segment,
 	}
 }
@@ -0,0 +1,218 @@
+package hardware
+
+import (
+	"bbc/utils"
+	"fmt"
+)
+
+const (
+	PagedROMSlots = 16
+	PagedROMSize  = 0x4000
+)
+
+// Read only memory, the image is mapped from the segment start. Writes are
+// left to the bus.
+type ROM struct {
+	name    string
+	image   []byte
+	segment *utils.Segment
+	bus     *Bus
+}
+
+func (rom *ROM) GetName() string            { return rom.name }
+func (rom *ROM) PlugToBus(bus *Bus)         { rom.bus = bus }
+func (rom *ROM) IsWritable() bool           { return false }
+func (rom *ROM) IsReadable() bool           { return true }
+func (rom *ROM) GetSegment() *utils.Segment { return rom.segment }
+
+func (rom *ROM) Start() error {
+	return nil
+}
+
+func (rom *ROM) Reset() error {
+	return nil
+}
+
+func (rom *ROM) Stop() error {
+	return nil
+}
+
+func (rom *ROM) DirectRead(addr uint16) (byte, error) {
+	offset := int(addr - rom.segment.Start)
+	if offset >= len(rom.image) {
+		return 0xFF, nil
+	}
+	return rom.image[offset], nil
+}
+
+func (rom *ROM) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := rom.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func NewROM(name string, segment *utils.Segment, image []byte) (*ROM, error) {
+	if len(image) > int(segment.Size()) {
+		return nil, fmt.Errorf("ROM %s image is larger than %s", name, segment)
+	}
+	return &ROM{
+		name:    name,
+		image:   image,
+		segment: segment,
+	}, nil
+}
+
+// Sideways ROM sockets sharing 8000-BFFF, one of them is paged in by the
+// ROMSEL register. Sideways RAM slots are writable.
+type PagedROM struct {
+	name     string
+	segment  *utils.Segment
+	bus      *Bus
+	selected byte
+	slots    [PagedROMSlots][]byte
+	writable [PagedROMSlots]bool
+}
+
+func (paged *PagedROM) GetName() string            { return paged.name }
+func (paged *PagedROM) PlugToBus(bus *Bus)         { paged.bus = bus }
+func (paged *PagedROM) IsWritable() bool           { return true }
+func (paged *PagedROM) IsReadable() bool           { return true }
+func (paged *PagedROM) GetSegment() *utils.Segment { return paged.segment }
+
+func (paged *PagedROM) Start() error {
+	return nil
+}
+
+func (paged *PagedROM) Reset() error {
+	paged.selected = 0
+	return nil
+}
+
+func (paged *PagedROM) Stop() error {
+	return nil
+}
+
+func (paged *PagedROM) LoadROM(slot int, image []byte) error {
+	if slot < 0 || slot >= PagedROMSlots {
+		return fmt.Errorf("invalid sideways ROM slot %d", slot)
+	}
+	if len(image) > PagedROMSize {
+		return fmt.Errorf("sideways ROM image is %d bytes long", len(image))
+	}
+	paged.slots[slot] = image
+	paged.writable[slot] = false
+	return nil
+}
+
+// Turns a slot into 16K of sideways RAM.
+func (paged *PagedROM) SetRAM(slot int) error {
+	if slot < 0 || slot >= PagedROMSlots {
+		return fmt.Errorf("invalid sideways RAM slot %d", slot)
+	}
+	paged.slots[slot] = make([]byte, PagedROMSize)
+	paged.writable[slot] = true
+	return nil
+}
+
+func (paged *PagedROM) GetSlot(slot int) []byte {
+	return paged.slots[slot]
+}
+
+func (paged *PagedROM) Select(slot byte) {
+	paged.selected = slot % PagedROMSlots
+}
+
+func (paged *PagedROM) GetSelected() byte {
+	return paged.selected
+}
+
+func (paged *PagedROM) DirectRead(addr uint16) (byte, error) {
+	image := paged.slots[paged.selected]
+	offset := int(addr - paged.segment.Start)
+	if offset >= len(image) {
+		// empty socket
+		return 0xFF, nil
+	}
+	return image[offset], nil
+}
+
+func (paged *PagedROM) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := paged.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func (paged *PagedROM) DirectWrite(value byte, addr uint16) error {
+	if paged.writable[paged.selected] {
+		paged.slots[paged.selected][addr-paged.segment.Start] = value
+	}
+	return nil
+}
+
+func (paged *PagedROM) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := paged.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+func NewPagedROM(name string) *PagedROM {
+	return &PagedROM{
+		name:    name,
+		segment: utils.NewSegment(0x8000, 0xBFFF),
+	}
+}
+
+// ROMSEL write only latch selecting the paged ROM.
+type ROMSelect struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+	paged   *PagedROM
+}
+
+func (romsel *ROMSelect) GetName() string            { return romsel.name }
+func (romsel *ROMSelect) PlugToBus(bus *Bus)         { romsel.bus = bus }
+func (romsel *ROMSelect) IsWritable() bool           { return true }
+func (romsel *ROMSelect) IsReadable() bool           { return false }
+func (romsel *ROMSelect) GetSegment() *utils.Segment { return romsel.segment }
+
+func (romsel *ROMSelect) Start() error {
+	return nil
+}
+
+func (romsel *ROMSelect) Reset() error {
+	return nil
+}
+
+func (romsel *ROMSelect) Stop() error {
+	return nil
+}
+
+func (romsel *ROMSelect) DirectWrite(value byte, addr uint16) error {
+	romsel.paged.Select(value & 0x0F)
+	return nil
+}
+
+func (romsel *ROMSelect) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := romsel.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+func NewROMSelect(name string, segment *utils.Segment, paged *PagedROM) *ROMSelect {
+	return &ROMSelect{
+		name:    name,
+		segment: segment,
+		paged:   paged,
+	}
+}
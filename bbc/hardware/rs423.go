@@ -0,0 +1,192 @@
+package hardware
+
+import (
+	"io"
+	"sync"
+)
+
+// Host end of the RS423 port. The frames the ACIA sends are decoded from
+// TxD and written to the connection, the bytes read from it are sent on
+// RxD, both at the rates of the Serial ULA and in the word format of the
+// ACIA. Bytes are only sent while the ACIA holds RTS low, CTS is raised
+// while the host does not keep up with what is sent to it.
+
+const (
+	rs423OutputBuffer = 256
+	// bytes pending before CTS is raised
+	rs423OutputLimit = rs423OutputBuffer - 16
+)
+
+type RS423 struct {
+	acia *ACIA
+
+	// host facing state, the connection is served by its own goroutines
+	lock   sync.Mutex
+	conn   io.ReadWriteCloser
+	input  []byte
+	output chan byte
+
+	// frame sent on RxD from its least significant bit, with the receive
+	// clocks spent on the current bit
+	rxShift uint32
+	rxBits  int
+	rxClock int
+
+	// frame being decoded from TxD
+	txActive bool
+	txBit    int
+	txShift  uint16
+}
+
+// Serves the connection, replacing the one there was.
+func (rs423 *RS423) Connect(conn io.ReadWriteCloser) {
+	rs423.Disconnect()
+	output := make(chan byte, rs423OutputBuffer)
+	rs423.lock.Lock()
+	rs423.conn, rs423.output = conn, output
+	rs423.lock.Unlock()
+	go rs423.read(conn)
+	go rs423.write(conn, output)
+}
+
+func (rs423 *RS423) Disconnect() {
+	rs423.lock.Lock()
+	defer rs423.lock.Unlock()
+	if rs423.conn == nil {
+		return
+	}
+	rs423.conn.Close()
+	close(rs423.output)
+	rs423.conn, rs423.output, rs423.input = nil, nil, nil
+}
+
+func (rs423 *RS423) IsConnected() bool {
+	rs423.lock.Lock()
+	defer rs423.lock.Unlock()
+	return rs423.conn != nil
+}
+
+// Reading stops at the end of the input, the output stays open.
+func (rs423 *RS423) read(conn io.ReadWriteCloser) {
+	buffer := make([]byte, 256)
+	for {
+		n, err := conn.Read(buffer)
+		rs423.lock.Lock()
+		if rs423.conn != conn {
+			rs423.lock.Unlock()
+			return
+		}
+		rs423.input = append(rs423.input, buffer[:n]...)
+		rs423.lock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (rs423 *RS423) write(conn io.ReadWriteCloser, output chan byte) {
+	for value := range output {
+		data := []byte{value}
+		// whatever else is waiting goes in the same write
+		for more := true; more; {
+			select {
+			case next, ok := <-output:
+				if ok {
+					data = append(data, next)
+				}
+				more = ok
+			default:
+				more = false
+			}
+		}
+		if _, err := conn.Write(data); err != nil {
+			rs423.lock.Lock()
+			lost := rs423.conn == conn
+			rs423.lock.Unlock()
+			if lost {
+				rs423.Disconnect()
+			}
+			return
+		}
+	}
+}
+
+// CTS input of the ACIA, high while the output is backed up.
+func (rs423 *RS423) isBlocked() bool {
+	rs423.lock.Lock()
+	defer rs423.lock.Unlock()
+	return rs423.output != nil && len(rs423.output) >= rs423OutputLimit
+}
+
+// The next byte from the host, unless RTS is high or there is none.
+func (rs423 *RS423) nextFrame() bool {
+	if rs423.acia.GetRTS() {
+		return false
+	}
+	rs423.lock.Lock()
+	defer rs423.lock.Unlock()
+	if len(rs423.input) == 0 {
+		return false
+	}
+	rs423.rxShift, rs423.rxBits = rs423.acia.word().frame(rs423.input[0])
+	rs423.input = rs423.input[1:]
+	rs423.rxClock = 0
+	return true
+}
+
+// RxD level for one receive clock, the line idles high.
+func (rs423 *RS423) receiveClock() bool {
+	if rs423.acia.control&aciaDivideMask == aciaMasterReset {
+		return true
+	}
+	if rs423.rxBits == 0 && !rs423.nextFrame() {
+		return true
+	}
+	level := rs423.rxShift&1 != 0
+	rs423.rxClock++
+	if rs423.rxClock >= rs423.acia.divider() {
+		rs423.rxClock = 0
+		rs423.rxShift >>= 1
+		rs423.rxBits--
+	}
+	return level
+}
+
+// TxD level in the middle of a bit.
+func (rs423 *RS423) transmitBit(txd bool) {
+	if !rs423.txActive {
+		if !txd {
+			rs423.txActive, rs423.txBit, rs423.txShift = true, 0, 0
+		}
+		return
+	}
+	word := rs423.acia.word()
+	frameBits := word.bits
+	if word.parity != 'N' {
+		frameBits++
+	}
+	if rs423.txBit < frameBits {
+		if txd {
+			rs423.txShift |= 1 << rs423.txBit
+		}
+		rs423.txBit++
+		return
+	}
+	// stop bit
+	rs423.txActive = false
+	value := byte(rs423.txShift & (1<<word.bits - 1))
+	rs423.lock.Lock()
+	defer rs423.lock.Unlock()
+	if rs423.output == nil {
+		return
+	}
+	select {
+	case rs423.output <- value:
+	default:
+		// the host fell behind and CTS was ignored
+	}
+}
+
+func NewRS423(acia *ACIA) *RS423 {
+	return &RS423{acia: acia}
+}
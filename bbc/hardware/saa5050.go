@@ -0,0 +1,277 @@
+package hardware
+
+// Mullard SAA5050 teletext character generator, fed by the video ULA in
+// MODE 7. Each character is 6 dots wide and 10 rows high, output as 12 half
+// dots and 20 lines once rounded: lines are the scan lines of both fields.
+
+const (
+	teletextHalfDots = 12
+	teletextLines    = 20
+	// flashing characters are shown 48 fields out of 64
+	teletextFlashPeriod = 64
+	teletextFlashOn     = 48
+)
+
+// control codes
+const (
+	teletextAlphaRed      = 0x01
+	teletextAlphaWhite    = 0x07
+	teletextFlash         = 0x08
+	teletextSteady        = 0x09
+	teletextNormalHeight  = 0x0C
+	teletextDoubleHeight  = 0x0D
+	teletextGraphicsRed   = 0x11
+	teletextGraphicsWhite = 0x17
+	teletextConceal       = 0x18
+	teletextContiguous    = 0x19
+	teletextSeparated     = 0x1A
+	teletextBlackBack     = 0x1C
+	teletextNewBack       = 0x1D
+	teletextHold          = 0x1E
+	teletextRelease       = 0x1F
+)
+
+// rounded alphanumerics, 12 half dots per line
+var teletextGlyphs [96][teletextLines]uint16
+
+// mosaic cells covered by each line, and the lines left out when separated
+var mosaicRowBits = [teletextLines]byte{
+	0x03, 0x03, 0x03, 0x03, 0x03, 0x03,
+	0x0C, 0x0C, 0x0C, 0x0C, 0x0C, 0x0C, 0x0C, 0x0C,
+	0x50, 0x50, 0x50, 0x50, 0x50, 0x50,
+}
+var mosaicSeparatedGap = [teletextLines]bool{
+	4: true, 5: true, 12: true, 13: true, 18: true, 19: true,
+}
+
+func init() {
+	glyphs := parseSAA5050Font()
+	dot := func(c int, row int, x int) bool {
+		if row < 0 || row >= len(glyphs[c]) || x < 0 || x > 5 {
+			return false
+		}
+		return glyphs[c][row]&(0x20>>x) != 0
+	}
+	// character rounding: a half dot fills the corner of diagonals, on the
+	// line of each row next to the row the diagonal goes to
+	for c := range glyphs {
+		for line := 0; line < teletextLines; line++ {
+			row := line / 2
+			other := row - 1
+			if line%2 == 1 {
+				other = row + 1
+			}
+			bits := uint16(0)
+			for x := 0; x < 6; x++ {
+				left := uint16(1) << (teletextHalfDots - 1 - 2*x)
+				right := left >> 1
+				switch {
+				case dot(c, row, x):
+					bits |= left | right
+				case dot(c, other, x):
+					if dot(c, row, x-1) && !dot(c, other, x-1) {
+						bits |= left
+					}
+					if dot(c, row, x+1) && !dot(c, other, x+1) {
+						bits |= right
+					}
+				}
+			}
+			teletextGlyphs[c][line] = bits
+		}
+	}
+}
+
+func mosaicLine(code byte, line int, separated bool) uint16 {
+	cells := code & mosaicRowBits[line]
+	if separated && mosaicSeparatedGap[line] {
+		return 0
+	}
+	// left cells are bits 0, 2 and 4, right ones 1, 3 and 6
+	bits := uint16(0)
+	if cells&0x15 != 0 {
+		bits |= 0xFC0
+		if separated {
+			bits &^= 0xC00
+		}
+	}
+	if cells&0x4A != 0 {
+		bits |= 0x03F
+		if separated {
+			bits &^= 0x030
+		}
+	}
+	return bits
+}
+
+type SAA5050 struct {
+	// characters reach the generator through the board latch and come out
+	// one clock later, two clocks after the CRTC addressed them
+	latched [2]byte
+
+	// attributes, reset at the start of each line
+	inLine     bool
+	foreground byte
+	background byte
+	graphics   bool
+	separated  bool
+	flash      bool
+	conceal    bool
+	double     bool
+	hold       bool
+	held       byte
+	heldSep    bool
+
+	// the row following a double height one shows the lower halves
+	lastLine     int
+	rowHasDouble bool
+	bottomRow    bool
+
+	fields uint64
+}
+
+// Called on each vsync.
+func (saa *SAA5050) VSync() {
+	saa.fields++
+	saa.rowHasDouble = false
+	saa.bottomRow = false
+	saa.lastLine = 0
+}
+
+func (saa *SAA5050) startLine(line int) {
+	if line < saa.lastLine {
+		saa.bottomRow = saa.rowHasDouble && !saa.bottomRow
+		saa.rowHasDouble = false
+	}
+	saa.lastLine = line
+	saa.inLine = true
+	saa.foreground = teletextAlphaWhite
+	saa.background = 0
+	saa.graphics = false
+	saa.separated = false
+	saa.flash = false
+	saa.conceal = false
+	saa.double = false
+	saa.hold = false
+	saa.held = ' '
+	saa.heldSep = false
+}
+
+// Attributes taking effect on the control character itself.
+func (saa *SAA5050) setAt(code byte) {
+	switch code {
+	case teletextSteady:
+		saa.flash = false
+	case teletextNormalHeight:
+		if saa.double {
+			saa.held = ' '
+		}
+		saa.double = false
+	case teletextConceal:
+		saa.conceal = true
+	case teletextContiguous:
+		saa.separated = false
+	case teletextSeparated:
+		saa.separated = true
+	case teletextBlackBack:
+		saa.background = 0
+	case teletextNewBack:
+		saa.background = saa.foreground
+	case teletextHold:
+		saa.hold = true
+	}
+}
+
+// Attributes taking effect on the next character.
+func (saa *SAA5050) setAfter(code byte) {
+	switch {
+	case code >= teletextAlphaRed && code <= teletextAlphaWhite:
+		if saa.graphics {
+			saa.held = ' '
+		}
+		saa.foreground = code
+		saa.graphics = false
+		saa.conceal = false
+	case code >= teletextGraphicsRed && code <= teletextGraphicsWhite:
+		if !saa.graphics {
+			saa.held = ' '
+		}
+		saa.foreground = code & 0x07
+		saa.graphics = true
+		saa.conceal = false
+	case code == teletextFlash:
+		saa.flash = true
+	case code == teletextDoubleHeight:
+		if !saa.double {
+			saa.held = ' '
+		}
+		saa.double = true
+		saa.rowHasDouble = true
+	case code == teletextRelease:
+		saa.hold = false
+	}
+}
+
+// Dots of a character on a line, the character code is 7 bits.
+func (saa *SAA5050) dots(code byte, line int) uint16 {
+	if saa.double {
+		line /= 2
+		if saa.bottomRow {
+			line += teletextLines / 2
+		}
+	} else if saa.bottomRow {
+		return 0
+	}
+	if saa.conceal || (saa.flash && saa.fields%teletextFlashPeriod >= teletextFlashOn) {
+		return 0
+	}
+
+	separated := saa.separated
+	if code < 0x20 {
+		if !saa.hold || !saa.graphics {
+			return 0
+		}
+		code, separated = saa.held, saa.heldSep
+	}
+	if saa.graphics && code&0x20 != 0 {
+		saa.held, saa.heldSep = code, separated
+		return mosaicLine(code, line, separated)
+	}
+	return teletextGlyphs[code-0x20][line]
+}
+
+// Feeds a character from the screen memory and outputs the one fed two
+// clocks before as physical colours. Display is the CRTC display enable,
+// delayed by the skew to match, line the rounded line in the row, 0 to 19.
+func (saa *SAA5050) Character(data byte, line int, display bool, pixels []byte) {
+	code := saa.latched[1] & 0x7F
+	saa.latched[1], saa.latched[0] = saa.latched[0], data
+
+	if !display {
+		saa.inLine = false
+		for i := range pixels {
+			pixels[i] = 0
+		}
+		return
+	}
+	if !saa.inLine {
+		saa.startLine(line)
+	}
+	saa.setAt(code)
+	bits := saa.dots(code, line)
+	for i := range pixels {
+		colour := saa.background
+		if bits&(1<<(teletextHalfDots-1-i*teletextHalfDots/len(pixels))) != 0 {
+			colour = saa.foreground
+		}
+		pixels[i] = colour
+	}
+	saa.setAfter(code)
+}
+
+func NewSAA5050() *SAA5050 {
+	saa := &SAA5050{}
+	saa.startLine(0)
+	saa.inLine = false
+	return saa
+}
@@ -0,0 +1,128 @@
+package hardware
+
+import "strings"
+
+// SAA5050 UK character set from 0x20 to 0x7F. Each glyph is 5 dots wide and
+// 10 rows high, rows separated by spaces; the sixth column is always blank.
+var saa5050Font = [96]string{
+	// 0x20
+	"..... ..... ..... ..... ..... ..... ..... ..... ..... .....",
+	"..... ..#.. ..#.. ..#.. ..#.. ..#.. ..... ..#.. ..... .....",
+	"..... .#.#. .#.#. .#.#. ..... ..... ..... ..... ..... .....",
+	// pound sign
+	"..... ...#. ..#.# ..#.. .###. ..#.. ..#.. ##### ..... .....",
+	"..... .###. #.#.# #.#.. .###. ..#.# #.#.# .###. ..... .....",
+	"..... ##... ##..# ...#. ..#.. .#... #..## ...## ..... .....",
+	"..... .#... #.#.. #.#.. .#... #.#.# #..#. .##.# ..... .....",
+	"..... ..#.. ..#.. ..#.. ..... ..... ..... ..... ..... .....",
+	"..... ...#. ..#.. .#... .#... .#... ..#.. ...#. ..... .....",
+	"..... .#... ..#.. ...#. ...#. ...#. ..#.. .#... ..... .....",
+	"..... ..#.. #.#.# .###. ..#.. .###. #.#.# ..#.. ..... .....",
+	"..... ..... ..#.. ..#.. ##### ..#.. ..#.. ..... ..... .....",
+	"..... ..... ..... ..... ..... ..... ..#.. ..#.. .#... .....",
+	"..... ..... ..... ..... .###. ..... ..... ..... ..... .....",
+	"..... ..... ..... ..... ..... ..... ..... ..#.. ..... .....",
+	"..... ..... ....# ...#. ..#.. .#... #.... ..... ..... .....",
+	// 0x30
+	"..... ..#.. .#.#. #...# #...# #...# .#.#. ..#.. ..... .....",
+	"..... ..#.. .##.. ..#.. ..#.. ..#.. ..#.. .###. ..... .....",
+	"..... .###. #...# ....# ..##. .#... #.... ##### ..... .....",
+	"..... ##### ....# ...#. ..##. ....# #...# .###. ..... .....",
+	"..... ...#. ..##. .#.#. #..#. ##### ...#. ...#. ..... .....",
+	"..... ##### #.... ####. ....# ....# #...# .###. ..... .....",
+	"..... ..##. .#... #.... ####. #...# #...# .###. ..... .....",
+	"..... ##### ....# ...#. ..#.. .#... .#... .#... ..... .....",
+	"..... .###. #...# #...# .###. #...# #...# .###. ..... .....",
+	"..... .###. #...# #...# .#### ....# ...#. .##.. ..... .....",
+	"..... ..... ..... ..#.. ..... ..... ..... ..#.. ..... .....",
+	"..... ..... ..... ..#.. ..... ..... ..#.. ..#.. .#... .....",
+	"..... ...#. ..#.. .#... #.... .#... ..#.. ...#. ..... .....",
+	"..... ..... ..... ##### ..... ##### ..... ..... ..... .....",
+	"..... .#... ..#.. ...#. ....# ...#. ..#.. .#... ..... .....",
+	"..... .###. #...# ...#. ..#.. ..#.. ..... ..#.. ..... .....",
+	// 0x40
+	"..... .###. #...# #.### #.#.# #.### #.... .###. ..... .....",
+	"..... ..#.. .#.#. #...# #...# ##### #...# #...# ..... .....",
+	"..... ####. #...# #...# ####. #...# #...# ####. ..... .....",
+	"..... .###. #...# #.... #.... #.... #...# .###. ..... .....",
+	"..... ####. #...# #...# #...# #...# #...# ####. ..... .....",
+	"..... ##### #.... #.... ####. #.... #.... ##### ..... .....",
+	"..... ##### #.... #.... ####. #.... #.... #.... ..... .....",
+	"..... .###. #...# #.... #.... #..## #...# .#### ..... .....",
+	"..... #...# #...# #...# ##### #...# #...# #...# ..... .....",
+	"..... .###. ..#.. ..#.. ..#.. ..#.. ..#.. .###. ..... .....",
+	"..... ....# ....# ....# ....# ....# #...# .###. ..... .....",
+	"..... #...# #..#. #.#.. ##... #.#.. #..#. #...# ..... .....",
+	"..... #.... #.... #.... #.... #.... #.... ##### ..... .....",
+	"..... #...# ##.## #.#.# #.#.# #...# #...# #...# ..... .....",
+	"..... #...# #...# ##..# #.#.# #..## #...# #...# ..... .....",
+	"..... .###. #...# #...# #...# #...# #...# .###. ..... .....",
+	// 0x50
+	"..... ####. #...# #...# ####. #.... #.... #.... ..... .....",
+	"..... .###. #...# #...# #...# #.#.# #..#. .##.# ..... .....",
+	"..... ####. #...# #...# ####. #.#.. #..#. #...# ..... .....",
+	"..... .###. #...# #.... .###. ....# #...# .###. ..... .....",
+	"..... ##### ..#.. ..#.. ..#.. ..#.. ..#.. ..#.. ..... .....",
+	"..... #...# #...# #...# #...# #...# #...# .###. ..... .....",
+	"..... #...# #...# #...# .#.#. .#.#. ..#.. ..#.. ..... .....",
+	"..... #...# #...# #...# #.#.# #.#.# #.#.# .#.#. ..... .....",
+	"..... #...# #...# .#.#. ..#.. .#.#. #...# #...# ..... .....",
+	"..... #...# #...# .#.#. ..#.. ..#.. ..#.. ..#.. ..... .....",
+	"..... ##### ....# ...#. ..#.. .#... #.... ##### ..... .....",
+	// left arrow, one half, right arrow, up arrow, hash
+	"..... ..... ..#.. .#... ##### .#... ..#.. ..... ..... .....",
+	"..... #.... #.... #.... #.### ....# ..### ..#.. ..### .....",
+	"..... ..... ..#.. ...#. ##### ...#. ..#.. ..... ..... .....",
+	"..... ..#.. .###. #.#.# ..#.. ..#.. ..#.. ..#.. ..... .....",
+	"..... .#.#. .#.#. ##### .#.#. ##### .#.#. .#.#. ..... .....",
+	// 0x60 long dash
+	"..... ..... ..... ..... ##### ..... ..... ..... ..... .....",
+	"..... ..... ..... .###. ....# .#### #...# .#### ..... .....",
+	"..... #.... #.... ####. #...# #...# #...# ####. ..... .....",
+	"..... ..... ..... .#### #.... #.... #.... .#### ..... .....",
+	"..... ....# ....# .#### #...# #...# #...# .#### ..... .....",
+	"..... ..... ..... .###. #...# ##### #.... .###. ..... .....",
+	"..... ..##. .#... .#... ###.. .#... .#... .#... ..... .....",
+	"..... ..... ..... .#### #...# #...# #...# .#### ....# .###.",
+	"..... #.... #.... ####. #...# #...# #...# #...# ..... .....",
+	"..... ..#.. ..... .##.. ..#.. ..#.. ..#.. .###. ..... .....",
+	"..... ..#.. ..... ..#.. ..#.. ..#.. ..#.. ..#.. ..#.. .#...",
+	"..... .#... .#... .#..# .#.#. .##.. .#.#. .#..# ..... .....",
+	"..... .##.. ..#.. ..#.. ..#.. ..#.. ..#.. .###. ..... .....",
+	"..... ..... ..... ##.#. #.#.# #.#.# #.#.# #.#.# ..... .....",
+	"..... ..... ..... ####. #...# #...# #...# #...# ..... .....",
+	"..... ..... ..... .###. #...# #...# #...# .###. ..... .....",
+	// 0x70
+	"..... ..... ..... ####. #...# #...# #...# ####. #.... #....",
+	"..... ..... ..... .#### #...# #...# #...# .#### ....# ....#",
+	"..... ..... ..... .#.## .##.. .#... .#... .#... ..... .....",
+	"..... ..... ..... .#### #.... .###. ....# ####. ..... .....",
+	"..... .#... .#... ###.. .#... .#... .#... ..##. ..... .....",
+	"..... ..... ..... #...# #...# #...# #...# .#### ..... .....",
+	"..... ..... ..... #...# #...# .#.#. .#.#. ..#.. ..... .....",
+	"..... ..... ..... #...# #...# #.#.# #.#.# .#.#. ..... .....",
+	"..... ..... ..... #...# .#.#. ..#.. .#.#. #...# ..... .....",
+	"..... ..... ..... #...# #...# #...# #...# .#### ....# .###.",
+	"..... ..... ..... ##### ...#. ..#.. .#... ##### ..... .....",
+	// one quarter, double bar, three quarters, divide, block
+	"..... #.... #.... #.... #..#. ..##. .#.#. ##### ...#. .....",
+	"..... .#.#. .#.#. .#.#. .#.#. .#.#. .#.#. .#.#. ..... .....",
+	"..... ##... ..#.. .#... ..#.. ##.#. ..##. .#.#. ##### ...#.",
+	"..... ..... ..#.. ..... ##### ..... ..#.. ..... ..... .....",
+	"##### ##### ##### ##### ##### ##### ##### ##### ##### #####",
+}
+
+// Glyph rows, bit 5 is the leftmost dot.
+func parseSAA5050Font() [96][10]byte {
+	glyphs := [96][10]byte{}
+	for c, glyph := range saa5050Font {
+		for r, row := range strings.Fields(glyph) {
+			for x, dot := range row {
+				if dot == '#' {
+					glyphs[c][r] |= 0x20 >> x
+				}
+			}
+		}
+	}
+	return glyphs
+}
@@ -0,0 +1,115 @@
+package hardware
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+type EventFn func(cycle uint64) error
+
+type Event struct {
+	Name string
+
+	cycle uint64
+	fn    EventFn
+	// insertion order, keeps events due on the same cycle deterministic
+	order uint64
+	// position in the queue, -1 when not scheduled
+	index int
+}
+
+func (event *Event) GetCycle() uint64  { return event.cycle }
+func (event *Event) IsScheduled() bool { return event.index >= 0 }
+
+type eventQueue []*Event
+
+func (queue eventQueue) Len() int { return len(queue) }
+func (queue eventQueue) Less(i, j int) bool {
+	if queue[i].cycle == queue[j].cycle {
+		return queue[i].order < queue[j].order
+	}
+	return queue[i].cycle < queue[j].cycle
+}
+func (queue eventQueue) Swap(i, j int) {
+	queue[i], queue[j] = queue[j], queue[i]
+	queue[i].index = i
+	queue[j].index = j
+}
+func (queue *eventQueue) Push(x any) {
+	event := x.(*Event)
+	event.index = len(*queue)
+	*queue = append(*queue, event)
+}
+func (queue *eventQueue) Pop() any {
+	old := *queue
+	n := len(old)
+	event := old[n-1]
+	old[n-1] = nil
+	event.index = -1
+	*queue = old[:n-1]
+	return event
+}
+
+// Scheduler runs callbacks once the clock reaches their cycle.
+type Scheduler struct {
+	events eventQueue
+	order  uint64
+}
+
+func (scheduler *Scheduler) NewEvent(name string, fn EventFn) *Event {
+	return &Event{
+		Name:  name,
+		fn:    fn,
+		index: -1,
+	}
+}
+
+// Schedules (or moves) the event at an absolute cycle.
+func (scheduler *Scheduler) ScheduleAt(event *Event, cycle uint64) {
+	event.cycle = cycle
+	event.order = scheduler.order
+	scheduler.order++
+	if event.IsScheduled() {
+		heap.Fix(&scheduler.events, event.index)
+	} else {
+		heap.Push(&scheduler.events, event)
+	}
+}
+
+func (scheduler *Scheduler) Cancel(event *Event) {
+	if event.IsScheduled() {
+		heap.Remove(&scheduler.events, event.index)
+	}
+}
+
+func (scheduler *Scheduler) Clear() {
+	for _, event := range scheduler.events {
+		event.index = -1
+	}
+	scheduler.events = eventQueue{}
+}
+
+// Cycle of the next event, false if none is scheduled.
+func (scheduler *Scheduler) NextCycle() (uint64, bool) {
+	if len(scheduler.events) == 0 {
+		return 0, false
+	}
+	return scheduler.events[0].cycle, true
+}
+
+// Runs every event due at or before cycle, in order.
+func (scheduler *Scheduler) RunUntil(cycle uint64) error {
+	for len(scheduler.events) > 0 && scheduler.events[0].cycle <= cycle {
+		event := heap.Pop(&scheduler.events).(*Event)
+		if err := event.fn(event.cycle); err != nil {
+			return fmt.Errorf("event %s: %w", event.Name, err)
+		}
+	}
+	return nil
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		events: eventQueue{},
+	}
+}
@@ -0,0 +1,311 @@
+package hardware
+
+// SD card in SPI mode on the user port, as MMFS drives it: PB0 is the data
+// to the card, PB1 the clock. CB1 carries the clock too so the VIA shift
+// register, shifting in under the system clock, can read bytes from the
+// card on CB2. The card is always selected.
+
+const (
+	sdPortData  = 0x01
+	sdPortClock = 0x02
+
+	sdBlockSize = 512
+	// cards past 2GB are addressed by blocks instead of bytes
+	sdHighCapacityBlocks = 1 << 22
+)
+
+// R1 response bits
+const (
+	SDIdle           = 0x01
+	SDIllegalCommand = 0x04
+	SDAddressError   = 0x20
+	SDParameterError = 0x40
+)
+
+// Contents of a card, in blocks of 512 bytes.
+type SDStorage interface {
+	GetBlockCount() int
+	ReadBlock(number int) ([]byte, error)
+	WriteBlock(number int, data []byte) error
+	Flush() error
+}
+
+type SDCard struct {
+	via     *VIA
+	storage SDStorage
+
+	// lines, the clock is low when PB1 or CB1 is
+	data      bool
+	portClock bool
+	viaClock  bool
+	clock     bool
+
+	input  byte
+	output byte
+	next   byte
+	bits   int
+
+	idle       bool
+	appCommand bool
+	command    []byte
+	// bytes to send, 0xFF when empty
+	response []byte
+	// block written, from its start token
+	writing bool
+	block   []byte
+	blockAt int
+}
+
+func (card *SDCard) Insert(storage SDStorage) {
+	card.storage = storage
+	card.idle = true
+	card.appCommand = false
+	card.command, card.response, card.writing = nil, nil, false
+}
+
+func (card *SDCard) Eject() error {
+	err := card.Flush()
+	card.storage = nil
+	return err
+}
+
+func (card *SDCard) GetStorage() SDStorage {
+	return card.storage
+}
+
+func (card *SDCard) Flush() error {
+	if card.storage == nil {
+		return nil
+	}
+	return card.storage.Flush()
+}
+
+func (card *SDCard) ReadPort() byte {
+	return 0xFF
+}
+
+func (card *SDCard) WritePort(value, ddr byte) {
+	pins := value | ^ddr
+	card.data = pins&sdPortData != 0
+	card.portClock = pins&sdPortClock != 0
+	card.updateClock()
+}
+
+func (card *SDCard) WriteControl(line VIAControlLine, level bool) {
+	if line == VIAControl1 {
+		card.viaClock = level
+		card.updateClock()
+	}
+}
+
+// Mode 0: data is sampled on rising edges, the next bit is put out on
+// falling ones.
+func (card *SDCard) updateClock() {
+	clock := card.portClock && card.viaClock
+	if clock == card.clock {
+		return
+	}
+	card.clock = clock
+	if clock {
+		card.input <<= 1
+		if card.data {
+			card.input |= 0x01
+		}
+		card.bits++
+		if card.bits == 8 {
+			card.bits = 0
+			card.next = card.exchange(card.input)
+		}
+		return
+	}
+	if card.bits == 0 {
+		card.output = card.next
+	}
+	card.via.SetCB2(card.output<<card.bits&0x80 != 0)
+}
+
+// Takes a byte from the host and gives the one sent back next.
+func (card *SDCard) exchange(value byte) byte {
+	if card.storage == nil {
+		return 0xFF
+	}
+	switch {
+	case card.writing:
+		card.receiveBlock(value)
+	case len(card.command) > 0 || value&0xC0 == 0x40:
+		card.command = append(card.command, value)
+		if len(card.command) == 6 {
+			card.execute(card.command[0]&0x3F, uint32(card.command[1])<<24|uint32(card.command[2])<<16|uint32(card.command[3])<<8|uint32(card.command[4]))
+			card.command = nil
+		}
+	}
+	if len(card.response) == 0 {
+		return 0xFF
+	}
+	next := card.response[0]
+	card.response = card.response[1:]
+	return next
+}
+
+func (card *SDCard) isHighCapacity() bool {
+	return card.storage.GetBlockCount() > sdHighCapacityBlocks
+}
+
+func (card *SDCard) r1(flags byte) byte {
+	if card.idle {
+		flags |= SDIdle
+	}
+	return flags
+}
+
+// Responses come a byte after the command.
+func (card *SDCard) respond(data ...byte) {
+	card.response = append([]byte{0xFF}, data...)
+}
+
+// Data blocks start with the token FE and end with their CRC.
+func (card *SDCard) respondBlock(r1 byte, data []byte) {
+	crc := crc16(0, data...)
+	card.respond(append(append([]byte{r1, 0xFF, 0xFE}, data...), byte(crc>>8), byte(crc))...)
+}
+
+func (card *SDCard) execute(command byte, argument uint32) {
+	application := card.appCommand
+	card.appCommand = false
+	if card.idle && command != 0 && command != 1 && command != 8 && command != 55 && command != 58 && !(application && command == 41) {
+		card.respond(card.r1(SDIllegalCommand))
+		return
+	}
+	switch {
+	case command == 0:
+		card.idle = true
+		card.writing = false
+		card.respond(card.r1(0))
+	case command == 1 || application && command == 41:
+		card.idle = false
+		card.respond(card.r1(0))
+	case command == 8:
+		// voltage accepted, the check pattern echoed
+		card.respond(card.r1(0), 0x00, 0x00, byte(argument>>8)&0x0F, byte(argument))
+	case command == 9:
+		card.respondBlock(card.r1(0), card.csd())
+	case command == 10:
+		card.respondBlock(card.r1(0), []byte{
+			0x00, 'B', 'B', 'M', 'M', 'C', 'S', 'D', 0x10, 0xBB, 0xC0, 0xBB, 0xC0, 0x01, 0x6A, 0x01,
+		})
+	case command == 12 || command == 16 && argument == sdBlockSize:
+		card.respond(card.r1(0))
+	case command == 16:
+		card.respond(card.r1(SDParameterError))
+	case command == 13:
+		card.respond(card.r1(0), 0x00)
+	case command == 17:
+		number, ok := card.blockNumber(argument)
+		if !ok {
+			card.respond(card.r1(SDAddressError))
+			return
+		}
+		data, err := card.storage.ReadBlock(number)
+		if err != nil {
+			card.respond(card.r1(SDAddressError))
+			return
+		}
+		card.respondBlock(card.r1(0), data)
+	case command == 24:
+		number, ok := card.blockNumber(argument)
+		if !ok {
+			card.respond(card.r1(SDAddressError))
+			return
+		}
+		card.writing, card.block, card.blockAt = true, nil, number
+		card.respond(card.r1(0))
+	case command == 55:
+		card.appCommand = true
+		card.respond(card.r1(0))
+	case command == 58:
+		ocr := byte(0x80)
+		if card.isHighCapacity() {
+			ocr |= 0x40
+		}
+		card.respond(card.r1(0), ocr, 0xFF, 0x80, 0x00)
+	default:
+		card.respond(card.r1(SDIllegalCommand))
+	}
+}
+
+func (card *SDCard) blockNumber(argument uint32) (int, bool) {
+	number := int(argument)
+	if !card.isHighCapacity() {
+		if argument%sdBlockSize != 0 {
+			return 0, false
+		}
+		number /= sdBlockSize
+	}
+	return number, number < card.storage.GetBlockCount()
+}
+
+// Bytes before the start token are ignored, the block is followed by its
+// CRC and answered with the data response then busy until written.
+func (card *SDCard) receiveBlock(value byte) {
+	if card.block == nil {
+		if value == 0xFE {
+			card.block = make([]byte, 0, sdBlockSize+2)
+		}
+		return
+	}
+	card.block = append(card.block, value)
+	if len(card.block) < sdBlockSize+2 {
+		return
+	}
+	card.writing = false
+	if err := card.storage.WriteBlock(card.blockAt, card.block[:sdBlockSize]); err != nil {
+		// write error
+		card.respond(0x0D)
+		return
+	}
+	card.respond(0x05, 0x00)
+}
+
+// Card specific data: version 1 for byte addressed cards, 2 past 2GB.
+func (card *SDCard) csd() []byte {
+	csd := make([]byte, 16)
+	blocks := card.storage.GetBlockCount()
+	if card.isHighCapacity() {
+		size := blocks/1024 - 1
+		copy(csd, []byte{0x40, 0x0E, 0x00, 0x32, 0x5B, 0x59, 0x00, byte(size >> 16 & 0x3F), byte(size >> 8), byte(size), 0x7F, 0x80, 0x0A, 0x40, 0x00})
+	} else {
+		// capacity of the size plus 1 times 512 blocks of 512 or 1024 bytes
+		blockLength, units := byte(9), 512
+		if blocks > 4096*512 {
+			blockLength, units = 10, 1024
+		}
+		size := (blocks+units-1)/units - 1
+		if size < 0 {
+			size = 0
+		}
+		copy(csd, []byte{0x00, 0x0E, 0x00, 0x32, 0x5B, 0x50 | blockLength, 0x80 | byte(size>>10&0x03), byte(size >> 2), byte(size<<6) | 0x3F, 0xFF, 0xFF, 0x80, 0x0A, 0x40, 0x00})
+	}
+	csd[15] = crc7(csd[:15])<<1 | 0x01
+	return csd
+}
+
+func crc7(data []byte) byte {
+	crc := byte(0)
+	for _, value := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (value>>i)&0x01 ^ crc>>6&0x01
+			crc = crc << 1 & 0x7F
+			if bit != 0 {
+				crc ^= 0x09
+			}
+		}
+	}
+	return crc
+}
+
+// The card is wired to port B of the VIA.
+func NewSDCard(via *VIA) *SDCard {
+	card := &SDCard{via: via, portClock: true, viaClock: true, clock: true, output: 0xFF, next: 0xFF, idle: true}
+	via.ConnectPortB(card)
+	return card
+}
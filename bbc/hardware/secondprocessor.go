@@ -0,0 +1,166 @@
+package hardware
+
+import (
+	"bbc/logical"
+	"bbc/utils"
+	"fmt"
+)
+
+// 6502 second processor on the Tube: a CPU on its own bus with 64K of RAM,
+// the Tube registers at FEF8 and the client ROM over the top of the RAM.
+// The ROM is read from reset until the first access to the Tube registers,
+// by then the client has copied itself to the RAM under it. Writes always
+// go to the RAM. The host steps it in lockstep, running its CPU until its
+// own clock catches up with the host cycles at their ratio.
+
+const (
+	SecondProcessorFrequency = 3e6
+	// source of the parasite interrupt and reset lines
+	tubeSource = "Tube"
+)
+
+type SecondProcessor struct {
+	name string
+	bus  *Bus
+	tube *Tube
+
+	Clock  *Clock
+	CPU    *CPU
+	Bus    *Bus
+	memory *parasiteMemory
+
+	hostCycles uint64
+}
+
+func (sp *SecondProcessor) GetName() string    { return sp.name }
+func (sp *SecondProcessor) PlugToBus(bus *Bus) { sp.bus = bus }
+func (sp *SecondProcessor) GetDomain() string  { return DomainCPU }
+
+func (sp *SecondProcessor) Start() error {
+	return nil
+}
+
+func (sp *SecondProcessor) Reset() error {
+	sp.Bus.Reset()
+	sp.CPU.RequestReset()
+	sp.hostCycles = 0
+	sp.tube.updateInterrupts()
+	return nil
+}
+
+func (sp *SecondProcessor) Stop() error {
+	return nil
+}
+
+// Interrupt and reset lines driven by the Tube, the reset also pages the
+// ROM back in.
+func (sp *SecondProcessor) setLines(irq, nmi, reset bool) {
+	sp.Bus.IRQ.Set(tubeSource, irq)
+	sp.Bus.NMI.Set(tubeSource, nmi)
+	if reset {
+		sp.memory.romIn = true
+	}
+	sp.Bus.RESET.Set(tubeSource, reset)
+}
+
+func (sp *SecondProcessor) IsROMPagedIn() bool {
+	return sp.memory.romIn
+}
+
+func (sp *SecondProcessor) Step(ticks uint64) error {
+	sp.hostCycles += ticks
+	target := sp.hostCycles * sp.Clock.Frequency / sp.bus.Clock.Frequency
+	for sp.Clock.GetCycles() < target {
+		if err := sp.CPU.ExecuteNext(); err != nil {
+			return fmt.Errorf("second processor: %w", err)
+		}
+	}
+	return nil
+}
+
+type parasiteMemory struct {
+	bus   *Bus
+	tube  *Tube
+	ram   []byte
+	rom   []byte
+	romIn bool
+}
+
+func (memory *parasiteMemory) GetName() string            { return "parasite memory" }
+func (memory *parasiteMemory) PlugToBus(bus *Bus)         { memory.bus = bus }
+func (memory *parasiteMemory) IsWritable() bool           { return true }
+func (memory *parasiteMemory) IsReadable() bool           { return true }
+func (memory *parasiteMemory) GetSegment() *utils.Segment { return logical.AdressableSegment }
+
+func (memory *parasiteMemory) Start() error {
+	return nil
+}
+
+func (memory *parasiteMemory) Reset() error {
+	memory.romIn = true
+	return nil
+}
+
+func (memory *parasiteMemory) Stop() error {
+	return nil
+}
+
+func isTubeRegister(addr uint16) bool {
+	return addr&0xFFF8 == 0xFEF8
+}
+
+func (memory *parasiteMemory) DirectRead(addr uint16) (byte, error) {
+	if isTubeRegister(addr) {
+		memory.romIn = false
+		return memory.tube.parasiteRead(addr), nil
+	}
+	if romStart := 0x10000 - len(memory.rom); memory.romIn && int(addr) >= romStart {
+		return memory.rom[int(addr)-romStart], nil
+	}
+	return memory.ram[addr], nil
+}
+
+func (memory *parasiteMemory) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := memory.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func (memory *parasiteMemory) DirectWrite(value byte, addr uint16) error {
+	if isTubeRegister(addr) {
+		memory.romIn = false
+		memory.tube.parasiteWrite(value, addr)
+		return nil
+	}
+	memory.ram[addr] = value
+	return nil
+}
+
+func (memory *parasiteMemory) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := memory.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+// Fits the second processor to the Tube, with the client ROM of up to 4K.
+func NewSecondProcessor(name string, tube *Tube, rom []byte) (*SecondProcessor, error) {
+	if len(rom) == 0 || len(rom) > 0x1000 {
+		return nil, fmt.Errorf("invalid Tube client ROM of %d bytes", len(rom))
+	}
+	clock := NewVirtualClock(SecondProcessorFrequency)
+	cpu := NewCPU(clock)
+	memory := &parasiteMemory{tube: tube, ram: make([]byte, 0x10000), rom: rom, romIn: true}
+	bus, err := NewBus(clock, cpu, memory)
+	if err != nil {
+		return nil, err
+	}
+	sp := &SecondProcessor{name: name, tube: tube, Clock: clock, CPU: cpu, Bus: bus, memory: memory}
+	tube.parasite = sp
+	cpu.RequestReset()
+	return sp, nil
+}
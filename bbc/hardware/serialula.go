@@ -0,0 +1,231 @@
+package hardware
+
+import (
+	"bbc/tape"
+	"bbc/utils"
+)
+
+// Serial ULA at FE10, write only. It switches the ACIA between the cassette
+// and RS423 ports, generates its clocks, drives the cassette motor relay,
+// turns the tape tones into bits and carrier detection and the transmitted
+// bits into tones to record.
+
+const (
+	SerialTransmitBaud = 0x07
+	SerialReceiveBaud  = 0x38
+	SerialRS423        = 0x40
+	SerialMotor        = 0x80
+
+	// cassette clock, divided by 16 for 1200 baud or 64 for 300
+	cassetteClock = 19200
+	// the MOS has the ACIA divide the RS423 clocks by 64
+	rs423ClockMultiplier = 64
+)
+
+// RS423 baud rates by value of the baud select bits
+var SerialBaudRates = [8]int{19200, 1200, 4800, 150, 9600, 300, 2400, 75}
+
+type SerialULA struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+	domain  *ClockDomain
+	event   *Event
+	acia    *ACIA
+	deck    *TapeDeck
+	rs423   *RS423
+
+	control byte
+	// 1MHz tick the clocks were last brought up to
+	ticks uint64
+	// clock edges owed to the ACIA, in Hz.µs
+	rxPhase int
+	txPhase int
+	dcd     bool
+	cts     bool
+}
+
+func (ula *SerialULA) GetName() string            { return ula.name }
+func (ula *SerialULA) IsWritable() bool           { return true }
+func (ula *SerialULA) IsReadable() bool           { return false }
+func (ula *SerialULA) GetSegment() *utils.Segment { return ula.segment }
+func (ula *SerialULA) GetBusDomain() string       { return DomainPeripheral }
+
+func (ula *SerialULA) PlugToBus(bus *Bus) {
+	ula.bus = bus
+	ula.domain = bus.GetDomain(DomainPeripheral)
+	ula.ticks = ula.now()
+	ula.event = bus.NewEvent(ula.name+" clock", func(uint64) error {
+		ula.sync()
+		return nil
+	})
+	ula.schedule()
+}
+
+func (ula *SerialULA) Start() error {
+	return nil
+}
+
+func (ula *SerialULA) Reset() error {
+	ula.ticks = ula.now()
+	ula.rxPhase, ula.txPhase = 0, 0
+	ula.SetControl(0)
+	return nil
+}
+
+func (ula *SerialULA) Stop() error {
+	return nil
+}
+
+func (ula *SerialULA) SetControl(value byte) {
+	ula.sync()
+	ula.control = value
+	ula.deck.SetMotor(value&SerialMotor != 0)
+	ula.updateDCD()
+	ula.updateCTS()
+	ula.schedule()
+}
+
+func (ula *SerialULA) GetControl() byte {
+	return ula.control
+}
+
+func (ula *SerialULA) IsCassette() bool {
+	return ula.control&SerialRS423 == 0
+}
+
+func (ula *SerialULA) GetTapeDeck() *TapeDeck {
+	return ula.deck
+}
+
+func (ula *SerialULA) receiveFrequency() int {
+	if ula.IsCassette() {
+		return cassetteClock * ula.deck.speedup()
+	}
+	return SerialBaudRates[(ula.control&SerialReceiveBaud)>>3] * rs423ClockMultiplier
+}
+
+func (ula *SerialULA) transmitFrequency() int {
+	if ula.IsCassette() {
+		return cassetteClock
+	}
+	return SerialBaudRates[ula.control&SerialTransmitBaud] * rs423ClockMultiplier
+}
+
+func (ula *SerialULA) GetRS423() *RS423 {
+	return ula.rs423
+}
+
+// Level on the ACIA receive input: high tone is a 1, the line idles high
+// without a tone or a device.
+func (ula *SerialULA) rxd() bool {
+	if ula.IsCassette() {
+		return ula.deck.GetTone() != tape.LowTone
+	}
+	return ula.rs423.receiveClock()
+}
+
+// The ACIA carrier detect input is raised while the carrier is heard.
+func (ula *SerialULA) updateDCD() {
+	dcd := ula.IsCassette() && ula.deck.IsCarrier()
+	if dcd != ula.dcd {
+		ula.dcd = dcd
+		ula.acia.SetDCD(dcd)
+	}
+}
+
+// The ACIA clear to send input is raised while the RS423 host is behind.
+func (ula *SerialULA) updateCTS() {
+	cts := !ula.IsCassette() && ula.rs423.isBlocked()
+	if cts != ula.cts {
+		ula.cts = cts
+		ula.acia.SetCTS(cts)
+	}
+}
+
+func (ula *SerialULA) now() uint64 {
+	if ula.domain == nil {
+		return 0
+	}
+	return ula.domain.GetCycles()
+}
+
+// Ticks until the phase owes the ACIA its next clock edge.
+func ticksToEdge(phase int, frequency int) uint64 {
+	return uint64((1e6 - phase + frequency - 1) / frequency)
+}
+
+// Ticks until the next receive or transmit clock edge.
+func (ula *SerialULA) untilEdge() uint64 {
+	rx := ticksToEdge(ula.rxPhase, ula.receiveFrequency())
+	if tx := ticksToEdge(ula.txPhase, ula.transmitFrequency()); tx < rx {
+		return tx
+	}
+	return rx
+}
+
+// Brings the tape and the ACIA clocks up to the current tick, one stretch
+// between clock edges at a time.
+func (ula *SerialULA) sync() {
+	now := ula.now()
+	if now < ula.ticks {
+		// the clock was reset
+		ula.ticks = now
+	}
+	for ula.ticks < now {
+		ticks := now - ula.ticks
+		if edge := ula.untilEdge(); edge < ticks {
+			ticks = edge
+		}
+		ula.ticks += ticks
+		if ula.IsCassette() {
+			ula.deck.Advance(float64(ticks) * float64(ula.deck.speedup()))
+			ula.updateDCD()
+		}
+		ula.updateCTS()
+		for ula.rxPhase += int(ticks) * ula.receiveFrequency(); ula.rxPhase >= 1e6; ula.rxPhase -= 1e6 {
+			ula.acia.ReceiveClock(ula.rxd())
+		}
+		for ula.txPhase += int(ticks) * ula.transmitFrequency(); ula.txPhase >= 1e6; ula.txPhase -= 1e6 {
+			txd := ula.acia.TransmitClock()
+			if ula.IsCassette() && ula.acia.isMidBit() {
+				ula.deck.RecordBit(txd, ula.transmitFrequency()/ula.acia.divider())
+			} else if !ula.IsCassette() && ula.acia.isMidBit() {
+				ula.rs423.transmitBit(txd)
+			}
+		}
+	}
+	ula.schedule()
+}
+
+func (ula *SerialULA) schedule() {
+	if ula.event == nil {
+		return
+	}
+	ula.bus.ScheduleIn(ula.event, ula.domain.CyclesUntil(ula.untilEdge()))
+}
+
+func (ula *SerialULA) DirectWrite(value byte, addr uint16) error {
+	ula.SetControl(value)
+	return nil
+}
+
+func (ula *SerialULA) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := ula.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+func NewSerialULA(name string, segment *utils.Segment, acia *ACIA, deck *TapeDeck, rs423 *RS423) *SerialULA {
+	ula := &SerialULA{
+		name:    name,
+		segment: segment,
+		acia:    acia,
+		deck:    deck,
+		rs423:   rs423,
+	}
+	ula.Reset()
+	return ula
+}
@@ -0,0 +1,293 @@
+package hardware
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Texas Instruments SN76489 sound generator: three square wave tone channels
+// and a noise channel, each with a 2dB step attenuator. It is written from
+// the System VIA port A, the slow data bus, when the addressable latch sound
+// write enable goes low.
+
+const (
+	// 4MHz clock, divided by 16 before the channel counters
+	SN76489Frequency  = 250000
+	DefaultSampleRate = 44100
+
+	// samples kept when nobody reads them, the oldest are dropped
+	maxBufferedSeconds = 10
+	// full volume of a channel, the four mixed reach nearly full scale
+	channelAmplitude = 8191
+	// white noise feeds back bits 0 and 1, the register is 15 bits long
+	noiseInitial = 0x4000
+	noiseWhite   = 0x04
+)
+
+// register latched by the last byte with bit 7 set, channel*2 + attenuation
+const (
+	sn76489Tone        = 0
+	sn76489Attenuation = 1
+	sn76489Noise       = 6
+)
+
+var attenuationLevels [16]int32
+
+func init() {
+	for i := 0; i < 15; i++ {
+		attenuationLevels[i] = int32(math.Round(channelAmplitude * math.Pow(10, -float64(i)*2/20)))
+	}
+	// attenuation 15 is off
+	attenuationLevels[15] = 0
+}
+
+type SN76489 struct {
+	name string
+	via  *VIA
+	bus  *Bus
+
+	// slow data bus as driven by the System VIA
+	data byte
+
+	latched     int
+	tone        [3]uint16
+	attenuation [4]byte
+	noise       byte
+
+	counters [4]uint16
+	outputs  [3]bool
+	noiseFF  bool
+	lfsr     uint16
+
+	// output averaged over each sample period
+	sampleRate  int
+	sum         int64
+	count       int64
+	accumulated int
+
+	// host facing buffer, read from any goroutine
+	lock    sync.Mutex
+	samples []int16
+}
+
+func (psg *SN76489) GetName() string    { return psg.name }
+func (psg *SN76489) PlugToBus(bus *Bus) { psg.bus = bus }
+func (psg *SN76489) GetDomain() string  { return DomainSound }
+
+func (psg *SN76489) Start() error {
+	return nil
+}
+
+// The chip has no reset line: the MOS silences it, but a power on leaves
+// the registers random. They are cleared to silence here.
+func (psg *SN76489) Reset() error {
+	psg.latched = 0
+	psg.tone = [3]uint16{}
+	psg.attenuation = [4]byte{0x0F, 0x0F, 0x0F, 0x0F}
+	psg.noise = 0
+	psg.counters = [4]uint16{}
+	psg.outputs = [3]bool{}
+	psg.noiseFF = false
+	psg.lfsr = noiseInitial
+	psg.sum, psg.count, psg.accumulated = 0, 0, 0
+	psg.lock.Lock()
+	psg.samples = psg.samples[:0]
+	psg.lock.Unlock()
+	return nil
+}
+
+func (psg *SN76489) Stop() error {
+	return nil
+}
+
+func (psg *SN76489) ReadPort() byte {
+	return 0xFF
+}
+
+func (psg *SN76489) WritePort(value, ddr byte) {
+	// inputs are pulled up
+	psg.data = value | ^ddr
+}
+
+// Writes a byte to the chip. Bytes with bit 7 set select a register and set
+// its low 4 bits, others set the high 6 bits of a tone period or replace the
+// value of the other registers.
+func (psg *SN76489) Write(value byte) {
+	if value&0x80 != 0 {
+		psg.latched = int(value>>4) & 0x07
+		psg.setRegister(uint16(value&0x0F), false)
+		return
+	}
+	psg.setRegister(uint16(value&0x3F), true)
+}
+
+func (psg *SN76489) setRegister(value uint16, high bool) {
+	channel := psg.latched / 2
+	if psg.latched&1 == sn76489Attenuation {
+		psg.attenuation[channel] = byte(value & 0x0F)
+		return
+	}
+	if psg.latched == sn76489Noise {
+		psg.noise = byte(value & 0x07)
+		// writing the noise control resets the shift register
+		psg.lfsr = noiseInitial
+		return
+	}
+	if high {
+		psg.tone[channel] = psg.tone[channel]&0x0F | value<<4
+	} else {
+		psg.tone[channel] = psg.tone[channel]&0x3F0 | value
+	}
+}
+
+// Tone period of a channel in 250kHz ticks, 0 counts as 1024.
+func (psg *SN76489) GetTone(channel int) uint16 {
+	return psg.tone[channel]
+}
+
+// Attenuation of a channel from 0 (loudest) to 15 (off), channel 3 is noise.
+func (psg *SN76489) GetAttenuation(channel int) byte {
+	return psg.attenuation[channel]
+}
+
+// Noise control: bit 2 selects white noise, bits 0-1 the shift rate.
+func (psg *SN76489) GetNoise() byte {
+	return psg.noise
+}
+
+func tonePeriod(tone uint16) uint16 {
+	if tone == 0 {
+		return 0x400
+	}
+	return tone
+}
+
+func (psg *SN76489) noisePeriod() uint16 {
+	if rate := psg.noise & 0x03; rate != 3 {
+		return 0x10 << rate
+	}
+	return tonePeriod(psg.tone[2])
+}
+
+func (psg *SN76489) tick() {
+	for channel := range psg.outputs {
+		// periods of 1 hold the output high, sampled speech plays with the
+		// attenuation alone
+		if psg.tone[channel] == 1 {
+			psg.outputs[channel] = true
+			continue
+		}
+		if psg.counters[channel] <= 1 {
+			psg.counters[channel] = tonePeriod(psg.tone[channel])
+			psg.outputs[channel] = !psg.outputs[channel]
+		} else {
+			psg.counters[channel]--
+		}
+	}
+	if psg.counters[3] <= 1 {
+		psg.counters[3] = psg.noisePeriod()
+		psg.noiseFF = !psg.noiseFF
+		// the shift register moves on rising edges
+		if psg.noiseFF {
+			feedback := psg.lfsr & 1
+			if psg.noise&noiseWhite != 0 {
+				feedback ^= (psg.lfsr >> 1) & 1
+			}
+			psg.lfsr = psg.lfsr>>1 | feedback<<14
+		}
+	} else {
+		psg.counters[3]--
+	}
+}
+
+// Output level of the four channels mixed.
+func (psg *SN76489) level() int32 {
+	level := int32(0)
+	for channel, high := range psg.outputs {
+		if high {
+			level += attenuationLevels[psg.attenuation[channel]]
+		}
+	}
+	if psg.lfsr&1 != 0 {
+		level += attenuationLevels[psg.attenuation[3]]
+	}
+	return level
+}
+
+func (psg *SN76489) Step(ticks uint64) error {
+	for ; ticks > 0; ticks-- {
+		psg.tick()
+		psg.sum += int64(psg.level())
+		psg.count++
+		psg.accumulated += psg.sampleRate
+		if psg.accumulated >= SN76489Frequency {
+			psg.accumulated -= SN76489Frequency
+			psg.emit(int16(psg.sum / psg.count))
+			psg.sum, psg.count = 0, 0
+		}
+	}
+	return nil
+}
+
+func (psg *SN76489) emit(sample int16) {
+	psg.lock.Lock()
+	defer psg.lock.Unlock()
+	if limit := psg.sampleRate * maxBufferedSeconds; len(psg.samples) >= limit {
+		psg.samples = append(psg.samples[:0], psg.samples[len(psg.samples)-limit+1:]...)
+	}
+	psg.samples = append(psg.samples, sample)
+}
+
+// Sample rate of the PCM output, at most the chip frequency.
+func (psg *SN76489) SetSampleRate(rate int) error {
+	if rate <= 0 || rate > SN76489Frequency {
+		return fmt.Errorf("invalid sample rate %d", rate)
+	}
+	psg.sampleRate = rate
+	psg.accumulated = 0
+	psg.lock.Lock()
+	psg.samples = psg.samples[:0]
+	psg.lock.Unlock()
+	return nil
+}
+
+func (psg *SN76489) GetSampleRate() int {
+	return psg.sampleRate
+}
+
+// Number of samples waiting to be read.
+func (psg *SN76489) Buffered() int {
+	psg.lock.Lock()
+	defer psg.lock.Unlock()
+	return len(psg.samples)
+}
+
+// Moves the oldest buffered samples, signed 16 bits mono, to the slice and
+// returns how many were copied.
+func (psg *SN76489) ReadSamples(samples []int16) int {
+	psg.lock.Lock()
+	defer psg.lock.Unlock()
+	n := copy(samples, psg.samples)
+	psg.samples = append(psg.samples[:0], psg.samples[n:]...)
+	return n
+}
+
+func NewSN76489(name string, via *VIA, latch *AddressableLatch) *SN76489 {
+	psg := &SN76489{
+		name:       name,
+		via:        via,
+		data:       0xFF,
+		sampleRate: DefaultSampleRate,
+		samples:    []int16{},
+	}
+	psg.Reset()
+	via.ConnectPortA(psg)
+	// the chip reads the slow data bus when its write enable goes low
+	latch.Observe(func(output LatchOutput, level bool) {
+		if output == LatchSoundWrite && !level {
+			psg.Write(psg.data)
+		}
+	})
+	return psg
+}
@@ -0,0 +1,162 @@
+package hardware
+
+import (
+	"bbc/tape"
+)
+
+const (
+	// continuous high tone after which the Serial ULA detects the carrier
+	carrierDetectTime = 100000
+	// tape speed and receive clock multiplier when fast loading
+	fastLoadSpeedup = 16
+)
+
+// Cassette recorder driven by the Serial ULA motor relay. It plays UEF
+// images as a tone on the cassette input, or records the cassette output.
+type TapeDeck struct {
+	uef      *tape.UEF
+	player   *tape.Player
+	recorder *tape.Recorder
+	motor    bool
+	fastLoad bool
+
+	tone tape.Tone
+	// microseconds left in the current segment
+	remaining float64
+	// microseconds of high tone heard in a row
+	highTone float64
+}
+
+func (deck *TapeDeck) Insert(uef *tape.UEF) {
+	deck.uef = uef
+	deck.Rewind()
+}
+
+func (deck *TapeDeck) Eject() {
+	deck.uef = nil
+	deck.Rewind()
+}
+
+func (deck *TapeDeck) GetTape() *tape.UEF {
+	return deck.uef
+}
+
+func (deck *TapeDeck) Rewind() {
+	deck.player = nil
+	if deck.uef != nil {
+		deck.player = tape.NewPlayer(deck.uef)
+	}
+	deck.tone = tape.Silence
+	deck.remaining = 0
+	deck.highTone = 0
+}
+
+// Chunk of the image being played, for tape counters.
+func (deck *TapeDeck) GetPosition() int {
+	if deck.player == nil {
+		return 0
+	}
+	return deck.player.GetChunk()
+}
+
+func (deck *TapeDeck) IsEnded() bool {
+	return deck.player == nil || (deck.player.IsEnded() && deck.remaining <= 0)
+}
+
+func (deck *TapeDeck) SetMotor(on bool) {
+	if deck.motor && !on && deck.recorder != nil {
+		deck.recorder.Flush()
+	}
+	deck.motor = on
+}
+
+func (deck *TapeDeck) IsMotorOn() bool {
+	return deck.motor
+}
+
+// Fast loading plays the tape and clocks the ACIA receiver faster, loaders
+// only see the bytes arriving sooner.
+func (deck *TapeDeck) SetFastLoad(fast bool) {
+	deck.fastLoad = fast
+}
+
+func (deck *TapeDeck) IsFastLoad() bool {
+	return deck.fastLoad
+}
+
+func (deck *TapeDeck) speedup() int {
+	if deck.fastLoad {
+		return fastLoadSpeedup
+	}
+	return 1
+}
+
+// Moves the tape on while the motor runs.
+func (deck *TapeDeck) Advance(microseconds float64) {
+	if !deck.motor || deck.recorder != nil {
+		return
+	}
+	deck.remaining -= microseconds
+	for deck.remaining <= 0 {
+		if deck.player == nil {
+			deck.tone = tape.Silence
+			deck.remaining = 0
+			break
+		}
+		segment, ok := deck.player.Next()
+		if !ok {
+			deck.tone = tape.Silence
+			deck.remaining = 0
+			break
+		}
+		deck.tone = segment.Tone
+		deck.remaining += segment.Duration * 1e6
+	}
+	if deck.tone == tape.HighTone {
+		deck.highTone += microseconds
+	} else {
+		deck.highTone = 0
+	}
+}
+
+// Records on a blank tape until StopRecording, nothing is played meanwhile.
+func (deck *TapeDeck) StartRecording() {
+	deck.recorder = tape.NewRecorder()
+}
+
+// Returns the image recorded.
+func (deck *TapeDeck) StopRecording() *tape.UEF {
+	if deck.recorder == nil {
+		return nil
+	}
+	uef := deck.recorder.GetUEF()
+	deck.recorder = nil
+	return uef
+}
+
+func (deck *TapeDeck) IsRecording() bool {
+	return deck.recorder != nil
+}
+
+// A bit cell of the cassette output, recorded while the motor runs.
+func (deck *TapeDeck) RecordBit(level bool, baud int) {
+	if deck.motor && deck.recorder != nil {
+		deck.recorder.Bit(level, baud)
+	}
+}
+
+// Tone heard on the cassette input.
+func (deck *TapeDeck) GetTone() tape.Tone {
+	if !deck.motor || deck.recorder != nil {
+		return tape.Silence
+	}
+	return deck.tone
+}
+
+func (deck *TapeDeck) IsCarrier() bool {
+	return deck.motor && deck.recorder == nil && deck.highTone >= carrierDetectTime
+}
+
+func NewTapeDeck() *TapeDeck {
+	return &TapeDeck{}
+}
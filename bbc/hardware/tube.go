@@ -0,0 +1,249 @@
+package hardware
+
+import (
+	"bbc/utils"
+)
+
+// Tube ULA between the host at FEE0 and a second processor at FEF8. Four
+// register pairs carry data each way through FIFOs: R1 holds 24 bytes from
+// the parasite and 1 to it, R3 1 or 2 bytes each way and the others 1. The
+// even addresses read the status of their register, data available in bit
+// 7 and not full in bit 6, R1 status also has the control flags in its low
+// bits. The host sets flags writing FEE0 with bit 7 set, clears them with
+// it clear.
+
+// control flags
+const (
+	// host IRQ while R4 has data for the host
+	TubeHostIRQ = 0x01
+	// parasite IRQ while R1 or R4 have data for the parasite
+	TubeParasiteIRQR1 = 0x02
+	TubeParasiteIRQR4 = 0x04
+	// parasite NMI while R3 is full for the parasite or empty from it
+	TubeParasiteNMI = 0x08
+	TubeTwoByteR3   = 0x10
+	// holds the parasite in reset
+	TubeParasiteReset = 0x20
+	// clears the FIFOs, not kept
+	TubeClear    = 0x40
+	tubeSetFlags = 0x80
+	tubeFlags    = 0x3F
+)
+
+// status of a register
+const (
+	TubeDataAvailable = 0x80
+	TubeNotFull       = 0x40
+)
+
+// sizes of the FIFOs from the parasite
+var tubeParasiteFIFOs = [4]int{24, 1, 2, 1}
+
+type tubeFIFO struct {
+	data []byte
+	size int
+	// the last byte read is read again from an empty FIFO
+	last byte
+}
+
+func (fifo *tubeFIFO) push(value byte) {
+	if len(fifo.data) < fifo.size {
+		fifo.data = append(fifo.data, value)
+	}
+}
+
+func (fifo *tubeFIFO) pop() byte {
+	if len(fifo.data) > 0 {
+		fifo.last = fifo.data[0]
+		fifo.data = fifo.data[1:]
+	}
+	return fifo.last
+}
+
+type Tube struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+	// the second processor, nil until one is fitted
+	parasite *SecondProcessor
+
+	flags      byte
+	toParasite [4]tubeFIFO
+	toHost     [4]tubeFIFO
+}
+
+func (tube *Tube) GetName() string            { return tube.name }
+func (tube *Tube) PlugToBus(bus *Bus)         { tube.bus = bus }
+func (tube *Tube) IsWritable() bool           { return true }
+func (tube *Tube) IsReadable() bool           { return true }
+func (tube *Tube) GetSegment() *utils.Segment { return tube.segment }
+
+func (tube *Tube) Start() error {
+	return nil
+}
+
+func (tube *Tube) Reset() error {
+	tube.flags = 0
+	tube.clear()
+	return nil
+}
+
+func (tube *Tube) Stop() error {
+	return nil
+}
+
+// Empties the FIFOs, R3 from the parasite holds a byte as the ULA comes out
+// of reset.
+func (tube *Tube) clear() {
+	for i := range tube.toParasite {
+		tube.toParasite[i] = tubeFIFO{size: 1}
+		tube.toHost[i] = tubeFIFO{size: tubeParasiteFIFOs[i]}
+	}
+	tube.resizeR3()
+	tube.toHost[2].data = []byte{0}
+	tube.updateInterrupts()
+}
+
+func (tube *Tube) resizeR3() {
+	size := 1
+	if tube.flags&TubeTwoByteR3 != 0 {
+		size = 2
+	}
+	tube.toParasite[2].size = size
+	tube.toHost[2].size = size
+}
+
+func (tube *Tube) GetFlags() byte {
+	return tube.flags
+}
+
+func (tube *Tube) setFlags(value byte) {
+	if value&tubeSetFlags != 0 {
+		tube.flags |= value & tubeFlags
+	} else {
+		tube.flags &^= value & tubeFlags
+	}
+	tube.resizeR3()
+	if value&tubeSetFlags != 0 && value&TubeClear != 0 {
+		tube.clear()
+	}
+	tube.updateInterrupts()
+}
+
+// Data for the parasite is only available in R3 once it is full.
+func (tube *Tube) parasiteAvailable(register int) bool {
+	fifo := &tube.toParasite[register]
+	if register == 2 {
+		return len(fifo.data) >= fifo.size
+	}
+	return len(fifo.data) > 0
+}
+
+func (tube *Tube) hostStatus(register int) byte {
+	status := byte(0)
+	if len(tube.toHost[register].data) > 0 {
+		status |= TubeDataAvailable
+	}
+	if fifo := &tube.toParasite[register]; len(fifo.data) < fifo.size {
+		status |= TubeNotFull
+	}
+	if register == 0 {
+		status |= tube.flags
+	}
+	return status
+}
+
+func (tube *Tube) parasiteStatus(register int) byte {
+	status := byte(0)
+	if tube.parasiteAvailable(register) {
+		status |= TubeDataAvailable
+	}
+	if fifo := &tube.toHost[register]; len(fifo.data) < fifo.size {
+		status |= TubeNotFull
+	}
+	if register == 0 {
+		status |= tube.flags
+	}
+	return status
+}
+
+func (tube *Tube) updateInterrupts() {
+	if tube.bus != nil {
+		tube.bus.IRQ.Set(tube.name, tube.flags&TubeHostIRQ != 0 && len(tube.toHost[3].data) > 0)
+	}
+	if tube.parasite == nil {
+		return
+	}
+	irq := tube.flags&TubeParasiteIRQR1 != 0 && tube.parasiteAvailable(0) ||
+		tube.flags&TubeParasiteIRQR4 != 0 && tube.parasiteAvailable(3)
+	nmi := tube.flags&TubeParasiteNMI != 0 && (tube.parasiteAvailable(2) || len(tube.toHost[2].data) == 0)
+	tube.parasite.setLines(irq, nmi, tube.flags&TubeParasiteReset != 0)
+}
+
+func (tube *Tube) DirectRead(addr uint16) (byte, error) {
+	register := int(addr&0x07) >> 1
+	var value byte
+	if addr&0x01 == 0 {
+		value = tube.hostStatus(register)
+	} else {
+		value = tube.toHost[register].pop()
+	}
+	tube.updateInterrupts()
+	return value, nil
+}
+
+func (tube *Tube) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := tube.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func (tube *Tube) DirectWrite(value byte, addr uint16) error {
+	register := int(addr&0x07) >> 1
+	switch {
+	case addr&0x07 == 0:
+		tube.setFlags(value)
+	case addr&0x01 != 0:
+		tube.toParasite[register].push(value)
+		tube.updateInterrupts()
+	}
+	return nil
+}
+
+func (tube *Tube) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := tube.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+// Registers as the parasite sees them.
+func (tube *Tube) parasiteRead(addr uint16) byte {
+	register := int(addr&0x07) >> 1
+	var value byte
+	if addr&0x01 == 0 {
+		value = tube.parasiteStatus(register)
+	} else {
+		value = tube.toParasite[register].pop()
+	}
+	tube.updateInterrupts()
+	return value
+}
+
+func (tube *Tube) parasiteWrite(value byte, addr uint16) {
+	if addr&0x01 == 0 {
+		return
+	}
+	tube.toHost[int(addr&0x07)>>1].push(value)
+	tube.updateInterrupts()
+}
+
+func NewTube(name string, segment *utils.Segment) *Tube {
+	tube := &Tube{name: name, segment: segment}
+	tube.Reset()
+	return tube
+}
@@ -0,0 +1,754 @@
+package hardware
+
+import (
+	"bbc/utils"
+)
+
+// MOS 6522 Versatile Interface Adapter. The timers are counted from the
+// 1MHz ticks when their registers are accessed, their underflows and the
+// shift register clock edges are events scheduled on the bus clock.
+
+type VIAControlLine uint8
+
+const (
+	VIAControl1 VIAControlLine = iota
+	VIAControl2
+)
+
+// Devices wired to a VIA port.
+type VIAPeripheral interface {
+	// levels driven on the port pins, undriven pins read high
+	ReadPort() byte
+	// called when the port output register or data direction changes
+	WritePort(value, ddr byte)
+}
+
+// Peripherals also notified when the VIA drives their port control lines.
+type VIAControlPeripheral interface {
+	VIAPeripheral
+	WriteControl(line VIAControlLine, level bool)
+}
+
+const (
+	viaORB = iota
+	viaORA
+	viaDDRB
+	viaDDRA
+	viaT1CL
+	viaT1CH
+	viaT1LL
+	viaT1LH
+	viaT2CL
+	viaT2CH
+	viaSR
+	viaACR
+	viaPCR
+	viaIFR
+	viaIER
+	viaORANoHandshake
+)
+
+const (
+	VIAInterruptCA2 byte = 1 << iota
+	VIAInterruptCA1
+	VIAInterruptSR
+	VIAInterruptCB2
+	VIAInterruptCB1
+	VIAInterruptT2
+	VIAInterruptT1
+	VIAInterruptAny
+)
+
+const (
+	viaACRLatchA     = 0x01
+	viaACRLatchB     = 0x02
+	viaACRPulseCount = 0x20
+	viaACRFreeRun    = 0x40
+	viaACRPB7        = 0x80
+)
+
+// C2 control modes, from the PCR
+const (
+	viaC2InputNegative = iota
+	viaC2IndependentNegative
+	viaC2InputPositive
+	viaC2IndependentPositive
+	viaC2Handshake
+	viaC2Pulse
+	viaC2Low
+	viaC2High
+)
+
+// shift register modes, from the ACR
+const (
+	viaSRDisabled = iota
+	viaSRInT2
+	viaSRInClock
+	viaSRInExternal
+	viaSROutFreeRun
+	viaSROutT2
+	viaSROutClock
+	viaSROutExternal
+)
+
+type viaPort struct {
+	output      byte
+	ddr         byte
+	latch       byte
+	c1          bool
+	c2          bool
+	c2Output    bool
+	c2Pulse     bool
+	peripherals []VIAPeripheral
+}
+
+// Pin levels, outputs driven by the VIA may be pulled low by peripherals.
+func (port *viaPort) pins() byte {
+	value := port.output | ^port.ddr
+	for _, peripheral := range port.peripherals {
+		value &= peripheral.ReadPort()
+	}
+	return value
+}
+
+func (port *viaPort) notify(value, ddr byte) {
+	for _, peripheral := range port.peripherals {
+		peripheral.WritePort(value, ddr)
+	}
+}
+
+func (port *viaPort) notifyControl(line VIAControlLine, level bool) {
+	for _, peripheral := range port.peripherals {
+		if control, ok := peripheral.(VIAControlPeripheral); ok {
+			control.WriteControl(line, level)
+		}
+	}
+}
+
+type VIA struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+	domain  *ClockDomain
+	// 1MHz tick the timers were counted up to
+	ticks uint64
+
+	t1Event    *Event
+	t2Event    *Event
+	srEvent    *Event
+	pulseEvent *Event
+
+	portA viaPort
+	portB viaPort
+
+	t1Counter uint16
+	t1Latch   uint16
+	t1Reload  bool
+	t1Armed   bool
+	pb7       bool
+
+	t2Counter  uint16
+	t2LatchLow byte
+	t2Armed    bool
+	pb6        bool
+
+	sr        byte
+	srShifts  int
+	srClock   bool
+	srRunning bool
+
+	acr byte
+	pcr byte
+	ifr byte
+	ier byte
+}
+
+func (via *VIA) GetName() string            { return via.name }
+func (via *VIA) IsWritable() bool           { return true }
+func (via *VIA) IsReadable() bool           { return true }
+func (via *VIA) GetSegment() *utils.Segment { return via.segment }
+func (via *VIA) GetBusDomain() string       { return DomainPeripheral }
+
+func (via *VIA) PlugToBus(bus *Bus) {
+	via.bus = bus
+	via.domain = bus.GetDomain(DomainPeripheral)
+	via.ticks = via.now()
+	via.t1Event = bus.NewEvent(via.name+" timer 1", func(uint64) error {
+		via.sync()
+		via.timer1Underflow()
+		return nil
+	})
+	via.t2Event = bus.NewEvent(via.name+" timer 2", func(uint64) error {
+		via.sync()
+		via.t2Armed = false
+		via.setInterrupt(VIAInterruptT2)
+		return nil
+	})
+	via.srEvent = bus.NewEvent(via.name+" shift clock", func(uint64) error {
+		via.shiftClockEdge()
+		return nil
+	})
+	via.pulseEvent = bus.NewEvent(via.name+" pulse", func(uint64) error {
+		via.endPulse(&via.portA)
+		via.endPulse(&via.portB)
+		return nil
+	})
+}
+
+func (via *VIA) Start() error {
+	return nil
+}
+
+func (via *VIA) Reset() error {
+	via.sync()
+	via.portA.output, via.portA.ddr = 0, 0
+	via.portB.output, via.portB.ddr = 0, 0
+	via.portA.c2Output, via.portB.c2Output = true, true
+	via.portA.c2Pulse, via.portB.c2Pulse = false, false
+	via.t1Reload, via.t1Armed = false, false
+	via.t2Armed = false
+	via.pb7 = true
+	via.srShifts, via.srRunning, via.srClock = 0, false, true
+	via.acr, via.pcr, via.ifr, via.ier = 0, 0, 0, 0
+	via.schedule()
+	via.scheduleShiftClock()
+	via.updateIRQ()
+	via.portA.notify(via.portA.output, via.portA.ddr)
+	via.notifyPortB()
+	return nil
+}
+
+func (via *VIA) Stop() error {
+	return nil
+}
+
+func (via *VIA) ConnectPortA(peripheral VIAPeripheral) {
+	via.portA.peripherals = append(via.portA.peripherals, peripheral)
+}
+
+func (via *VIA) ConnectPortB(peripheral VIAPeripheral) {
+	via.portB.peripherals = append(via.portB.peripherals, peripheral)
+}
+
+func (via *VIA) updateIRQ() {
+	if via.bus != nil {
+		via.bus.IRQ.Set(via.name, via.ifr&via.ier&0x7F != 0)
+	}
+}
+
+func (via *VIA) setInterrupt(flags byte) {
+	via.ifr |= flags
+	via.updateIRQ()
+}
+
+func (via *VIA) clearInterrupt(flags byte) {
+	via.ifr &^= flags
+	via.updateIRQ()
+}
+
+func (via *VIA) IsIRQ() bool {
+	return via.ifr&via.ier&0x7F != 0
+}
+
+func (via *VIA) ca2Mode() byte { return (via.pcr >> 1) & 0x07 }
+func (via *VIA) cb2Mode() byte { return (via.pcr >> 5) & 0x07 }
+func (via *VIA) srMode() byte  { return (via.acr >> 2) & 0x07 }
+
+func isIndependentC2(mode byte) bool {
+	return mode == viaC2IndependentNegative || mode == viaC2IndependentPositive
+}
+
+// Port B as seen from the outside, PB7 may be driven by timer 1.
+func (via *VIA) portBOutput() (byte, byte) {
+	value, ddr := via.portB.output, via.portB.ddr
+	if via.acr&viaACRPB7 != 0 {
+		ddr |= 0x80
+		value &^= 0x80
+		if via.pb7 {
+			value |= 0x80
+		}
+	}
+	return value, ddr
+}
+
+func (via *VIA) notifyPortB() {
+	via.portB.notify(via.portBOutput())
+}
+
+func (via *VIA) setC2Output(port *viaPort, line VIAControlLine, level bool) {
+	if port.c2Output == level {
+		return
+	}
+	port.c2Output = level
+	port.notifyControl(line, level)
+}
+
+// Applies the C2 output modes after the PCR changed.
+func (via *VIA) updateC2Outputs() {
+	for _, c2 := range []struct {
+		port *viaPort
+		mode byte
+	}{{&via.portA, via.ca2Mode()}, {&via.portB, via.cb2Mode()}} {
+		switch c2.mode {
+		case viaC2Low:
+			via.setC2Output(c2.port, VIAControl2, false)
+		case viaC2High, viaC2Handshake, viaC2Pulse:
+			via.setC2Output(c2.port, VIAControl2, true)
+		}
+	}
+}
+
+// Handshake on port accesses: C2 goes low until the next C1 active edge, or
+// for one cycle in pulse mode.
+func (via *VIA) handshake(port *viaPort, mode byte) {
+	switch mode {
+	case viaC2Handshake:
+		via.setC2Output(port, VIAControl2, false)
+	case viaC2Pulse:
+		via.setC2Output(port, VIAControl2, false)
+		port.c2Pulse = true
+		if via.pulseEvent != nil {
+			via.bus.ScheduleIn(via.pulseEvent, via.domain.CyclesUntil(1))
+		}
+	}
+}
+
+func (via *VIA) readPortA() byte {
+	if via.acr&viaACRLatchA != 0 {
+		return via.portA.latch
+	}
+	return via.portA.pins()
+}
+
+func (via *VIA) readPortB() byte {
+	input := via.portB.pins()
+	if via.acr&viaACRLatchB != 0 {
+		input = via.portB.latch
+	}
+	value, ddr := via.portBOutput()
+	return value&ddr | input&^ddr
+}
+
+func (via *VIA) startShift() {
+	via.clearInterrupt(VIAInterruptSR)
+	via.srShifts = 0
+	via.srRunning = via.srMode() != viaSRDisabled
+	via.scheduleShiftClock()
+}
+
+func (via *VIA) read(reg uint16) byte {
+	via.sync()
+	switch reg {
+	case viaORB:
+		flags := VIAInterruptCB1
+		if !isIndependentC2(via.cb2Mode()) {
+			flags |= VIAInterruptCB2
+		}
+		via.clearInterrupt(flags)
+		return via.readPortB()
+	case viaORA:
+		flags := VIAInterruptCA1
+		if !isIndependentC2(via.ca2Mode()) {
+			flags |= VIAInterruptCA2
+		}
+		via.clearInterrupt(flags)
+		via.handshake(&via.portA, via.ca2Mode())
+		return via.readPortA()
+	case viaDDRB:
+		return via.portB.ddr
+	case viaDDRA:
+		return via.portA.ddr
+	case viaT1CL:
+		via.clearInterrupt(VIAInterruptT1)
+		return byte(via.t1Counter)
+	case viaT1CH:
+		return byte(via.t1Counter >> 8)
+	case viaT1LL:
+		return byte(via.t1Latch)
+	case viaT1LH:
+		return byte(via.t1Latch >> 8)
+	case viaT2CL:
+		via.clearInterrupt(VIAInterruptT2)
+		return byte(via.t2Counter)
+	case viaT2CH:
+		return byte(via.t2Counter >> 8)
+	case viaSR:
+		value := via.sr
+		via.startShift()
+		return value
+	case viaACR:
+		return via.acr
+	case viaPCR:
+		return via.pcr
+	case viaIFR:
+		if via.IsIRQ() {
+			return via.ifr | VIAInterruptAny
+		}
+		return via.ifr
+	case viaIER:
+		return via.ier | 0x80
+	case viaORANoHandshake:
+		return via.readPortA()
+	}
+	return 0
+}
+
+func (via *VIA) write(value byte, reg uint16) {
+	via.sync()
+	defer via.schedule()
+	switch reg {
+	case viaORB:
+		flags := VIAInterruptCB1
+		if !isIndependentC2(via.cb2Mode()) {
+			flags |= VIAInterruptCB2
+		}
+		via.clearInterrupt(flags)
+		via.portB.output = value
+		via.notifyPortB()
+		via.samplePB6()
+		via.handshake(&via.portB, via.cb2Mode())
+	case viaORA, viaORANoHandshake:
+		if reg == viaORA {
+			flags := VIAInterruptCA1
+			if !isIndependentC2(via.ca2Mode()) {
+				flags |= VIAInterruptCA2
+			}
+			via.clearInterrupt(flags)
+		}
+		via.portA.output = value
+		via.portA.notify(via.portA.output, via.portA.ddr)
+		if reg == viaORA {
+			via.handshake(&via.portA, via.ca2Mode())
+		}
+	case viaDDRB:
+		via.portB.ddr = value
+		via.notifyPortB()
+		via.samplePB6()
+	case viaDDRA:
+		via.portA.ddr = value
+		via.portA.notify(via.portA.output, via.portA.ddr)
+	case viaT1CL, viaT1LL:
+		via.t1Latch = via.t1Latch&0xFF00 | uint16(value)
+	case viaT1CH:
+		via.t1Latch = via.t1Latch&0x00FF | uint16(value)<<8
+		via.t1Counter = via.t1Latch
+		via.t1Reload = false
+		via.t1Armed = true
+		via.clearInterrupt(VIAInterruptT1)
+		if via.acr&viaACRPB7 != 0 {
+			via.pb7 = false
+			via.notifyPortB()
+		}
+	case viaT1LH:
+		via.t1Latch = via.t1Latch&0x00FF | uint16(value)<<8
+		via.clearInterrupt(VIAInterruptT1)
+	case viaT2CL:
+		via.t2LatchLow = value
+	case viaT2CH:
+		via.t2Counter = uint16(value)<<8 | uint16(via.t2LatchLow)
+		via.t2Armed = true
+		via.clearInterrupt(VIAInterruptT2)
+	case viaSR:
+		via.sr = value
+		via.startShift()
+	case viaACR:
+		// PB6 edges are counted from the level it had
+		via.samplePB6()
+		previous := via.acr
+		via.acr = value
+		if (previous^value)&viaACRPB7 != 0 {
+			via.notifyPortB()
+		}
+		if via.srMode() == viaSRDisabled {
+			via.srRunning = false
+		}
+		via.scheduleShiftClock()
+	case viaPCR:
+		via.pcr = value
+		via.updateC2Outputs()
+	case viaIFR:
+		via.clearInterrupt(value & 0x7F)
+	case viaIER:
+		if value&0x80 != 0 {
+			via.ier |= value & 0x7F
+		} else {
+			via.ier &^= value & 0x7F
+		}
+		via.updateIRQ()
+	}
+}
+
+func (via *VIA) DirectRead(addr uint16) (byte, error) {
+	return via.read(addr & 0x0F), nil
+}
+
+func (via *VIA) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := via.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func (via *VIA) DirectWrite(value byte, addr uint16) error {
+	via.write(value, addr&0x0F)
+	return nil
+}
+
+func (via *VIA) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := via.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+// Active edge on C1: sets the interrupt flag, latches the port input and
+// ends a C2 handshake.
+func (via *VIA) controlLine1(port *viaPort, level bool, positive bool, flag byte, latch byte, c2Mode byte) {
+	if port.c1 == level {
+		return
+	}
+	port.c1 = level
+	if level != positive {
+		return
+	}
+	if via.acr&latch != 0 {
+		port.latch = port.pins()
+	}
+	if c2Mode == viaC2Handshake {
+		via.setC2Output(port, VIAControl2, true)
+	}
+	via.setInterrupt(flag)
+}
+
+func (via *VIA) controlLine2(port *viaPort, level bool, mode byte, flag byte) {
+	if port.c2 == level {
+		return
+	}
+	port.c2 = level
+	if mode >= viaC2Handshake {
+		return
+	}
+	positive := mode == viaC2InputPositive || mode == viaC2IndependentPositive
+	if level == positive {
+		via.setInterrupt(flag)
+	}
+}
+
+func (via *VIA) SetCA1(level bool) {
+	via.controlLine1(&via.portA, level, via.pcr&0x01 != 0, VIAInterruptCA1, viaACRLatchA, via.ca2Mode())
+}
+
+func (via *VIA) SetCA2(level bool) {
+	via.controlLine2(&via.portA, level, via.ca2Mode(), VIAInterruptCA2)
+}
+
+func (via *VIA) SetCB1(level bool) {
+	previous := via.portB.c1
+	via.controlLine1(&via.portB, level, via.pcr&0x10 != 0, VIAInterruptCB1, viaACRLatchB, via.cb2Mode())
+	if previous == level || !via.srRunning {
+		return
+	}
+	// external shift clock: data is shifted in on rising edges, out on falling ones
+	switch via.srMode() {
+	case viaSRInExternal:
+		if level {
+			via.shiftIn()
+		}
+	case viaSROutExternal:
+		if !level {
+			via.shiftOut()
+		}
+	}
+}
+
+func (via *VIA) SetCB2(level bool) {
+	via.controlLine2(&via.portB, level, via.cb2Mode(), VIAInterruptCB2)
+}
+
+func (via *VIA) GetCA2() bool { return via.portA.c2Output }
+func (via *VIA) GetCB2() bool { return via.portB.c2Output }
+
+func (via *VIA) shiftDone() {
+	via.srShifts++
+	if via.srShifts == 8 && via.srMode() != viaSROutFreeRun {
+		via.srRunning = false
+		via.setInterrupt(VIAInterruptSR)
+	}
+}
+
+func (via *VIA) shiftIn() {
+	via.sr <<= 1
+	if via.portB.c2 {
+		via.sr |= 0x01
+	}
+	via.shiftDone()
+}
+
+func (via *VIA) shiftOut() {
+	bit := via.sr&0x80 != 0
+	via.sr = via.sr<<1 | via.sr>>7
+	via.setC2Output(&via.portB, VIAControl2, bit)
+	via.shiftDone()
+}
+
+// Half period of the internal shift clock in 1MHz ticks, 0 when CB1 is
+// clocked from outside.
+func (via *VIA) shiftHalfPeriod() uint64 {
+	switch via.srMode() {
+	case viaSRInClock, viaSROutClock:
+		return 1
+	case viaSRInT2, viaSROutFreeRun, viaSROutT2:
+		return uint64(via.t2LatchLow) + 2
+	}
+	return 0
+}
+
+func (via *VIA) scheduleShiftClock() {
+	if via.srEvent == nil {
+		return
+	}
+	halfPeriod := via.shiftHalfPeriod()
+	if !via.srRunning || halfPeriod == 0 {
+		via.bus.Cancel(via.srEvent)
+		return
+	}
+	via.bus.ScheduleIn(via.srEvent, via.domain.CyclesUntil(halfPeriod))
+}
+
+// Internal shift clock: CB1 toggles every half period, data moves on the
+// rising edges.
+func (via *VIA) shiftClockEdge() {
+	via.srClock = !via.srClock
+	via.portB.notifyControl(VIAControl1, via.srClock)
+	if via.srClock {
+		if via.srMode() < viaSROutFreeRun {
+			via.shiftIn()
+		} else {
+			via.shiftOut()
+		}
+	}
+	via.scheduleShiftClock()
+}
+
+// 1MHz ticks since the bus was reset.
+func (via *VIA) now() uint64 {
+	if via.domain == nil {
+		return 0
+	}
+	return via.domain.GetCycles()
+}
+
+// Counts the timers down to the current tick. Timer 1 reloads from its latch
+// the tick after it passes 0, timer 2 wraps around.
+func (via *VIA) sync() {
+	now := via.now()
+	if now <= via.ticks {
+		// the clock was reset
+		via.ticks = now
+		return
+	}
+	elapsed := now - via.ticks
+	via.ticks = now
+
+	t1 := elapsed
+	if via.t1Reload {
+		via.t1Counter = via.t1Latch
+		via.t1Reload = false
+		t1--
+	}
+	if t1 <= uint64(via.t1Counter) {
+		via.t1Counter -= uint16(t1)
+	} else {
+		// from the first underflow, the counter runs latch+2 tick periods
+		t1 = (t1 - uint64(via.t1Counter) - 1) % (uint64(via.t1Latch) + 2)
+		via.t1Counter, via.t1Reload = 0xFFFF, true
+		if t1 > 0 {
+			via.t1Counter = via.t1Latch - uint16(t1-1)
+			via.t1Reload = false
+		}
+	}
+
+	if via.acr&viaACRPulseCount == 0 {
+		via.t2Counter -= uint16(elapsed)
+	}
+}
+
+// Ticks until the timer 1 counter next passes 0.
+func (via *VIA) untilTimer1() uint64 {
+	if via.t1Reload {
+		return uint64(via.t1Latch) + 2
+	}
+	return uint64(via.t1Counter) + 1
+}
+
+// Only underflows raising an interrupt or toggling PB7 are scheduled.
+func (via *VIA) schedule() {
+	if via.t1Event == nil {
+		return
+	}
+	if via.t1Armed {
+		via.bus.ScheduleIn(via.t1Event, via.domain.CyclesUntil(via.untilTimer1()))
+	} else {
+		via.bus.Cancel(via.t1Event)
+	}
+	if via.t2Armed && via.acr&viaACRPulseCount == 0 {
+		via.bus.ScheduleIn(via.t2Event, via.domain.CyclesUntil(uint64(via.t2Counter)+1))
+	} else {
+		via.bus.Cancel(via.t2Event)
+	}
+}
+
+func (via *VIA) timer1Underflow() {
+	if via.acr&viaACRFreeRun == 0 {
+		via.t1Armed = false
+		via.pb7 = true
+	} else {
+		via.pb7 = !via.pb7
+	}
+	if via.acr&viaACRPB7 != 0 {
+		via.notifyPortB()
+	}
+	via.setInterrupt(VIAInterruptT1)
+	via.schedule()
+}
+
+// In pulse counting mode timer 2 counts falling edges on PB6.
+func (via *VIA) samplePB6() {
+	pb6 := via.portB.pins()&0x40 != 0
+	falling := via.pb6 && !pb6
+	via.pb6 = pb6
+	if !falling || via.acr&viaACRPulseCount == 0 {
+		return
+	}
+	via.t2Counter--
+	if via.t2Counter == 0 && via.t2Armed {
+		via.t2Armed = false
+		via.setInterrupt(VIAInterruptT2)
+	}
+}
+
+// Peripherals call it when the levels they drive on port B change.
+func (via *VIA) UpdateInputs() {
+	via.samplePB6()
+}
+
+func (via *VIA) endPulse(port *viaPort) {
+	if port.c2Pulse {
+		port.c2Pulse = false
+		via.setC2Output(port, VIAControl2, true)
+	}
+}
+
+func NewVIA(name string, segment *utils.Segment) *VIA {
+	via := &VIA{
+		name:    name,
+		segment: segment,
+	}
+	via.portA.c1, via.portA.c2 = true, true
+	via.portB.c1, via.portB.c2 = true, true
+	via.Reset()
+	return via
+}
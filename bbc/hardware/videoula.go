@@ -0,0 +1,386 @@
+package hardware
+
+import (
+	"bbc/utils"
+	"image"
+	"image/color"
+)
+
+// Video ULA: serialises the screen memory addressed by the CRTC into pixels
+// through a 16 entries palette. The control register is at even addresses,
+// the palette at odd ones. Frames are rendered at the 16MHz pixel rate with
+// scan lines doubled.
+
+const (
+	ScreenWidth  = 640
+	ScreenHeight = 512
+	// picture origin, in 16MHz pixels after the hsync start and in scan
+	// lines after the vsync start
+	screenLeft = 240
+	screenTop  = 40
+)
+
+// control register bits
+const (
+	ULAFlash     = 0x01
+	ULATeletext  = 0x02
+	ULAPixelRate = 0x0C
+	ULAFastCRTC  = 0x10
+	ULACursor    = 0xE0
+)
+
+// screen sizes indexed by the latch C0 and C1 (16K, 8K, 20K and 10K),
+// subtracted from addresses beyond the end of the RAM
+var screenWrap = [4]uint32{0x4000, 0x2000, 0x5000, 0x2800}
+
+// physical colours: bit 0 red, bit 1 green, bit 2 blue
+var physicalColours [8]color.RGBA
+
+func init() {
+	for c := range physicalColours {
+		physicalColours[c] = color.RGBA{
+			R: byte(c&1) * 0xFF,
+			G: byte((c>>1)&1) * 0xFF,
+			B: byte((c>>2)&1) * 0xFF,
+			A: 0xFF,
+		}
+	}
+}
+
+type FrameFn func(frame *image.RGBA)
+
+type ReadFn func()
+
+type VideoULA struct {
+	name     string
+	segment  *utils.Segment
+	bus      *Bus
+	crtc     *CRTC
+	latch    *AddressableLatch
+	memory   ReadableComponent
+	teletext *SAA5050
+
+	control byte
+	palette [16]byte
+
+	framebuffer *image.RGBA
+	// beam position, in 16MHz pixels since hsync and scan lines since vsync
+	x     int
+	line  int
+	hSync bool
+	vSync bool
+	// characters since the CRTC cursor started, -1 out of the cursor
+	cursor int
+	pixels [16]byte
+
+	frames         uint64
+	frameObservers []FrameFn
+	readObservers  []ReadFn
+}
+
+func (ula *VideoULA) GetName() string            { return ula.name }
+func (ula *VideoULA) PlugToBus(bus *Bus)         { ula.bus = bus }
+func (ula *VideoULA) IsWritable() bool           { return true }
+func (ula *VideoULA) IsReadable() bool           { return true }
+func (ula *VideoULA) GetSegment() *utils.Segment { return ula.segment }
+
+func (ula *VideoULA) Start() error {
+	return nil
+}
+
+func (ula *VideoULA) Reset() error {
+	ula.palette = [16]byte{}
+	ula.cursor = -1
+	ula.frames = 0
+	return ula.SetControl(0)
+}
+
+func (ula *VideoULA) Stop() error {
+	return nil
+}
+
+func (ula *VideoULA) GetControl() byte {
+	return ula.control
+}
+
+// The control register also selects the CRTC and pixel clocks.
+func (ula *VideoULA) SetControl(value byte) error {
+	ula.crtc.Sync()
+	ula.control = value
+	if ula.bus == nil {
+		return nil
+	}
+	// the CRTC line end moves with its clock
+	defer ula.crtc.Sync()
+	character := uint64(16)
+	if ula.isFastCRTC() {
+		character = 8
+	}
+	if domain := ula.bus.GetDomain(DomainCharacter); domain != nil && domain.GetDivider() != character {
+		if err := domain.SetDivider(character, 0); err != nil {
+			return err
+		}
+	}
+	pixel := ula.pixelWidth()
+	if domain := ula.bus.GetDomain(DomainPixel); domain != nil && domain.GetDivider() != pixel {
+		if err := domain.SetDivider(pixel, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Palette writes hold the logical colour in the high nibble, the physical
+// one inverted in the low nibble.
+func (ula *VideoULA) SetPalette(value byte) {
+	ula.crtc.Sync()
+	ula.palette[value>>4] = value & 0x0F
+}
+
+func (ula *VideoULA) GetPalette(logical byte) byte {
+	return ula.palette[logical&0x0F]
+}
+
+func (ula *VideoULA) isFastCRTC() bool { return ula.control&ULAFastCRTC != 0 }
+func (ula *VideoULA) IsTeletext() bool { return ula.control&ULATeletext != 0 }
+func (ula *VideoULA) characterWidth() int {
+	if ula.isFastCRTC() {
+		return 8
+	}
+	return 16
+}
+
+// Width of a pixel at 16MHz: 2, 4, 8 or 16MHz pixel clocks.
+func (ula *VideoULA) pixelWidth() uint64 {
+	return 8 >> ((ula.control & ULAPixelRate) >> 2)
+}
+
+func (ula *VideoULA) DirectWrite(value byte, addr uint16) error {
+	if addr&0x01 == 0 {
+		return ula.SetControl(value)
+	}
+	ula.SetPalette(value)
+	return nil
+}
+
+// The ULA registers are write only, the board decodes reads of their
+// addresses as INTON for the Econet.
+func (ula *VideoULA) DirectRead(addr uint16) (byte, error) {
+	for _, observer := range ula.readObservers {
+		observer()
+	}
+	return 0xFF, nil
+}
+
+func (ula *VideoULA) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := ula.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func (ula *VideoULA) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := ula.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+func (ula *VideoULA) physicalColour(logical byte) byte {
+	entry := ula.palette[logical]
+	colour := (entry & 0x07) ^ 0x07
+	if entry&0x08 != 0 && ula.control&ULAFlash != 0 {
+		colour ^= 0x07
+	}
+	return colour
+}
+
+// Translates the CRTC address to the RAM, wrapping around the end of the
+// screen as selected by the latch. Addresses with bit 13 set are the
+// teletext ones, in the last kilobyte.
+func (ula *VideoULA) screenAddress(signals CRTCSignals) uint16 {
+	if signals.Address&0x2000 != 0 {
+		return 0x7C00 | signals.Address&0x03FF
+	}
+	addr := uint32(signals.Address)<<3 | uint32(signals.Raster&0x07)
+	if addr&0x8000 != 0 {
+		addr -= screenWrap[ula.latch.GetScreenSize()]
+	}
+	return uint16(addr & 0x7FFF)
+}
+
+func (ula *VideoULA) readScreen(addr uint16) byte {
+	value, err := ula.memory.DirectRead(addr)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// Each pixel takes its logical colour from bits 7, 5, 3 and 1 of the shift
+// register, which is shifted left with ones.
+func (ula *VideoULA) bitmapPixels(signals CRTCSignals, width int) {
+	pixelWidth := int(ula.pixelWidth())
+	// lines 8 and beyond of a character row are blank in bitmap modes
+	if !signals.Display || signals.Raster&0x08 != 0 {
+		for i := 0; i < width; i++ {
+			ula.pixels[i] = 0
+		}
+		return
+	}
+	shift := ula.readScreen(ula.screenAddress(signals))
+	for i := 0; i < width; i += pixelWidth {
+		logical := (shift>>4)&0x08 | (shift>>3)&0x04 | (shift>>2)&0x02 | (shift>>1)&0x01
+		colour := ula.physicalColour(logical)
+		for j := i; j < i+pixelWidth && j < width; j++ {
+			ula.pixels[j] = colour
+		}
+		shift = shift<<1 | 1
+	}
+}
+
+// The cursor is 1 to 4 characters wide, each segment enabled by a control
+// bit: the third one covers the last two characters.
+func (ula *VideoULA) applyCursor(signals CRTCSignals, width int) {
+	if signals.Cursor && ula.cursor < 0 {
+		ula.cursor = 0
+	}
+	if ula.cursor < 0 {
+		return
+	}
+	segment := ula.cursor
+	if segment > 2 {
+		segment = 2
+	}
+	if ula.control&(0x80>>segment) != 0 {
+		for i := 0; i < width; i++ {
+			ula.pixels[i] ^= 0x07
+		}
+	}
+	ula.cursor++
+	if ula.cursor > 3 {
+		ula.cursor = -1
+	}
+}
+
+// The SAA5050 colours bypass the palette. Interlaced fields each show one
+// of the rounded lines, otherwise the even ones are shown.
+func (ula *VideoULA) teletextPixels(signals CRTCSignals, width int) {
+	line := int(signals.Raster)
+	if !ula.crtc.IsInterlacedVideo() {
+		line *= 2
+	}
+	if line >= teletextLines {
+		line = teletextLines - 1
+	}
+	data := ula.readScreen(ula.screenAddress(signals))
+	ula.teletext.Character(data, line, signals.Display, ula.pixels[:width])
+}
+
+func (ula *VideoULA) draw(width int) {
+	y := ula.line - screenTop
+	left := ula.x - screenLeft
+	if y < 0 || y >= ScreenHeight/2 || left+width <= 0 || left >= ScreenWidth {
+		return
+	}
+	// interlaced fields each draw one line of the pair
+	first, last := 2*y, 2*y+1
+	if ula.crtc.IsInterlacedVideo() {
+		if ula.crtc.IsOddField() {
+			first++
+		} else {
+			last--
+		}
+	}
+	for row := first; row <= last; row++ {
+		for i := 0; i < width; i++ {
+			x := left + i
+			if x < 0 || x >= ScreenWidth {
+				continue
+			}
+			colour := physicalColours[ula.pixels[i]]
+			offset := ula.framebuffer.PixOffset(x, row)
+			pix := ula.framebuffer.Pix[offset : offset+4 : offset+4]
+			pix[0], pix[1], pix[2], pix[3] = colour.R, colour.G, colour.B, colour.A
+		}
+	}
+}
+
+func (ula *VideoULA) character(signals CRTCSignals) {
+	if signals.HSync && !ula.hSync {
+		ula.x = 0
+		ula.line++
+	}
+	ula.hSync = signals.HSync
+	if signals.VSync && !ula.vSync {
+		ula.line = 0
+		ula.endFrame()
+	}
+	ula.vSync = signals.VSync
+
+	width := ula.characterWidth()
+	if ula.IsTeletext() {
+		ula.teletextPixels(signals, width)
+	} else {
+		ula.bitmapPixels(signals, width)
+	}
+	ula.applyCursor(signals, width)
+	ula.draw(width)
+	ula.x += width
+}
+
+func (ula *VideoULA) endFrame() {
+	ula.frames++
+	ula.teletext.VSync()
+	for _, observer := range ula.frameObservers {
+		observer(ula.framebuffer)
+	}
+}
+
+// The framebuffer is drawn into as the beam moves, it holds a complete
+// picture right after vsync.
+func (ula *VideoULA) GetFramebuffer() *image.RGBA {
+	return ula.framebuffer
+}
+
+func (ula *VideoULA) GetTeletext() *SAA5050 {
+	return ula.teletext
+}
+
+func (ula *VideoULA) GetFrameCount() uint64 {
+	return ula.frames
+}
+
+// Observers are called at each vsync with the completed frame.
+func (ula *VideoULA) OnFrame(observer FrameFn) {
+	ula.frameObservers = append(ula.frameObservers, observer)
+}
+
+// Observers are called on reads of the ULA addresses.
+func (ula *VideoULA) OnRead(observer ReadFn) {
+	ula.readObservers = append(ula.readObservers, observer)
+}
+
+// The ULA reads the screen from memory without going through the bus.
+func NewVideoULA(name string, segment *utils.Segment, crtc *CRTC, latch *AddressableLatch, memory ReadableComponent) *VideoULA {
+	framebuffer := image.NewRGBA(image.Rect(0, 0, ScreenWidth, ScreenHeight))
+	for i := 3; i < len(framebuffer.Pix); i += 4 {
+		framebuffer.Pix[i] = 0xFF
+	}
+	ula := &VideoULA{
+		name:           name,
+		segment:        segment,
+		crtc:           crtc,
+		latch:          latch,
+		memory:         memory,
+		teletext:       NewSAA5050(),
+		framebuffer:    framebuffer,
+		cursor:         -1,
+		frameObservers: []FrameFn{},
+	}
+	crtc.Observe(ula.character)
+	return ula
+}
@@ -0,0 +1,865 @@
+package hardware
+
+import (
+	"bbc/disc"
+	"bbc/utils"
+)
+
+// Western Digital WD1770 floppy disc controller, as on the B+, the Master and
+// the double density disc upgrades. The boards decode FE80-FE87: four
+// controller registers and a write only control register selecting the
+// drive, the side and the density, laid out differently on each board. Data
+// requests and interrupts are both wired to the NMI.
+
+type WD1770Board uint8
+
+const (
+	// control register at FE80, controller at FE84
+	WD1770Acorn WD1770Board = iota
+	// controller at FE80, control register at FE84
+	WD1770Opus
+	// control register at FE80, controller at FE84
+	WD1770Watford
+)
+
+// status register, some bits read differently after type I commands
+const (
+	WD1770Busy           = 0x01
+	WD1770DataRequest    = 0x02
+	WD1770Index          = 0x02
+	WD1770LostData       = 0x04
+	WD1770Track0         = 0x04
+	WD1770CRCError       = 0x08
+	WD1770RecordNotFound = 0x10
+	WD1770SeekError      = 0x10
+	WD1770RecordType     = 0x20
+	WD1770SpinUp         = 0x20
+	WD1770WriteProtect   = 0x40
+	WD1770MotorOn        = 0x80
+)
+
+// command flags
+const (
+	wd1770Verify      = 0x04
+	wd1770Settle      = 0x04
+	wd1770NoSpinUp    = 0x08
+	wd1770UpdateTrack = 0x10
+	wd1770Multiple    = 0x10
+	wd1770DeletedMark = 0x01
+	// force interrupt conditions
+	wd1770InterruptIndex = 0x04
+	wd1770InterruptNow   = 0x08
+)
+
+const (
+	// double density, 250kbit/s
+	doubleDensityByteTime = 32
+	// bytes in a revolution
+	singleDensityTrackSize = DiscRevolution / singleDensityByteTime
+	doubleDensityTrackSize = DiscRevolution / doubleDensityByteTime
+	// microseconds
+	wd1770SettleTime = 30000
+	// index pulses counted
+	wd1770SpinUpRevolutions = 6
+	wd1770SearchRevolutions = 5
+	wd1770MotorRevolutions  = 9
+)
+
+// step rates of the 1770, in microseconds
+var wd1770StepRates = [4]uint64{6000, 12000, 20000, 30000}
+
+type WD1770 struct {
+	name    string
+	segment *utils.Segment
+	bus     *Bus
+	board   WD1770Board
+	drives  [2]*DiscDrive
+
+	// control register
+	selected      int
+	side          int
+	doubleDensity bool
+
+	status      byte
+	track       byte
+	sector      byte
+	data        byte
+	command     byte
+	typeI       bool
+	interrupt   bool
+	dataRequest bool
+	stepIn      bool
+	motorOn     bool
+	// disc written by the command, flushed as it ends
+	modified *disc.Disc
+
+	// 1MHz ticks count the microseconds of the disc rotation
+	domain *ClockDomain
+	// next step of the command running, and the motor turning off
+	event      *Event
+	next       func()
+	motorEvent *Event
+}
+
+func (wd *WD1770) GetName() string            { return wd.name }
+func (wd *WD1770) IsWritable() bool           { return true }
+func (wd *WD1770) IsReadable() bool           { return true }
+func (wd *WD1770) GetSegment() *utils.Segment { return wd.segment }
+func (wd *WD1770) GetBusDomain() string       { return DomainPeripheral }
+
+func (wd *WD1770) PlugToBus(bus *Bus) {
+	wd.bus = bus
+	wd.domain = bus.GetDomain(DomainPeripheral)
+	wd.event = bus.NewEvent(wd.name, func(uint64) error {
+		next := wd.next
+		wd.next = nil
+		if next != nil {
+			next()
+		}
+		return nil
+	})
+	wd.motorEvent = bus.NewEvent(wd.name+" motor", func(uint64) error {
+		if wd.status&WD1770Busy == 0 {
+			wd.motorOn = false
+		}
+		return nil
+	})
+}
+
+func (wd *WD1770) Start() error {
+	return nil
+}
+
+func (wd *WD1770) Reset() error {
+	wd.selected = -1
+	wd.side = 0
+	wd.doubleDensity = false
+	wd.resetController()
+	return nil
+}
+
+// Master reset of the controller alone, the control register is kept.
+func (wd *WD1770) resetController() {
+	wd.status = 0
+	wd.track = 0
+	wd.sector = 1
+	wd.data = 0
+	wd.typeI = true
+	wd.interrupt = false
+	wd.dataRequest = false
+	wd.motorOn = false
+	wd.cancel()
+	if wd.motorEvent != nil {
+		wd.bus.Cancel(wd.motorEvent)
+	}
+	wd.updateNMI()
+}
+
+func (wd *WD1770) Stop() error {
+	if err := wd.flush(); err != nil {
+		return err
+	}
+	for _, drive := range wd.drives {
+		if drive != nil {
+			if err := drive.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (wd *WD1770) GetBoard() WD1770Board {
+	return wd.board
+}
+
+func (wd *WD1770) GetDrive(index int) *DiscDrive {
+	return wd.drives[index]
+}
+
+// Status register as read, without clearing the interrupt.
+func (wd *WD1770) GetStatus() byte {
+	status := wd.status
+	if wd.typeI {
+		status &^= WD1770Index | WD1770Track0 | WD1770WriteProtect
+		if drive := wd.drive(); drive != nil {
+			if drive.IsIndex(wd.now()) {
+				status |= WD1770Index
+			}
+			if drive.IsTrack0() {
+				status |= WD1770Track0
+			}
+			if drive.IsWriteProtected() {
+				status |= WD1770WriteProtect
+			}
+		}
+	} else if wd.dataRequest {
+		status |= WD1770DataRequest
+	}
+	if wd.motorOn {
+		status |= WD1770MotorOn
+	}
+	return status
+}
+
+func (wd *WD1770) GetTrack() byte {
+	return wd.track
+}
+
+func (wd *WD1770) GetSector() byte {
+	return wd.sector
+}
+
+func (wd *WD1770) updateNMI() {
+	if wd.bus != nil {
+		wd.bus.NMI.Set(wd.name, wd.interrupt || wd.dataRequest)
+	}
+}
+
+func (wd *WD1770) setInterrupt(level bool) {
+	wd.interrupt = level
+	wd.updateNMI()
+}
+
+// Microseconds since the bus was reset.
+func (wd *WD1770) now() uint64 {
+	if wd.domain == nil {
+		return 0
+	}
+	return wd.domain.GetCycles()
+}
+
+// Runs the next step of the command after some microseconds, at least one.
+func (wd *WD1770) after(microseconds uint64, next func()) {
+	if microseconds == 0 {
+		microseconds = 1
+	}
+	wd.next = next
+	wd.bus.ScheduleIn(wd.event, wd.domain.CyclesUntil(microseconds))
+}
+
+func (wd *WD1770) cancel() {
+	wd.next = nil
+	if wd.event != nil {
+		wd.bus.Cancel(wd.event)
+	}
+}
+
+// The motor turns off after some revolutions without a command.
+func (wd *WD1770) motorTimeout() {
+	wd.bus.ScheduleIn(wd.motorEvent, wd.domain.CyclesUntil(wd1770MotorRevolutions*DiscRevolution))
+}
+
+func (wd *WD1770) drive() *DiscDrive {
+	if wd.selected < 0 {
+		return nil
+	}
+	return wd.drives[wd.selected]
+}
+
+// Disc in the selected drive, nil when none.
+func (wd *WD1770) selectedDisc() *disc.Disc {
+	if drive := wd.drive(); drive != nil {
+		return drive.GetDisc()
+	}
+	return nil
+}
+
+func (wd *WD1770) writeControl(value byte) {
+	wd.selected = -1
+	switch wd.board {
+	case WD1770Acorn:
+		if value&0x01 != 0 {
+			wd.selected = 0
+		} else if value&0x02 != 0 {
+			wd.selected = 1
+		}
+		wd.side = int(value>>2) & 1
+		wd.doubleDensity = value&0x08 == 0
+		// reset is active low
+		if value&0x20 == 0 {
+			wd.resetController()
+		}
+	case WD1770Opus:
+		wd.selected = int(value>>1) & 1
+		wd.side = int(value) & 1
+		wd.doubleDensity = value&0x40 != 0
+	case WD1770Watford:
+		wd.selected = int(value>>2) & 1
+		wd.side = int(value>>1) & 1
+		wd.doubleDensity = value&0x01 == 0
+	}
+}
+
+func (wd *WD1770) byteTime() uint64 {
+	if wd.doubleDensity {
+		return doubleDensityByteTime
+	}
+	return singleDensityByteTime
+}
+
+func (wd *WD1770) writeCommand(value byte) {
+	if value&0xF0 == 0xD0 {
+		wd.forceInterrupt(value)
+		return
+	}
+	if wd.status&WD1770Busy != 0 {
+		return
+	}
+	wd.command = value
+	wd.typeI = value&0x80 == 0
+	wd.status = WD1770Busy
+	wd.dataRequest = false
+	wd.setInterrupt(false)
+
+	// the motor spins up over six index pulses unless it is already on
+	spinning := wd.motorOn || value&wd1770NoSpinUp != 0
+	wd.motorOn = true
+	wait := uint64(1)
+	if !spinning {
+		wait = wd1770SpinUpRevolutions * DiscRevolution
+	}
+	wd.after(wait, func() {
+		if wd.typeI {
+			wd.status |= WD1770SpinUp
+		}
+		wd.execute()
+	})
+}
+
+func (wd *WD1770) execute() {
+	command := wd.command
+	switch {
+	case command&0x80 == 0:
+		wd.seek()
+	case command&0xE0 == 0x80:
+		wd.settle(wd.readSector)
+	case command&0xE0 == 0xA0:
+		if wd.isWriteProtected() {
+			return
+		}
+		wd.settle(wd.writeSector)
+	case command&0xF0 == 0xC0:
+		wd.settle(wd.readAddress)
+	case command&0xF0 == 0xE0:
+		wd.settle(wd.readTrack)
+	default:
+		if wd.isWriteProtected() {
+			return
+		}
+		wd.settle(wd.writeTrack)
+	}
+}
+
+// Ends the command with an interrupt.
+func (wd *WD1770) finish(status byte) {
+	wd.cancel()
+	if wd.flush() != nil {
+		status |= WD1770LostData
+	}
+	wd.status = wd.status&^WD1770Busy | status
+	wd.dataRequest = false
+	wd.motorTimeout()
+	wd.setInterrupt(true)
+}
+
+// The disc is written back even when its drive was deselected or it was
+// ejected since. The controller has no write fault status, a disc that could
+// not be written back to its image is reported as lost data and written
+// again at the end of the next command or when stopped.
+func (wd *WD1770) flush() error {
+	if wd.modified == nil {
+		return nil
+	}
+	if err := wd.modified.Flush(); err != nil {
+		return err
+	}
+	wd.modified = nil
+	return nil
+}
+
+func (wd *WD1770) forceInterrupt(value byte) {
+	if wd.status&WD1770Busy != 0 {
+		wd.status &^= WD1770Busy
+		wd.cancel()
+		if wd.flush() != nil {
+			wd.status |= WD1770LostData
+		}
+	} else {
+		wd.status &= WD1770SpinUp
+		wd.typeI = true
+	}
+	wd.dataRequest = false
+	wd.motorTimeout()
+	wd.setInterrupt(value&wd1770InterruptNow != 0)
+	if value&wd1770InterruptIndex != 0 {
+		if drive := wd.drive(); drive != nil && drive.IsReady() {
+			wd.after(drive.UntilIndex(wd.now()), func() { wd.setInterrupt(true) })
+		}
+	}
+}
+
+func (wd *WD1770) isWriteProtected() bool {
+	drive := wd.drive()
+	if drive == nil || drive.IsWriteProtected() {
+		wd.finish(WD1770WriteProtect)
+		return true
+	}
+	return false
+}
+
+// Type II and III commands may wait for the head to settle.
+func (wd *WD1770) settle(then func()) {
+	if wd.command&wd1770Settle != 0 {
+		wd.after(wd1770SettleTime, then)
+		return
+	}
+	then()
+}
+
+func (wd *WD1770) stepHead(in bool) {
+	if drive := wd.drive(); drive != nil {
+		drive.Step(in)
+	}
+}
+
+// Type I commands: restore, seek and the steps.
+func (wd *WD1770) seek() {
+	command := wd.command
+	drive := wd.drive()
+	steps := 0
+	switch command & 0xF0 {
+	case 0x00:
+		for ; steps < 255 && (drive == nil || !drive.IsTrack0()); steps++ {
+			wd.stepHead(false)
+		}
+		wd.track = 0
+		if drive == nil || !drive.IsTrack0() {
+			wd.status |= WD1770SeekError
+		}
+	case 0x10:
+		for ; wd.track != wd.data; steps++ {
+			wd.stepIn = wd.data > wd.track
+			wd.stepHead(wd.stepIn)
+			if wd.stepIn {
+				wd.track++
+			} else {
+				wd.track--
+			}
+		}
+	default:
+		if command&0x40 != 0 {
+			wd.stepIn = command&0x20 == 0
+		}
+		wd.stepHead(wd.stepIn)
+		if command&wd1770UpdateTrack != 0 {
+			if wd.stepIn {
+				wd.track++
+			} else {
+				wd.track--
+			}
+		}
+		steps = 1
+	}
+	wait := uint64(steps)*wd1770StepRates[command&0x03] + 1
+	if command&wd1770Verify == 0 || wd.status&WD1770SeekError != 0 {
+		wd.after(wait, func() { wd.finish(0) })
+		return
+	}
+	wd.after(wait+wd1770SettleTime, func() {
+		_, wait, ok := wd.findSector(func(sector *disc.Sector) bool { return sector.Track == wd.track })
+		wd.after(wait, func() {
+			if !ok {
+				wd.finish(WD1770SeekError)
+				return
+			}
+			wd.finish(0)
+		})
+	})
+}
+
+// Next ID field matching under the head, in the density selected.
+func (wd *WD1770) findSector(match func(*disc.Sector) bool) (*disc.Sector, uint64, bool) {
+	drive := wd.drive()
+	if drive == nil {
+		return nil, wd1770SearchRevolutions * DiscRevolution, false
+	}
+	if track := drive.GetTrack(wd.side); track != nil && track.DoubleDensity != wd.doubleDensity {
+		return nil, wd1770SearchRevolutions * DiscRevolution, false
+	}
+	return drive.FindSector(wd.side, wd.now(), wd1770SearchRevolutions, match)
+}
+
+// Matching ID fields with a CRC error are passed over, the error shows when
+// no other is found.
+func (wd *WD1770) searchSector(then func(*disc.Sector)) {
+	badID := false
+	found, wait, ok := wd.findSector(func(sector *disc.Sector) bool {
+		matches := sector.Track == wd.track && sector.Number == wd.sector
+		badID = badID || matches && sector.IDCRCError
+		return matches && !sector.IDCRCError
+	})
+	wd.after(wait, func() {
+		if !ok {
+			if badID {
+				wd.finish(WD1770RecordNotFound | WD1770CRCError)
+				return
+			}
+			wd.finish(WD1770RecordNotFound)
+			return
+		}
+		then(found)
+	})
+}
+
+func sectorLength(sector *disc.Sector) int {
+	return 128 << (sector.SizeCode & 0x03)
+}
+
+// Multiple sector reads go on until no next sector is found.
+func (wd *WD1770) readSector() {
+	wd.searchSector(func(sector *disc.Sector) {
+		if sector.Deleted {
+			wd.status |= WD1770RecordType
+		}
+		data := make([]byte, sectorLength(sector))
+		copy(data, sector.Data)
+		wd.stream(data, func() {
+			// the ID field gives the length, the CRC is where the data ends
+			if sector.DataCRCError || len(sector.Data) != len(data) {
+				wd.finish(WD1770CRCError)
+				return
+			}
+			if wd.command&wd1770Multiple == 0 {
+				wd.finish(0)
+				return
+			}
+			wd.sector++
+			wd.readSector()
+		})
+	})
+}
+
+// The disc must still be under the head once the data is collected.
+func (wd *WD1770) writeSector() {
+	image := wd.selectedDisc()
+	wd.searchSector(func(sector *disc.Sector) {
+		wd.collect(sectorLength(sector), func(data []byte) {
+			if image == nil || wd.selectedDisc() != image {
+				wd.finish(WD1770RecordNotFound)
+				return
+			}
+			sector.Data = data
+			sector.Deleted = wd.command&wd1770DeletedMark != 0
+			sector.DataCRCError = false
+			image.MarkModified()
+			wd.modified = image
+			if wd.command&wd1770Multiple == 0 {
+				wd.finish(0)
+				return
+			}
+			wd.sector++
+			wd.writeSector()
+		})
+	})
+}
+
+// Track, side, sector, size and CRC of the next ID field, the track goes to
+// the sector register.
+func (wd *WD1770) readAddress() {
+	found, wait, ok := wd.findSector(func(*disc.Sector) bool { return true })
+	wd.after(wait, func() {
+		if !ok {
+			wd.finish(WD1770RecordNotFound)
+			return
+		}
+		crc := idCRC(found, wd.doubleDensity)
+		wd.stream([]byte{found.Track, found.Head, found.Number, found.SizeCode, byte(crc >> 8), byte(crc)}, func() {
+			wd.sector = found.Track
+			if found.IDCRCError {
+				wd.finish(WD1770CRCError)
+				return
+			}
+			wd.finish(0)
+		})
+	})
+}
+
+func (wd *WD1770) readTrack() {
+	drive := wd.drive()
+	if drive == nil || !drive.IsReady() {
+		// no index pulse ever comes
+		return
+	}
+	wd.after(drive.UntilIndex(wd.now()), func() {
+		wd.stream(encodeTrack(drive.GetTrack(wd.side), wd.doubleDensity), func() { wd.finish(0) })
+	})
+}
+
+// Formats the track from index to index, the CPU writes its raw bytes.
+func (wd *WD1770) writeTrack() {
+	drive := wd.drive()
+	if drive == nil || !drive.IsReady() {
+		return
+	}
+	image := drive.GetDisc()
+	size := singleDensityTrackSize
+	if wd.doubleDensity {
+		size = doubleDensityTrackSize
+	}
+	wd.dataRequest = true
+	wd.updateNMI()
+	wd.after(drive.UntilIndex(wd.now()), func() {
+		if wd.dataRequest {
+			wd.finish(WD1770LostData)
+			return
+		}
+		// the first byte is taken at the index
+		first := wd.data
+		wd.collect(size-1, func(raw []byte) {
+			track := decodeTrack(append([]byte{first}, raw...), wd.doubleDensity)
+			if wd.selectedDisc() != image {
+				wd.finish(WD1770RecordNotFound)
+				return
+			}
+			if err := image.SetTrack(wd.side, drive.GetHead(), track); err != nil {
+				wd.finish(WD1770RecordNotFound)
+				return
+			}
+			wd.modified = image
+			wd.finish(0)
+		})
+	})
+}
+
+// Hands bytes to the CPU at the disc rate, a byte not taken in time is
+// overwritten by the next.
+func (wd *WD1770) stream(data []byte, done func()) {
+	position := 0
+	var next func()
+	next = func() {
+		if position == len(data) {
+			done()
+			return
+		}
+		if wd.dataRequest {
+			wd.status |= WD1770LostData
+		}
+		wd.data = data[position]
+		position++
+		wd.dataRequest = true
+		wd.updateNMI()
+		wd.after(wd.byteTime(), next)
+	}
+	next()
+}
+
+// Takes bytes from the CPU at the disc rate. The command ends when the first
+// byte is late, zeros are written for the later ones.
+func (wd *WD1770) collect(length int, done func([]byte)) {
+	data := make([]byte, 0, length)
+	var next func()
+	next = func() {
+		if wd.dataRequest {
+			if len(data) == 0 {
+				wd.finish(WD1770LostData)
+				return
+			}
+			wd.status |= WD1770LostData
+			wd.data = 0
+		}
+		data = append(data, wd.data)
+		if len(data) == length {
+			done(data)
+			return
+		}
+		wd.dataRequest = true
+		wd.updateNMI()
+		wd.after(wd.byteTime(), next)
+	}
+	wd.dataRequest = true
+	wd.updateNMI()
+	wd.after(2*wd.byteTime(), next)
+}
+
+// CRC-CCITT of the disc fields, preset to FFFF.
+func crc16(crc uint16, data ...byte) uint16 {
+	for _, value := range data {
+		crc ^= uint16(value) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func idCRC(sector *disc.Sector, doubleDensity bool) uint16 {
+	crc := uint16(0xFFFF)
+	if doubleDensity {
+		crc = crc16(crc, 0xA1, 0xA1, 0xA1)
+	}
+	crc = crc16(crc, 0xFE, sector.Track, sector.Head, sector.Number, sector.SizeCode)
+	if sector.IDCRCError {
+		crc = ^crc
+	}
+	return crc
+}
+
+func dataCRC(sector *disc.Sector, mark byte, data []byte, doubleDensity bool) uint16 {
+	crc := uint16(0xFFFF)
+	if doubleDensity {
+		crc = crc16(crc, 0xA1, 0xA1, 0xA1)
+	}
+	crc = crc16(crc16(crc, mark), data...)
+	if sector.DataCRCError {
+		crc = ^crc
+	}
+	return crc
+}
+
+func repeatByte(raw []byte, value byte, count int) []byte {
+	for i := 0; i < count; i++ {
+		raw = append(raw, value)
+	}
+	return raw
+}
+
+// Bytes of a track as read track returns them, in the IBM layout.
+func encodeTrack(track *disc.Track, doubleDensity bool) []byte {
+	size, gap, sync, marks := singleDensityTrackSize, byte(0xFF), 6, 0
+	if doubleDensity {
+		size, gap, sync, marks = doubleDensityTrackSize, 0x4E, 12, 3
+	}
+	raw := repeatByte(nil, gap, 16+marks*14)
+	if track != nil && track.DoubleDensity == doubleDensity {
+		for _, sector := range track.Sectors {
+			raw = repeatByte(raw, 0x00, sync)
+			raw = repeatByte(raw, 0xA1, marks)
+			crc := idCRC(sector, doubleDensity)
+			raw = append(raw, 0xFE, sector.Track, sector.Head, sector.Number, sector.SizeCode, byte(crc>>8), byte(crc))
+			raw = repeatByte(raw, gap, 11+marks*4)
+			raw = repeatByte(raw, 0x00, sync)
+			raw = repeatByte(raw, 0xA1, marks)
+			mark := byte(0xFB)
+			if sector.Deleted {
+				mark = 0xF8
+			}
+			data := make([]byte, sectorLength(sector))
+			copy(data, sector.Data)
+			crc = dataCRC(sector, mark, data, doubleDensity)
+			raw = append(append(append(raw, mark), data...), byte(crc>>8), byte(crc))
+			raw = repeatByte(raw, gap, 27)
+		}
+	}
+	if len(raw) < size {
+		raw = repeatByte(raw, gap, size-len(raw))
+	}
+	return raw[:size]
+}
+
+// Sectors of a track from the bytes written by write track: an ID address
+// mark FE and its four bytes, then a data mark FB or F8 and the data.
+func decodeTrack(raw []byte, doubleDensity bool) *disc.Track {
+	track := &disc.Track{DoubleDensity: doubleDensity}
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != 0xFE || i+4 >= len(raw) {
+			continue
+		}
+		sector := &disc.Sector{Track: raw[i+1], Head: raw[i+2], Number: raw[i+3], SizeCode: raw[i+4]}
+		i += 5
+		for ; i < len(raw) && raw[i] != 0xFB && raw[i] != 0xF8; i++ {
+		}
+		if i == len(raw) {
+			break
+		}
+		sector.Deleted = raw[i] == 0xF8
+		length := sectorLength(sector)
+		sector.Data = make([]byte, length)
+		i += copy(sector.Data, raw[i+1:])
+		track.Sectors = append(track.Sectors, sector)
+	}
+	return track
+}
+
+func (wd *WD1770) isControl(addr uint16) bool {
+	return (addr&0x04 != 0) == (wd.board == WD1770Opus)
+}
+
+func (wd *WD1770) read(addr uint16) byte {
+	if wd.isControl(addr) {
+		return 0xFF
+	}
+	switch addr & 0x03 {
+	case 0:
+		status := wd.GetStatus()
+		wd.setInterrupt(false)
+		return status
+	case 1:
+		return wd.track
+	case 2:
+		return wd.sector
+	}
+	wd.dataRequest = false
+	wd.updateNMI()
+	return wd.data
+}
+
+func (wd *WD1770) write(value byte, addr uint16) {
+	if wd.isControl(addr) {
+		wd.writeControl(value)
+		return
+	}
+	switch addr & 0x03 {
+	case 0:
+		wd.writeCommand(value)
+	case 1:
+		wd.track = value
+	case 2:
+		wd.sector = value
+	case 3:
+		wd.data = value
+		wd.dataRequest = false
+		wd.updateNMI()
+	}
+}
+
+func (wd *WD1770) DirectRead(addr uint16) (byte, error) {
+	return wd.read(addr), nil
+}
+
+func (wd *WD1770) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	addr := base + uint16(offset)
+	value, err := wd.DirectRead(addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, addr, nil
+}
+
+func (wd *WD1770) DirectWrite(value byte, addr uint16) error {
+	wd.write(value, addr)
+	return nil
+}
+
+func (wd *WD1770) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	addr := base + uint16(offset)
+	if err := wd.DirectWrite(value, addr); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+func NewWD1770(name string, segment *utils.Segment, board WD1770Board, drive0, drive1 *DiscDrive) *WD1770 {
+	wd := &WD1770{
+		name:    name,
+		segment: segment,
+		board:   board,
+		drives:  [2]*DiscDrive{drive0, drive1},
+	}
+	wd.Reset()
+	return wd
+}
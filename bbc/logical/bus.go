@@ -3,8 +3,12 @@ package logical
 import "bbc/utils"
 
 const (
-	IRQVectorAddr0 uint16 = 0xFFFE
-	IRQVectorAddr1 uint16 = 0xFFFF
+	NMIVectorAddr0   uint16 = 0xFFFA
+	NMIVectorAddr1   uint16 = 0xFFFB
+	ResetVectorAddr0 uint16 = 0xFFFC
+	ResetVectorAddr1 uint16 = 0xFFFD
+	IRQVectorAddr0   uint16 = 0xFFFE
+	IRQVectorAddr1   uint16 = 0xFFFF
 )
 
 var (
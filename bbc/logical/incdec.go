@@ -18,9 +18,10 @@ func incdecRegister(register Register, increment bool) ExecFn {
 var inc = InstructionDescription{
 	Name: "INC",
 	SubExec: OperationRMWFn(func(value byte, cpu LogicalCPU) (byte, error) {
+		value += 1
 		cpu.SetStatus(value&0x80 != 0, NegativeFlagBit)
 		cpu.SetStatus(value == 0, ZeroFlagBit)
-		return value + 1, nil
+		return value, nil
 	}),
 	Access: ReadModifyWrite,
 	OpcodeMapping: map[Opcode]AddressingMode{
@@ -52,9 +53,10 @@ var iny = InstructionDescription{
 var dec = InstructionDescription{
 	Name: "DEC",
 	SubExec: OperationRMWFn(func(value byte, cpu LogicalCPU) (byte, error) {
+		value -= 1
 		cpu.SetStatus(value&0x80 != 0, NegativeFlagBit)
 		cpu.SetStatus(value == 0, ZeroFlagBit)
-		return value - 1, nil
+		return value, nil
 	}),
 	Access: ReadModifyWrite,
 	OpcodeMapping: map[Opcode]AddressingMode{
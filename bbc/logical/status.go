@@ -75,7 +75,7 @@ var sed = InstructionDescription{
 var sei = InstructionDescription{
 	Name: "SEI",
 	SubExec: ExecFn(func(cpu LogicalCPU) error {
-		cpu.SetStatus(true, CarryFlagBit)
+		cpu.SetStatus(true, InterruptDisableFlagBit)
 		return nil
 	}),
 	Access: ImpliedAccess,
@@ -2,36 +2,48 @@ package logical
 
 import "bbc/utils"
 
+// 5 cycles
+// pushes PC and status, then jumps through the vector with interrupts disabled
+func Interrupt(cpu LogicalCPU, vectorAddr0, vectorAddr1 uint16, brk bool) error {
+	bus := cpu.GetBus()
+	if err := cpu.Push(cpu.GetRegister(RegisterPCH)); err != nil {
+		return err
+	}
+	if err := cpu.Push(cpu.GetRegister(RegisterPCL)); err != nil {
+		return err
+	}
+	status := cpu.GetRegister(RegisterStatus) | 1<<UnusedFlagBit
+	if brk {
+		status |= 1 << BreakFlagBit
+	} else {
+		status &^= 1 << BreakFlagBit
+	}
+	if err := cpu.Push(status); err != nil {
+		return err
+	}
+	cpu.SetStatus(true, InterruptDisableFlagBit)
+	pcl, err := bus.DirectRead(vectorAddr0)
+	if err != nil {
+		return err
+	}
+	pch, err := bus.DirectRead(vectorAddr1)
+	if err != nil {
+		return err
+	}
+	cpu.SetRegister(pcl, RegisterPCL)
+	cpu.SetRegister(pch, RegisterPCH)
+	return nil
+}
+
 var brk = InstructionDescription{
 	Name: "BRK",
 	SubExec: ExecFn(func(cpu LogicalCPU) error {
-		bus := cpu.GetBus()
-		pcl := cpu.GetRegister(RegisterPCL)
-		if err := cpu.Push(pcl); err != nil {
-			return err
-		}
-		pch := cpu.GetRegister(RegisterPCH)
-		if err := cpu.Push(pch); err != nil {
-			return err
-		}
-		status := cpu.GetRegister(RegisterStatus)
-		if err := cpu.Push(status); err != nil {
-			return err
-		}
-		cpu.SetStatus(true, BreakFlagBit)
-		pcl, err := bus.DirectRead(IRQVectorAddr0)
-		if err != nil {
-			return err
-		}
-		pch, err = bus.DirectRead(IRQVectorAddr1)
-		if err != nil {
-			return err
-		}
-		nextPC := utils.AddressFromNibbles(pch, pcl)
-		nextPCH, nextPCL := utils.AddressToNibbles(nextPC)
+		// the byte following BRK is skipped
+		pc := utils.AddressFromNibbles(cpu.GetRegister(RegisterPCH), cpu.GetRegister(RegisterPCL)) + 1
+		nextPCH, nextPCL := utils.AddressToNibbles(pc)
 		cpu.SetRegister(nextPCL, RegisterPCL)
 		cpu.SetRegister(nextPCH, RegisterPCH)
-		return nil
+		return Interrupt(cpu, IRQVectorAddr0, IRQVectorAddr1, true)
 	}),
 	Access: ImpliedAccess,
 	OpcodeMapping: map[Opcode]AddressingMode{
@@ -0,0 +1,277 @@
+package machine
+
+import (
+	"bbc/hardware"
+	"bbc/utils"
+	"fmt"
+	"image"
+)
+
+const (
+	// BBC micro run at 2MHz
+	CPUFrequency = 2e6
+	MOSSize      = 0x4000
+)
+
+// disc interfaces at FE80
+const (
+	Disc8271        = "8271"
+	DiscAcorn1770   = "acorn1770"
+	DiscOpus1770    = "opus1770"
+	DiscWatford1770 = "watford1770"
+)
+
+var wd1770Boards = map[string]hardware.WD1770Board{
+	DiscAcorn1770:   hardware.WD1770Acorn,
+	DiscOpus1770:    hardware.WD1770Opus,
+	DiscWatford1770: hardware.WD1770Watford,
+}
+
+type Config struct {
+	// 16K operating system image, mapped at C000 around the I/O pages
+	MOS []byte
+	// sideways ROM images by slot
+	ROMs map[int][]byte
+	// runs in virtual time, as fast as possible
+	Virtual bool
+	// rate of the sound PCM output, hardware.DefaultSampleRate when 0
+	SampleRate int
+	// 40 or 80 track disc drives, 80 when 0
+	DriveTracks int
+	// disc controller board, Disc8271 when empty
+	DiscInterface string
+	// Econet station number set by the links
+	EconetStation byte
+	// client ROM of a 6502 second processor, fitted over the Tube when set
+	TubeROM []byte
+}
+
+// BBC Model B: the components wired on a bus, and the API to run it.
+type Machine struct {
+	Clock     *hardware.Clock
+	Bus       *hardware.Bus
+	CPU       *hardware.CPU
+	RAM       *hardware.RAM
+	PagedROM  *hardware.PagedROM
+	SystemVIA *hardware.VIA
+	UserVIA   *hardware.VIA
+	Latch     *hardware.AddressableLatch
+	Keyboard  *hardware.Keyboard
+	ADC       *hardware.UPD7002
+	Printer   *hardware.Printer
+	CRTC      *hardware.CRTC
+	VideoULA  *hardware.VideoULA
+	Sound     *hardware.SN76489
+	ACIA      *hardware.ACIA
+	SerialULA *hardware.SerialULA
+	RS423     *hardware.RS423
+	Tape      *hardware.TapeDeck
+	Drives    [2]*hardware.DiscDrive
+	// the disc controller fitted, the other is nil
+	FDC    *hardware.I8271
+	WD1770 *hardware.WD1770
+	// SD card on the user port, for MMFS
+	SDCard *hardware.SDCard
+	ADLC   *hardware.ADLC
+	Econet *hardware.EconetStation
+	// Tube and second processor, nil unless fitted
+	Tube            *hardware.Tube
+	SecondProcessor *hardware.SecondProcessor
+}
+
+func New(config Config) (*Machine, error) {
+	if len(config.MOS) != MOSSize {
+		return nil, fmt.Errorf("MOS image is %d bytes long instead of %d", len(config.MOS), MOSSize)
+	}
+
+	var clock *hardware.Clock
+	if config.Virtual {
+		clock = hardware.NewVirtualClock(CPUFrequency)
+	} else {
+		clock = hardware.NewClock(CPUFrequency)
+	}
+	if err := clock.AddBBCDomains(); err != nil {
+		return nil, err
+	}
+
+	machine := &Machine{
+		Clock:     clock,
+		CPU:       hardware.NewCPU(clock),
+		RAM:       hardware.NewRAMSegment(utils.NewSegment(0x0000, 0x7FFF)),
+		PagedROM:  hardware.NewPagedROM("paged ROM"),
+		SystemVIA: hardware.NewVIA("system VIA", utils.NewSegment(0xFE40, 0xFE5F)),
+		UserVIA:   hardware.NewVIA("user VIA", utils.NewSegment(0xFE60, 0xFE7F)),
+		Latch:     hardware.NewAddressableLatch("IC32"),
+		ACIA:      hardware.NewACIA("ACIA", utils.NewSegment(0xFE08, 0xFE0F)),
+		ADLC:      hardware.NewADLC("ADLC", utils.NewSegment(0xFEA0, 0xFEBF)),
+		Tape:      hardware.NewTapeDeck(),
+	}
+	machine.RS423 = hardware.NewRS423(machine.ACIA)
+	tracks := config.DriveTracks
+	if tracks == 0 {
+		tracks = 80
+	}
+	for i := range machine.Drives {
+		machine.Drives[i] = hardware.NewDiscDrive(tracks)
+	}
+	var fdc hardware.Component
+	switch config.DiscInterface {
+	case "", Disc8271:
+		machine.FDC = hardware.NewI8271("8271", utils.NewSegment(0xFE80, 0xFE9F), machine.Drives[0], machine.Drives[1])
+		fdc = machine.FDC
+	default:
+		board, ok := wd1770Boards[config.DiscInterface]
+		if !ok {
+			return nil, fmt.Errorf("unknown disc interface %s", config.DiscInterface)
+		}
+		machine.WD1770 = hardware.NewWD1770("WD1770", utils.NewSegment(0xFE80, 0xFE9F), board, machine.Drives[0], machine.Drives[1])
+		fdc = machine.WD1770
+	}
+	for slot, image := range config.ROMs {
+		if err := machine.PagedROM.LoadROM(slot, image); err != nil {
+			return nil, err
+		}
+	}
+
+	// FC00-FEFF are the I/O pages
+	mos, err := hardware.NewROM("MOS", utils.NewSegment(0xC000, 0xFBFF), config.MOS[:0x3C00])
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := hardware.NewROM("MOS vectors", utils.NewSegment(0xFF00, 0xFFFF), config.MOS[0x3F00:])
+	if err != nil {
+		return nil, err
+	}
+
+	machine.SystemVIA.ConnectPortB(machine.Latch)
+	machine.SDCard = hardware.NewSDCard(machine.UserVIA)
+	machine.Printer = hardware.NewPrinter("printer", machine.UserVIA)
+	machine.Keyboard = hardware.NewKeyboard("keyboard", machine.SystemVIA, machine.Latch)
+	machine.ADC = hardware.NewUPD7002("uPD7002", utils.NewSegment(0xFEC0, 0xFEDF), machine.SystemVIA)
+	machine.CRTC = hardware.NewCRTC("CRTC", utils.NewSegment(0xFE00, 0xFE07), machine.SystemVIA)
+	machine.VideoULA = hardware.NewVideoULA("video ULA", utils.NewSegment(0xFE20, 0xFE2F),
+		machine.CRTC, machine.Latch, machine.RAM)
+	machine.VideoULA.OnRead(func() { machine.ADLC.EnableNMI(true) })
+	machine.Econet = hardware.NewEconetStation("Econet links", utils.NewSegment(0xFE18, 0xFE1F),
+		machine.ADLC, config.EconetStation)
+	machine.SerialULA = hardware.NewSerialULA("serial ULA", utils.NewSegment(0xFE10, 0xFE17),
+		machine.ACIA, machine.Tape, machine.RS423)
+	machine.Sound = hardware.NewSN76489("SN76489", machine.SystemVIA, machine.Latch)
+	if config.SampleRate != 0 {
+		if err := machine.Sound.SetSampleRate(config.SampleRate); err != nil {
+			return nil, err
+		}
+	}
+
+	components := []hardware.Component{
+		machine.CPU,
+		machine.RAM,
+		mos,
+		vectors,
+		machine.PagedROM,
+		hardware.NewROMSelect("ROMSEL", utils.NewSegment(0xFE30, 0xFE3F), machine.PagedROM),
+		machine.SystemVIA,
+		machine.UserVIA,
+		machine.Latch,
+		machine.Keyboard,
+		machine.ADC,
+		machine.Printer,
+		machine.CRTC,
+		machine.VideoULA,
+		machine.Sound,
+		machine.ACIA,
+		machine.SerialULA,
+		machine.Econet,
+		machine.ADLC,
+		fdc,
+	}
+	if config.TubeROM != nil {
+		machine.Tube = hardware.NewTube("Tube", utils.NewSegment(0xFEE0, 0xFEFF))
+		machine.SecondProcessor, err = hardware.NewSecondProcessor("second processor", machine.Tube, config.TubeROM)
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, machine.Tube, machine.SecondProcessor)
+	}
+	machine.Bus, err = hardware.NewBus(clock, components...)
+	if err != nil {
+		return nil, err
+	}
+	machine.Bus.SetOpenBus(true)
+	machine.Reset()
+	return machine, nil
+}
+
+// Power on reset, the CPU fetches the reset vector before its next
+// instruction.
+func (machine *Machine) Reset() {
+	machine.Bus.Reset()
+	machine.CPU.RequestReset()
+}
+
+// Runs at least the given number of CPU cycles.
+func (machine *Machine) RunCycles(cycles uint64) error {
+	end := machine.Clock.GetCycles() + cycles
+	for machine.Clock.GetCycles() < end {
+		if err := machine.CPU.ExecuteNext(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs until the next vsync and returns the completed frame. The image is
+// the live framebuffer, drawn over as the emulation goes on.
+func (machine *Machine) RunFrame() (*image.RGBA, error) {
+	frame := machine.VideoULA.GetFrameCount()
+	// a frame lasts 20ms, give up if the CRTC produces no vsync for a second
+	limit := machine.Clock.GetCycles() + CPUFrequency
+	for machine.VideoULA.GetFrameCount() == frame {
+		if machine.Clock.GetCycles() > limit {
+			return nil, fmt.Errorf("no vsync within a second")
+		}
+		if err := machine.CPU.ExecuteNext(); err != nil {
+			return nil, err
+		}
+	}
+	return machine.VideoULA.GetFramebuffer(), nil
+}
+
+// Runs until the next vsync and returns a copy of the frame, left untouched
+// by the emulation.
+func (machine *Machine) NextFrame() (image.Image, error) {
+	if _, err := machine.RunFrame(); err != nil {
+		return nil, err
+	}
+	return machine.Screenshot(), nil
+}
+
+// Copy of the framebuffer as it is now.
+func (machine *Machine) Screenshot() *image.RGBA {
+	frame := machine.VideoULA.GetFramebuffer()
+	screenshot := image.NewRGBA(frame.Bounds())
+	copy(screenshot.Pix, frame.Pix)
+	return screenshot
+}
+
+// Runs until the clock is stopped.
+func (machine *Machine) Run() error {
+	if err := machine.Clock.Start(); err != nil {
+		return err
+	}
+	return machine.CPU.Start()
+}
+
+// Writes the modified discs and SD card back to their image files.
+func (machine *Machine) FlushDiscs() error {
+	for _, drive := range machine.Drives {
+		if err := drive.Flush(); err != nil {
+			return err
+		}
+	}
+	return machine.SDCard.Flush()
+}
+
+func (machine *Machine) Stop() error {
+	return machine.Clock.Stop()
+}
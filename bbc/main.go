@@ -1,27 +1,380 @@
 package main
 
 import (
+	"bbc/audio"
+	"bbc/dfs"
+	"bbc/econet"
 	"bbc/hardware"
+	"bbc/machine"
+	"bbc/mmb"
+	"bbc/printer"
+	"bbc/screenshot"
+	"bbc/serial"
+	"bbc/tape"
+	"bufio"
+	"flag"
 	"fmt"
+	"image"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 )
 
+// Sideways ROMs given as slot=path, the flag may be repeated.
+type romFlags map[int]string
+
+func (roms romFlags) String() string {
+	return fmt.Sprint(map[int]string(roms))
+}
+
+func (roms romFlags) Set(value string) error {
+	slot, path, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected slot=path")
+	}
+	index, err := strconv.Atoi(slot)
+	if err != nil {
+		return err
+	}
+	roms[index] = path
+	return nil
+}
+
+func loadMachine(mos string, roms romFlags, virtual bool, driveTracks int, discInterface string, station byte, tube string) (*machine.Machine, error) {
+	config := machine.Config{
+		ROMs:          map[int][]byte{},
+		Virtual:       virtual,
+		DriveTracks:   driveTracks,
+		DiscInterface: discInterface,
+		EconetStation: station,
+	}
+	var err error
+	if config.MOS, err = os.ReadFile(mos); err != nil {
+		return nil, err
+	}
+	if tube != "" {
+		if config.TubeROM, err = os.ReadFile(tube); err != nil {
+			return nil, err
+		}
+	}
+	for slot, path := range roms {
+		if config.ROMs[slot], err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return machine.New(config)
+}
+
+func runMachine(bbc *machine.Machine, speed float64, turbo bool) error {
+	if err := bbc.Clock.SetSpeed(speed); err != nil {
+		return err
+	}
+	bbc.Clock.SetTurbo(turbo)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		bbc.Stop()
+	}()
+	return bbc.Run()
+}
+
+// Writes the sound output to a WAV file, the samples are collected at each
+// frame.
+func recordSound(bbc *machine.Machine, path string) (*audio.WAVWriter, error) {
+	writer, err := audio.CreateWAV(path, bbc.Sound.GetSampleRate())
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]int16, bbc.Sound.GetSampleRate())
+	bbc.VideoULA.OnFrame(func(frame *image.RGBA) {
+		for n := bbc.Sound.ReadSamples(samples); n > 0; n = bbc.Sound.ReadSamples(samples) {
+			writer.Write(samples[:n])
+		}
+	})
+	return writer, nil
+}
+
+// Saves the tape recorded as UEF and WAV files, the paths may be empty.
+func saveRecording(bbc *machine.Machine, uefPath, wavPath string) error {
+	uef := bbc.Tape.StopRecording()
+	if uefPath != "" {
+		if err := uef.Save(uefPath); err != nil {
+			return err
+		}
+	}
+	if wavPath != "" {
+		writer, err := audio.CreateWAV(wavPath, hardware.DefaultSampleRate)
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(tape.Render(uef, hardware.DefaultSampleRate)); err != nil {
+			writer.Close()
+			return err
+		}
+		return writer.Close()
+	}
+	return nil
+}
+
+// Cycle counts given as a comma separated list.
+func parseCycles(list string) ([]uint64, error) {
+	cycles := []uint64{}
+	for _, field := range strings.Split(list, ",") {
+		if field == "" {
+			continue
+		}
+		cycle, err := strconv.ParseUint(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		cycles = append(cycles, cycle)
+	}
+	return cycles, nil
+}
+
+func printStats(stats hardware.ClockStats) {
+	if stats.RealTimePercent == 0 {
+		// virtual clock, not measured against the wall clock
+		fmt.Printf("Simulated frequency: %.0f Hz, %.0f instructions/s\n",
+			stats.CyclesPerSecond, stats.InstructionsPerSecond)
+		return
+	}
+	fmt.Printf("Simulated frequency: %.0f Hz (%.1f%%), %.0f instructions/s\n",
+		stats.CyclesPerSecond, stats.RealTimePercent, stats.InstructionsPerSecond)
+}
+
 func main() {
+	os.Exit(run())
+}
+
+// Runs with the flags given, returns the exit code once the deferred
+// flushes and closes have run.
+func run() int {
+	if len(os.Args) > 1 && os.Args[1] == "dfs" {
+		if err := runDFS(os.Args[2:]); err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		return 0
+	}
+
+	speed := flag.Float64("speed", 1, "emulation speed multiplier")
+	turbo := flag.Bool("turbo", false, "run unthrottled")
+	virtual := flag.Bool("virtual", false, "run in virtual time, without consulting the wall clock")
+	stats := flag.Bool("stats", false, "print performance statistics every second")
+	mos := flag.String("mos", "", "MOS ROM image, runs a full BBC micro when set")
+	roms := romFlags{}
+	flag.Var(roms, "rom", "sideways ROM image as slot=path, may be repeated")
+	tube := flag.String("tube", "", "client ROM of a 6502 second processor fitted over the Tube")
+	frames := flag.Uint64("frames", 0, "run headless in virtual time for the given number of frames")
+	screenshotDir := flag.String("screenshot-dir", "screenshots", "directory of the headless screenshots")
+	screenshotEvery := flag.Uint64("screenshot-every", 0, "write a PNG screenshot every N frames")
+	screenshotAt := flag.String("screenshot-at", "", "comma separated cycle counts to write a PNG screenshot at")
+	wav := flag.String("wav", "", "WAV file recording the sound output")
+	tapeImage := flag.String("tape", "", "UEF tape image inserted in the cassette recorder")
+	fastTape := flag.Bool("fast-tape", false, "load from tape faster than real time")
+	disc0 := flag.String("disc0", "", "disc image in drive 0, .ssd, .dsd, .adf, .adl or .fsd")
+	disc1 := flag.String("disc1", "", "disc image in drive 1")
+	driveTracks := flag.Int("drive-tracks", 80, "tracks of the disc drives, 40 or 80")
+	protect := flag.Bool("write-protect", false, "write protect the discs")
+	discInterface := flag.String("disc-interface", machine.Disc8271, "disc controller board: 8271, acorn1770, opus1770 or watford1770")
+	sdCard := flag.String("mmc", "", "SD card on the user port for MMFS, a .mmb file or a raw card image")
+	printerFile := flag.String("printer", "", "file capturing the bytes sent to the printer")
+	printerPages := flag.String("printer-pages", "", "directory of PNG pages printed as an Epson FX-80")
+	rs423 := flag.String("rs423", "", "host end of the RS423 port: pty, tcp:PORT on localhost or file:IN,OUT")
+	econetStation := flag.Int("econet", 0, "Econet station number, bridged to AUN over UDP on localhost when set")
+	econetPort := flag.Int("econet-port", econet.DefaultBasePort, "UDP port of Econet station 0, each station listens on this plus its number")
+	econetFS := flag.String("econet-fs", "", "directory served by a file server stand-in at Econet station 254")
+	recordTape := flag.String("record-tape", "", "UEF file recording what is saved to tape")
+	recordTapeWAV := flag.String("record-tape-wav", "", "WAV file recording what is saved to tape as audio")
+	flag.Parse()
+
+	// 0 leaves the machine off the network, 255 is the broadcast address
+	if *econetStation < 0 || *econetStation > 254 {
+		fmt.Printf("invalid Econet station %d, expected 0 to 254\n", *econetStation)
+		return 1
+	}
+
+	if *mos != "" {
+		bbc, err := loadMachine(*mos, roms, *virtual || *frames != 0, *driveTracks, *discInterface, byte(*econetStation), *tube)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		for i, path := range []string{*disc0, *disc1} {
+			if path == "" {
+				continue
+			}
+			image, err := dfs.Open(path)
+			if err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			image.WriteProtected = *protect
+			if err := bbc.Drives[i].Insert(image.Disc); err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+		}
+		if *sdCard != "" {
+			card, err := mmb.OpenCard(*sdCard)
+			if err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			bbc.SDCard.Insert(card)
+		}
+		defer bbc.FlushDiscs()
+		if *printerFile != "" {
+			capture, err := os.Create(*printerFile)
+			if err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			writer := bufio.NewWriter(capture)
+			bbc.Printer.OnPrint(func(value byte) { writer.WriteByte(value) })
+			defer capture.Close()
+			defer writer.Flush()
+		}
+		if *printerPages != "" {
+			fx80 := printer.NewFX80()
+			bbc.Printer.OnPrint(func(value byte) { fx80.Write([]byte{value}) })
+			defer func() {
+				if _, err := fx80.SavePages(*printerPages); err != nil {
+					fmt.Println(err.Error())
+				}
+			}()
+		}
+		if *rs423 != "" {
+			where, err := serial.Open(*rs423, bbc.RS423.Connect)
+			if err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			fmt.Printf("RS423 port on %s\n", where)
+			defer bbc.RS423.Disconnect()
+		}
+		if *econetFS != "" {
+			files, err := econet.LoadFiles(*econetFS)
+			if err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			fs := econet.NewFileServer(files)
+			if err := fs.Listen(econet.FileServerStation, *econetPort); err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			defer fs.Close()
+		}
+		if *econetStation != 0 {
+			bridge, err := econet.NewBridge(byte(*econetStation), *econetPort, bbc.ADLC)
+			if err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			bbc.ADLC.Connect(bridge)
+			defer bridge.Close()
+		}
+		if *tapeImage != "" {
+			uef, err := tape.Load(*tapeImage)
+			if err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			bbc.Tape.Insert(uef)
+		}
+		bbc.Tape.SetFastLoad(*fastTape)
+		recording := *recordTape != "" || *recordTapeWAV != ""
+		if recording {
+			bbc.Tape.StartRecording()
+		}
+		if *wav != "" {
+			sound, err := recordSound(bbc, *wav)
+			if err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			defer sound.Close()
+		}
+		code := 0
+		if *frames != 0 {
+			cycles, err := parseCycles(*screenshotAt)
+			if err != nil {
+				fmt.Println(err.Error())
+				return 1
+			}
+			err = screenshot.Run(bbc, screenshot.Options{
+				Dir:      *screenshotDir,
+				Frames:   *frames,
+				Every:    *screenshotEvery,
+				AtCycles: cycles,
+			})
+			if err != nil {
+				fmt.Printf("Error while executing: %v", err)
+				code = 1
+			}
+		} else {
+			if *stats {
+				bbc.Clock.OnStats(printStats)
+			}
+			if err := runMachine(bbc, *speed, *turbo); err != nil {
+				fmt.Printf("Error while executing: %v", err)
+				code = 1
+			}
+		}
+		if recording {
+			if err := saveRecording(bbc, *recordTape, *recordTapeWAV); err != nil {
+				fmt.Println(err.Error())
+				code = 1
+			}
+		}
+		return code
+	}
+
 	// BBC micro run at 2MHz
-	clock := hardware.NewClock(2e6)
+	var clock *hardware.Clock
+	if *virtual {
+		clock = hardware.NewVirtualClock(2e6)
+	} else {
+		clock = hardware.NewClock(2e6)
+	}
+	if err := clock.AddBBCDomains(); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	if *stats {
+		clock.OnStats(printStats)
+	}
 	cpu := hardware.NewCPU(clock)
 	ram := hardware.NewRAM()
 
 	bus, err := hardware.NewBus(clock, cpu, ram)
 	if err != nil {
 		fmt.Println(err.Error())
-		os.Exit(1)
+		return 1
+	}
+
+	if err := clock.SetSpeed(*speed); err != nil {
+		fmt.Println(err.Error())
+		return 1
 	}
+	clock.SetTurbo(*turbo)
 
 	if err := clock.Start(); err != nil {
 		fmt.Printf("Error while starting clock: %v", err)
 	}
 
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		clock.Stop()
+	}()
+
 	initialPC := cpu.ProgramCounter
 
 	program := []byte{
@@ -32,5 +385,7 @@ func main() {
 
 	if err := cpu.Start(); err != nil {
 		fmt.Printf("Error while executing: %v", err)
+		return 1
 	}
+	return 0
 }
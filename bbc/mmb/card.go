@@ -0,0 +1,208 @@
+package mmb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SD card contents in 512 byte blocks. MMFS looks for BEEB.MMB in the root
+// of a FAT partition, an MMB file is presented on a FAT16 volume made up
+// around it. Other files are raw images of whole cards.
+
+const (
+	BlockSize = 512
+	FileName  = "BEEB.MMB"
+
+	// first sector of the partition, after the MBR
+	partitionStart = 63
+	rootEntries    = 512
+	rootSectors    = rootEntries * 32 / BlockSize
+	// fewer clusters would make a FAT12 volume
+	minClusters = 4096
+	maxClusters = 65000
+	volumeLabel = "BBC MMC    "
+)
+
+type Card struct {
+	// sectors before the file in the FAT volume, none for raw images
+	header []byte
+	data   []byte
+	blocks int
+	// blocks written past the file, kept until the card is ejected
+	written  map[int][]byte
+	path     string
+	modified bool
+}
+
+// Raw card image.
+func NewCard(data []byte) *Card {
+	return &Card{data: data, blocks: (len(data) + BlockSize - 1) / BlockSize, written: map[int][]byte{}}
+}
+
+// Card with the MMB as BEEB.MMB on a FAT16 volume.
+func NewMMBCard(mmb *MMB) *Card {
+	header, blocks := fatVolume(len(mmb.Data))
+	return &Card{header: header, data: mmb.Data, blocks: blocks, written: map[int][]byte{}}
+}
+
+// Opens a .mmb file or a raw image, written back when flushed.
+func OpenCard(path string) (*Card, error) {
+	var card *Card
+	if strings.EqualFold(filepath.Ext(path), ".mmb") {
+		mmb, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		card = NewMMBCard(mmb)
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		card = NewCard(data)
+	}
+	card.path = path
+	return card, nil
+}
+
+func (card *Card) GetBlockCount() int {
+	return card.blocks
+}
+
+// Bytes of the image behind the block, nil past its end.
+func (card *Card) locate(number int) []byte {
+	offset := number*BlockSize - len(card.header)
+	if offset < 0 {
+		return card.header[number*BlockSize : (number+1)*BlockSize]
+	}
+	if offset >= len(card.data) {
+		return nil
+	}
+	end := offset + BlockSize
+	if end > len(card.data) {
+		end = len(card.data)
+	}
+	return card.data[offset:end]
+}
+
+func (card *Card) ReadBlock(number int) ([]byte, error) {
+	if number < 0 || number >= card.blocks {
+		return nil, fmt.Errorf("block %d is past the end of the card", number)
+	}
+	block := make([]byte, BlockSize)
+	if written, ok := card.written[number]; ok {
+		copy(block, written)
+	} else {
+		copy(block, card.locate(number))
+	}
+	return block, nil
+}
+
+func (card *Card) WriteBlock(number int, data []byte) error {
+	if number < 0 || number >= card.blocks {
+		return fmt.Errorf("block %d is past the end of the card", number)
+	}
+	stored := card.locate(number)
+	if stored == nil || number*BlockSize < len(card.header) {
+		card.written[number] = append([]byte{}, data[:BlockSize]...)
+		return nil
+	}
+	copy(stored, data)
+	card.modified = true
+	return nil
+}
+
+// Writes the image back when it was modified.
+func (card *Card) Flush() error {
+	if !card.modified || card.path == "" {
+		return nil
+	}
+	if err := os.WriteFile(card.path, card.data, 0o644); err != nil {
+		return err
+	}
+	card.modified = false
+	return nil
+}
+
+// MBR, boot sector, FATs and root directory of a FAT16 volume holding a
+// file of the size from its first cluster, and the size of the card.
+func fatVolume(size int) ([]byte, int) {
+	fileSectors := (size + BlockSize - 1) / BlockSize
+	clusterSectors := 1
+	for (fileSectors+clusterSectors-1)/clusterSectors > maxClusters {
+		clusterSectors *= 2
+	}
+	fileClusters := (fileSectors + clusterSectors - 1) / clusterSectors
+	clusters := fileClusters
+	if clusters < minClusters {
+		clusters = minClusters
+	}
+	fatSectors := ((clusters+2)*2 + BlockSize - 1) / BlockSize
+	dataStart := 1 + 2*fatSectors + rootSectors
+	volumeSectors := dataStart + clusters*clusterSectors
+	header := make([]byte, (partitionStart+dataStart)*BlockSize)
+
+	mbr := header[:BlockSize]
+	partition := mbr[0x1BE:]
+	partitionType := byte(0x06)
+	if volumeSectors < 0x10000 {
+		partitionType = 0x04
+	}
+	copy(partition, []byte{0x00, 0xFE, 0xFF, 0xFF, partitionType, 0xFE, 0xFF, 0xFF})
+	binary.LittleEndian.PutUint32(partition[8:], partitionStart)
+	binary.LittleEndian.PutUint32(partition[12:], uint32(volumeSectors))
+	mbr[510], mbr[511] = 0x55, 0xAA
+
+	boot := header[partitionStart*BlockSize:]
+	copy(boot, []byte{0xEB, 0x3C, 0x90})
+	copy(boot[3:], "BBCMICRO")
+	binary.LittleEndian.PutUint16(boot[0x0B:], BlockSize)
+	boot[0x0D] = byte(clusterSectors)
+	binary.LittleEndian.PutUint16(boot[0x0E:], 1)
+	boot[0x10] = 2
+	binary.LittleEndian.PutUint16(boot[0x11:], rootEntries)
+	if volumeSectors < 0x10000 {
+		binary.LittleEndian.PutUint16(boot[0x13:], uint16(volumeSectors))
+	} else {
+		binary.LittleEndian.PutUint32(boot[0x20:], uint32(volumeSectors))
+	}
+	boot[0x15] = 0xF8
+	binary.LittleEndian.PutUint16(boot[0x16:], uint16(fatSectors))
+	binary.LittleEndian.PutUint16(boot[0x18:], 63)
+	binary.LittleEndian.PutUint16(boot[0x1A:], 255)
+	binary.LittleEndian.PutUint32(boot[0x1C:], partitionStart)
+	boot[0x24], boot[0x26] = 0x80, 0x29
+	binary.LittleEndian.PutUint32(boot[0x27:], 0xBBC0BBC0)
+	copy(boot[0x2B:], volumeLabel)
+	copy(boot[0x36:], "FAT16   ")
+	boot[510], boot[511] = 0x55, 0xAA
+
+	// the file takes the clusters from 2 one after the other
+	for table := 0; table < 2; table++ {
+		fat := boot[(1+table*fatSectors)*BlockSize:]
+		binary.LittleEndian.PutUint16(fat[0:], 0xFFF8)
+		binary.LittleEndian.PutUint16(fat[2:], 0xFFFF)
+		for cluster := 2; cluster < 2+fileClusters; cluster++ {
+			next := uint16(cluster + 1)
+			if cluster == 1+fileClusters {
+				next = 0xFFFF
+			}
+			binary.LittleEndian.PutUint16(fat[cluster*2:], next)
+		}
+	}
+
+	root := boot[(1+2*fatSectors)*BlockSize:]
+	copy(root, volumeLabel)
+	root[0x0B] = 0x08
+	file := root[32:]
+	copy(file, "BEEB    MMB")
+	file[0x0B] = 0x20
+	if fileClusters > 0 {
+		binary.LittleEndian.PutUint16(file[0x1A:], 2)
+	}
+	binary.LittleEndian.PutUint32(file[0x1C:], uint32(size))
+	return header, partitionStart + volumeSectors
+}
@@ -0,0 +1,147 @@
+package mmb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MMB files hold the discs of MMFS: an 8K catalogue of 16 byte entries,
+// the first giving the discs inserted at boot in drives 0 to 3, then one
+// per disc with its title and status. The discs follow, each in 200K as an
+// 80 track SSD image.
+
+const (
+	CatalogueSize = 0x2000
+	DiscSize      = 80 * 10 * 256
+	MaxDiscs      = CatalogueSize/entrySize - 1
+
+	entrySize   = 16
+	titleSize   = 12
+	statusField = 15
+)
+
+// disc status
+const (
+	StatusLocked      = 0x00
+	StatusUnlocked    = 0x0F
+	StatusUnformatted = 0xF0
+	StatusInvalid     = 0xFF
+)
+
+type MMB struct {
+	Data []byte
+}
+
+// Discs left unformatted, the boot drives have discs 0 to 3.
+func New(discs int) (*MMB, error) {
+	if discs < 1 || discs > MaxDiscs {
+		return nil, fmt.Errorf("MMB files hold 1 to %d discs, not %d", MaxDiscs, discs)
+	}
+	mmb := &MMB{Data: make([]byte, CatalogueSize+discs*DiscSize)}
+	for drive := 0; drive < 4; drive++ {
+		mmb.Data[drive] = byte(drive)
+	}
+	for i := 0; i < MaxDiscs; i++ {
+		status := byte(StatusInvalid)
+		if i < discs {
+			status = StatusUnformatted
+		}
+		mmb.entry(i)[statusField] = status
+	}
+	return mmb, nil
+}
+
+func Load(path string) (*MMB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < CatalogueSize {
+		return nil, fmt.Errorf("%s: no MMB catalogue", path)
+	}
+	return &MMB{Data: data}, nil
+}
+
+func (mmb *MMB) entry(number int) []byte {
+	offset := (number + 1) * entrySize
+	return mmb.Data[offset : offset+entrySize]
+}
+
+// Discs the file has room for.
+func (mmb *MMB) GetDiscCount() int {
+	count := (len(mmb.Data) - CatalogueSize) / DiscSize
+	if count > MaxDiscs {
+		return MaxDiscs
+	}
+	return count
+}
+
+func (mmb *MMB) check(number int) error {
+	if number < 0 || number >= mmb.GetDiscCount() {
+		return fmt.Errorf("no disc %d in the MMB", number)
+	}
+	return nil
+}
+
+func (mmb *MMB) GetStatus(number int) byte {
+	if mmb.check(number) != nil {
+		return StatusInvalid
+	}
+	return mmb.entry(number)[statusField]
+}
+
+func (mmb *MMB) GetTitle(number int) string {
+	if mmb.check(number) != nil {
+		return ""
+	}
+	return strings.TrimRight(string(mmb.entry(number)[:titleSize]), "\x00 ")
+}
+
+// SSD image of the disc.
+func (mmb *MMB) GetDisc(number int) ([]byte, error) {
+	if err := mmb.check(number); err != nil {
+		return nil, err
+	}
+	offset := CatalogueSize + number*DiscSize
+	return append([]byte{}, mmb.Data[offset:offset+DiscSize]...), nil
+}
+
+// Stores an SSD image as the disc, its title taken from the DFS catalogue.
+func (mmb *MMB) PutDisc(number int, ssd []byte, locked bool) error {
+	if err := mmb.check(number); err != nil {
+		return err
+	}
+	if len(ssd) > DiscSize {
+		return fmt.Errorf("%d bytes do not fit a disc of the MMB", len(ssd))
+	}
+	offset := CatalogueSize + number*DiscSize
+	disc := mmb.Data[offset : offset+DiscSize]
+	copy(disc, make([]byte, DiscSize))
+	copy(disc, ssd)
+	entry := mmb.entry(number)
+	// the title is split between the first two sectors
+	copy(entry[:8], disc[0:8])
+	copy(entry[8:titleSize], disc[0x100:0x104])
+	entry[statusField] = StatusUnlocked
+	if locked {
+		entry[statusField] = StatusLocked
+	}
+	return nil
+}
+
+// Disc inserted in the drive at boot.
+func (mmb *MMB) SetBootDisc(drive, number int) error {
+	if drive < 0 || drive > 3 {
+		return fmt.Errorf("no drive %d", drive)
+	}
+	if err := mmb.check(number); err != nil {
+		return err
+	}
+	mmb.Data[drive], mmb.Data[drive+4] = byte(number), byte(number>>8)
+	return nil
+}
+
+func (mmb *MMB) Save(path string) error {
+	return os.WriteFile(path, mmb.Data, 0o644)
+}
@@ -0,0 +1,109 @@
+package printer
+
+// Draft characters from 0x20 to 0x7F, 5 dots wide and 10 rows high with
+// the first row blank, the last two for descenders. They are the teletext
+// shapes with the ASCII ones in place of the UK symbols.
+var draftFont = [96]string{
+	// 0x20
+	"..... ..... ..... ..... ..... ..... ..... ..... ..... .....",
+	"..... ..#.. ..#.. ..#.. ..#.. ..#.. ..... ..#.. ..... .....",
+	"..... .#.#. .#.#. .#.#. ..... ..... ..... ..... ..... .....",
+	"..... .#.#. .#.#. ##### .#.#. ##### .#.#. .#.#. ..... .....",
+	"..... .###. #.#.# #.#.. .###. ..#.# #.#.# .###. ..... .....",
+	"..... ##... ##..# ...#. ..#.. .#... #..## ...## ..... .....",
+	"..... .#... #.#.. #.#.. .#... #.#.# #..#. .##.# ..... .....",
+	"..... ..#.. ..#.. ..#.. ..... ..... ..... ..... ..... .....",
+	"..... ...#. ..#.. .#... .#... .#... ..#.. ...#. ..... .....",
+	"..... .#... ..#.. ...#. ...#. ...#. ..#.. .#... ..... .....",
+	"..... ..#.. #.#.# .###. ..#.. .###. #.#.# ..#.. ..... .....",
+	"..... ..... ..#.. ..#.. ##### ..#.. ..#.. ..... ..... .....",
+	"..... ..... ..... ..... ..... ..... ..#.. ..#.. .#... .....",
+	"..... ..... ..... ..... .###. ..... ..... ..... ..... .....",
+	"..... ..... ..... ..... ..... ..... ..... ..#.. ..... .....",
+	"..... ..... ....# ...#. ..#.. .#... #.... ..... ..... .....",
+	// 0x30
+	"..... ..#.. .#.#. #...# #...# #...# .#.#. ..#.. ..... .....",
+	"..... ..#.. .##.. ..#.. ..#.. ..#.. ..#.. .###. ..... .....",
+	"..... .###. #...# ....# ..##. .#... #.... ##### ..... .....",
+	"..... ##### ....# ...#. ..##. ....# #...# .###. ..... .....",
+	"..... ...#. ..##. .#.#. #..#. ##### ...#. ...#. ..... .....",
+	"..... ##### #.... ####. ....# ....# #...# .###. ..... .....",
+	"..... ..##. .#... #.... ####. #...# #...# .###. ..... .....",
+	"..... ##### ....# ...#. ..#.. .#... .#... .#... ..... .....",
+	"..... .###. #...# #...# .###. #...# #...# .###. ..... .....",
+	"..... .###. #...# #...# .#### ....# ...#. .##.. ..... .....",
+	"..... ..... ..... ..#.. ..... ..... ..... ..#.. ..... .....",
+	"..... ..... ..... ..#.. ..... ..... ..#.. ..#.. .#... .....",
+	"..... ...#. ..#.. .#... #.... .#... ..#.. ...#. ..... .....",
+	"..... ..... ..... ##### ..... ##### ..... ..... ..... .....",
+	"..... .#... ..#.. ...#. ....# ...#. ..#.. .#... ..... .....",
+	"..... .###. #...# ...#. ..#.. ..#.. ..... ..#.. ..... .....",
+	// 0x40
+	"..... .###. #...# #.### #.#.# #.### #.... .###. ..... .....",
+	"..... ..#.. .#.#. #...# #...# ##### #...# #...# ..... .....",
+	"..... ####. #...# #...# ####. #...# #...# ####. ..... .....",
+	"..... .###. #...# #.... #.... #.... #...# .###. ..... .....",
+	"..... ####. #...# #...# #...# #...# #...# ####. ..... .....",
+	"..... ##### #.... #.... ####. #.... #.... ##### ..... .....",
+	"..... ##### #.... #.... ####. #.... #.... #.... ..... .....",
+	"..... .###. #...# #.... #.... #..## #...# .#### ..... .....",
+	"..... #...# #...# #...# ##### #...# #...# #...# ..... .....",
+	"..... .###. ..#.. ..#.. ..#.. ..#.. ..#.. .###. ..... .....",
+	"..... ....# ....# ....# ....# ....# #...# .###. ..... .....",
+	"..... #...# #..#. #.#.. ##... #.#.. #..#. #...# ..... .....",
+	"..... #.... #.... #.... #.... #.... #.... ##### ..... .....",
+	"..... #...# ##.## #.#.# #.#.# #...# #...# #...# ..... .....",
+	"..... #...# #...# ##..# #.#.# #..## #...# #...# ..... .....",
+	"..... .###. #...# #...# #...# #...# #...# .###. ..... .....",
+	// 0x50
+	"..... ####. #...# #...# ####. #.... #.... #.... ..... .....",
+	"..... .###. #...# #...# #...# #.#.# #..#. .##.# ..... .....",
+	"..... ####. #...# #...# ####. #.#.. #..#. #...# ..... .....",
+	"..... .###. #...# #.... .###. ....# #...# .###. ..... .....",
+	"..... ##### ..#.. ..#.. ..#.. ..#.. ..#.. ..#.. ..... .....",
+	"..... #...# #...# #...# #...# #...# #...# .###. ..... .....",
+	"..... #...# #...# #...# .#.#. .#.#. ..#.. ..#.. ..... .....",
+	"..... #...# #...# #...# #.#.# #.#.# #.#.# .#.#. ..... .....",
+	"..... #...# #...# .#.#. ..#.. .#.#. #...# #...# ..... .....",
+	"..... #...# #...# .#.#. ..#.. ..#.. ..#.. ..#.. ..... .....",
+	"..... ##### ....# ...#. ..#.. .#... #.... ##### ..... .....",
+	"..... .###. .#... .#... .#... .#... .#... .###. ..... .....",
+	"..... ..... #.... .#... ..#.. ...#. ....# ..... ..... .....",
+	"..... .###. ...#. ...#. ...#. ...#. ...#. .###. ..... .....",
+	"..... ..#.. .#.#. #...# ..... ..... ..... ..... ..... .....",
+	"..... ..... ..... ..... ..... ..... ..... ..... ##### .....",
+	// 0x60
+	"..... .#... ..#.. ...#. ..... ..... ..... ..... ..... .....",
+	"..... ..... ..... .###. ....# .#### #...# .#### ..... .....",
+	"..... #.... #.... ####. #...# #...# #...# ####. ..... .....",
+	"..... ..... ..... .#### #.... #.... #.... .#### ..... .....",
+	"..... ....# ....# .#### #...# #...# #...# .#### ..... .....",
+	"..... ..... ..... .###. #...# ##### #.... .###. ..... .....",
+	"..... ..##. .#... .#... ###.. .#... .#... .#... ..... .....",
+	"..... ..... ..... .#### #...# #...# #...# .#### ....# .###.",
+	"..... #.... #.... ####. #...# #...# #...# #...# ..... .....",
+	"..... ..#.. ..... .##.. ..#.. ..#.. ..#.. .###. ..... .....",
+	"..... ..#.. ..... ..#.. ..#.. ..#.. ..#.. ..#.. ..#.. .#...",
+	"..... .#... .#... .#..# .#.#. .##.. .#.#. .#..# ..... .....",
+	"..... .##.. ..#.. ..#.. ..#.. ..#.. ..#.. .###. ..... .....",
+	"..... ..... ..... ##.#. #.#.# #.#.# #.#.# #.#.# ..... .....",
+	"..... ..... ..... ####. #...# #...# #...# #...# ..... .....",
+	"..... ..... ..... .###. #...# #...# #...# .###. ..... .....",
+	// 0x70
+	"..... ..... ..... ####. #...# #...# #...# ####. #.... #....",
+	"..... ..... ..... .#### #...# #...# #...# .#### ....# ....#",
+	"..... ..... ..... .#.## .##.. .#... .#... .#... ..... .....",
+	"..... ..... ..... .#### #.... .###. ....# ####. ..... .....",
+	"..... .#... .#... ###.. .#... .#... .#... ..##. ..... .....",
+	"..... ..... ..... #...# #...# #...# #...# .#### ..... .....",
+	"..... ..... ..... #...# #...# .#.#. .#.#. ..#.. ..... .....",
+	"..... ..... ..... #...# #...# #.#.# #.#.# .#.#. ..... .....",
+	"..... ..... ..... #...# .#.#. ..#.. .#.#. #...# ..... .....",
+	"..... ..... ..... #...# #...# #...# #...# .#### ....# .###.",
+	"..... ..... ..... ##### ...#. ..#.. .#... ##### ..... .....",
+	"..... ...## ..#.. ..#.. .#... ..#.. ..#.. ...## ..... .....",
+	"..... ..#.. ..#.. ..#.. ..#.. ..#.. ..#.. ..#.. ..... .....",
+	"..... ##... ..#.. ..#.. ...#. ..#.. ..#.. ##... ..... .....",
+	"..... .#... #.#.# ...#. ..... ..... ..... ..... ..... .....",
+	"..... ..... ..... ..... ..... ..... ..... ..... ..... .....",
+}
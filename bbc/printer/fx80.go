@@ -0,0 +1,429 @@
+package printer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Epson FX-80 interpreter rendering what is printed on pages of 8.5 by 11
+// inches, at 240 dots per inch across and 216 down. The print head has 9
+// pins 1/72 inch apart, the top one is bit 7 of bit image columns.
+// Positions across count 1/720 inch, down 1/216 inch.
+
+const (
+	DotsPerInchX = 240
+	DotsPerInchY = 216
+	PageWidth    = DotsPerInchX * 17 / 2
+	PageHeight   = DotsPerInchY * 11
+
+	unitsPerInch = 720
+	unitsPerDot  = unitsPerInch / DotsPerInchX
+	pinRows      = DotsPerInchY / 72
+	pins         = 9
+	// 80 columns of pica from the left edge
+	printWidth = 8 * unitsPerInch
+)
+
+// character pitches in 1/720 inch
+const (
+	pitchPica      = unitsPerInch / 10
+	pitchElite     = unitsPerInch / 12
+	pitchCondensed = 42
+)
+
+// ESC ! bits
+const (
+	modeElite        = 0x01
+	modeCondensed    = 0x04
+	modeEmphasized   = 0x08
+	modeDoubleStrike = 0x10
+	modeExpanded     = 0x20
+	modeItalic       = 0x40
+	modeUnderline    = 0x80
+)
+
+// parameters of ESC sequences, those not listed have none
+var escParameters = map[byte]int{
+	'!': 1, '-': 1, '3': 1, 'A': 1, 'J': 1, 'W': 1, 'S': 1, 'Q': 1, 'l': 1,
+	'N': 1, 'R': 1, 'U': 1, 'x': 1, 'p': 1, 's': 1, '?': 2, 'K': 2, 'L': 2,
+	'Y': 2, 'Z': 2, '*': 3, '^': 3, 'C': 1, 'e': 2, 'f': 2, 'j': 1, 'a': 1,
+}
+
+// 1/720 inch per column of the ESC * modes
+var bitImageSpacing = map[byte]int{
+	0: 12, 1: 6, 2: 6, 3: 3, 4: 9, 5: 10, 6: 8,
+}
+
+type FX80 struct {
+	// carriage return also feeds a line, as the BBC micro sends no line
+	// feeds unless told to
+	AutoLineFeed bool
+
+	pages   []*image.Gray
+	page    *image.Gray
+	x, y    int
+	mode    byte
+	spacing int
+	// lines of the page, in 1/216 inch
+	pageLength  int
+	leftMargin  int
+	rightMargin int
+	// expanded for the rest of the line, by SO
+	lineExpanded bool
+
+	// the escape sequence being received
+	escape   []byte
+	graphics int
+}
+
+func NewFX80() *FX80 {
+	printer := &FX80{AutoLineFeed: true}
+	printer.reset()
+	return printer
+}
+
+func (printer *FX80) reset() {
+	printer.mode = 0
+	printer.spacing = DotsPerInchY / 6
+	printer.pageLength = PageHeight
+	printer.leftMargin, printer.rightMargin = 0, printWidth
+	printer.lineExpanded = false
+	printer.x = printer.leftMargin
+}
+
+// Takes the bytes sent to the printer.
+func (printer *FX80) Write(data []byte) (int, error) {
+	for _, value := range data {
+		printer.receive(value)
+	}
+	return len(data), nil
+}
+
+// Pages finished and the one being printed.
+func (printer *FX80) GetPages() []*image.Gray {
+	if printer.page == nil {
+		return printer.pages
+	}
+	return append(append([]*image.Gray{}, printer.pages...), printer.page)
+}
+
+// Writes the pages as page-001.png and so on, returns their paths.
+func (printer *FX80) SavePages(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for i, page := range printer.GetPages() {
+		path := filepath.Join(dir, fmt.Sprintf("page-%03d.png", i+1))
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := png.Encode(file, page); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if err := file.Close(); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func (printer *FX80) receive(value byte) {
+	if printer.graphics > 0 {
+		printer.escape = append(printer.escape, value)
+		printer.graphics--
+		if printer.graphics == 0 {
+			printer.bitImage()
+		}
+		return
+	}
+	if printer.escape != nil {
+		printer.escape = append(printer.escape, value)
+		printer.escapeSequence()
+		return
+	}
+	// codes 80-9F act as the control codes 00-1F
+	if value&0xE0 == 0x80 {
+		value &= 0x7F
+	}
+	switch value {
+	case 0x07, 0x00, 0x11, 0x13, 0x18, 0x7F:
+	case 0x08:
+		printer.x -= printer.pitch()
+		if printer.x < printer.leftMargin {
+			printer.x = printer.leftMargin
+		}
+	case 0x09:
+		tab := 8 * printer.pitch()
+		printer.x += tab - (printer.x-printer.leftMargin)%tab
+	case 0x0A, 0x0B:
+		printer.lineFeed(printer.spacing)
+	case 0x0C:
+		printer.formFeed()
+	case 0x0D:
+		printer.carriageReturn()
+		if printer.AutoLineFeed {
+			printer.lineFeed(printer.spacing)
+		}
+	case 0x0E:
+		printer.lineExpanded = true
+	case 0x0F:
+		printer.mode |= modeCondensed
+	case 0x12:
+		printer.mode &^= modeCondensed
+	case 0x14:
+		printer.lineExpanded = false
+	case 0x1B:
+		printer.escape = []byte{}
+	default:
+		// the other control codes are ignored
+		if value >= 0x20 {
+			printer.character(value & 0x7F)
+		}
+	}
+}
+
+func (printer *FX80) escapeSequence() {
+	command := printer.escape[0]
+	arguments := printer.escape[1:]
+	if len(arguments) < escParameters[command] {
+		return
+	}
+	// tab stops run to a NUL
+	if (command == 'D' || command == 'B') && (len(arguments) == 0 || arguments[len(arguments)-1] != 0) {
+		return
+	}
+	// ESC C 0 n sets the page length in inches
+	if command == 'C' && arguments[0] == 0 && len(arguments) < 2 {
+		return
+	}
+	switch command {
+	case 'K', 'L', 'Y', 'Z', '*', '^':
+		count := int(arguments[len(arguments)-2]) | int(arguments[len(arguments)-1])<<8
+		if command == '^' {
+			count *= 2
+		}
+		printer.graphics = count
+		if count == 0 {
+			printer.bitImage()
+		}
+		return
+	}
+	printer.escape = nil
+	argument := byte(0)
+	if len(arguments) > 0 {
+		argument = arguments[0]
+	}
+	switch command {
+	case '@':
+		printer.reset()
+	case '!':
+		printer.mode = argument
+		printer.lineExpanded = false
+	case '-':
+		printer.setMode(modeUnderline, argument&0x01 != 0)
+	case 'W':
+		printer.setMode(modeExpanded, argument&0x01 != 0)
+		printer.lineExpanded = false
+	case 'E':
+		printer.mode |= modeEmphasized
+	case 'F':
+		printer.mode &^= modeEmphasized
+	case 'G':
+		printer.mode |= modeDoubleStrike
+	case 'H':
+		printer.mode &^= modeDoubleStrike
+	case '4':
+		printer.mode |= modeItalic
+	case '5':
+		printer.mode &^= modeItalic
+	case 'M':
+		printer.mode |= modeElite
+	case 'P':
+		printer.mode &^= modeElite
+	case '0':
+		printer.spacing = DotsPerInchY / 8
+	case '1':
+		printer.spacing = DotsPerInchY * 7 / 72
+	case '2':
+		printer.spacing = DotsPerInchY / 6
+	case '3':
+		printer.spacing = int(argument)
+	case 'A':
+		printer.spacing = int(argument) * pinRows
+	case 'J':
+		printer.lineFeed(int(argument))
+	case 'C':
+		if argument == 0 {
+			printer.pageLength = int(arguments[1]) * DotsPerInchY
+		} else {
+			printer.pageLength = int(argument) * printer.spacing
+		}
+		if printer.pageLength > PageHeight || printer.pageLength == 0 {
+			printer.pageLength = PageHeight
+		}
+	case 'l':
+		printer.leftMargin = int(argument) * printer.pitch()
+	case 'Q':
+		printer.rightMargin = int(argument) * printer.pitch()
+	}
+}
+
+func (printer *FX80) setMode(mode byte, on bool) {
+	if on {
+		printer.mode |= mode
+	} else {
+		printer.mode &^= mode
+	}
+}
+
+// Character width in 1/720 inch.
+func (printer *FX80) pitch() int {
+	pitch := pitchPica
+	switch {
+	case printer.mode&modeElite != 0:
+		pitch = pitchElite
+	case printer.mode&modeCondensed != 0:
+		pitch = pitchCondensed
+	}
+	if printer.mode&modeExpanded != 0 || printer.lineExpanded {
+		pitch *= 2
+	}
+	return pitch
+}
+
+func (printer *FX80) carriageReturn() {
+	printer.x = printer.leftMargin
+	printer.lineExpanded = false
+}
+
+func (printer *FX80) lineFeed(rows int) {
+	printer.y += rows
+	if printer.y >= printer.pageLength {
+		printer.ejectPage()
+		printer.y -= printer.pageLength
+	}
+}
+
+func (printer *FX80) formFeed() {
+	if printer.page == nil {
+		printer.newPage()
+	}
+	printer.ejectPage()
+	printer.y = 0
+	printer.x = printer.leftMargin
+}
+
+func (printer *FX80) newPage() {
+	printer.page = image.NewGray(image.Rect(0, 0, PageWidth, PageHeight))
+	for i := range printer.page.Pix {
+		printer.page.Pix[i] = 0xFF
+	}
+}
+
+func (printer *FX80) ejectPage() {
+	if printer.page != nil {
+		printer.pages = append(printer.pages, printer.page)
+		printer.page = nil
+	}
+}
+
+// Fires a pin at the position across, in 1/720 inch.
+func (printer *FX80) dot(x int, pin int) {
+	if printer.page == nil {
+		printer.newPage()
+	}
+	left, top := x/unitsPerDot, printer.y+pin*pinRows
+	for row := top; row < top+pinRows; row++ {
+		for column := left; column < left+pinRows; column++ {
+			printer.page.SetGray(column, row, color.Gray{})
+		}
+	}
+}
+
+// Emphasized moves the head half a dot and strikes again, double strike
+// moves the paper a third of a dot.
+func (printer *FX80) strike(x int, pin int) {
+	printer.dot(x, pin)
+	if printer.mode&modeEmphasized != 0 {
+		printer.dot(x+unitsPerDot, pin)
+	}
+	if printer.mode&modeDoubleStrike != 0 {
+		printer.y++
+		printer.dot(x, pin)
+		printer.y--
+	}
+}
+
+func (printer *FX80) character(value byte) {
+	pitch := printer.pitch()
+	if printer.x+pitch > printer.rightMargin {
+		printer.carriageReturn()
+		printer.lineFeed(printer.spacing)
+	}
+	rows := strings.Split(draftFont[value-0x20], " ")
+	column := pitch / 6
+	for pin := 0; pin < pins; pin++ {
+		slant := 0
+		if printer.mode&modeItalic != 0 {
+			slant = (pins - 1 - pin) * column / 4
+		}
+		for i, dot := range rows[pin+1] {
+			if dot == '#' {
+				printer.strike(printer.x+column/2+i*column+slant, pin)
+			}
+		}
+	}
+	if printer.mode&modeUnderline != 0 {
+		for x := printer.x; x < printer.x+pitch; x += unitsPerDot {
+			printer.dot(x, pins-1)
+		}
+	}
+	printer.x += pitch
+}
+
+// Columns of the bit image received, after ESC, the mode and the count.
+func (printer *FX80) bitImage() {
+	command := printer.escape[0]
+	parameters := escParameters[command]
+	data := printer.escape[1+parameters:]
+	spacing := 12
+	switch command {
+	case 'L', 'Y':
+		spacing = 6
+	case 'Z':
+		spacing = 3
+	case '*', '^':
+		if mode, ok := bitImageSpacing[printer.escape[1]]; ok {
+			spacing = mode
+		}
+	}
+	printer.escape = nil
+	step := 1
+	if command == '^' {
+		step = 2
+	}
+	for i := 0; i+step <= len(data); i += step {
+		if printer.x >= printer.rightMargin {
+			break
+		}
+		for pin := 0; pin < 8; pin++ {
+			if data[i]&(0x80>>pin) != 0 {
+				printer.dot(printer.x, pin)
+			}
+		}
+		// the ninth pin is bit 7 of the second byte
+		if step == 2 && data[i+1]&0x80 != 0 {
+			printer.dot(printer.x, 8)
+		}
+		printer.x += spacing
+	}
+}
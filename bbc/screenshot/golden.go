@@ -0,0 +1,78 @@
+package screenshot
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"testing"
+)
+
+// Set to write the frames compared by AssertGolden as the new golden files.
+const UpdateGoldenEnv = "UPDATE_GOLDEN"
+
+// Pixels match when no channel differs by more than Channel, frames match
+// when at most Pixels pixels differ.
+type Tolerance struct {
+	Channel uint8
+	Pixels  int
+}
+
+func channelDelta(a, b uint32) uint32 {
+	// colours are 16 bits per channel
+	a, b = a>>8, b>>8
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// Counts the pixels differing beyond the channel tolerance.
+func Diff(frame, golden image.Image, channel uint8) (int, error) {
+	if frame.Bounds().Size() != golden.Bounds().Size() {
+		return 0, fmt.Errorf("frame size %v differs from golden %v", frame.Bounds().Size(), golden.Bounds().Size())
+	}
+	differing := 0
+	frameOrigin, goldenOrigin := frame.Bounds().Min, golden.Bounds().Min
+	size := frame.Bounds().Size()
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			r1, g1, b1, _ := frame.At(frameOrigin.X+x, frameOrigin.Y+y).RGBA()
+			r2, g2, b2, _ := golden.At(goldenOrigin.X+x, goldenOrigin.Y+y).RGBA()
+			limit := uint32(channel)
+			if channelDelta(r1, r2) > limit || channelDelta(g1, g2) > limit || channelDelta(b1, b2) > limit {
+				differing++
+			}
+		}
+	}
+	return differing, nil
+}
+
+func Compare(frame image.Image, goldenPath string, tolerance Tolerance) error {
+	golden, err := Load(goldenPath)
+	if err != nil {
+		return err
+	}
+	differing, err := Diff(frame, golden, tolerance.Channel)
+	if err != nil {
+		return err
+	}
+	if differing > tolerance.Pixels {
+		return fmt.Errorf("%d pixels differ from %s", differing, goldenPath)
+	}
+	return nil
+}
+
+// Test helper failing when the frame does not match the golden PNG. With
+// UPDATE_GOLDEN set the golden file is written instead.
+func AssertGolden(t testing.TB, frame image.Image, goldenPath string, tolerance Tolerance) {
+	t.Helper()
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := Save(goldenPath, frame); err != nil {
+			t.Fatalf("writing golden %s: %v", goldenPath, err)
+		}
+		return
+	}
+	if err := Compare(frame, goldenPath, tolerance); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
@@ -0,0 +1,74 @@
+package screenshot
+
+import (
+	"bbc/machine"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func Save(path string, frame image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, frame); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func Load(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
+
+// Headless run writing PNG screenshots. Screenshots requested at a cycle
+// count are taken at the first vsync reached from it.
+type Options struct {
+	Dir string
+	// frames to run
+	Frames uint64
+	// a screenshot every N frames, none when 0
+	Every    uint64
+	AtCycles []uint64
+}
+
+func Run(bbc *machine.Machine, options Options) error {
+	if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+		return err
+	}
+	cycles := append([]uint64{}, options.AtCycles...)
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i] < cycles[j] })
+
+	for frame := uint64(1); frame <= options.Frames; frame++ {
+		start := bbc.Clock.GetCycles()
+		if _, err := bbc.RunFrame(); err != nil {
+			return err
+		}
+		names := []string{}
+		if options.Every != 0 && frame%options.Every == 0 {
+			names = append(names, fmt.Sprintf("frame-%06d.png", frame))
+		}
+		for len(cycles) > 0 && cycles[0] <= bbc.Clock.GetCycles() {
+			if cycles[0] >= start {
+				names = append(names, fmt.Sprintf("cycle-%d.png", cycles[0]))
+			}
+			cycles = cycles[1:]
+		}
+		for _, name := range names {
+			if err := Save(filepath.Join(options.Dir, name), bbc.VideoULA.GetFramebuffer()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package serial
+
+import (
+	"errors"
+	"os"
+)
+
+func OpenPTY() (*os.File, string, error) {
+	return nil, "", errors.New("pseudo-terminals are only supported on Linux")
+}
@@ -0,0 +1,144 @@
+package serial
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Host ends of the RS423 port: a pseudo-terminal, a TCP port on localhost
+// or a pair of files, one read and one written.
+
+// Connects the host end described by the spec, pty, tcp:PORT or
+// file:IN,OUT. The connection may be made later, as for a TCP client, and
+// is then made again by each new client. Returns a description of where
+// to find the host end.
+func Open(spec string, connect func(io.ReadWriteCloser)) (string, error) {
+	kind, argument, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "pty":
+		master, path, err := OpenPTY()
+		if err != nil {
+			return "", err
+		}
+		connect(master)
+		return path, nil
+	case "tcp":
+		port, err := strconv.Atoi(argument)
+		if err != nil {
+			return "", fmt.Errorf("invalid TCP port %q", argument)
+		}
+		listener, err := Listen(port, connect)
+		if err != nil {
+			return "", err
+		}
+		return listener.Addr().String(), nil
+	case "file":
+		in, out, ok := strings.Cut(argument, ",")
+		if !ok {
+			return "", fmt.Errorf("expected file:IN,OUT, got %q", spec)
+		}
+		connect(OpenFiles(in, out))
+		return in + ", " + out, nil
+	}
+	return "", fmt.Errorf("unknown serial connection %q, expected pty, tcp:PORT or file:IN,OUT", spec)
+}
+
+// Accepts clients on the port of 127.0.0.1 in the background, each one
+// replacing the previous one.
+func Listen(port int, connect func(io.ReadWriteCloser)) (net.Listener, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connect(conn)
+		}
+	}()
+	return listener, nil
+}
+
+// Files opened on first use, so that opening a FIFO does not wait for the
+// other end before the emulation runs.
+type FilePair struct {
+	inPath, outPath string
+
+	lock    sync.Mutex
+	in, out *os.File
+	closed  bool
+}
+
+func OpenFiles(in, out string) *FilePair {
+	return &FilePair{inPath: in, outPath: out}
+}
+
+// The open file, opening it on first use without holding the lock.
+func (pair *FilePair) file(file **os.File, open func() (*os.File, error)) (*os.File, error) {
+	pair.lock.Lock()
+	opened, closed := *file, pair.closed
+	pair.lock.Unlock()
+	if closed {
+		return nil, os.ErrClosed
+	}
+	if opened != nil {
+		return opened, nil
+	}
+	opened, err := open()
+	if err != nil {
+		return nil, err
+	}
+	pair.lock.Lock()
+	defer pair.lock.Unlock()
+	if pair.closed {
+		opened.Close()
+		return nil, os.ErrClosed
+	}
+	*file = opened
+	return opened, nil
+}
+
+func (pair *FilePair) Read(data []byte) (int, error) {
+	in, err := pair.file(&pair.in, func() (*os.File, error) {
+		return os.Open(pair.inPath)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return in.Read(data)
+}
+
+func (pair *FilePair) Write(data []byte) (int, error) {
+	out, err := pair.file(&pair.out, func() (*os.File, error) {
+		return os.OpenFile(pair.outPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return out.Write(data)
+}
+
+// Closes the files opened, one still being opened is closed once it is.
+func (pair *FilePair) Close() error {
+	pair.lock.Lock()
+	defer pair.lock.Unlock()
+	pair.closed = true
+	var err error
+	for _, file := range []*os.File{pair.in, pair.out} {
+		if file == nil {
+			continue
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package tape
+
+import "math"
+
+// Records the bits sent to the cassette port as UEF chunks: the idle line
+// as carrier, frames as 8N1 data bytes, the format the MOS saves with.
+type Recorder struct {
+	uef  *UEF
+	baud int
+
+	carrierCycles int
+	data          []byte
+
+	inFrame bool
+	bit     int
+	shift   byte
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{uef: New()}
+}
+
+func (recorder *Recorder) flushCarrier() {
+	for recorder.carrierCycles > 0 {
+		cycles := recorder.carrierCycles
+		if cycles > math.MaxUint16 {
+			cycles = math.MaxUint16
+		}
+		recorder.uef.Add(ChunkCarrier, Uint16Data(uint16(cycles)))
+		recorder.carrierCycles -= cycles
+	}
+}
+
+func (recorder *Recorder) flushData() {
+	if len(recorder.data) > 0 {
+		recorder.uef.Add(ChunkData, recorder.data)
+		recorder.data = nil
+	}
+}
+
+// A bit cell of the cassette output at the baud rate.
+func (recorder *Recorder) Bit(level bool, baud int) {
+	if baud != recorder.baud {
+		recorder.flushCarrier()
+		recorder.flushData()
+		recorder.inFrame = false
+		recorder.baud = baud
+		recorder.uef.Add(ChunkBaudRate, Uint16Data(uint16(baud)))
+	}
+
+	if !recorder.inFrame {
+		if level {
+			// a 1 is two carrier cycles at 1200 baud
+			recorder.flushData()
+			recorder.carrierCycles += 2 * DefaultBaseFrequency / baud
+			return
+		}
+		recorder.flushCarrier()
+		recorder.inFrame = true
+		recorder.bit = 0
+		recorder.shift = 0
+		return
+	}
+	if recorder.bit < 8 {
+		if level {
+			recorder.shift |= 1 << recorder.bit
+		}
+		recorder.bit++
+		return
+	}
+	// stop bit
+	recorder.inFrame = false
+	recorder.data = append(recorder.data, recorder.shift)
+}
+
+// Ends the pending chunks, when the motor stops.
+func (recorder *Recorder) Flush() {
+	recorder.flushCarrier()
+	recorder.flushData()
+	recorder.inFrame = false
+}
+
+// The image recorded so far.
+func (recorder *Recorder) GetUEF() *UEF {
+	recorder.Flush()
+	return recorder.uef
+}
+
+// Renders an image as audio, sine waves at the tape frequencies a real
+// machine can load from.
+func Render(uef *UEF, sampleRate int) []int16 {
+	const amplitude = 16384
+	samples := []int16{}
+	player := NewPlayer(uef)
+	phase, time := 0.0, 0.0
+	for {
+		segment, ok := player.Next()
+		if !ok {
+			return samples
+		}
+		frequency := 0.0
+		switch segment.Tone {
+		case HighTone:
+			frequency = 2 * player.baseFrequency
+		case LowTone:
+			frequency = player.baseFrequency
+		}
+		// samples are taken at multiples of the sample period
+		for time += segment.Duration; time > 0; time -= 1 / float64(sampleRate) {
+			sample := 0.0
+			if frequency == 0 {
+				phase = 0
+			} else {
+				sample = math.Sin(2 * math.Pi * phase)
+				phase += frequency / float64(sampleRate)
+				phase -= math.Floor(phase)
+			}
+			samples = append(samples, int16(sample*amplitude))
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package tape
+
+import "encoding/binary"
+
+// Tape signal: Acorn computers use a 1200Hz cycle for a 0 bit and two 2400Hz
+// cycles for a 1 at 1200 baud, four and eight cycles at 300 baud. The idle
+// line is a continuous 2400Hz carrier.
+
+type Tone int
+
+const (
+	Silence Tone = iota
+	LowTone
+	HighTone
+)
+
+const (
+	DefaultBaseFrequency = 1200
+	DefaultBaud          = 1200
+)
+
+func (tone Tone) String() string {
+	switch tone {
+	case LowTone:
+		return "low tone"
+	case HighTone:
+		return "high tone"
+	}
+	return "silence"
+}
+
+// A stretch of constant tone.
+type Segment struct {
+	Tone Tone
+	// seconds
+	Duration float64
+}
+
+// Plays the chunks of an image as tape signal segments.
+type Player struct {
+	uef           *UEF
+	chunk         int
+	pending       []Segment
+	baseFrequency float64
+	baud          float64
+}
+
+func NewPlayer(uef *UEF) *Player {
+	player := &Player{uef: uef}
+	player.Rewind()
+	return player
+}
+
+func (player *Player) Rewind() {
+	player.chunk = 0
+	player.pending = nil
+	player.baseFrequency = DefaultBaseFrequency
+	player.baud = DefaultBaud
+}
+
+// Index of the next chunk to be played.
+func (player *Player) GetChunk() int {
+	return player.chunk
+}
+
+func (player *Player) IsEnded() bool {
+	return len(player.pending) == 0 && player.chunk >= len(player.uef.Chunks)
+}
+
+// Next segment of signal, false at the end of the tape.
+func (player *Player) Next() (Segment, bool) {
+	for len(player.pending) == 0 {
+		if player.chunk >= len(player.uef.Chunks) {
+			return Segment{}, false
+		}
+		player.pending = player.expand(player.uef.Chunks[player.chunk])
+		player.chunk++
+	}
+	segment := player.pending[0]
+	player.pending = player.pending[1:]
+	return segment, true
+}
+
+func (player *Player) bit(bit bool) Segment {
+	if bit {
+		return Segment{HighTone, 1 / player.baud}
+	}
+	return Segment{LowTone, 1 / player.baud}
+}
+
+// Serial frame of a byte: start bit, data bits, parity and stop bits.
+func (player *Player) frame(value byte, bits int, parity byte, stop int) []Segment {
+	segments := []Segment{player.bit(false)}
+	ones := 0
+	for i := 0; i < bits; i++ {
+		bit := value&(1<<i) != 0
+		if bit {
+			ones++
+		}
+		segments = append(segments, player.bit(bit))
+	}
+	switch parity {
+	case 'E':
+		segments = append(segments, player.bit(ones%2 == 1))
+	case 'O':
+		segments = append(segments, player.bit(ones%2 == 0))
+	}
+	for i := 0; i < stop; i++ {
+		segments = append(segments, player.bit(true))
+	}
+	return segments
+}
+
+func (player *Player) carrier(cycles uint16) Segment {
+	return Segment{HighTone, float64(cycles) / (2 * player.baseFrequency)}
+}
+
+func (player *Player) expand(chunk Chunk) []Segment {
+	segments := []Segment{}
+	switch chunk.ID {
+	case ChunkData:
+		for _, value := range chunk.Data {
+			segments = append(segments, player.frame(value, 8, 'N', 1)...)
+		}
+	case ChunkDefinedData:
+		if len(chunk.Data) < 3 {
+			break
+		}
+		bits, parity, stop := int(chunk.Data[0]), chunk.Data[1], int(int8(chunk.Data[2]))
+		// negative counts are stop bits with an extra short wave
+		if stop < 0 {
+			stop = -stop
+		}
+		for _, value := range chunk.Data[3:] {
+			segments = append(segments, player.frame(value, bits, parity, stop)...)
+		}
+	case ChunkRawData:
+		if len(chunk.Data) < 1 {
+			break
+		}
+		bits := (len(chunk.Data)-1)*8 - int(chunk.Data[0])
+		for i := 0; i < bits; i++ {
+			segments = append(segments, player.bit(chunk.Data[1+i/8]&(1<<(i%8)) != 0))
+		}
+	case ChunkCarrier:
+		segments = append(segments, player.carrier(chunk.uint16At(0)))
+	case ChunkCarrierDummy:
+		segments = append(segments, player.carrier(chunk.uint16At(0)))
+		segments = append(segments, player.frame(0xAA, 8, 'N', 1)...)
+		segments = append(segments, player.carrier(chunk.uint16At(2)))
+	case ChunkGap:
+		segments = append(segments, Segment{Silence, float64(chunk.uint16At(0)) / (2 * player.baseFrequency)})
+	case ChunkFloatGap:
+		segments = append(segments, Segment{Silence, chunk.float32At(0)})
+	case ChunkBaseFrequency:
+		if frequency := chunk.float32At(0); frequency > 0 {
+			player.baseFrequency = frequency
+		}
+	case ChunkBaudRate:
+		if baud := chunk.uint16At(0); baud != 0 {
+			player.baud = float64(baud)
+		}
+	case ChunkSecurity:
+		// 24 bits cycle count, first and last half cycle markers, then a bit
+		// per cycle, most significant first: 1 for a high cycle
+		if len(chunk.Data) < 5 {
+			break
+		}
+		cycles := int(binary.LittleEndian.Uint32(append(chunk.Data[:3:3], 0)))
+		for i := 0; i < cycles && 5+i/8 < len(chunk.Data); i++ {
+			if chunk.Data[5+i/8]&(0x80>>(i%8)) != 0 {
+				segments = append(segments, Segment{HighTone, 1 / (2 * player.baseFrequency)})
+			} else {
+				segments = append(segments, Segment{LowTone, 1 / player.baseFrequency})
+			}
+		}
+	}
+	return segments
+}
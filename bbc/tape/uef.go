@@ -0,0 +1,144 @@
+package tape
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// UEF tape images: a header followed by chunks, each an identifier, a
+// length and its data. Files are often gzip compressed.
+
+const uefMagic = "UEF File!\x00"
+
+// chunk identifiers
+const (
+	ChunkOrigin        = 0x0000
+	ChunkData          = 0x0100
+	ChunkRawData       = 0x0102
+	ChunkDefinedData   = 0x0104
+	ChunkCarrier       = 0x0110
+	ChunkCarrierDummy  = 0x0111
+	ChunkGap           = 0x0112
+	ChunkBaseFrequency = 0x0113
+	ChunkSecurity      = 0x0114
+	ChunkPhase         = 0x0115
+	ChunkFloatGap      = 0x0116
+	ChunkBaudRate      = 0x0117
+)
+
+type Chunk struct {
+	ID   uint16
+	Data []byte
+}
+
+type UEF struct {
+	Major, Minor byte
+	Chunks       []Chunk
+}
+
+func Read(input io.Reader) (*UEF, error) {
+	buffered := bufio.NewReader(input)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1F && magic[1] == 0x8B {
+		unzipped, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer unzipped.Close()
+		buffered = bufio.NewReader(unzipped)
+	}
+
+	header := make([]byte, len(uefMagic)+2)
+	if _, err := io.ReadFull(buffered, header); err != nil {
+		return nil, fmt.Errorf("reading UEF header: %w", err)
+	}
+	if string(header[:len(uefMagic)]) != uefMagic {
+		return nil, fmt.Errorf("not a UEF file")
+	}
+	uef := &UEF{Minor: header[len(uefMagic)], Major: header[len(uefMagic)+1]}
+	for {
+		chunkHeader := make([]byte, 6)
+		if _, err := io.ReadFull(buffered, chunkHeader); err == io.EOF {
+			return uef, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading UEF chunk: %w", err)
+		}
+		chunk := Chunk{
+			ID:   binary.LittleEndian.Uint16(chunkHeader),
+			Data: make([]byte, binary.LittleEndian.Uint32(chunkHeader[2:])),
+		}
+		if _, err := io.ReadFull(buffered, chunk.Data); err != nil {
+			return nil, fmt.Errorf("reading UEF chunk &%04X: %w", chunk.ID, err)
+		}
+		uef.Chunks = append(uef.Chunks, chunk)
+	}
+}
+
+func Load(path string) (*UEF, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Writes the image uncompressed.
+func (uef *UEF) Write(output io.Writer) error {
+	buffer := bytes.NewBufferString(uefMagic)
+	buffer.Write([]byte{uef.Minor, uef.Major})
+	for _, chunk := range uef.Chunks {
+		binary.Write(buffer, binary.LittleEndian, chunk.ID)
+		binary.Write(buffer, binary.LittleEndian, uint32(len(chunk.Data)))
+		buffer.Write(chunk.Data)
+	}
+	_, err := output.Write(buffer.Bytes())
+	return err
+}
+
+func (uef *UEF) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := uef.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (uef *UEF) Add(id uint16, data []byte) {
+	uef.Chunks = append(uef.Chunks, Chunk{ID: id, Data: data})
+}
+
+func New() *UEF {
+	return &UEF{Major: 0, Minor: 10, Chunks: []Chunk{}}
+}
+
+func (chunk Chunk) uint16At(offset int) uint16 {
+	if offset+2 > len(chunk.Data) {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(chunk.Data[offset:])
+}
+
+func (chunk Chunk) float32At(offset int) float64 {
+	if offset+4 > len(chunk.Data) {
+		return 0
+	}
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(chunk.Data[offset:])))
+}
+
+func Uint16Data(value uint16) []byte {
+	return binary.LittleEndian.AppendUint16(nil, value)
+}
+
+func Float32Data(value float64) []byte {
+	return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(value)))
+}
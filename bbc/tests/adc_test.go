@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"testing"
+)
+
+func TestUPD7002Conversion(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	bbc.ADC.SetJoystick(0, -1, 0.5)
+	bbc.ADC.SetChannel(2, 0x1234)
+
+	// channel 1 in 8 bits takes 4ms
+	bbc.Bus.DirectWrite(0x01, 0xFEC0)
+	if status, _ := bbc.Bus.DirectRead(0xFEC0); status != hardware.UPD7002NotEOC|0x01 {
+		t.Fatalf("converting status %02X", status)
+	}
+	bbc.RunCycles(7800)
+	if !bbc.ADC.IsConverting() {
+		t.Fatal("8 bit conversion ended early")
+	}
+	bbc.RunCycles(400)
+	if bbc.ADC.IsConverting() {
+		t.Fatal("8 bit conversion not ended")
+	}
+	high, _ := bbc.Bus.DirectRead(0xFEC1)
+	low, _ := bbc.Bus.DirectRead(0xFEC2)
+	status, _ := bbc.Bus.DirectRead(0xFEC0)
+	if high != 0xBF || low != 0 || status != hardware.UPD7002NotBusy|0x20|0x01 {
+		t.Fatalf("result %02X%02X status %02X", high, low, status)
+	}
+	// end of conversion on CB1
+	if flags, _ := bbc.Bus.DirectRead(0xFE4D); flags&hardware.VIAInterruptCB1 == 0 {
+		t.Fatalf("no CB1 interrupt, flags %02X", flags)
+	}
+
+	// channel 2 in 10 bits takes 10ms
+	bbc.Bus.DirectWrite(0x0A, 0xFEC0)
+	bbc.RunCycles(19800)
+	if !bbc.ADC.IsConverting() {
+		t.Fatal("10 bit conversion ended early")
+	}
+	bbc.RunCycles(400)
+	high, _ = bbc.Bus.DirectRead(0xFEC1)
+	low, _ = bbc.Bus.DirectRead(0xFEC2)
+	if high != 0x12 || low != 0x00 {
+		t.Fatalf("10 bit result %02X%02X", high, low)
+	}
+	bbc.ADC.SetChannel(2, 0xFFFF)
+	bbc.Bus.DirectWrite(0x0A, 0xFEC0)
+	bbc.RunCycles(20200)
+	if low, _ := bbc.Bus.DirectRead(0xFEC2); low != 0xC0 {
+		t.Fatalf("10 bit low byte %02X", low)
+	}
+
+	// the joystick left is the top of the range
+	bbc.Bus.DirectWrite(0x00, 0xFEC0)
+	bbc.RunCycles(8200)
+	if high, _ := bbc.Bus.DirectRead(0xFEC1); high != 0xFF {
+		t.Fatalf("left joystick %02X", high)
+	}
+}
+
+func TestFireButtons(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	if value, _ := bbc.Bus.DirectRead(0xFE40); value&0x30 != 0x30 {
+		t.Fatalf("buttons up read %02X", value)
+	}
+	bbc.ADC.SetFireButton(1, true)
+	if value, _ := bbc.Bus.DirectRead(0xFE40); value&0x30 != 0x10 {
+		t.Fatalf("button 1 down read %02X", value)
+	}
+	bbc.ADC.SetFireButton(0, true)
+	bbc.ADC.SetFireButton(1, false)
+	if value, _ := bbc.Bus.DirectRead(0xFE40); value&0x30 != 0x20 || !bbc.ADC.IsFireButtonPressed(0) {
+		t.Fatalf("button 0 down read %02X", value)
+	}
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"bbc/adfs"
+	"bbc/disc"
+	"bbc/hardware"
+	"bbc/machine"
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func newTestADFS(t *testing.T, device adfs.Device) *adfs.FileSystem {
+	fs, err := adfs.Format(device)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := fs.MakeDirectory("$.GAMES"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := fs.MakeDirectory("GAMES.ARCADE"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := fs.WriteFile("$.GAMES.ARCADE.Zap", 0xFFFF1900, 0xFFFF8023, bytes.Repeat([]byte("zap!"), 300)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := fs.WriteFile("!BOOT", 0, 0, []byte("*RUN GAMES.ARCADE.ZAP\r")); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := fs.SetBoot(adfs.BootExec); err != nil {
+		t.Fatalf(err.Error())
+	}
+	return fs
+}
+
+func TestADFSFloppy(t *testing.T) {
+	image, err := adfs.NewFloppyDisc(adfs.LargeSectors)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	newTestADFS(t, adfs.NewFloppy(image))
+	path := filepath.Join(t.TempDir(), "games.adl")
+	if err := image.Save(path); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	loaded, err := disc.Load(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	fs, err := adfs.Open(adfs.NewFloppy(loaded))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if fs.Sectors != adfs.LargeSectors || fs.Boot != adfs.BootExec {
+		t.Fatalf("map of %d sectors, boot %d", fs.Sectors, fs.Boot)
+	}
+	root, err := fs.ReadDirectory("$")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(root.Entries) != 2 || root.Entries[0].Name != "!BOOT" || root.Entries[1].GetAttributes() != "DLR" {
+		t.Fatalf("root directory %+v", root.Entries)
+	}
+	entry, data, err := fs.ReadFile("games.arcade.zap")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if entry.Load != 0xFFFF1900 || entry.Length != 1200 || !bytes.Equal(data, bytes.Repeat([]byte("zap!"), 300)) {
+		t.Fatalf("file %+v", entry)
+	}
+	arcade, err := fs.ReadDirectory("GAMES.ARCADE")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	games, _ := fs.Lookup("GAMES")
+	if arcade.Parent != games.Start || arcade.Name != "ARCADE" {
+		t.Fatalf("directory %+v", arcade)
+	}
+	// logical sectors alternate between the sides a track at a time
+	if loaded.GetTrack(1, 0).Find(0, 0) == nil || loaded.GetSides() != 2 || loaded.GetTrackCount() != 80 {
+		t.Fatal("L format layout")
+	}
+
+	if err := fs.Delete("GAMES"); err == nil {
+		t.Fatal("locked directory deleted")
+	}
+	if err := fs.SetAccess("GAMES", ""); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := fs.Delete("GAMES"); err == nil {
+		t.Fatal("directory not empty deleted")
+	}
+	free := fs.GetFreeSectors()
+	if err := fs.Delete("!BOOT"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if fs.GetFreeSectors() != free+1 {
+		t.Fatalf("%d sectors free after deleting from %d", fs.GetFreeSectors(), free)
+	}
+
+	// a replacement too large keeps the file and its sectors
+	free = fs.GetFreeSectors()
+	if err := fs.WriteFile("GAMES.ARCADE.ZAP", 0, 0, make([]byte, adfs.LargeSectors*256)); err == nil {
+		t.Fatal("file larger than the disc written")
+	}
+	if fs.GetFreeSectors() != free {
+		t.Fatalf("%d sectors free after a failed write from %d", fs.GetFreeSectors(), free)
+	}
+	if err := fs.WriteFile("GAMES.ARCADE.ZIP", 0, 0, bytes.Repeat([]byte("zip!"), 300)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, data, err := fs.ReadFile("GAMES.ARCADE.ZAP"); err != nil || !bytes.Equal(data, bytes.Repeat([]byte("zap!"), 300)) {
+		t.Fatal("file overwritten after a failed replacement")
+	}
+}
+
+func TestADFSHardDisc(t *testing.T) {
+	hard := adfs.NewHardDisc(4000)
+	newTestADFS(t, hard)
+	path := filepath.Join(t.TempDir(), "scsi0.dat")
+	if err := hard.Save(path); err != nil {
+		t.Fatalf(err.Error())
+	}
+	loaded, err := adfs.OpenHardDisc(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	fs, err := adfs.Open(loaded)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, data, err := fs.ReadFile("!BOOT"); err != nil || string(data) != "*RUN GAMES.ARCADE.ZAP\r" {
+		t.Fatalf("!BOOT read %q, %v", data, err)
+	}
+	if err := fs.WriteFile("BIG", 0, 0, make([]byte, 3990*256)); err == nil {
+		t.Fatal("file larger than the free space written")
+	}
+	// the space freed is merged back, after the two directories
+	for _, path := range []string{"$.GAMES.ARCADE.ZAP", "!BOOT"} {
+		if err := fs.Delete(path); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if len(fs.Free) != 1 || fs.Free[0].Start != 17 || fs.Free[0].Length != 4000-17 {
+		t.Fatalf("free space %+v", fs.Free)
+	}
+}
+
+// The 1770 reads the map of an ADFS floppy in double density.
+func TestADFSController(t *testing.T) {
+	image, _ := adfs.NewFloppyDisc(adfs.MediumSectors)
+	newTestADFS(t, adfs.NewFloppy(image))
+	bbc := newDiscMachine(t, machine.DiscAcorn1770, image)
+	bbc.Bus.DirectWrite(0x21, 0xFE80)
+	wd1770Command(t, bbc, 0xFE84, 0x00)
+	bbc.Bus.DirectWrite(1, 0xFE86)
+	if status := wd1770Command(t, bbc, 0xFE84, 0x80); status&^hardware.WD1770MotorOn != 0 {
+		t.Fatalf("read status %02X", status)
+	}
+	lengths := readMemory(bbc, 0x3000, 256)
+	if lengths[0xFD] != adfs.BootExec || lengths[0xFE] != 3 {
+		t.Fatalf("map read %02X", lengths[0xF0:])
+	}
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"bbc/logical"
 	"testing"
 )
 
@@ -77,3 +78,79 @@ func TestTAX(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSEIBRK(t *testing.T) {
+	testCtx.Reset()
+	cpu := testCtx.cpu
+	cpu.ProgramCounter = 0x0200
+	cpu.StackPointer = 0xFF
+	cpu.SetRegister(0x00, logical.RegisterStatus)
+
+	program := []byte{
+		0x78,       // SEI
+		0x00, 0xEA, // BRK, signature byte
+	}
+	testCtx.bus.WriteMultiple(program, 0x0200)
+	testCtx.bus.WriteMultiple([]byte{0x40}, 0x0300) // RTI
+	testCtx.bus.WriteMultiple([]byte{0x00, 0x03}, logical.IRQVectorAddr0)
+
+	if err := cpu.ExecuteNext(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !cpu.GetStatus(logical.InterruptDisableFlagBit) || cpu.GetStatus(logical.CarryFlagBit) {
+		t.Fatalf("status %02X after SEI", cpu.GetRegister(logical.RegisterStatus))
+	}
+
+	if err := cpu.ExecuteNext(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cpu.ProgramCounter != 0x0300 || cpu.StackPointer != 0xFC {
+		t.Fatalf("PC %04X, SP %02X after BRK", cpu.ProgramCounter, cpu.StackPointer)
+	}
+	// return address past the signature byte, high byte first, then the
+	// status with B and the unused bit set
+	stack := []byte{}
+	for addr := uint16(0x01FD); addr <= 0x01FF; addr++ {
+		value, _ := testCtx.bus.DirectRead(addr)
+		stack = append(stack, value)
+	}
+	if stack[0] != 0x34 || stack[1] != 0x03 || stack[2] != 0x02 {
+		t.Fatalf("stack %02X after BRK", stack)
+	}
+
+	if err := cpu.ExecuteNext(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cpu.ProgramCounter != 0x0203 || !cpu.GetStatus(logical.InterruptDisableFlagBit) {
+		t.Fatalf("PC %04X, status %02X after RTI", cpu.ProgramCounter, cpu.GetRegister(logical.RegisterStatus))
+	}
+}
+
+func TestINCDEC(t *testing.T) {
+	testCtx.Reset()
+	cpu := testCtx.cpu
+	cpu.ProgramCounter = 0x0200
+
+	program := []byte{
+		0xE6, 0x80, // INC $80
+		0xC6, 0x81, // DEC $81
+	}
+	testCtx.bus.WriteMultiple(program, 0x0200)
+	testCtx.bus.WriteMultiple([]byte{0xFF, 0x00}, 0x0080)
+
+	if err := cpu.ExecuteNext(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	value, _ := testCtx.bus.DirectRead(0x0080)
+	if value != 0x00 || !cpu.GetStatus(logical.ZeroFlagBit) || cpu.GetStatus(logical.NegativeFlagBit) {
+		t.Fatalf("value %02X, status %02X after INC", value, cpu.GetRegister(logical.RegisterStatus))
+	}
+
+	if err := cpu.ExecuteNext(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	value, _ = testCtx.bus.DirectRead(0x0081)
+	if value != 0xFF || cpu.GetStatus(logical.ZeroFlagBit) || !cpu.GetStatus(logical.NegativeFlagBit) {
+		t.Fatalf("value %02X, status %02X after DEC", value, cpu.GetRegister(logical.RegisterStatus))
+	}
+}
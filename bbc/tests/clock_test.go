@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"testing"
+	"time"
+)
+
+func TestClockPacing(t *testing.T) {
+	clock := hardware.NewClock(1e6)
+	if err := clock.Start(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer clock.Stop()
+
+	start := time.Now()
+	// 20ms of emulated time
+	for i := 0; i < 20000; i++ {
+		clock.Tick()
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Fatalf("throttled clock ran too fast (%s)", elapsed)
+	}
+
+	clock.SetTurbo(true)
+	start = time.Now()
+	for i := 0; i < 200000; i++ {
+		clock.Tick()
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("turbo clock was throttled (%s)", elapsed)
+	}
+}
+
+func TestClockPauseStop(t *testing.T) {
+	clock := hardware.NewClock(2e6)
+	cpu := hardware.NewCPU(clock)
+	ram := hardware.NewRAM()
+	bus, err := hardware.NewBus(clock, cpu, ram)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// JMP 0000
+	bus.WriteMultiple([]byte{0x4C, 0x00, 0x00}, 0x0000)
+
+	clock.SetTurbo(true)
+	if err := clock.Start(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	done := make(chan error)
+	go func() { done <- cpu.Start() }()
+
+	time.Sleep(10 * time.Millisecond)
+	if err := clock.Pause(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	time.Sleep(10 * time.Millisecond)
+	paused := clock.GetCycles()
+	time.Sleep(10 * time.Millisecond)
+	// an instruction in flight may still complete
+	if clock.GetCycles()-paused > 8 {
+		t.Fatal("clock kept running while paused")
+	}
+
+	if err := clock.Resume(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	time.Sleep(10 * time.Millisecond)
+	if clock.GetCycles() == paused {
+		t.Fatal("clock did not resume")
+	}
+
+	clock.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cpu did not stop with the clock")
+	}
+}
+
+// Speed and turbo are changed from another goroutine, run with -race.
+func TestClockSpeedWhileTicking(t *testing.T) {
+	clock := hardware.NewClock(1e6)
+	if err := clock.Start(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer clock.Stop()
+	clock.SetTurbo(true)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 200000; i++ {
+			clock.Tick()
+		}
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			clock.SetSpeed(100)
+			clock.SetTurbo(false)
+			clock.SetTurbo(true)
+		}
+	}
+}
+
+func TestVirtualClockStats(t *testing.T) {
+	clock := hardware.NewVirtualClock(1000)
+	cpu := hardware.NewCPU(clock)
+	ram := hardware.NewRAM()
+	bus, err := hardware.NewBus(clock, cpu, ram)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// NOP; JMP 0000
+	bus.WriteMultiple([]byte{0xEA, 0x4C, 0x00, 0x00}, 0x0000)
+	clock.Reset()
+
+	samples := []hardware.ClockStats{}
+	clock.OnStats(func(stats hardware.ClockStats) {
+		samples = append(samples, stats)
+	})
+	if err := clock.Start(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	for clock.GetCycles() < 2000 {
+		if err := cpu.ExecuteNext(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	clock.Stop()
+
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(samples))
+	}
+	// NOP + JMP loop is 5 cycles for 2 instructions, the JMP in flight at the
+	// sampling cycle is not counted yet
+	if samples[0].Cycles != 1000 || samples[0].CyclesPerSecond != 1000 || samples[0].InstructionsPerSecond != 399 {
+		t.Fatalf("unexpected statistics %+v", samples[0])
+	}
+	// emulated seconds say nothing about the speed against the wall clock
+	for _, stats := range samples {
+		if stats.RealTimePercent != 0 {
+			t.Fatalf("virtual clock reported %.1f%% of real time", stats.RealTimePercent)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"bbc/utils"
+	"testing"
+)
+
+func newCRTCBus(t *testing.T) (*hardware.Bus, *hardware.CRTC) {
+	bus, via := newVIABus(t)
+	crtc := hardware.NewCRTC("CRTC", utils.NewSegment(0xFE00, 0xFE07), via)
+	if err := bus.AddComponent(crtc); err != nil {
+		t.Fatalf(err.Error())
+	}
+	return bus, crtc
+}
+
+func writeCRTC(bus *hardware.Bus, registers []byte) {
+	for register, value := range registers {
+		bus.DirectWrite(byte(register), 0xFE00)
+		bus.DirectWrite(value, 0xFE01)
+	}
+}
+
+func TestCRTCFrame(t *testing.T) {
+	bus, crtc := newCRTCBus(t)
+	// 10 characters per line, 4 displayed, 5 rows of 2 lines, 3 displayed,
+	// 1 adjust line: 110 characters per frame
+	writeCRTC(bus, []byte{9, 4, 6, 0x22, 4, 1, 3, 4, 0, 1, 0, 0, 0x01, 0x00})
+	// CA1 interrupt enabled
+	bus.DirectWrite(0x7F, 0xFE4D)
+	bus.DirectWrite(0x82, 0xFE4E)
+	crtc.Reset()
+
+	signals := []hardware.CRTCSignals{}
+	crtc.Observe(func(s hardware.CRTCSignals) {
+		signals = append(signals, s)
+	})
+	for i := 0; i < 110; i++ {
+		bus.Tick()
+	}
+	crtc.Sync()
+	if len(signals) != 110 || crtc.GetFrame() != 1 {
+		t.Fatalf("%d characters in frame %d", len(signals), crtc.GetFrame())
+	}
+
+	displayed, hsyncs, vsyncs := 0, 0, 0
+	for _, s := range signals {
+		if s.Display {
+			displayed++
+		}
+		if s.HSync {
+			hsyncs++
+		}
+		if s.VSync {
+			vsyncs++
+		}
+	}
+	if displayed != 24 || hsyncs != 22 || vsyncs != 20 {
+		t.Fatalf("%d displayed, %d hsync, %d vsync characters", displayed, hsyncs, vsyncs)
+	}
+	if signals[0].Address != 0x100 || signals[10].Address != 0x100 || signals[10].Raster != 1 {
+		t.Fatal("second scan line does not restart the row")
+	}
+	if signals[20].Address != 0x104 || signals[20].Raster != 0 {
+		t.Fatalf("second row starts at %x", signals[20].Address)
+	}
+	if !bus.IRQ.IsAsserted() {
+		t.Fatal("vsync did not reach the VIA CA1")
+	}
+}
+
+func TestCRTCRegistersAndCursor(t *testing.T) {
+	bus, crtc := newCRTCBus(t)
+	// cursor on address 0x101 lines 0 to 1, steady
+	writeCRTC(bus, []byte{9, 4, 6, 0x22, 4, 0, 3, 4, 0, 1, 0x00, 0x01, 0x01, 0x00, 0x01, 0x01})
+	crtc.Reset()
+
+	bus.DirectWrite(hardware.CRTCCursorLow, 0xFE00)
+	if value, _ := bus.DirectRead(0xFE01); value != 0x01 {
+		t.Fatalf("cursor register read %x", value)
+	}
+	bus.DirectWrite(hardware.CRTCStartAddressHigh, 0xFE00)
+	if value, _ := bus.DirectRead(0xFE01); value != 0 {
+		t.Fatal("start address is write only")
+	}
+
+	cursors := []uint16{}
+	crtc.Observe(func(s hardware.CRTCSignals) {
+		if s.Cursor {
+			cursors = append(cursors, s.Address)
+		}
+	})
+	for i := 0; i < 100; i++ {
+		bus.Tick()
+	}
+	crtc.Sync()
+	if len(cursors) != 2 || cursors[0] != 0x101 {
+		t.Fatalf("cursor shown at %v", cursors)
+	}
+
+	// cursor skew delays it by one character
+	crtc.SetRegister(hardware.CRTCInterlace, 0x40)
+	cursors = cursors[:0]
+	for i := 0; i < 100; i++ {
+		bus.Tick()
+	}
+	crtc.Sync()
+	if len(cursors) != 2 || cursors[0] != 0x102 {
+		t.Fatalf("skewed cursor shown at %v", cursors)
+	}
+
+	crtc.SetLightPen(true)
+	bus.DirectWrite(hardware.CRTCLightPenHigh, 0xFE00)
+	high, _ := bus.DirectRead(0xFE01)
+	if high != 0x01 {
+		t.Fatalf("light pen high %x", high)
+	}
+}
+
+// Vsync reaches the VIA at the end of its scan line without the CRTC being
+// accessed.
+func TestCRTCVSyncEvent(t *testing.T) {
+	bus, crtc := newCRTCBus(t)
+	// 10 characters per line, vsync from row 4 of 2 lines: line 8
+	writeCRTC(bus, []byte{9, 4, 6, 0x22, 4, 1, 3, 4, 0, 1, 0, 0, 0x01, 0x00})
+	// CA1 interrupt on the rising edge
+	bus.DirectWrite(0x01, 0xFE4C)
+	bus.DirectWrite(0x82, 0xFE4E)
+	bus.DirectWrite(0x7F, 0xFE4D)
+	crtc.Reset()
+
+	due, ok := bus.NextCycle()
+	if !ok || due != bus.GetCycles()+10 {
+		t.Fatalf("line end scheduled at %d from %d", due, bus.GetCycles())
+	}
+	for i := 0; i < 79; i++ {
+		bus.Tick()
+	}
+	if bus.IRQ.IsAsserted() {
+		t.Fatal("vsync before line 8")
+	}
+	bus.Tick()
+	if !bus.IRQ.IsAsserted() {
+		t.Fatal("vsync not raised at the end of line 7")
+	}
+}
@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"bbc/dfs"
+	"bbc/disc"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func newTestVolume(t *testing.T, tracks int) (*dfs.Image, *dfs.Volume) {
+	image, err := dfs.Blank(1, tracks)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	volume, err := image.Volume(0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return image, volume
+}
+
+func TestDFSCatalogue(t *testing.T) {
+	image, volume := newTestVolume(t, disc.Tracks80)
+	if volume.Sectors != 800 || len(volume.Files) != 0 || volume.Cycle != 0 {
+		t.Fatalf("blank catalogue %+v", volume)
+	}
+	data := bytes.Repeat([]byte{0xAA}, 1000)
+	err := volume.AddFile(dfs.File{Directory: 'B', Name: "GAME", Load: 0xFF1900, Exec: 0xFF8023, Locked: true}, data)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := volume.SetBoot(dfs.BootExec); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := volume.SetTitle("ADVENTURES"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// read back from the image file
+	path := filepath.Join(t.TempDir(), "cat.ssd")
+	if err := image.Save(path); err != nil {
+		t.Fatalf(err.Error())
+	}
+	saved, err := dfs.Open(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if volume, err = saved.Volume(0); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if volume.Title != "ADVENTURES" || volume.Boot != dfs.BootExec || volume.Cycle != 0x03 {
+		t.Fatalf("catalogue %q boot %d cycle %02X", volume.Title, volume.Boot, volume.Cycle)
+	}
+	file, read, err := volume.ReadFile("b.game")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if file.Load != 0xFF1900 || file.Exec != 0xFF8023 || file.Length != 1000 || file.Start != 2 || !file.Locked {
+		t.Fatalf("file %+v", file)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatal("file data read wrongly")
+	}
+	if volume.GetFreeSectors() != 794 {
+		t.Fatalf("%d sectors free", volume.GetFreeSectors())
+	}
+
+	// locked files stay
+	if err := volume.DeleteFile("B.GAME"); err == nil {
+		t.Fatal("locked file deleted")
+	}
+	if err := volume.RenameFile("B.GAME", "OTHER"); err == nil {
+		t.Fatal("locked file renamed")
+	}
+	if err := volume.AddFile(dfs.File{Directory: 'B', Name: "GAME"}, nil); err == nil {
+		t.Fatal("locked file replaced")
+	}
+	if err := volume.SetLocked("B.GAME", false); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := volume.RenameFile("B.GAME", "$.LOADER"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := volume.Find("LOADER"); !ok {
+		t.Fatal("renamed file not found")
+	}
+	for _, name := range []string{"TOOLONGNAME", "A.B.C", "SP ACE", "$.STAR*"} {
+		if err := volume.AddFile(dfs.File{Directory: '$', Name: name}, nil); err == nil {
+			t.Fatalf("bad name %s added", name)
+		}
+	}
+}
+
+func TestDFSLimits(t *testing.T) {
+	_, volume := newTestVolume(t, disc.Tracks40)
+	for i := 0; i < dfs.MaxFiles; i++ {
+		if err := volume.AddFile(dfs.File{Directory: '$', Name: fmt.Sprintf("F%d", i)}, make([]byte, 256)); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := volume.AddFile(dfs.File{Directory: '$', Name: "MORE"}, nil); err == nil {
+		t.Fatal("32nd file added")
+	}
+	// replacing a file does not take another entry
+	if err := volume.AddFile(dfs.File{Directory: '$', Name: "F3"}, make([]byte, 512)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := volume.DeleteFile("F0"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := volume.AddFile(dfs.File{Directory: '$', Name: "BIG"}, make([]byte, 400*disc.SectorSize)); err == nil {
+		t.Fatal("file larger than the disc added")
+	}
+}
+
+func TestDFSReplace(t *testing.T) {
+	_, volume := newTestVolume(t, disc.Tracks40)
+	data := bytes.Repeat([]byte("old!"), 200*disc.SectorSize/4)
+	if err := volume.AddFile(dfs.File{Directory: '$', Name: "DATA"}, data); err != nil {
+		t.Fatalf(err.Error())
+	}
+	old, _ := volume.Find("DATA")
+
+	// the old sectors are not reused while the new data is written
+	if err := volume.AddFile(dfs.File{Directory: '$', Name: "DATA"}, make([]byte, 300*disc.SectorSize)); err == nil {
+		t.Fatal("replacement larger than the space left added")
+	}
+	if _, read, err := volume.ReadFile("DATA"); err != nil || !bytes.Equal(read, data) {
+		t.Fatal("file damaged by a failed replacement")
+	}
+
+	if err := volume.AddFile(dfs.File{Directory: '$', Name: "DATA"}, []byte("new")); err != nil {
+		t.Fatalf(err.Error())
+	}
+	file, read, err := volume.ReadFile("DATA")
+	if err != nil || string(read) != "new" {
+		t.Fatalf("replaced file read %q, %v", read, err)
+	}
+	if file.Start < old.Start+old.GetSectors() {
+		t.Fatalf("replacement at sector %d inside the old file at %d", file.Start, old.Start)
+	}
+	// the catalogue takes 2 sectors, the new file 1
+	if len(volume.Files) != 1 || volume.GetFreeSectors() != volume.Sectors-3 {
+		t.Fatalf("%d files, %d sectors free after replacing", len(volume.Files), volume.GetFreeSectors())
+	}
+}
+
+func TestDFSCompact(t *testing.T) {
+	_, volume := newTestVolume(t, disc.Tracks40)
+	for i, size := range []int{3, 5, 2, 4} {
+		data := bytes.Repeat([]byte{byte(i + 1)}, size*disc.SectorSize-10)
+		if err := volume.AddFile(dfs.File{Directory: '$', Name: fmt.Sprintf("F%d", i)}, data); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := volume.DeleteFile("F1"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// a small file goes in the gap
+	if err := volume.AddFile(dfs.File{Directory: '$', Name: "GAP"}, []byte{9}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if file, _ := volume.Find("GAP"); file.Start != 5 {
+		t.Fatalf("file in the gap starts at %d", file.Start)
+	}
+	if err := volume.Compact(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	starts := map[string]int{"F0": 2, "GAP": 5, "F2": 6, "F3": 8}
+	for name, start := range starts {
+		file, data, err := volume.ReadFile(name)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if file.Start != start || data[0] != data[len(data)-1] {
+			t.Fatalf("%s at %d after compacting", name, file.Start)
+		}
+	}
+	if volume.Files[0].Name != "F3" || volume.GetFreeSectors() != 400-12 {
+		t.Fatalf("catalogue %+v", volume.Files)
+	}
+}
+
+func TestDFSINF(t *testing.T) {
+	file := dfs.File{Directory: '$', Name: "!BOOT", Load: 0xFF1900, Exec: 0xFF8023, Length: 0x123, Locked: true}
+	inf := dfs.FormatINF(file)
+	if inf != "$.!BOOT FF1900 FF8023 000123 L\n" {
+		t.Fatalf("sidecar %q", inf)
+	}
+	parsed, err := dfs.ParseINF("A.PROG  FFFF0E00 FFFF802B 00000400 Locked CRC=1234\n")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if parsed.Directory != 'A' || parsed.Name != "PROG" || parsed.Load != 0xFF0E00 || parsed.Exec != 0xFF802B || !parsed.Locked {
+		t.Fatalf("parsed %+v", parsed)
+	}
+}
@@ -0,0 +1,571 @@
+package tests
+
+import (
+	"bbc/disc"
+	"bbc/hardware"
+	"bbc/machine"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Sector dump where each byte tells its side, track, sector and offset.
+func testDiscImage(sides, tracks int) []byte {
+	image := []byte{}
+	for track := 0; track < tracks; track++ {
+		for side := 0; side < sides; side++ {
+			for sector := 0; sector < disc.DFSSectorsPerTrack; sector++ {
+				for i := 0; i < disc.SectorSize; i++ {
+					image = append(image, byte(side*0x80+track*10+sector)^byte(i))
+				}
+			}
+		}
+	}
+	return image
+}
+
+// NMI handler at 0D00, as the DFS installs: data bytes are moved from or to
+// the pointer at 70 when the status has the data request bit, to the
+// controller when bit 7 of 74 is set. Otherwise the result is stored at 72
+// and 73 counts down.
+func discNMIHandler(status uint16, request byte, data uint16, result uint16) []byte {
+	return []byte{
+		0x48,                                  // PHA
+		0x98,                                  // TYA
+		0x48,                                  // PHA
+		0xAD, byte(status), byte(status >> 8), // LDA status
+		0x29, request, // AND #request
+		0xF0, 0x1B, // BEQ result
+		0xA0, 0x00, // LDY #0
+		0x24, 0x74, // BIT 74
+		0x30, 0x08, // BMI write
+		0xAD, byte(data), byte(data >> 8), // LDA data
+		0x91, 0x70, // STA (70),Y
+		0x4C, 0x1D, 0x0D, // JMP next
+		0xB1, 0x70, // write: LDA (70),Y
+		0x8D, byte(data), byte(data >> 8), // STA data
+		0xE6, 0x70, // next: INC 70
+		0xD0, 0x0B, // BNE exit
+		0xE6, 0x71, // INC 71
+		0xD0, 0x07, // BNE exit
+		0xAD, byte(result), byte(result >> 8), // result: LDA result
+		0x85, 0x72, // STA 72
+		0xC6, 0x73, // DEC 73
+		0x68, // exit: PLA
+		0xA8, // TAY
+		0x68, // PLA
+		0x40, // RTI
+	}
+}
+
+func newDiscMachine(t *testing.T, discInterface string, images ...*disc.Disc) *machine.Machine {
+	mos := make([]byte, machine.MOSSize)
+	copy(mos, idleProgram)
+	mos[0x3FFA], mos[0x3FFB] = 0x00, 0x0D
+	mos[0x3FFC], mos[0x3FFD] = 0x00, 0xC0
+	bbc, err := machine.New(machine.Config{MOS: mos, Virtual: true, DiscInterface: discInterface})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i, image := range images {
+		if err := bbc.Drives[i].Insert(image); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	return bbc
+}
+
+// Runs a command with data going to or from 3000 and returns its result.
+func runDiscCommand(t *testing.T, bbc *machine.Machine, write bool, issue func()) byte {
+	direction := byte(0x00)
+	if write {
+		direction = 0x80
+	}
+	bbc.Bus.WriteMultiple([]byte{0x00, 0x30, 0x00, 0x00, direction}, 0x0070)
+	issue()
+	for i := 0; i < 300; i++ {
+		if done, _ := bbc.Bus.DirectRead(0x0073); done != 0 {
+			result, _ := bbc.Bus.DirectRead(0x0072)
+			return result
+		}
+		if err := bbc.RunCycles(machine.CPUFrequency / 100); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	t.Fatalf("disc command did not complete")
+	return 0
+}
+
+// Only the 8271 commands reading from the disc have bit 4 set.
+func discCommand(t *testing.T, bbc *machine.Machine, command byte, parameters ...byte) byte {
+	bbc.Bus.WriteMultiple(discNMIHandler(0xFE80, hardware.I8271DataRequest, 0xFE84, 0xFE81), 0x0D00)
+	return runDiscCommand(t, bbc, command&0x10 == 0, func() {
+		bbc.Bus.DirectWrite(command, 0xFE80)
+		for _, parameter := range parameters {
+			bbc.Bus.DirectWrite(parameter, 0xFE81)
+		}
+	})
+}
+
+func readMemory(bbc *machine.Machine, start uint16, length int) []byte {
+	data := make([]byte, length)
+	for i := range data {
+		data[i], _ = bbc.Bus.DirectRead(start + uint16(i))
+	}
+	return data
+}
+
+func TestDiscImages(t *testing.T) {
+	ssd := testDiscImage(1, 40)
+	image, err := disc.Decode(ssd[:5000], ".ssd")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if image.GetSides() != 1 || image.GetTrackCount() != disc.Tracks40 {
+		t.Fatalf("%d sides of %d tracks", image.GetSides(), image.GetTrackCount())
+	}
+	encoded, err := disc.SSD.Encode(image)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// images cut short are padded to the whole disc
+	if len(encoded) != 40*disc.DFSTrackSize || !bytes.Equal(encoded[:5000], ssd[:5000]) || encoded[5000] != 0 {
+		t.Fatal("SSD image not encoded back")
+	}
+
+	dsd := testDiscImage(2, 80)
+	image, err = disc.Decode(dsd, ".dsd")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	sector := image.GetTrack(1, 3).Find(3, 4)
+	if image.GetTrackCount() != disc.Tracks80 || sector == nil || sector.Data[0] != 0x80+34 {
+		t.Fatal("DSD tracks not interleaved")
+	}
+	if _, err := disc.Decode(dsd, ".xyz"); err == nil {
+		t.Fatal("unknown format decoded")
+	}
+	if err := hardware.NewDiscDrive(40).Insert(image); err == nil {
+		t.Fatal("80 track disc inserted in a 40 track drive")
+	}
+}
+
+func TestI8271Read(t *testing.T) {
+	image, _ := disc.Decode(testDiscImage(2, 80), ".dsd")
+	bbc := newDiscMachine(t, machine.Disc8271, image)
+
+	// specify: step rate, head settle and load
+	bbc.Bus.DirectWrite(0x35, 0xFE80)
+	for _, parameter := range []byte{0x0D, 0x0C, 0x0A, 0xC8} {
+		bbc.Bus.DirectWrite(parameter, 0xFE81)
+	}
+	// track 2, sectors 0 to 9 of 256 bytes
+	if result := discCommand(t, bbc, 0x53, 2, 0, 0x2A); result != hardware.I8271ResultOK {
+		t.Fatalf("read result %02X", result)
+	}
+	expected := testDiscImage(2, 80)[2*2*disc.DFSTrackSize:]
+	if !bytes.Equal(readMemory(bbc, 0x3000, disc.DFSTrackSize), expected[:disc.DFSTrackSize]) {
+		t.Fatal("track read wrongly")
+	}
+	if bbc.Drives[0].GetHead() != 2 {
+		t.Fatalf("head on track %d", bbc.Drives[0].GetHead())
+	}
+
+	// side 1 through the drive control output
+	bbc.Bus.DirectWrite(0x7A, 0xFE80)
+	bbc.Bus.DirectWrite(hardware.I8271DriveOutput, 0xFE81)
+	bbc.Bus.DirectWrite(hardware.I8271OutputSelect0|hardware.I8271OutputSide, 0xFE81)
+	if result := discCommand(t, bbc, 0x53, 5, 9, 0x21); result != hardware.I8271ResultOK {
+		t.Fatalf("side 1 read result %02X", result)
+	}
+	if data := readMemory(bbc, 0x3000, 2); data[0] != 0x80+59 || data[1] != (0x80+59)^1 {
+		t.Fatalf("side 1 read %02X", data)
+	}
+
+	// ID fields of the track
+	if result := discCommand(t, bbc, 0x5B, 5, 0, 3); result != hardware.I8271ResultOK {
+		t.Fatalf("read ID result %02X", result)
+	}
+	ids := readMemory(bbc, 0x3000, 12)
+	for i := 0; i < 3; i++ {
+		if ids[i*4] != 5 || ids[i*4+3] != 1 || ids[i*4+2] != (ids[2]+byte(i))%10 {
+			t.Fatalf("IDs read %02X", ids)
+		}
+	}
+
+	if result := discCommand(t, bbc, 0x53, 5, 10, 0x21); result != hardware.I8271ResultSectorNotFound {
+		t.Fatalf("missing sector result %02X", result)
+	}
+	if result := discCommand(t, bbc, 0x93, 0, 0, 0x21); result != hardware.I8271ResultNotReady {
+		t.Fatalf("empty drive result %02X", result)
+	}
+	// drive status
+	bbc.Bus.DirectWrite(0x6C, 0xFE80)
+	if status, _ := bbc.Bus.DirectRead(0xFE81); status&(hardware.I8271InputReady0|hardware.I8271InputReady1) != hardware.I8271InputReady0 {
+		t.Fatalf("drive status %02X", status)
+	}
+}
+
+func TestI8271Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "write.ssd")
+	if err := os.WriteFile(path, testDiscImage(1, 40), 0o644); err != nil {
+		t.Fatalf(err.Error())
+	}
+	image, err := disc.Load(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bbc := newDiscMachine(t, machine.Disc8271, image)
+	data := bytes.Repeat([]byte("written!"), 64)
+	bbc.Bus.WriteMultiple(data, 0x3000)
+	if result := discCommand(t, bbc, 0x4B, 7, 3, 0x22); result != hardware.I8271ResultOK {
+		t.Fatalf("write result %02X", result)
+	}
+	// written back to the image file
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	offset := 7*disc.DFSTrackSize + 3*disc.SectorSize
+	if !bytes.Equal(saved[offset:offset+512], data) || saved[offset+512] != testDiscImage(1, 40)[offset+512] {
+		t.Fatal("sectors not written to the image")
+	}
+
+	// deleted data marks are reported by reads
+	if result := discCommand(t, bbc, 0x4F, 7, 3, 0x21); result != hardware.I8271ResultOK {
+		t.Fatalf("write deleted result %02X", result)
+	}
+	if result := discCommand(t, bbc, 0x53, 7, 3, 0x21); result != hardware.I8271ResultDeletedData {
+		t.Fatalf("read deleted result %02X", result)
+	}
+
+	image.WriteProtected = true
+	if result := discCommand(t, bbc, 0x4B, 7, 3, 0x21); result != hardware.I8271ResultWriteProtected {
+		t.Fatalf("write protected result %02X", result)
+	}
+}
+
+// Writes reach the disc but not its image, the directory is gone.
+func TestI8271WriteFault(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "gone")
+	if err := os.Mkdir(directory, 0o755); err != nil {
+		t.Fatalf(err.Error())
+	}
+	path := filepath.Join(directory, "write.ssd")
+	if err := os.WriteFile(path, testDiscImage(1, 40), 0o644); err != nil {
+		t.Fatalf(err.Error())
+	}
+	image, err := disc.Load(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := os.RemoveAll(directory); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bbc := newDiscMachine(t, machine.Disc8271, image)
+	bbc.Bus.WriteMultiple(bytes.Repeat([]byte("written!"), 32), 0x3000)
+	if result := discCommand(t, bbc, 0x4B, 7, 3, 0x21); result != hardware.I8271ResultWriteFault {
+		t.Fatalf("write result %02X", result)
+	}
+	if err := bbc.Drives[0].Flush(); err == nil {
+		t.Fatal("image written to a removed directory")
+	}
+}
+
+func TestI8271Format(t *testing.T) {
+	image, _ := disc.Decode(testDiscImage(1, 40), ".ssd")
+	bbc := newDiscMachine(t, machine.Disc8271, image)
+	// sector IDs in reverse order, 5 sectors of 512 bytes
+	ids := []byte{}
+	for sector := byte(0); sector < 5; sector++ {
+		ids = append(ids, 9, 0, 4-sector, 2)
+	}
+	bbc.Bus.WriteMultiple(ids, 0x3000)
+	if result := discCommand(t, bbc, 0x63, 9, 0x15, 0x45, 0x00, 0x10); result != hardware.I8271ResultOK {
+		t.Fatalf("format result %02X", result)
+	}
+	track := image.GetTrack(0, 9)
+	if len(track.Sectors) != 5 || track.Sectors[0].Number != 4 || len(track.Sectors[0].Data) != 512 || track.Sectors[0].Data[0] != disc.FormatFill {
+		t.Fatal("track not formatted")
+	}
+	if _, err := disc.SSD.Encode(image); err == nil {
+		t.Fatal("non standard track encoded in an SSD image")
+	}
+}
+
+// Issues a WD1770 command, its registers from base. Bit 1 of the status is
+// the index pulse after type I commands, they move no data.
+func wd1770Command(t *testing.T, bbc *machine.Machine, base uint16, command byte) byte {
+	request := byte(hardware.WD1770DataRequest)
+	if command&0x80 == 0 {
+		request = 0
+	}
+	bbc.Bus.WriteMultiple(discNMIHandler(base, request, base+3, base), 0x0D00)
+	write := command&0xE0 == 0xA0 || command&0xF0 == 0xF0
+	return runDiscCommand(t, bbc, write, func() { bbc.Bus.DirectWrite(command, base) })
+}
+
+func TestWD1770Read(t *testing.T) {
+	image, _ := disc.Decode(testDiscImage(2, 80), ".dsd")
+	bbc := newDiscMachine(t, machine.DiscAcorn1770, image)
+	// drive 0, side 0, single density
+	bbc.Bus.DirectWrite(0x29, 0xFE80)
+
+	if status := wd1770Command(t, bbc, 0xFE84, 0x00); status&(hardware.WD1770Track0|hardware.WD1770MotorOn|hardware.WD1770Busy) != hardware.WD1770Track0|hardware.WD1770MotorOn {
+		t.Fatalf("restore status %02X", status)
+	}
+	bbc.Bus.DirectWrite(5, 0xFE87)
+	if status := wd1770Command(t, bbc, 0xFE84, 0x14); status&(hardware.WD1770SeekError|hardware.WD1770Busy) != 0 {
+		t.Fatalf("seek status %02X", status)
+	}
+	if bbc.WD1770.GetTrack() != 5 || bbc.Drives[0].GetHead() != 5 {
+		t.Fatalf("track register %d, head on %d", bbc.WD1770.GetTrack(), bbc.Drives[0].GetHead())
+	}
+
+	bbc.Bus.DirectWrite(3, 0xFE86)
+	if status := wd1770Command(t, bbc, 0xFE84, 0x80); status&^hardware.WD1770MotorOn != 0 {
+		t.Fatalf("read status %02X", status)
+	}
+	track := testDiscImage(2, 80)[5*2*disc.DFSTrackSize:]
+	if !bytes.Equal(readMemory(bbc, 0x3000, disc.SectorSize), track[3*disc.SectorSize:4*disc.SectorSize]) {
+		t.Fatal("sector read wrongly")
+	}
+	// multiple sector reads end when no next sector is found
+	bbc.Bus.DirectWrite(0, 0xFE86)
+	if status := wd1770Command(t, bbc, 0xFE84, 0x90); status&^hardware.WD1770MotorOn != hardware.WD1770RecordNotFound {
+		t.Fatalf("multiple read status %02X", status)
+	}
+	if !bytes.Equal(readMemory(bbc, 0x3000, disc.DFSTrackSize), track[:disc.DFSTrackSize]) {
+		t.Fatal("track read wrongly")
+	}
+
+	// side 1
+	bbc.Bus.DirectWrite(0x2D, 0xFE80)
+	bbc.Bus.DirectWrite(9, 0xFE86)
+	if status := wd1770Command(t, bbc, 0xFE84, 0x80); status&^hardware.WD1770MotorOn != 0 {
+		t.Fatalf("side 1 read status %02X", status)
+	}
+	if data := readMemory(bbc, 0x3000, 1); data[0] != 0x80+59 {
+		t.Fatalf("side 1 read %02X", data)
+	}
+
+	if status := wd1770Command(t, bbc, 0xFE84, 0xC0); status&^hardware.WD1770MotorOn != 0 {
+		t.Fatalf("read address status %02X", status)
+	}
+	if id := readMemory(bbc, 0x3000, 6); id[0] != 5 || id[3] != 1 || bbc.WD1770.GetSector() != 5 {
+		t.Fatalf("address read %02X", id)
+	}
+
+	// FM tracks are not found in double density
+	bbc.Bus.DirectWrite(0x25, 0xFE80)
+	if status := wd1770Command(t, bbc, 0xFE84, 0x80); status&hardware.WD1770RecordNotFound == 0 {
+		t.Fatalf("double density read status %02X", status)
+	}
+
+	// the track register disagrees with the disc
+	bbc.Bus.DirectWrite(0x29, 0xFE80)
+	bbc.Bus.DirectWrite(20, 0xFE85)
+	bbc.Bus.DirectWrite(21, 0xFE87)
+	if status := wd1770Command(t, bbc, 0xFE84, 0x14); status&hardware.WD1770SeekError == 0 {
+		t.Fatalf("verify status %02X", status)
+	}
+
+	// the motor turns off 9 revolutions after the last command
+	if err := bbc.RunCycles(8 * hardware.DiscRevolution * 2); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if bbc.WD1770.GetStatus()&hardware.WD1770MotorOn == 0 {
+		t.Fatal("motor turned off early")
+	}
+	if err := bbc.RunCycles(2 * hardware.DiscRevolution * 2); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if bbc.WD1770.GetStatus()&hardware.WD1770MotorOn != 0 {
+		t.Fatal("motor still on")
+	}
+}
+
+func TestWD1770Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "write.ssd")
+	if err := os.WriteFile(path, testDiscImage(1, 40), 0o644); err != nil {
+		t.Fatalf(err.Error())
+	}
+	image, err := disc.Load(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bbc := newDiscMachine(t, machine.DiscOpus1770, image)
+	// controller at FE80, drive 0, side 0, single density
+	bbc.Bus.DirectWrite(0x00, 0xFE84)
+	wd1770Command(t, bbc, 0xFE80, 0x00)
+
+	data := bytes.Repeat([]byte("written!"), 32)
+	bbc.Bus.WriteMultiple(data, 0x3000)
+	bbc.Bus.DirectWrite(2, 0xFE82)
+	if status := wd1770Command(t, bbc, 0xFE80, 0xA0); status&^hardware.WD1770MotorOn != 0 {
+		t.Fatalf("write status %02X", status)
+	}
+	saved, err := disc.Load(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !bytes.Equal(saved.GetTrack(0, 0).Find(0, 2).Data, data) {
+		t.Fatal("sector not written to the image")
+	}
+
+	if status := wd1770Command(t, bbc, 0xFE80, 0xA1); status&^hardware.WD1770MotorOn != 0 {
+		t.Fatalf("write deleted status %02X", status)
+	}
+	if status := wd1770Command(t, bbc, 0xFE80, 0x80); status&^hardware.WD1770MotorOn != hardware.WD1770RecordType {
+		t.Fatalf("read deleted status %02X", status)
+	}
+
+	image.WriteProtected = true
+	if status := wd1770Command(t, bbc, 0xFE80, 0xA0); status&hardware.WD1770WriteProtect == 0 {
+		t.Fatalf("write protected status %02X", status)
+	}
+}
+
+// The image directory is removed, then restored before the next command.
+func TestWD1770WriteFault(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "gone")
+	if err := os.Mkdir(directory, 0o755); err != nil {
+		t.Fatalf(err.Error())
+	}
+	path := filepath.Join(directory, "write.ssd")
+	if err := os.WriteFile(path, testDiscImage(1, 40), 0o644); err != nil {
+		t.Fatalf(err.Error())
+	}
+	image, err := disc.Load(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := os.RemoveAll(directory); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bbc := newDiscMachine(t, machine.DiscAcorn1770, image)
+	bbc.Bus.DirectWrite(0x29, 0xFE80)
+	wd1770Command(t, bbc, 0xFE84, 0x00)
+
+	data := bytes.Repeat([]byte("written!"), 32)
+	bbc.Bus.WriteMultiple(data, 0x3000)
+	bbc.Bus.DirectWrite(2, 0xFE86)
+	if status := wd1770Command(t, bbc, 0xFE84, 0xA0); status&hardware.WD1770LostData == 0 {
+		t.Fatalf("write status %02X", status)
+	}
+
+	// written back at the end of the next command
+	if err := os.Mkdir(directory, 0o755); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if status := wd1770Command(t, bbc, 0xFE84, 0x80); status&^hardware.WD1770MotorOn != 0 {
+		t.Fatalf("read status %02X", status)
+	}
+	saved, err := disc.Load(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !bytes.Equal(saved.GetTrack(0, 0).Find(0, 2).Data, data) {
+		t.Fatal("sector not written to the image")
+	}
+}
+
+func TestWD1770Deselected(t *testing.T) {
+	image, _ := disc.Decode(testDiscImage(1, 40), ".ssd")
+	bbc := newDiscMachine(t, machine.DiscAcorn1770, image)
+	// drive 0, side 0, single density
+	bbc.Bus.DirectWrite(0x29, 0xFE80)
+	wd1770Command(t, bbc, 0xFE84, 0x00)
+	original := append([]byte{}, image.GetTrack(0, 0).Find(0, 3).Data...)
+	bbc.Bus.WriteMultiple(discNMIHandler(0xFE84, hardware.WD1770DataRequest, 0xFE87, 0xFE84), 0x0D00)
+
+	// issues a write from sector 2 and waits for the transfer to reach the
+	// address
+	write := func(command byte, until uint16) {
+		bbc.Bus.WriteMultiple([]byte{0x00, 0x30, 0x00, 0x00, 0x80}, 0x0070)
+		bbc.Bus.DirectWrite(2, 0xFE86)
+		bbc.Bus.DirectWrite(command, 0xFE84)
+		for i := 0; i < 3000; i++ {
+			if pointer := readMemory(bbc, 0x0070, 2); uint16(pointer[0])|uint16(pointer[1])<<8 >= until {
+				return
+			}
+			if err := bbc.RunCycles(machine.CPUFrequency / 1000); err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+		t.Fatal("transfer not started")
+	}
+
+	// no drive selected in the middle of the second sector, then the
+	// command forced to end
+	write(0xB0, 0x3110)
+	bbc.Bus.DirectWrite(0x28, 0xFE80)
+	bbc.Bus.DirectWrite(0xD0, 0xFE84)
+	if status, _ := bbc.Bus.DirectRead(0xFE84); status&hardware.WD1770Busy != 0 {
+		t.Fatalf("forced interrupt status %02X", status)
+	}
+	// the NMI of the last data request is still pending
+	if err := bbc.RunCycles(1000); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// deselected or ejected before the end of the second sector
+	for _, stop := range []func(){
+		func() { bbc.Bus.DirectWrite(0x28, 0xFE80) },
+		func() { bbc.Drives[0].Eject() },
+	} {
+		bbc.Bus.DirectWrite(0x29, 0xFE80)
+		bbc.Drives[0].Insert(image)
+		status := runDiscCommand(t, bbc, true, func() {
+			write(0xB0, 0x3110)
+			stop()
+		})
+		if status&hardware.WD1770RecordNotFound == 0 {
+			t.Fatalf("write status %02X", status)
+		}
+	}
+	if !bytes.Equal(image.GetTrack(0, 0).Find(0, 3).Data, original) {
+		t.Fatal("sector written after the disc was gone")
+	}
+}
+
+func TestWD1770Format(t *testing.T) {
+	image, _ := disc.Decode(testDiscImage(1, 40), ".ssd")
+	bbc := newDiscMachine(t, machine.DiscWatford1770, image)
+	// control register at FE80: drive 0, side 0, single density
+	bbc.Bus.DirectWrite(0x01, 0xFE80)
+	bbc.Bus.DirectWrite(3, 0xFE87)
+	wd1770Command(t, bbc, 0xFE84, 0x10)
+
+	// five sectors of 512 bytes, F7 writes the CRCs
+	raw := bytes.Repeat([]byte{0xFF}, 16)
+	for sector := byte(0); sector < 5; sector++ {
+		raw = append(raw, bytes.Repeat([]byte{0x00}, 6)...)
+		raw = append(raw, 0xFE, 3, 0, sector+1, 2, 0xF7)
+		raw = append(raw, bytes.Repeat([]byte{0xFF}, 11)...)
+		raw = append(raw, bytes.Repeat([]byte{0x00}, 6)...)
+		raw = append(raw, 0xFB)
+		raw = append(raw, bytes.Repeat([]byte{disc.FormatFill}, 512)...)
+		raw = append(raw, 0xF7)
+		raw = append(raw, bytes.Repeat([]byte{0xFF}, 27)...)
+	}
+	raw = append(raw, bytes.Repeat([]byte{0xFF}, 3125-len(raw))...)
+	bbc.Bus.WriteMultiple(raw, 0x3000)
+	if status := wd1770Command(t, bbc, 0xFE84, 0xF0); status&^hardware.WD1770MotorOn != 0 {
+		t.Fatalf("write track status %02X", status)
+	}
+	track := image.GetTrack(0, 3)
+	if len(track.Sectors) != 5 || track.Sectors[4].Number != 5 || len(track.Sectors[4].Data) != 512 {
+		t.Fatal("track not formatted")
+	}
+
+	if status := wd1770Command(t, bbc, 0xFE84, 0xE0); status&^hardware.WD1770MotorOn != 0 {
+		t.Fatalf("read track status %02X", status)
+	}
+	read := readMemory(bbc, 0x3000, 3125)
+	id := bytes.IndexByte(read, 0xFE)
+	if id < 0 || !bytes.Equal(read[id:id+5], []byte{0xFE, 3, 0, 1, 2}) || bytes.Count(read, []byte{0xFB, 0xE5, 0xE5}) != 5 {
+		t.Fatal("track read wrongly")
+	}
+}
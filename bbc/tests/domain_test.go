@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"bbc/utils"
+	"testing"
+)
+
+// 1MHz register answering at 0xFE00, counting its domain ticks
+type slowRegister struct {
+	ticks uint64
+	value byte
+}
+
+func (reg *slowRegister) GetName() string                 { return "slow register" }
+func (reg *slowRegister) Start() error                    { return nil }
+func (reg *slowRegister) Reset() error                    { reg.ticks = 0; return nil }
+func (reg *slowRegister) Stop() error                     { return nil }
+func (reg *slowRegister) PlugToBus(*hardware.Bus)         {}
+func (reg *slowRegister) IsWritable() bool                { return true }
+func (reg *slowRegister) IsReadable() bool                { return true }
+func (reg *slowRegister) GetSegment() *utils.Segment      { return utils.NewSegment(0xFE00, 0xFE00) }
+func (reg *slowRegister) GetDomain() string               { return hardware.DomainPeripheral }
+func (reg *slowRegister) GetBusDomain() string            { return hardware.DomainPeripheral }
+func (reg *slowRegister) Step(ticks uint64) error         { reg.ticks += ticks; return nil }
+func (reg *slowRegister) DirectRead(uint16) (byte, error) { return reg.value, nil }
+func (reg *slowRegister) DirectWrite(value byte, _ uint16) error {
+	reg.value = value
+	return nil
+}
+func (reg *slowRegister) OffsetRead(base uint16, offset uint8) (byte, uint16, error) {
+	return reg.value, base + uint16(offset), nil
+}
+func (reg *slowRegister) OffsetWrite(value byte, base uint16, offset uint8) (uint16, error) {
+	reg.value = value
+	return base + uint16(offset), nil
+}
+
+func TestClockDomains(t *testing.T) {
+	clock := hardware.NewVirtualClock(2e6)
+	if err := clock.AddBBCDomains(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	peripheral := clock.GetDomain(hardware.DomainPeripheral)
+	character := clock.GetDomain(hardware.DomainCharacter)
+	if peripheral.GetFrequency() != 1e6 || character.GetFrequency() != 2e6 {
+		t.Fatal("wrong domain frequencies")
+	}
+
+	for i := 0; i < 10; i++ {
+		clock.Tick()
+	}
+	if peripheral.GetCycles() != 5 || character.GetCycles() != 10 {
+		t.Fatalf("wrong domain ticks %d %d", peripheral.GetCycles(), character.GetCycles())
+	}
+
+	// switching the CRTC to 1MHz keeps the elapsed ticks
+	if err := character.SetDivider(16, 0); err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := 0; i < 10; i++ {
+		clock.Tick()
+	}
+	if character.GetCycles() != 15 {
+		t.Fatalf("wrong character ticks after divider change %d", character.GetCycles())
+	}
+	if character.ToCPUCycles(3) != 6 {
+		t.Fatal("wrong conversion to CPU cycles")
+	}
+}
+
+func TestStretchedAccess(t *testing.T) {
+	clock := hardware.NewVirtualClock(2e6)
+	if err := clock.AddBBCDomains(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	reg := &slowRegister{}
+	bus, err := hardware.NewBus(clock, reg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// aligned on the 1MHz edge: one extra cycle
+	if _, err := bus.DirectRead(0xFE00); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if clock.GetCycles() != 2 {
+		t.Fatalf("aligned access lasted %d cycles", clock.GetCycles())
+	}
+
+	// misaligned: two extra cycles
+	bus.Tick()
+	if err := bus.DirectWrite(0x42, 0xFE00); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if clock.GetCycles() != 6 {
+		t.Fatalf("misaligned access lasted %d cycles", clock.GetCycles()-3)
+	}
+
+	if reg.ticks != 3 || reg.value != 0x42 {
+		t.Fatalf("component stepped %d ticks", reg.ticks)
+	}
+}
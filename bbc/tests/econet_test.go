@@ -0,0 +1,268 @@
+package tests
+
+import (
+	"bbc/econet"
+	"bbc/hardware"
+	"bbc/machine"
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testNetwork struct {
+	frames [][]byte
+}
+
+func (network *testNetwork) Transmit(frame []byte) {
+	network.frames = append(network.frames, frame)
+}
+
+func TestADLCFrames(t *testing.T) {
+	mos := make([]byte, machine.MOSSize)
+	copy(mos, idleProgram)
+	mos[0x3FFC], mos[0x3FFD] = 0x00, 0xC0
+	bbc, err := machine.New(machine.Config{MOS: mos, Virtual: true, EconetStation: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if station, _ := bbc.Bus.DirectRead(0xFE18); station != 5 {
+		t.Fatalf("station %d", station)
+	}
+	// no network, no clock
+	if status, _ := bbc.Bus.DirectRead(0xFEA0); status&hardware.ADLCNotClear == 0 {
+		t.Fatalf("clear to send without a network, status 1 %02X", status)
+	}
+	network := &testNetwork{}
+	bbc.ADLC.Connect(network)
+
+	// reset, 8 bit words, then receive in one byte mode with interrupts
+	bbc.Bus.DirectWrite(0xC1, 0xFEA0)
+	bbc.Bus.DirectWrite(0x1E, 0xFEA3)
+	bbc.Bus.DirectWrite(0x00, 0xFEA1)
+	bbc.Bus.DirectWrite(0x82, 0xFEA0)
+	bbc.Bus.DirectWrite(0x65, 0xFEA1)
+
+	scout := []byte{5, 0, 254, 0, 0x80, 0x99}
+	bbc.ADLC.Receive(scout)
+	received := []byte{}
+	for len(received) < len(scout) && bbc.Clock.GetCycles() < 10000 {
+		bbc.RunCycles(20)
+		status1, _ := bbc.Bus.DirectRead(0xFEA0)
+		if status1&hardware.ADLCReceiveAvailable == 0 {
+			continue
+		}
+		if status1&hardware.ADLCInterrupt == 0 {
+			t.Fatal("no interrupt with data available")
+		}
+		status2, _ := bbc.Bus.DirectRead(0xFEA1)
+		if (status2&hardware.ADLCAddressPresent != 0) != (len(received) == 0) {
+			t.Fatalf("address present %02X at byte %d", status2, len(received))
+		}
+		if (status2&hardware.ADLCFrameValid != 0) != (len(received) == len(scout)-1) {
+			t.Fatalf("frame valid %02X at byte %d", status2, len(received))
+		}
+		value, _ := bbc.Bus.DirectRead(0xFEA2)
+		received = append(received, value)
+	}
+	if !bytes.Equal(received, scout) {
+		t.Fatalf("received %v", received)
+	}
+
+	// the interrupt reaches the NMI between INTON and INTOFF
+	bbc.ADLC.Receive([]byte{5, 0, 254, 0})
+	bbc.RunCycles(300)
+	if bbc.Bus.NMI.IsAssertedBy("ADLC") {
+		t.Fatal("NMI before INTON")
+	}
+	bbc.Bus.DirectRead(0xFE20)
+	if !bbc.Bus.NMI.IsAssertedBy("ADLC") {
+		t.Fatal("no NMI after INTON")
+	}
+	bbc.Bus.DirectRead(0xFE18)
+	if bbc.Bus.NMI.IsAssertedBy("ADLC") {
+		t.Fatal("NMI after INTOFF")
+	}
+
+	// transmit with the receiver reset, the last byte terminates the frame
+	bbc.Bus.DirectWrite(0xE5, 0xFEA1)
+	bbc.Bus.DirectWrite(0x44, 0xFEA0)
+	frame := []byte{254, 0, 5, 0, 0x80, 0x99, 0x90, 0x00}
+	for sent := 0; sent < len(frame) && bbc.Clock.GetCycles() < 20000; {
+		if status1, _ := bbc.Bus.DirectRead(0xFEA0); status1&hardware.ADLCTransmitAvailable == 0 {
+			bbc.RunCycles(20)
+			continue
+		}
+		addr := uint16(0xFEA2)
+		if sent == len(frame)-1 {
+			addr = 0xFEA3
+		}
+		bbc.Bus.DirectWrite(frame[sent], addr)
+		sent++
+	}
+	// then wait for the frame complete status
+	bbc.Bus.DirectWrite(0x2D, 0xFEA1)
+	bbc.RunCycles(1000)
+	if status1, _ := bbc.Bus.DirectRead(0xFEA0); status1&hardware.ADLCTransmitAvailable == 0 {
+		t.Fatalf("frame not complete, status 1 %02X", status1)
+	}
+	if len(network.frames) != 1 || !bytes.Equal(network.frames[0], frame) {
+		t.Fatalf("transmitted %v", network.frames)
+	}
+}
+
+func TestFileServerStandIn(t *testing.T) {
+	fs := econet.NewFileServer([]econet.File{
+		{Name: "HELLO", Load: 0x1900, Exec: 0x8023, Data: make([]byte, 0x123)},
+		{Name: "!BOOT", Data: []byte("CHAIN\"HELLO\"\r")},
+	})
+	request := func(function byte, data string) []byte {
+		port, reply, ok := fs.Handle(7, append([]byte{0x90, function, 1, 2, 3}, data...))
+		if !ok || port != 0x90 {
+			t.Fatalf("no reply to function %d", function)
+		}
+		return reply
+	}
+
+	if reply := request(0, "CAT\r"); reply[1] != 0xBF {
+		t.Fatalf("catalogue before logon %v", reply)
+	}
+	if reply := request(0, "I AM SYST\r"); !bytes.Equal(reply, []byte{5, 0, 1, 2, 3, 0}) {
+		t.Fatalf("logon reply %v", reply)
+	}
+	if reply := request(0, "CAT\r"); reply[0] != 3 || reply[1] != 0 {
+		t.Fatalf("catalogue reply %v", reply)
+	}
+	// names and access of the entries
+	reply := request(3, "\x03\x00\x10$\r")
+	if reply[1] != 0 || reply[2] != 2 || reply[len(reply)-1] != 0x80 {
+		t.Fatalf("examine reply %v", reply)
+	}
+	entries := strings.Split(string(reply[4:len(reply)-1]), "\x00")
+	if !strings.HasPrefix(entries[0], "!BOOT") || !strings.HasPrefix(entries[1], "HELLO") {
+		t.Fatalf("entries %q", entries)
+	}
+	if reply := request(0, "INFO HELLO\r"); reply[0] != 4 || !strings.Contains(string(reply), "00001900 00008023   000123") {
+		t.Fatalf("info reply %q", reply)
+	}
+}
+
+func TestFileServerLoadFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "GAME"), []byte("data"), 0o644)
+	os.WriteFile(filepath.Join(dir, "GAME.inf"), []byte("$.GAME FFFF1900 FFFF8023 L\n"), 0o644)
+	files, err := econet.LoadFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Load != 0xFFFF1900 || files[0].Exec != 0xFFFF8023 || !files[0].Locked {
+		t.Fatalf("files %+v", files)
+	}
+
+	// a malformed sidecar is reported
+	os.WriteFile(filepath.Join(dir, "GAME.inf"), []byte("$.GAME 19Z0 8023\n"), 0o644)
+	if _, err := econet.LoadFiles(dir); err == nil {
+		t.Fatal("bad load address loaded")
+	}
+}
+
+type testReceiver chan []byte
+
+func (receiver testReceiver) Receive(frame []byte) {
+	receiver <- append([]byte{}, frame...)
+}
+
+func (receiver testReceiver) expect(t *testing.T, expected []byte) {
+	t.Helper()
+	select {
+	case frame := <-receiver:
+		if !bytes.Equal(frame, expected) {
+			t.Fatalf("received %v, expected %v", frame, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%v not received", expected)
+	}
+}
+
+func TestAUNBridge(t *testing.T) {
+	// a free port for station 1
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := probe.LocalAddr().(*net.UDPAddr).Port - 1
+	probe.Close()
+
+	fs := econet.NewFileServer(nil)
+	if err := fs.Listen(econet.FileServerStation, base); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	adlc1, adlc2 := make(testReceiver, 8), make(testReceiver, 8)
+	station1, err := econet.NewBridge(1, base, adlc1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer station1.Close()
+	station2, err := econet.NewBridge(2, base, adlc2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer station2.Close()
+
+	// scout, its acknowledge from the bridge, then the data acknowledged by
+	// the file server
+	station1.Transmit([]byte{254, 0, 1, 0, 0x80, 0x99})
+	adlc1.expect(t, []byte{1, 0, 254, 0})
+	station1.Transmit(append([]byte{254, 0, 1, 0, 0x90, 0, 0, 0, 0}, "I AM SYST\r"...))
+	adlc1.expect(t, []byte{1, 0, 254, 0})
+
+	// the reply comes as a transfer to the reply port
+	adlc1.expect(t, []byte{1, 0, 254, 0, 0x80, 0x90})
+	station1.Transmit([]byte{254, 0, 1, 0})
+	adlc1.expect(t, []byte{1, 0, 254, 0, 5, 0, 1, 2, 3, 0})
+	station1.Transmit([]byte{254, 0, 1, 0})
+
+	// broadcasts reach the other stations
+	station1.Transmit([]byte{0xFF, 0xFF, 1, 0, 0x80, 0x55, 'X'})
+	adlc2.expect(t, []byte{0xFF, 0xFF, 1, 0, 0x80, 0x55, 'X'})
+}
+
+func TestAUNBridgeQueue(t *testing.T) {
+	sender, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	// the sender is station 3
+	base := sender.LocalAddr().(*net.UDPAddr).Port - 3
+	adlc := make(testReceiver, 64)
+	bridge, err := econet.NewBridge(1, base, adlc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Close()
+
+	// data packets of type 2 to port 0x99, the first two sent twice
+	to := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: base + 1}
+	for _, i := range []byte{1, 1, 2, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20} {
+		sender.WriteToUDP([]byte{2, 0x99, 0, 0, i * 4, 0, 0, 0, i}, to)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// one in flight and a full queue are delivered, once each
+	for i := byte(1); i <= 17; i++ {
+		adlc.expect(t, []byte{1, 0, 3, 0, 0x80, 0x99})
+		bridge.Transmit([]byte{3, 0, 1, 0})
+		adlc.expect(t, []byte{1, 0, 3, 0, i})
+		bridge.Transmit([]byte{3, 0, 1, 0})
+	}
+	select {
+	case frame := <-adlc:
+		t.Fatalf("received %v beyond the queue", frame)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"bbc/disc"
+	"bbc/hardware"
+	"bbc/machine"
+	"bytes"
+	"testing"
+)
+
+// FSD image of 2 tracks: track 0 with a good sector, a sector shorter than
+// its ID says, a data CRC error, a deleted sector and an ID CRC error, then
+// track 1 that could not be read.
+func testFSDImage() []byte {
+	image := append([]byte("FSD"), 1, 2, 3, 4, 5)
+	image = append(image, "PROTECTED"...)
+	image = append(image, 0, 1)
+	image = append(image, 0, 5, 0xFF)
+	for number, recorded := range []struct {
+		code   byte
+		errors byte
+	}{{1, 0}, {0, 0}, {1, 0x0E}, {1, 0x20}, {1, 0x0C}} {
+		image = append(image, 0, 0, byte(number), 1, recorded.code, recorded.errors)
+		image = append(image, bytes.Repeat([]byte{byte(0x10 + number)}, 128<<recorded.code)...)
+	}
+	image = append(image, 1, 1, 0x00)
+	return append(image, 1, 0, 0, 1)
+}
+
+func TestFSDImage(t *testing.T) {
+	fsd := testFSDImage()
+	image, err := disc.Decode(fsd, ".fsd")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	track := image.GetTrack(0, 0)
+	if image.GetTrackCount() != 2 || track == nil || len(track.Sectors) != 5 {
+		t.Fatal("FSD tracks not decoded")
+	}
+	short, bad, deleted, badID := track.Sectors[1], track.Sectors[2], track.Sectors[3], track.Sectors[4]
+	if short.GetSize() != 256 || len(short.Data) != 128 || !bad.DataCRCError || !deleted.Deleted || !badID.IDCRCError {
+		t.Fatal("FSD sectors decoded wrongly")
+	}
+	if track.Find(0, 4) != nil {
+		t.Fatal("sector with an ID CRC error found")
+	}
+	unreadable := image.GetTrack(0, 1).Sectors[0]
+	if unreadable.Data != nil || !unreadable.DataCRCError {
+		t.Fatal("unreadable track has data")
+	}
+	encoded, err := image.GetFormat().Encode(image)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !bytes.Equal(encoded, fsd) {
+		t.Fatal("FSD image not encoded back")
+	}
+	if _, err := disc.Decode(fsd[:len(fsd)-2], ".fsd"); err == nil {
+		t.Fatal("cut FSD image decoded")
+	}
+}
+
+func TestI8271Protection(t *testing.T) {
+	image, _ := disc.Decode(testFSDImage(), ".fsd")
+	bbc := newDiscMachine(t, machine.Disc8271, image)
+
+	for _, read := range []struct {
+		command, sector, result byte
+	}{
+		{0x53, 0, hardware.I8271ResultOK},
+		{0x53, 1, hardware.I8271ResultDataCRCError},
+		{0x53, 2, hardware.I8271ResultDataCRCError},
+		{0x53, 3, hardware.I8271ResultDeletedData},
+		{0x57, 3, hardware.I8271ResultDeletedData},
+		{0x53, 4, hardware.I8271ResultIDCRCError},
+		{0x5F, 1, hardware.I8271ResultDataCRCError},
+	} {
+		if result := discCommand(t, bbc, read.command, 0, read.sector, 0x21); result != read.result {
+			t.Fatalf("command %02X of sector %d result %02X", read.command, read.sector, result)
+		}
+	}
+	// the short sector is read to the length asked for
+	if result := discCommand(t, bbc, 0x53, 0, 1, 0x01); result != hardware.I8271ResultOK {
+		t.Fatalf("short sector result %02X", result)
+	}
+	if data := readMemory(bbc, 0x3000, 128); !bytes.Equal(data, bytes.Repeat([]byte{0x11}, 128)) {
+		t.Fatalf("short sector read %02X", data)
+	}
+	if result := discCommand(t, bbc, 0x53, 1, 0, 0x21); result != hardware.I8271ResultDataCRCError {
+		t.Fatalf("unreadable track result %02X", result)
+	}
+
+	// ID fields as recorded, with the size code of the ID
+	if result := discCommand(t, bbc, 0x5B, 0, 0, 5); result != hardware.I8271ResultOK {
+		t.Fatalf("read ID result %02X", result)
+	}
+	ids := readMemory(bbc, 0x3000, 20)
+	for i := 0; i < 5; i++ {
+		if ids[i*4] != 0 || ids[i*4+1] != 0 || ids[i*4+2] != (ids[2]+byte(i))%5 || ids[i*4+3] != 1 {
+			t.Fatalf("IDs read %02X", ids)
+		}
+	}
+}
+
+func TestWD1770Protection(t *testing.T) {
+	image, _ := disc.Decode(testFSDImage(), ".fsd")
+	bbc := newDiscMachine(t, machine.DiscAcorn1770, image)
+	bbc.Bus.DirectWrite(0x29, 0xFE80)
+
+	for _, read := range []struct {
+		sector, status byte
+	}{
+		{0, 0},
+		{1, hardware.WD1770CRCError},
+		{2, hardware.WD1770CRCError},
+		{3, hardware.WD1770RecordType},
+		{4, hardware.WD1770RecordNotFound | hardware.WD1770CRCError},
+	} {
+		bbc.Bus.DirectWrite(read.sector, 0xFE86)
+		if status := wd1770Command(t, bbc, 0xFE84, 0x80); status&^hardware.WD1770MotorOn != read.status {
+			t.Fatalf("sector %d status %02X", read.sector, status)
+		}
+	}
+}
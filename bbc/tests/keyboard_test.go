@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"testing"
+)
+
+func newKeyboardBus(t *testing.T) (*hardware.Bus, *hardware.Keyboard) {
+	bus, via := newVIABus(t)
+	latch := hardware.NewAddressableLatch("IC32")
+	via.ConnectPortB(latch)
+	keyboard := hardware.NewKeyboard("keyboard", via, latch)
+	for _, component := range []hardware.Component{latch, keyboard} {
+		if err := bus.AddComponent(component); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bus.DirectWrite(0x0F, 0xFE42)
+	bus.DirectWrite(0x7F, 0xFE43)
+	return bus, keyboard
+}
+
+func readKey(bus *hardware.Bus, position byte) bool {
+	bus.DirectWrite(position, 0xFE4F)
+	value, _ := bus.DirectRead(0xFE4F)
+	return value&0x80 != 0
+}
+
+func TestKeyboardManualScan(t *testing.T) {
+	bus, keyboard := newKeyboardBus(t)
+	// keyboard write enable low
+	bus.DirectWrite(0x03, 0xFE40)
+
+	if err := keyboard.Press("A"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !readKey(bus, 0x41) || readKey(bus, 0x42) {
+		t.Fatal("A not read at row 4 column 1")
+	}
+	keyboard.Release("A")
+	if readKey(bus, 0x41) {
+		t.Fatal("A still pressed")
+	}
+
+	keyboard.SetStartupLinks(0x81)
+	if !readKey(bus, 0x09) || !readKey(bus, 0x02) || readKey(bus, 0x05) {
+		t.Fatal("startup links not read in row 0")
+	}
+
+	if err := keyboard.PressRune('"'); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !keyboard.IsPressed(hardware.KeyShift) || !readKey(bus, 0x31) {
+		t.Fatal("double quote is SHIFT 2")
+	}
+	keyboard.ReleaseRune('"')
+	if keyboard.IsPressed(hardware.KeyShift) {
+		t.Fatal("SHIFT not released")
+	}
+
+	if err := keyboard.PressHost("BracketLeft"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !keyboard.IsPressed("@") {
+		t.Fatal("physical mapping failed")
+	}
+	if err := keyboard.Press("NOPE"); err == nil {
+		t.Fatal("unknown key accepted")
+	}
+}
+
+func TestKeyboardAutoScan(t *testing.T) {
+	bus, keyboard := newKeyboardBus(t)
+	// autoscan, CA2 positive edge interrupt enabled
+	bus.DirectWrite(0x0B, 0xFE40)
+	bus.DirectWrite(0x04, 0xFE4C)
+	bus.DirectWrite(0x7F, 0xFE4D)
+	bus.DirectWrite(0x81, 0xFE4E)
+
+	// SHIFT and CTRL are in row 0 and don't interrupt
+	keyboard.Press(hardware.KeyShift)
+	step1MHz(t, bus, 32)
+	if bus.IRQ.IsAsserted() {
+		t.Fatal("row 0 key raised an interrupt")
+	}
+
+	keyboard.Press("RETURN")
+	step1MHz(t, bus, 16)
+	if !bus.IRQ.IsAsserted() {
+		t.Fatal("key press did not raise CA2 interrupt")
+	}
+}
+
+func TestKeyboardBreak(t *testing.T) {
+	bus, keyboard := newKeyboardBus(t)
+	keyboard.Press(hardware.KeyBreak)
+	step1MHz(t, bus, 1)
+	if !bus.RESET.IsAsserted() {
+		t.Fatal("BREAK does not assert RESET")
+	}
+	keyboard.Release(hardware.KeyBreak)
+	step1MHz(t, bus, 1)
+	if bus.RESET.IsAsserted() {
+		t.Fatal("RESET still asserted")
+	}
+}
+
+func TestKeyboardReleaseAll(t *testing.T) {
+	bus, keyboard := newKeyboardBus(t)
+	bus.DirectWrite(0x03, 0xFE40)
+
+	keyboard.SetStartupLinks(0x81)
+	keyboard.Press(hardware.KeyShift)
+	keyboard.Press(hardware.KeyCtrl)
+	keyboard.Press("A")
+	keyboard.ReleaseAll()
+
+	if readKey(bus, 0x00) || readKey(bus, 0x01) || readKey(bus, 0x41) {
+		t.Fatal("keys still pressed after ReleaseAll")
+	}
+	if keyboard.IsPressed(hardware.KeyShift) || keyboard.IsPressed(hardware.KeyCtrl) {
+		t.Fatal("SHIFT or CTRL still pressed after ReleaseAll")
+	}
+	if !readKey(bus, 0x09) || !readKey(bus, 0x02) {
+		t.Fatal("startup links lost by ReleaseAll")
+	}
+}
@@ -20,7 +20,7 @@ func (ctx *Context) Reset() {
 var testCtx Context
 
 func TestMain(m *testing.M) {
-	clock := hardware.NewClock(2e6)
+	clock := hardware.NewVirtualClock(2e6)
 	cpu := hardware.NewCPU(clock)
 	ram := hardware.NewRAM()
 
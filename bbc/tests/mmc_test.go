@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"bbc/dfs"
+	"bbc/disc"
+	"bbc/machine"
+	"bbc/mmb"
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+// Bit-bangs a byte to the card on PB0 and PB1, as MMFS does.
+func spiWrite(bbc *machine.Machine, value byte) {
+	for i := 7; i >= 0; i-- {
+		bit := value >> i & 0x01
+		bbc.Bus.DirectWrite(0xFC|bit, 0xFE60)
+		bbc.Bus.DirectWrite(0xFE|bit, 0xFE60)
+	}
+}
+
+// Reads a byte from CB2 with the shift register clocked by the system
+// clock.
+func spiRead(t *testing.T, bbc *machine.Machine) byte {
+	bbc.Bus.DirectWrite(0xFF, 0xFE60)
+	bbc.Bus.DirectWrite(0x08, 0xFE6B)
+	bbc.Bus.DirectRead(0xFE6A)
+	if err := bbc.RunCycles(64); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bbc.Bus.DirectWrite(0x00, 0xFE6B)
+	value, _ := bbc.Bus.DirectRead(0xFE6A)
+	return value
+}
+
+// Sends a command and returns its R1 response, FF when the card is silent.
+func sdCommand(t *testing.T, bbc *machine.Machine, command byte, argument uint32) byte {
+	spiWrite(bbc, 0x40|command)
+	for shift := 24; shift >= 0; shift -= 8 {
+		spiWrite(bbc, byte(argument>>shift))
+	}
+	spiWrite(bbc, 0x95)
+	for i := 0; i < 8; i++ {
+		if response := spiRead(t, bbc); response != 0xFF {
+			return response
+		}
+	}
+	return 0xFF
+}
+
+func sdReadBlock(t *testing.T, bbc *machine.Machine, number int) []byte {
+	if response := sdCommand(t, bbc, 17, uint32(number*mmb.BlockSize)); response != 0 {
+		t.Fatalf("read of block %d response %02X", number, response)
+	}
+	for i := 0; spiRead(t, bbc) != 0xFE; i++ {
+		if i == 8 {
+			t.Fatalf("no data token for block %d", number)
+		}
+	}
+	block := make([]byte, mmb.BlockSize)
+	for i := range block {
+		block[i] = spiRead(t, bbc)
+	}
+	spiRead(t, bbc)
+	spiRead(t, bbc)
+	return block
+}
+
+func TestSDCard(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	bbc.Bus.DirectWrite(0x03, 0xFE62)
+	if response := sdCommand(t, bbc, 0, 0); response != 0xFF {
+		t.Fatalf("no card response %02X", response)
+	}
+
+	image, _ := dfs.Blank(1, disc.Tracks80)
+	volume, _ := image.Volume(0)
+	volume.SetTitle("GAMES")
+	ssd, _ := disc.SSD.Encode(image.Disc)
+	discs, _ := mmb.New(2)
+	if err := discs.PutDisc(0, ssd, false); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if discs.GetTitle(0) != "GAMES" || discs.GetStatus(0) != mmb.StatusUnlocked || discs.GetStatus(1) != mmb.StatusUnformatted {
+		t.Fatalf("disc 0 %s status %02X", discs.GetTitle(0), discs.GetStatus(0))
+	}
+	path := filepath.Join(t.TempDir(), "beeb.mmb")
+	discs.Save(path)
+	card, err := mmb.OpenCard(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bbc.SDCard.Insert(card)
+
+	// initialisation
+	if response := sdCommand(t, bbc, 0, 0); response != 0x01 {
+		t.Fatalf("CMD0 response %02X", response)
+	}
+	if response := sdCommand(t, bbc, 17, 0); response != 0x05 {
+		t.Fatalf("read before initialisation response %02X", response)
+	}
+	if response := sdCommand(t, bbc, 8, 0x1AA); response != 0x01 {
+		t.Fatalf("CMD8 response %02X", response)
+	}
+	if echo := []byte{spiRead(t, bbc), spiRead(t, bbc), spiRead(t, bbc), spiRead(t, bbc)}; !bytes.Equal(echo, []byte{0, 0, 1, 0xAA}) {
+		t.Fatalf("CMD8 echo %02X", echo)
+	}
+	sdCommand(t, bbc, 55, 0)
+	if response := sdCommand(t, bbc, 41, 0x40000000); response != 0x00 {
+		t.Fatalf("ACMD41 response %02X", response)
+	}
+	if response := sdCommand(t, bbc, 58, 0); response != 0x00 || spiRead(t, bbc)&0xC0 != 0x80 {
+		t.Fatal("card not byte addressed")
+	}
+
+	// BEEB.MMB through the partition table and the FAT volume
+	mbr := sdReadBlock(t, bbc, 0)
+	start := int(binary.LittleEndian.Uint32(mbr[0x1C6:]))
+	if mbr[510] != 0x55 || mbr[511] != 0xAA || mbr[0x1C2] != 0x04 {
+		t.Fatal("no partition table")
+	}
+	boot := sdReadBlock(t, bbc, start)
+	if string(boot[0x36:0x3B]) != "FAT16" {
+		t.Fatalf("no FAT16 volume at block %d", start)
+	}
+	fatSectors := int(binary.LittleEndian.Uint16(boot[0x16:]))
+	root := start + int(binary.LittleEndian.Uint16(boot[0x0E:])) + int(boot[0x10])*fatSectors
+	entry := sdReadBlock(t, bbc, root)[32:]
+	if string(entry[:11]) != "BEEB    MMB" || binary.LittleEndian.Uint16(entry[0x1A:]) != 2 || int(binary.LittleEndian.Uint32(entry[0x1C:])) != len(discs.Data) {
+		t.Fatalf("root entry %q", entry[:32])
+	}
+	data := root + 32
+	if catalogue := sdReadBlock(t, bbc, data+mmb.CatalogueSize/mmb.BlockSize); string(catalogue[:5]) != "GAMES" {
+		t.Fatalf("disc 0 catalogue %q", catalogue[:8])
+	}
+
+	// a block written to disc 1 goes to the file when flushed
+	disc1 := data + (mmb.CatalogueSize+mmb.DiscSize)/mmb.BlockSize
+	if response := sdCommand(t, bbc, 24, uint32(disc1*mmb.BlockSize)); response != 0x00 {
+		t.Fatalf("write response %02X", response)
+	}
+	spiWrite(bbc, 0xFF)
+	spiWrite(bbc, 0xFE)
+	for i := 0; i < mmb.BlockSize+2; i++ {
+		spiWrite(bbc, byte(i))
+	}
+	response := spiRead(t, bbc)
+	for i := 0; response == 0xFF && i < 8; i++ {
+		response = spiRead(t, bbc)
+	}
+	if response&0x1F != 0x05 {
+		t.Fatalf("data response %02X", response)
+	}
+	if err := bbc.FlushDiscs(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	saved, err := mmb.Load(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	written, _ := saved.GetDisc(1)
+	if written[0] != 0 || written[255] != 255 || written[256] != 0 || written[511] != 255 {
+		t.Fatal("written block not saved")
+	}
+	if block := sdReadBlock(t, bbc, disc1); !bytes.Equal(block, written[:mmb.BlockSize]) {
+		t.Fatal("written block not read back")
+	}
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"bbc/printer"
+	"image"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrinterHandshake(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	printed := []byte{}
+	bbc.Printer.OnPrint(func(value byte) { printed = append(printed, value) })
+	// port A outputs, CA2 pulse strobe, CA1 interrupt on the falling edge
+	bbc.Bus.DirectWrite(0xFF, 0xFE63)
+	bbc.Bus.DirectWrite(0x0A, 0xFE6C)
+
+	for _, value := range []byte("HI\r") {
+		bbc.Bus.DirectWrite(value, 0xFE61)
+		if !bbc.Printer.IsBusy() {
+			t.Fatalf("%02X not taken", value)
+		}
+		// a write while busy is lost
+		bbc.Bus.DirectWrite(0x00, 0xFE61)
+		// 10us busy then a 5us acknowledge
+		bbc.RunCycles(12)
+		if flags, _ := bbc.Bus.DirectRead(0xFE6D); flags&hardware.VIAInterruptCA1 != 0 {
+			t.Fatal("acknowledged early")
+		}
+		bbc.RunCycles(16)
+		if flags, _ := bbc.Bus.DirectRead(0xFE6D); flags&hardware.VIAInterruptCA1 == 0 {
+			t.Fatal("not acknowledged")
+		}
+		bbc.RunCycles(16)
+		if bbc.Printer.IsBusy() {
+			t.Fatal("acknowledge not ended")
+		}
+		// reading port A clears the interrupt
+		bbc.Bus.DirectRead(0xFE6F)
+		bbc.Bus.DirectWrite(hardware.VIAInterruptCA1, 0xFE6D)
+	}
+	if string(printed) != "HI\r" {
+		t.Fatalf("printed %q", printed)
+	}
+
+	bbc.Printer.SetOnline(false)
+	bbc.Bus.DirectWrite('X', 0xFE61)
+	bbc.RunCycles(100)
+	if string(printed) != "HI\r" {
+		t.Fatal("offline printer printed")
+	}
+}
+
+// Dark dots in the rectangle of the page.
+func countDots(page *image.Gray, area image.Rectangle) int {
+	count := 0
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			if page.GrayAt(x, y).Y < 0x80 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func TestFX80Rendering(t *testing.T) {
+	fx80 := printer.NewFX80()
+	// pica characters are 24 dots wide, a line of 1/6 inch 36 dots high
+	fx80.Write([]byte("I\r"))
+	fx80.Write([]byte("\x0eW\r"))
+	// bit image: a full column, an empty one, the top pin
+	fx80.Write([]byte{0x1B, 'K', 3, 0, 0xFF, 0x00, 0x80, '\r', 0x0C})
+	fx80.Write([]byte("\x1b3\x48.\r"))
+
+	pages := fx80.GetPages()
+	if len(pages) != 2 || pages[0].Bounds().Dx() != printer.PageWidth || pages[0].Bounds().Dy() != printer.PageHeight {
+		t.Fatalf("%d pages", len(pages))
+	}
+	page := pages[0]
+	if countDots(page, image.Rect(0, 0, 24, 36)) == 0 || countDots(page, image.Rect(24, 0, 240, 36)) != 0 {
+		t.Fatal("I not printed in the first cell")
+	}
+	if countDots(page, image.Rect(24, 36, 48, 72)) == 0 || countDots(page, image.Rect(48, 36, 240, 72)) != 0 {
+		t.Fatal("expanded W not 48 dots wide")
+	}
+	// 60 dots per inch columns are 4 dots apart
+	if countDots(page, image.Rect(0, 72, 3, 99)) != 3*24 || countDots(page, image.Rect(4, 72, 8, 99)) != 0 {
+		t.Fatal("bit image column not printed")
+	}
+	if countDots(page, image.Rect(8, 72, 11, 75)) != 9 || countDots(page, image.Rect(8, 75, 11, 99)) != 0 {
+		t.Fatal("top pin not printed")
+	}
+	// the full stop on the second page is on its first line
+	if countDots(pages[1], image.Rect(0, 0, 24, 36)) == 0 {
+		t.Fatal("nothing on the second page")
+	}
+
+	paths, err := fx80.SavePages(t.TempDir())
+	if err != nil || len(paths) != 2 || filepath.Base(paths[1]) != "page-002.png" {
+		t.Fatalf("pages saved as %v: %v", paths, err)
+	}
+}
+
+func TestFX80AllBytes(t *testing.T) {
+	fx80 := printer.NewFX80()
+	// each byte alone, with a cancel to end the escape sequences, then as
+	// the command of an escape sequence
+	for value := 0; value < 0x100; value++ {
+		fx80.Write([]byte{byte(value), 0x18})
+	}
+	for value := 0; value < 0x100; value++ {
+		fx80.Write([]byte{0x1B, byte(value)})
+	}
+	if len(fx80.GetPages()) == 0 {
+		t.Fatal("nothing printed")
+	}
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"bbc/machine"
+	"net"
+	"testing"
+	"time"
+)
+
+// Machine with the ACIA on the RS423 port at 9600 baud, 8N1 divided by 64,
+// and the host end of a pipe connected to it.
+func newRS423Machine(t *testing.T, control byte) (*machine.Machine, net.Conn) {
+	bbc := newTestMachine(t, idleProgram)
+	host, port := net.Pipe()
+	bbc.RS423.Connect(port)
+	t.Cleanup(func() {
+		bbc.RS423.Disconnect()
+		host.Close()
+	})
+	bbc.Bus.DirectWrite(0x03, 0xFE08)
+	bbc.Bus.DirectWrite(control, 0xFE08)
+	bbc.Bus.DirectWrite(hardware.SerialRS423|4<<3|4, 0xFE10)
+	return bbc, host
+}
+
+func TestRS423Transmit(t *testing.T) {
+	bbc, host := newRS423Machine(t, 0x16)
+	received := make(chan byte, 16)
+	go func() {
+		buffer := make([]byte, 16)
+		for {
+			n, err := host.Read(buffer)
+			for _, value := range buffer[:n] {
+				received <- value
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, value := range []byte("OK\r") {
+		for bbc.ACIA.GetStatus()&hardware.ACIATransmitFree == 0 {
+			bbc.RunCycles(100)
+		}
+		bbc.Bus.DirectWrite(value, 0xFE09)
+	}
+	// three frames of 10 bits at 9600 baud
+	bbc.RunCycles(4 * 2100)
+	for _, expected := range []byte("OK\r") {
+		select {
+		case value := <-received:
+			if value != expected {
+				t.Fatalf("host received %02X, expected %02X", value, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("host did not receive %02X", expected)
+		}
+	}
+}
+
+func TestRS423Receive(t *testing.T) {
+	// RTS high holds the host back
+	bbc, host := newRS423Machine(t, 0x56)
+	if _, err := host.Write([]byte("AT")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	bbc.RunCycles(10000)
+	if bbc.ACIA.GetStatus()&hardware.ACIAReceiveFull != 0 {
+		t.Fatal("received with RTS high")
+	}
+
+	// a frame takes 1042us at 9600 baud
+	bbc.Bus.DirectWrite(0x16, 0xFE08)
+	start := bbc.Clock.GetCycles()
+	received := []byte{}
+	for len(received) < 2 && bbc.Clock.GetCycles()-start < machine.CPUFrequency/10 {
+		bbc.RunCycles(20)
+		if bbc.ACIA.GetStatus()&hardware.ACIAReceiveFull == 0 {
+			continue
+		}
+		if len(received) == 0 {
+			if micros := (bbc.Clock.GetCycles() - start) * 1e6 / machine.CPUFrequency; micros < 900 || micros > 1100 {
+				t.Fatalf("first byte received after %dus", micros)
+			}
+		}
+		value, _ := bbc.Bus.DirectRead(0xFE09)
+		received = append(received, value)
+	}
+	if string(received) != "AT" {
+		t.Fatalf("received %q", received)
+	}
+}
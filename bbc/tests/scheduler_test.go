@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"bbc/disc"
+	"bbc/hardware"
+	"bbc/utils"
+	"testing"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	testCtx.Reset()
+	clock := testCtx.clock
+
+	fired := []string{}
+	record := func(name string) *hardware.Event {
+		return clock.NewEvent(name, func(cycle uint64) error {
+			fired = append(fired, name)
+			if cycle != clock.GetCycles() {
+				t.Errorf("event %s fired at %d instead of %d", name, clock.GetCycles(), cycle)
+			}
+			return nil
+		})
+	}
+
+	late := record("late")
+	first := record("first")
+	second := record("second")
+	cancelled := record("cancelled")
+
+	clock.ScheduleAt(late, 10)
+	clock.ScheduleAt(first, 5)
+	clock.ScheduleAt(second, 5)
+	clock.ScheduleIn(cancelled, 7)
+	clock.Cancel(cancelled)
+
+	for i := 0; i < 20; i++ {
+		if err := testCtx.bus.Tick(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	expected := []string{"first", "second", "late"}
+	if len(fired) != len(expected) {
+		t.Fatalf("fired %v, expected %v", fired, expected)
+	}
+	for i := range expected {
+		if fired[i] != expected[i] {
+			t.Fatalf("fired %v, expected %v", fired, expected)
+		}
+	}
+}
+
+func TestSchedulerPeriodic(t *testing.T) {
+	testCtx.Reset()
+	clock := testCtx.clock
+
+	count := 0
+	var periodic *hardware.Event
+	periodic = clock.NewEvent("periodic", func(cycle uint64) error {
+		count++
+		clock.ScheduleAt(periodic, cycle+4)
+		return nil
+	})
+
+	// NOP executes in 2 cycles
+	program := []byte{0xEA, 0xEA, 0xEA, 0xEA, 0xEA, 0xEA, 0xEA, 0xEA}
+	testCtx.bus.WriteMultiple(program, 0x0200)
+	clock.Reset()
+	clock.ScheduleIn(periodic, 4)
+	testCtx.cpu.SetPC(0x0200)
+	for i := 0; i < 8; i++ {
+		if err := testCtx.cpu.ExecuteNext(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if count != 4 {
+		t.Fatalf("periodic event fired %d times instead of 4", count)
+	}
+
+	clock.Reset()
+	if periodic.IsScheduled() {
+		t.Fatal("clock reset must clear scheduled events")
+	}
+}
+
+// The 8271 schedules each step of its commands instead of counting down.
+func TestSchedulerDeviceEvent(t *testing.T) {
+	clock := hardware.NewVirtualClock(2e6)
+	if err := clock.AddBBCDomains(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	drive := hardware.NewDiscDrive(80)
+	image, _ := disc.Decode(testDiscImage(1, 40), ".ssd")
+	if err := drive.Insert(image); err != nil {
+		t.Fatalf(err.Error())
+	}
+	fdc := hardware.NewI8271("8271", utils.NewSegment(0xFE80, 0xFE9F), drive, nil)
+	bus, err := hardware.NewBus(clock, fdc)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// 2ms step rate and head settle time
+	bus.DirectWrite(0x35, 0xFE80)
+	for _, parameter := range []byte{hardware.I8271StepRate, 1, 1, 0} {
+		bus.DirectWrite(parameter, 0xFE81)
+	}
+
+	// seek 5 tracks: steps, settle and the step ending the command, at 1MHz
+	bus.DirectWrite(0x69, 0xFE80)
+	bus.DirectWrite(5, 0xFE81)
+	ticks := clock.GetDomain(hardware.DomainPeripheral).GetCycles() + 5*2000 + 2000 + 1
+	due, ok := bus.NextCycle()
+	if !ok {
+		t.Fatal("seek not scheduled")
+	}
+	for clock.GetCycles() < due {
+		if bus.NMI.IsAsserted() {
+			t.Fatalf("seek ended at cycle %d before %d", clock.GetCycles(), due)
+		}
+		if err := bus.Tick(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if !bus.NMI.IsAsserted() || fdc.GetStatus()&hardware.I8271ResultFull == 0 {
+		t.Fatalf("seek did not end at cycle %d", due)
+	}
+	if elapsed := clock.GetDomain(hardware.DomainPeripheral).GetCycles(); elapsed != ticks {
+		t.Fatalf("seek ended after %d ticks instead of %d", elapsed, ticks)
+	}
+	if _, ok := bus.NextCycle(); ok {
+		t.Fatal("event still scheduled after the command")
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"bbc/screenshot"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoldenTeletext(t *testing.T) {
+	bbc, _ := renderTeletext(t,
+		"\x0d\x01BBC \x02Computer \x0332K",
+		"\x0d\x01BBC \x02Computer \x0332K",
+		"",
+		"\x94\x7f\x35\x1a\x7f\x1e\x96\x7f",
+		"\x88Flash \x89steady",
+		"BASIC",
+		"",
+		">_",
+	)
+	frame, err := bbc.NextFrame()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	screenshot.AssertGolden(t, frame, filepath.Join("testdata", "teletext.png"), screenshot.Tolerance{})
+}
+
+func TestGoldenTolerance(t *testing.T) {
+	_, frame := renderTeletext(t, "tolerance")
+	golden := image.NewRGBA(frame.Bounds())
+	copy(golden.Pix, frame.Pix)
+	// one pixel slightly off, one completely
+	golden.Pix[golden.PixOffset(0, 0)] += 3
+	golden.Pix[golden.PixOffset(1, 0)+1] ^= 0xFF
+
+	if differing, _ := screenshot.Diff(frame, golden, 0); differing != 2 {
+		t.Fatalf("%d pixels differ", differing)
+	}
+	if differing, _ := screenshot.Diff(frame, golden, 4); differing != 1 {
+		t.Fatalf("%d pixels differ beyond tolerance", differing)
+	}
+	path := filepath.Join(t.TempDir(), "golden.png")
+	if err := screenshot.Save(path, golden); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := screenshot.Compare(frame, path, screenshot.Tolerance{Channel: 4}); err == nil {
+		t.Fatal("frame matched with a differing pixel")
+	}
+	if err := screenshot.Compare(frame, path, screenshot.Tolerance{Channel: 4, Pixels: 1}); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestScreenshotRun(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for _, dir := range dirs {
+		bbc, _ := renderTeletext(t, "deterministic")
+		err := screenshot.Run(bbc, screenshot.Options{
+			Dir:      dir,
+			Frames:   4,
+			Every:    2,
+			AtCycles: []uint64{200000},
+		})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	for _, name := range []string{"frame-000002.png", "frame-000004.png", "cycle-200000.png"} {
+		first, err := screenshot.Load(filepath.Join(dirs[0], name))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		second, err := screenshot.Load(filepath.Join(dirs[1], name))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if differing, _ := screenshot.Diff(first, second, 0); differing != 0 {
+			t.Fatalf("%s differs between runs", name)
+		}
+	}
+	if entries, _ := os.ReadDir(dirs[0]); len(entries) != 3 {
+		t.Fatalf("%d screenshots written", len(entries))
+	}
+}
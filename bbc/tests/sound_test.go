@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"bbc/audio"
+	"bbc/machine"
+	"path/filepath"
+	"testing"
+)
+
+// Writes to the sound chip as the MOS does: the byte on the slow data bus,
+// then a pulse of the latch sound write enable.
+func writeSound(bbc *machine.Machine, values ...byte) {
+	bbc.Bus.DirectWrite(0x0F, 0xFE42)
+	bbc.Bus.DirectWrite(0xFF, 0xFE43)
+	// the latch outputs reset low
+	bbc.Bus.DirectWrite(0x08, 0xFE40)
+	for _, value := range values {
+		bbc.Bus.DirectWrite(value, 0xFE4F)
+		bbc.Bus.DirectWrite(0x00, 0xFE40)
+		bbc.Bus.DirectWrite(0x08, 0xFE40)
+	}
+}
+
+func readAllSamples(bbc *machine.Machine) []int16 {
+	samples := make([]int16, bbc.Sound.Buffered())
+	return samples[:bbc.Sound.ReadSamples(samples)]
+}
+
+func TestSoundRegisters(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	// tone 1 period 0x3FE, then its high bits alone
+	writeSound(bbc, 0xAE, 0x3F)
+	if bbc.Sound.GetTone(1) != 0x3FE {
+		t.Fatalf("wrong tone period %03X", bbc.Sound.GetTone(1))
+	}
+	writeSound(bbc, 0x01)
+	if bbc.Sound.GetTone(1) != 0x01E {
+		t.Fatalf("wrong tone period after data byte %03X", bbc.Sound.GetTone(1))
+	}
+	// a data byte after an attenuation replaces it
+	writeSound(bbc, 0xD5, 0x03)
+	if bbc.Sound.GetAttenuation(2) != 0x03 {
+		t.Fatalf("wrong attenuation %X", bbc.Sound.GetAttenuation(2))
+	}
+	writeSound(bbc, 0xE5)
+	if bbc.Sound.GetNoise() != 0x05 {
+		t.Fatalf("wrong noise control %X", bbc.Sound.GetNoise())
+	}
+
+	// port A writes without the write enable do not reach the chip
+	bbc.Bus.DirectWrite(0x9F, 0xFE4F)
+	if bbc.Sound.GetAttenuation(0) != 0x0F {
+		t.Fatal("chip written while disabled")
+	}
+}
+
+func TestSoundTone(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	if err := bbc.Sound.SetSampleRate(50000); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// 250 ticks per half period: 500Hz at full volume
+	writeSound(bbc, 0x8A, 0x0F, 0x90)
+	// the counter first ends the half period of tone 0 from reset
+	if err := bbc.RunCycles(10000); err != nil {
+		t.Fatalf(err.Error())
+	}
+	readAllSamples(bbc)
+	if err := bbc.RunCycles(machine.CPUFrequency / 10); err != nil {
+		t.Fatalf(err.Error())
+	}
+	samples := readAllSamples(bbc)
+	if len(samples) < 4990 || len(samples) > 5010 {
+		t.Fatalf("%d samples in 100ms", len(samples))
+	}
+	rising := 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 4000 && samples[i] >= 4000 {
+			rising++
+		}
+	}
+	if rising < 49 || rising > 51 {
+		t.Fatalf("%d periods in 100ms", rising)
+	}
+
+	// silenced
+	writeSound(bbc, 0x9F)
+	if err := bbc.RunCycles(1000); err != nil {
+		t.Fatalf(err.Error())
+	}
+	samples = readAllSamples(bbc)
+	if samples[len(samples)-1] != 0 {
+		t.Fatalf("output %d once attenuated", samples[len(samples)-1])
+	}
+}
+
+// Tone 0 is the lowest note, toggling every 1024 ticks.
+func TestSoundLowestTone(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	if err := bbc.Sound.SetSampleRate(50000); err != nil {
+		t.Fatalf(err.Error())
+	}
+	writeSound(bbc, 0x80, 0x00, 0x90)
+	readAllSamples(bbc)
+	if err := bbc.RunCycles(machine.CPUFrequency / 5); err != nil {
+		t.Fatalf(err.Error())
+	}
+	samples := readAllSamples(bbc)
+	edges := []int{}
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 4000 && samples[i] >= 4000 {
+			edges = append(edges, i)
+		}
+	}
+	// 2048 ticks at 250kHz are 409.6 samples
+	if len(edges) < 20 {
+		t.Fatalf("%d periods in 200ms", len(edges))
+	}
+	for i := 1; i < len(edges); i++ {
+		if period := edges[i] - edges[i-1]; period < 409 || period > 410 {
+			t.Fatalf("period of %d samples", period)
+		}
+	}
+}
+
+func TestSoundNoise(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	// periodic noise, high one shift out of 15
+	writeSound(bbc, 0xE0, 0xF0)
+	readAllSamples(bbc)
+	if err := bbc.RunCycles(machine.CPUFrequency / 10); err != nil {
+		t.Fatalf(err.Error())
+	}
+	periodic := readAllSamples(bbc)
+	sum := 0
+	for _, sample := range periodic {
+		sum += int(sample)
+	}
+	if mean := sum / len(periodic); mean < 8191/15-100 || mean > 8191/15+100 {
+		t.Fatalf("periodic noise mean %d", mean)
+	}
+
+	// white noise is high about half of the time, less at first from the
+	// reset seed
+	writeSound(bbc, 0xE4)
+	readAllSamples(bbc)
+	if err := bbc.RunCycles(machine.CPUFrequency / 10); err != nil {
+		t.Fatalf(err.Error())
+	}
+	white := readAllSamples(bbc)
+	sum = 0
+	for _, sample := range white {
+		sum += int(sample)
+	}
+	if mean := sum / len(white); mean < 8191/4 || mean > 8191*3/4 {
+		t.Fatalf("white noise mean %d", mean)
+	}
+}
+
+func TestSoundWAV(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	writeSound(bbc, 0x8A, 0x0F, 0x90)
+	if err := bbc.RunCycles(machine.CPUFrequency / 50); err != nil {
+		t.Fatalf(err.Error())
+	}
+	samples := readAllSamples(bbc)
+
+	path := filepath.Join(t.TempDir(), "sound.wav")
+	writer, err := audio.CreateWAV(path, bbc.Sound.GetSampleRate())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := writer.Write(samples); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	rate, read, err := audio.LoadWAV(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if rate != 44100 || len(read) != len(samples) || read[len(read)/2] != samples[len(samples)/2] {
+		t.Fatalf("WAV read back as %d samples at %dHz", len(read), rate)
+	}
+}
@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"bbc/machine"
+	"bbc/tape"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func newTestTape(baud uint16, data []byte) *tape.UEF {
+	uef := tape.New()
+	uef.Add(tape.ChunkOrigin, []byte("test\x00"))
+	uef.Add(tape.ChunkBaudRate, tape.Uint16Data(baud))
+	// half a second of carrier
+	uef.Add(tape.ChunkCarrier, tape.Uint16Data(1200))
+	uef.Add(tape.ChunkData, data)
+	uef.Add(tape.ChunkCarrier, tape.Uint16Data(240))
+	uef.Add(tape.ChunkFloatGap, tape.Float32Data(0.1))
+	return uef
+}
+
+// Plays the tape with the ACIA set to the divider, returns the bytes received
+// and the cycles taken.
+func loadTape(t *testing.T, uef *tape.UEF, control byte, fast bool) ([]byte, uint64, bool) {
+	bbc := newTestMachine(t, idleProgram)
+	bbc.Tape.Insert(uef)
+	bbc.Tape.SetFastLoad(fast)
+	bbc.Bus.DirectWrite(0x03, 0xFE08)
+	bbc.Bus.DirectWrite(control, 0xFE08)
+	bbc.Bus.DirectWrite(hardware.SerialMotor, 0xFE10)
+
+	received := []byte{}
+	carrier := false
+	start := bbc.Clock.GetCycles()
+	for !bbc.Tape.IsEnded() && bbc.Clock.GetCycles()-start < 4*machine.CPUFrequency {
+		if err := bbc.RunCycles(100); err != nil {
+			t.Fatalf(err.Error())
+		}
+		status := bbc.ACIA.GetStatus()
+		if status&hardware.ACIACarrierLost != 0 && !carrier {
+			// the MOS clears the carrier status reading the status then data
+			carrier = true
+			bbc.Bus.DirectRead(0xFE08)
+			bbc.Bus.DirectRead(0xFE09)
+			continue
+		}
+		if status&hardware.ACIAReceiveFull != 0 {
+			value, _ := bbc.Bus.DirectRead(0xFE09)
+			received = append(received, value)
+		}
+	}
+	return received, bbc.Clock.GetCycles() - start, carrier
+}
+
+func TestTapeLoad(t *testing.T) {
+	// 8N1, divided by 16
+	received, cycles, carrier := loadTape(t, newTestTape(1200, []byte("HELLO")), 0x15, false)
+	if !carrier {
+		t.Fatal("carrier not detected")
+	}
+	if string(received) != "HELLO" {
+		t.Fatalf("received %q", received)
+	}
+	// 0.5s + 5 bytes of 10 bits + 0.1s + 0.1s
+	if seconds := float64(cycles) / machine.CPUFrequency; seconds < 0.7 || seconds > 0.8 {
+		t.Fatalf("tape played in %.3fs", seconds)
+	}
+
+	// 300 baud, divided by 64
+	received, _, _ = loadTape(t, newTestTape(300, []byte{0x00, 0xFF, 0x2A}), 0x16, false)
+	if !bytes.Equal(received, []byte{0x00, 0xFF, 0x2A}) {
+		t.Fatalf("received %v at 300 baud", received)
+	}
+}
+
+func TestTapeFastLoad(t *testing.T) {
+	data := []byte("a longer block of data, loaded faster")
+	_, slow, _ := loadTape(t, newTestTape(1200, data), 0x15, false)
+	received, fast, _ := loadTape(t, newTestTape(1200, data), 0x15, true)
+	if string(received) != string(data) {
+		t.Fatalf("received %q when fast loading", received)
+	}
+	if fast*8 > slow {
+		t.Fatalf("fast load took %d cycles, %d otherwise", fast, slow)
+	}
+}
+
+func TestACIAInterrupts(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	bbc.Tape.Insert(newTestTape(1200, []byte{0x55}))
+	bbc.Bus.DirectWrite(0x03, 0xFE08)
+	// receive interrupts
+	bbc.Bus.DirectWrite(0x95, 0xFE08)
+	bbc.Bus.DirectWrite(hardware.SerialMotor, 0xFE10)
+	if err := bbc.RunCycles(machine.CPUFrequency / 5); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// carrier detected
+	if !bbc.Bus.IRQ.IsAssertedBy("ACIA") {
+		t.Fatal("no interrupt on carrier")
+	}
+	bbc.Bus.DirectRead(0xFE08)
+	bbc.Bus.DirectRead(0xFE09)
+	if bbc.Bus.IRQ.IsAssertedBy("ACIA") {
+		t.Fatal("carrier interrupt not cleared")
+	}
+
+	// transmit interrupts while the transmit register is free
+	bbc.Bus.DirectWrite(0x35, 0xFE08)
+	if !bbc.Bus.IRQ.IsAssertedBy("ACIA") {
+		t.Fatal("no transmit interrupt")
+	}
+	bbc.Bus.DirectWrite(0x41, 0xFE09)
+	if bbc.Bus.IRQ.IsAssertedBy("ACIA") {
+		t.Fatal("transmit interrupt with a full register")
+	}
+	// a 1200 baud clock edge moves the byte to the shift register
+	if err := bbc.RunCycles(200); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !bbc.Bus.IRQ.IsAssertedBy("ACIA") || !bbc.ACIA.IsTransmitting() {
+		t.Fatal("transmit register not emptied")
+	}
+}
+
+func TestUEFReadWrite(t *testing.T) {
+	uef := newTestTape(1200, []byte("DATA"))
+	buffer := &bytes.Buffer{}
+	if err := uef.Write(buffer); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// images are usually compressed
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	writer.Write(buffer.Bytes())
+	writer.Close()
+
+	for _, image := range [][]byte{buffer.Bytes(), compressed.Bytes()} {
+		read, err := tape.Read(bytes.NewReader(image))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if len(read.Chunks) != len(uef.Chunks) || read.Chunks[3].ID != tape.ChunkData || string(read.Chunks[3].Data) != "DATA" {
+			t.Fatalf("chunks read back as %v", read.Chunks)
+		}
+	}
+	if _, err := tape.Read(bytes.NewReader([]byte("not a tape image"))); err == nil {
+		t.Fatal("invalid image accepted")
+	}
+}
+
+func TestTapeRecord(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	bbc.Tape.StartRecording()
+	bbc.Bus.DirectWrite(0x03, 0xFE08)
+	bbc.Bus.DirectWrite(0x15, 0xFE08)
+	bbc.Bus.DirectWrite(hardware.SerialMotor, 0xFE10)
+	// carrier while the ACIA idles
+	if err := bbc.RunCycles(machine.CPUFrequency / 5); err != nil {
+		t.Fatalf(err.Error())
+	}
+	data := []byte("*SAVED\x00\xFF")
+	for _, value := range data {
+		for bbc.ACIA.GetStatus()&hardware.ACIATransmitFree == 0 {
+			if err := bbc.RunCycles(10); err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+		bbc.Bus.DirectWrite(value, 0xFE09)
+	}
+	if err := bbc.RunCycles(machine.CPUFrequency / 5); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bbc.Bus.DirectWrite(0x00, 0xFE10)
+	// not recorded with the motor off
+	if err := bbc.RunCycles(machine.CPUFrequency / 10); err != nil {
+		t.Fatalf(err.Error())
+	}
+	uef := bbc.Tape.StopRecording()
+
+	ids := []uint16{}
+	for _, chunk := range uef.Chunks {
+		ids = append(ids, chunk.ID)
+	}
+	expected := []uint16{tape.ChunkBaudRate, tape.ChunkCarrier, tape.ChunkData, tape.ChunkCarrier}
+	if len(ids) != len(expected) {
+		t.Fatalf("recorded chunks %04X", ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("recorded chunks %04X", ids)
+		}
+	}
+	if !bytes.Equal(uef.Chunks[2].Data, data) {
+		t.Fatalf("recorded %q", uef.Chunks[2].Data)
+	}
+	// 0.2s of carrier at 2400Hz, up to a bit either side
+	if cycles := int(uef.Chunks[1].Data[0]) | int(uef.Chunks[1].Data[1])<<8; cycles < 476 || cycles > 484 {
+		t.Fatalf("%d carrier cycles recorded", cycles)
+	}
+
+	// the recording loads back
+	received, _, carrier := loadTape(t, uef, 0x15, false)
+	if !carrier || !bytes.Equal(received, data) {
+		t.Fatalf("recording loaded as %q", received)
+	}
+}
+
+func TestTapeRender(t *testing.T) {
+	uef := newTestTape(1200, []byte{0x00})
+	samples := tape.Render(uef, 44100)
+	// 0.5s, 10 bits, 0.1s, 0.1s
+	seconds := 0.7 + 10.0/1200
+	if expected := int(seconds * 44100); len(samples) < expected-2 || len(samples) > expected+2 {
+		t.Fatalf("%d samples rendered instead of %d", len(samples), expected)
+	}
+	// the carrier is a 2400Hz tone
+	crossings := 0
+	for i := 1; i < 22050; i++ {
+		if samples[i-1] < 0 && samples[i] >= 0 {
+			crossings++
+		}
+	}
+	if crossings < 1198 || crossings > 1200 {
+		t.Fatalf("%d carrier cycles in 0.5s", crossings)
+	}
+	// silence at the end
+	if samples[len(samples)-1] != 0 {
+		t.Fatal("gap not silent")
+	}
+}
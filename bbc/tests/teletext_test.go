@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"bbc/machine"
+	"image"
+	"testing"
+)
+
+var mode7 = []byte{63, 40, 51, 0x24, 30, 2, 25, 27, 0x93, 18, 0x72, 0x13, 0x28, 0x00}
+
+// Both fields of a MODE 7 screen holding the given rows.
+func renderTeletext(t *testing.T, rows ...string) (*machine.Machine, *image.RGBA) {
+	bbc := newTestMachine(t, idleProgram)
+	setMode(bbc, 0x4B, mode7, false, false)
+	for row, text := range rows {
+		for column, c := range []byte(text) {
+			bbc.RAM.DirectWrite(c, 0x7C00+uint16(40*row+column))
+		}
+	}
+	var frame *image.RGBA
+	for i := 0; i < 3; i++ {
+		var err error
+		if frame, err = bbc.RunFrame(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	return bbc, frame
+}
+
+// Text rows are 20 lines high, the first one starts on line 4.
+const teletextTop = 4
+
+func TestTeletextAlphanumerics(t *testing.T) {
+	_, frame := renderTeletext(t, "A\x01A")
+	white, red, black := [3]byte{0xFF, 0xFF, 0xFF}, [3]byte{0xFF, 0, 0}, [3]byte{}
+
+	// apex of the A on its second row, the crossbar on the sixth
+	if pixelAt(frame, 6, teletextTop+2) != white || pixelAt(frame, 0, teletextTop+2) != black {
+		t.Fatal("A apex not drawn")
+	}
+	if pixelAt(frame, 1, teletextTop+10) != white || pixelAt(frame, 12, teletextTop+11) != white {
+		t.Fatal("A crossbar not drawn")
+	}
+	// character rounding fills the diagonal half dots
+	if pixelAt(frame, 5, teletextTop+3) != white || pixelAt(frame, 5, teletextTop+2) != black {
+		t.Fatal("A not rounded")
+	}
+	// the control code shows as a space, the colour applies after it
+	for x := 16; x < 32; x++ {
+		if pixelAt(frame, x, teletextTop+10) != black {
+			t.Fatal("control code not shown as a space")
+		}
+	}
+	if pixelAt(frame, 32+6, teletextTop+2) != red {
+		t.Fatal("alphanumeric red not applied")
+	}
+}
+
+func TestTeletextGraphics(t *testing.T) {
+	// graphics green, full block, left column, separated full block, then
+	// hold graphics over a colour change
+	_, frame := renderTeletext(t, "\x12\x7f\x35\x1a\x7f\x19\x1e\x7f\x13\x01A")
+	green, yellow, black := [3]byte{0, 0xFF, 0}, [3]byte{0xFF, 0xFF, 0}, [3]byte{}
+
+	for _, y := range []int{0, 9, 19} {
+		if pixelAt(frame, 16, teletextTop+y) != green || pixelAt(frame, 31, teletextTop+y) != green {
+			t.Fatalf("full block line %d not drawn", y)
+		}
+	}
+	if pixelAt(frame, 32, teletextTop+10) != green || pixelAt(frame, 40, teletextTop+10) != black {
+		t.Fatal("left column mosaic not drawn")
+	}
+	// separated mosaics lose the left dot and the bottom lines of each cell
+	if pixelAt(frame, 64, teletextTop+10) != black || pixelAt(frame, 67, teletextTop+10) != green {
+		t.Fatal("separated mosaic left gap")
+	}
+	if pixelAt(frame, 67, teletextTop+4) != black || pixelAt(frame, 67, teletextTop+3) != green {
+		t.Fatal("separated mosaic bottom gap")
+	}
+	// the held block replaces the control codes, in the new colour
+	if pixelAt(frame, 8*16+1, teletextTop) != green || pixelAt(frame, 9*16+1, teletextTop) != yellow {
+		t.Fatal("held graphics not shown")
+	}
+	if pixelAt(frame, 10*16+1, teletextTop) != black {
+		t.Fatal("held graphics shown in alphanumerics")
+	}
+}
+
+func TestTeletextDoubleHeight(t *testing.T) {
+	_, frame := renderTeletext(t, "", "\x0dB", "\x0dB", "B")
+	white, black := [3]byte{0xFF, 0xFF, 0xFF}, [3]byte{}
+	// the B top bar is on row 1, its bottom one on row 7: doubled they fall
+	// in the top and bottom text rows
+	top, bottom := teletextTop+20, teletextTop+40
+	if pixelAt(frame, 16, top+4) != white || pixelAt(frame, 16, top+1) != black {
+		t.Fatal("double height top half")
+	}
+	if pixelAt(frame, 20, bottom+8) != white || pixelAt(frame, 20, bottom+12) != black {
+		t.Fatal("double height bottom half")
+	}
+	// normal height on the next row
+	if pixelAt(frame, 4, teletextTop+60+2) != white || pixelAt(frame, 4, teletextTop+60+1) != black {
+		t.Fatal("normal height row after the double height pair")
+	}
+}
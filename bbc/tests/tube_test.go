@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"bbc/machine"
+	"testing"
+)
+
+// Client ROM at F800 copying its first page to the RAM under it, then
+// sending back each byte received in R2 plus one.
+func tubeClientROM() []byte {
+	rom := make([]byte, 0x800)
+	copy(rom, []byte{
+		0xA2, 0x00, // LDX #0
+		0xBD, 0x00, 0xF8, // F802: LDA F800,X
+		0x9D, 0x00, 0xF8, // STA F800,X
+		0xE8,       // INX
+		0xF0, 0x03, // BEQ F80E
+		0x4C, 0x02, 0xF8, // JMP F802
+		0xAD, 0xFA, 0xFE, // F80E: LDA FEFA
+		0x30, 0x03, // BMI F816
+		0x4C, 0x0E, 0xF8, // JMP F80E
+		0xAD, 0xFB, 0xFE, // F816: LDA FEFB
+		0x18,       // CLC
+		0x69, 0x01, // ADC #1
+		0x8D, 0xFB, 0xFE, // STA FEFB
+		0x4C, 0x0E, 0xF8, // JMP F80E
+	})
+	rom[0x7FC], rom[0x7FD] = 0x00, 0xF8
+	return rom
+}
+
+func newTubeMachine(t *testing.T) *machine.Machine {
+	mos := make([]byte, machine.MOSSize)
+	copy(mos, idleProgram)
+	mos[0x3FFC], mos[0x3FFD] = 0x00, 0xC0
+	bbc, err := machine.New(machine.Config{MOS: mos, Virtual: true, TubeROM: tubeClientROM()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bbc
+}
+
+// Sends a byte in R2 and waits for the answer of the client.
+func tubeEcho(t *testing.T, bbc *machine.Machine, value byte) byte {
+	bbc.Bus.DirectWrite(value, 0xFEE3)
+	for i := 0; i < 1000; i++ {
+		bbc.RunCycles(10)
+		if status, _ := bbc.Bus.DirectRead(0xFEE2); status&hardware.TubeDataAvailable != 0 {
+			answer, _ := bbc.Bus.DirectRead(0xFEE3)
+			return answer
+		}
+	}
+	t.Fatalf("no answer to %02X", value)
+	return 0
+}
+
+func TestTubeSecondProcessor(t *testing.T) {
+	bbc := newTubeMachine(t)
+	// the MOS finds the Tube setting then reading back a flag
+	bbc.Bus.DirectWrite(0x81, 0xFEE0)
+	if status, _ := bbc.Bus.DirectRead(0xFEE0); status&0x3F != hardware.TubeHostIRQ {
+		t.Fatalf("R1 status %02X", status)
+	}
+
+	bbc.RunCycles(2000)
+	host, parasite := bbc.Clock.GetCycles(), bbc.SecondProcessor.Clock.GetCycles()
+	if parasite < host*3/2-10 || parasite > host*3/2+10 {
+		t.Fatalf("%d parasite cycles for %d host ones", parasite, host)
+	}
+	if answer := tubeEcho(t, bbc, 0x41); answer != 0x42 {
+		t.Fatalf("answer %02X", answer)
+	}
+	// the client runs from its copy in RAM
+	if bbc.SecondProcessor.IsROMPagedIn() {
+		t.Fatal("ROM still paged in")
+	}
+	if vector, _ := bbc.SecondProcessor.Bus.DirectRead(0xFFFD); vector != 0 {
+		t.Fatalf("ROM read after the Tube access, %02X", vector)
+	}
+
+	// parasite reset, the ROM is back and the client starts again
+	bbc.Bus.DirectWrite(0x80|hardware.TubeParasiteReset, 0xFEE0)
+	bbc.RunCycles(100)
+	if !bbc.SecondProcessor.IsROMPagedIn() {
+		t.Fatal("ROM not paged in by the reset")
+	}
+	bbc.Bus.DirectWrite(hardware.TubeParasiteReset, 0xFEE0)
+	if answer := tubeEcho(t, bbc, 0x7F); answer != 0x80 {
+		t.Fatalf("answer %02X after the reset", answer)
+	}
+}
+
+func TestTubeInterrupts(t *testing.T) {
+	bbc := newTubeMachine(t)
+	sp := bbc.SecondProcessor
+
+	// R1 to the parasite interrupts it
+	bbc.Bus.DirectWrite(0x80|hardware.TubeParasiteIRQR1, 0xFEE0)
+	bbc.Bus.DirectWrite(0x12, 0xFEE1)
+	if !sp.Bus.IRQ.IsAssertedBy("Tube") {
+		t.Fatal("no parasite IRQ from R1")
+	}
+	if value, _ := sp.Bus.DirectRead(0xFEF9); value != 0x12 || sp.Bus.IRQ.IsAsserted() {
+		t.Fatalf("parasite read %02X from R1", value)
+	}
+
+	// R4 to the host interrupts it
+	bbc.Bus.DirectWrite(0x80|hardware.TubeHostIRQ, 0xFEE0)
+	sp.Bus.DirectWrite(0x55, 0xFEFF)
+	if !bbc.Bus.IRQ.IsAssertedBy("Tube") {
+		t.Fatal("no host IRQ from R4")
+	}
+	if value, _ := bbc.Bus.DirectRead(0xFEE7); value != 0x55 || bbc.Bus.IRQ.IsAssertedBy("Tube") {
+		t.Fatalf("host read %02X from R4", value)
+	}
+
+	// R3 holds a byte out of reset, the NMI waits for the host to read it
+	bbc.Bus.DirectWrite(0x80|hardware.TubeParasiteNMI, 0xFEE0)
+	if sp.Bus.NMI.IsAssertedBy("Tube") {
+		t.Fatal("parasite NMI with R3 from the parasite full")
+	}
+	bbc.Bus.DirectRead(0xFEE5)
+	if !sp.Bus.NMI.IsAssertedBy("Tube") {
+		t.Fatal("no parasite NMI with R3 empty")
+	}
+
+	// the parasite fills the 24 bytes of R1 to the host
+	for i := 0; i < 30; i++ {
+		sp.Bus.DirectWrite(byte(i), 0xFEF9)
+	}
+	if status, _ := sp.Bus.DirectRead(0xFEF8); status&hardware.TubeNotFull != 0 {
+		t.Fatalf("R1 not full, parasite status %02X", status)
+	}
+	for i := 0; i < 24; i++ {
+		if value, _ := bbc.Bus.DirectRead(0xFEE1); value != byte(i) {
+			t.Fatalf("R1 byte %d read %02X", i, value)
+		}
+	}
+	if status, _ := bbc.Bus.DirectRead(0xFEE0); status&hardware.TubeDataAvailable != 0 {
+		t.Fatalf("R1 not empty, host status %02X", status)
+	}
+}
@@ -0,0 +1,295 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"bbc/utils"
+	"testing"
+)
+
+type portRecorder struct {
+	input  byte
+	output byte
+	ca2    []bool
+}
+
+func (port *portRecorder) ReadPort() byte            { return port.input }
+func (port *portRecorder) WritePort(value, ddr byte) { port.output = value & ddr }
+func (port *portRecorder) WriteControl(line hardware.VIAControlLine, level bool) {
+	if line == hardware.VIAControl2 {
+		port.ca2 = append(port.ca2, level)
+	}
+}
+
+func newVIABus(t *testing.T) (*hardware.Bus, *hardware.VIA) {
+	clock := hardware.NewVirtualClock(2e6)
+	if err := clock.AddBBCDomains(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	via := hardware.NewVIA("system VIA", utils.NewSegment(0xFE40, 0xFE5F))
+	bus, err := hardware.NewBus(clock, via)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return bus, via
+}
+
+// runs the 1MHz domain for the given number of ticks
+func step1MHz(t *testing.T, bus *hardware.Bus, ticks int) {
+	for i := 0; i < 2*ticks; i++ {
+		if err := bus.Tick(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+}
+
+func TestVIATimer1(t *testing.T) {
+	bus, via := newVIABus(t)
+	// free-running, PB7 output, T1 interrupt enabled
+	bus.DirectWrite(0xC0, 0xFE4B)
+	bus.DirectWrite(0xC0, 0xFE4E)
+	bus.DirectWrite(0x10, 0xFE44)
+	bus.DirectWrite(0x00, 0xFE45)
+	if bus.IRQ.IsAsserted() {
+		t.Fatal("IRQ asserted before timeout")
+	}
+
+	// the interrupt fires N+1.5 cycles after the write
+	step1MHz(t, bus, 16)
+	if bus.IRQ.IsAsserted() {
+		t.Fatal("IRQ asserted too early")
+	}
+	step1MHz(t, bus, 1)
+	if !bus.IRQ.IsAsserted() {
+		t.Fatal("IRQ not asserted on timeout")
+	}
+	peripheral := bus.GetDomain(hardware.DomainPeripheral)
+	fired := peripheral.GetCycles()
+	pb, _ := bus.DirectRead(0xFE40)
+	if pb&0x80 == 0 {
+		t.Fatal("PB7 not toggled on timeout")
+	}
+
+	// reading T1 low clears the flag, the next one comes N+2 cycles later
+	bus.DirectRead(0xFE44)
+	if bus.IRQ.IsAsserted() || via.IsIRQ() {
+		t.Fatal("IRQ not cleared")
+	}
+	ifr, _ := bus.DirectRead(0xFE4D)
+	if ifr != 0 {
+		t.Fatalf("IFR not cleared (%x)", ifr)
+	}
+	for !via.IsIRQ() {
+		bus.Tick()
+	}
+	if period := peripheral.GetCycles() - fired; period != 18 {
+		t.Fatalf("free running period is %d cycles", period)
+	}
+	ifr, _ = bus.DirectRead(0xFE4D)
+	if ifr != hardware.VIAInterruptAny|hardware.VIAInterruptT1 {
+		t.Fatalf("unexpected IFR %x", ifr)
+	}
+}
+
+func TestVIATimer2OneShot(t *testing.T) {
+	bus, _ := newVIABus(t)
+	bus.DirectWrite(0xA0, 0xFE4E)
+	bus.DirectWrite(0x04, 0xFE48)
+	bus.DirectWrite(0x00, 0xFE49)
+	step1MHz(t, bus, 6)
+	if !bus.IRQ.IsAsserted() {
+		t.Fatal("T2 did not fire")
+	}
+	bus.DirectRead(0xFE48)
+	step1MHz(t, bus, 0x10000)
+	if bus.IRQ.IsAsserted() {
+		t.Fatal("one shot T2 fired twice")
+	}
+}
+
+// Timer underflows are events, the counter is worked out when read.
+func TestVIATimerEvents(t *testing.T) {
+	bus, _ := newVIABus(t)
+	peripheral := bus.GetDomain(hardware.DomainPeripheral)
+	if _, ok := bus.NextCycle(); ok {
+		t.Fatal("event scheduled by an idle VIA")
+	}
+	// one shot, T1 interrupt enabled
+	bus.DirectWrite(0xC0, 0xFE4E)
+	bus.DirectWrite(0x00, 0xFE44)
+	bus.DirectWrite(0x01, 0xFE45)
+	start := peripheral.GetCycles()
+	due, ok := bus.NextCycle()
+	if !ok {
+		t.Fatal("T1 underflow not scheduled")
+	}
+
+	step1MHz(t, bus, 0x40)
+	low, _ := bus.DirectRead(0xFE44)
+	if elapsed := peripheral.GetCycles() - start; uint64(low) != 0x100-elapsed {
+		t.Fatalf("counter low %02X after %d ticks", low, elapsed)
+	}
+	if high, _ := bus.DirectRead(0xFE45); high != 0 {
+		t.Fatalf("counter high %02X", high)
+	}
+
+	for bus.GetCycles() < due {
+		if bus.IRQ.IsAsserted() {
+			t.Fatalf("T1 fired at cycle %d before %d", bus.GetCycles(), due)
+		}
+		bus.Tick()
+	}
+	if !bus.IRQ.IsAsserted() || peripheral.GetCycles()-start != 0x101 {
+		t.Fatalf("T1 did not fire 257 ticks after the write")
+	}
+	if _, ok := bus.NextCycle(); ok {
+		t.Fatal("one shot T1 still scheduled")
+	}
+}
+
+// Timer 2 counts PB6 falling edges, peripherals report their changes.
+func TestVIATimer2PulseCount(t *testing.T) {
+	bus, via := newVIABus(t)
+	port := &portRecorder{input: 0xFF}
+	via.ConnectPortB(port)
+	bus.DirectWrite(0x20, 0xFE4B)
+	bus.DirectWrite(0xA0, 0xFE4E)
+	bus.DirectWrite(0x02, 0xFE48)
+	bus.DirectWrite(0x00, 0xFE49)
+	if _, ok := bus.NextCycle(); ok {
+		t.Fatal("pulse counting scheduled an event")
+	}
+	for i := 0; i < 2; i++ {
+		if bus.IRQ.IsAsserted() {
+			t.Fatalf("T2 fired after %d pulses", i)
+		}
+		port.input = 0xBF
+		via.UpdateInputs()
+		step1MHz(t, bus, 100)
+		port.input = 0xFF
+		via.UpdateInputs()
+	}
+	if !bus.IRQ.IsAsserted() {
+		t.Fatal("T2 did not fire after 2 pulses")
+	}
+}
+
+func TestVIAPortsAndHandshake(t *testing.T) {
+	bus, via := newVIABus(t)
+	port := &portRecorder{input: 0xAF}
+	via.ConnectPortA(port)
+
+	// low nibble output, CA2 handshake, CA1 positive edge, latching enabled
+	bus.DirectWrite(0x0F, 0xFE43)
+	bus.DirectWrite(0x09, 0xFE4C)
+	bus.DirectWrite(0x01, 0xFE4B)
+	bus.DirectWrite(0x05, 0xFE41)
+	if port.output != 0x05 {
+		t.Fatalf("port output %x", port.output)
+	}
+	if len(port.ca2) != 1 || port.ca2[0] {
+		t.Fatal("CA2 did not go low on ORA write")
+	}
+
+	via.SetCA1(false)
+	via.SetCA1(true)
+	if len(port.ca2) != 2 || !port.ca2[1] {
+		t.Fatal("CA2 not released by CA1")
+	}
+	port.input = 0x0F
+	value, _ := bus.DirectRead(0xFE4F)
+	if value != 0xA5 {
+		t.Fatalf("latched port A read %x", value)
+	}
+	ifr, _ := bus.DirectRead(0xFE4D)
+	if ifr&hardware.VIAInterruptCA1 == 0 {
+		t.Fatal("CA1 flag not set")
+	}
+	if bus.IRQ.IsAsserted() {
+		t.Fatal("disabled interrupt asserted IRQ")
+	}
+}
+
+func TestIRQServicing(t *testing.T) {
+	testCtx.Reset()
+	cpu := testCtx.cpu
+	bus := testCtx.bus
+
+	// handler at 0x0300: LDA #$42; RTI
+	bus.WriteMultiple([]byte{0xA9, 0x42, 0x40}, 0x0300)
+	bus.WriteMultiple([]byte{0x00, 0x03}, 0xFFFE)
+	// main: CLI; NOP; NOP
+	bus.WriteMultiple([]byte{0x58, 0xEA, 0xEA}, 0x0200)
+	cpu.SetPC(0x0200)
+	cpu.SetStatus(true, 2)
+
+	bus.IRQ.Set("test", true)
+	if err := cpu.ExecuteNext(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	start := testCtx.clock.GetCycles()
+	if err := cpu.ExecuteNext(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cpu.GetPC() != 0x0300 || testCtx.clock.GetCycles()-start != 7 {
+		t.Fatalf("IRQ not serviced (PC %x)", cpu.GetPC())
+	}
+	bus.IRQ.Set("test", false)
+	for i := 0; i < 2; i++ {
+		if err := cpu.ExecuteNext(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if cpu.GetPC() != 0x0201 || cpu.A != 0x42 {
+		t.Fatalf("RTI returned to %x", cpu.GetPC())
+	}
+
+	// NMI ignores the I flag and is edge triggered
+	bus.WriteMultiple([]byte{0x00, 0x03}, 0xFFFA)
+	cpu.SetStatus(true, 2)
+	bus.NMI.Set("test", true)
+	cpu.ExecuteNext()
+	if cpu.GetPC() != 0x0300 {
+		t.Fatal("NMI not serviced")
+	}
+	cpu.ExecuteNext()
+	cpu.ExecuteNext()
+	if cpu.GetPC() != 0x0201 {
+		t.Fatal("NMI serviced twice")
+	}
+}
+
+func TestAddressableLatch(t *testing.T) {
+	bus, via := newVIABus(t)
+	latch := hardware.NewAddressableLatch("IC32")
+	if err := bus.AddComponent(latch); err != nil {
+		t.Fatalf(err.Error())
+	}
+	via.ConnectPortB(latch)
+
+	changes := []hardware.LatchOutput{}
+	latch.Observe(func(output hardware.LatchOutput, level bool) {
+		changes = append(changes, output)
+	})
+
+	// address lines still inputs: nothing is latched
+	bus.DirectWrite(0x0E, 0xFE40)
+	if latch.GetValue() != 0 {
+		t.Fatal("latched while port B is an input")
+	}
+
+	bus.DirectWrite(0x0F, 0xFE42)
+	// caps lock LED output high (LED off) then keyboard autoscan
+	bus.DirectWrite(0x0E, 0xFE40)
+	bus.DirectWrite(0x0B, 0xFE40)
+	if latch.IsCapsLockLED() || !latch.IsKeyboardAutoScan() || !latch.IsShiftLockLED() {
+		t.Fatalf("unexpected latch value %x", latch.GetValue())
+	}
+	// C1 set: 20K screen
+	bus.DirectWrite(0x0D, 0xFE40)
+	if latch.GetScreenSize() != 2 {
+		t.Fatalf("screen size %d", latch.GetScreenSize())
+	}
+	if len(changes) != 3 || changes[0] != hardware.LatchCapsLockLED || changes[2] != hardware.LatchScreenC1 {
+		t.Fatalf("unexpected changes %v", changes)
+	}
+}
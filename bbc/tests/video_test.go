@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"bbc/hardware"
+	"bbc/machine"
+	"image"
+	"testing"
+)
+
+// Machine booting a MOS image made of the given program at C000.
+func newTestMachine(t *testing.T, program []byte) *machine.Machine {
+	mos := make([]byte, machine.MOSSize)
+	copy(mos, program)
+	mos[0x3FFC], mos[0x3FFD] = 0x00, 0xC0
+	bbc, err := machine.New(machine.Config{MOS: mos, Virtual: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return bbc
+}
+
+// JMP C000
+var idleProgram = []byte{0x4C, 0x00, 0xC0}
+
+// Programs the CRTC, ULA and latch for a screen mode, as the MOS does.
+func setMode(bbc *machine.Machine, control byte, registers []byte, c0, c1 bool) {
+	for register, value := range registers {
+		bbc.CRTC.SetRegister(register, value)
+	}
+	bbc.VideoULA.SetControl(control)
+	bbc.Latch.Set(hardware.LatchScreenC0, c0)
+	bbc.Latch.Set(hardware.LatchScreenC1, c1)
+}
+
+var mode4 = []byte{63, 40, 49, 0x24, 38, 0, 32, 34, 0, 7, 0x67, 8, 0x0B, 0x00}
+var mode2 = []byte{127, 80, 98, 0x28, 38, 0, 32, 34, 0, 7, 0x67, 8, 0x06, 0x00}
+
+func pixelAt(frame *image.RGBA, x, y int) [3]byte {
+	offset := frame.PixOffset(x, y)
+	return [3]byte{frame.Pix[offset], frame.Pix[offset+1], frame.Pix[offset+2]}
+}
+
+func TestVideoMode4(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	setMode(bbc, 0x88, mode4, true, true)
+	// logical colour 0 black, 1 white
+	for logical := byte(0); logical < 16; logical++ {
+		physical := byte(0)
+		if logical >= 8 {
+			physical = 7
+		}
+		bbc.VideoULA.SetPalette(logical<<4 | physical ^ 0x07)
+	}
+	bbc.RAM.DirectWrite(0xF0, 0x5800)
+	// second character row
+	bbc.RAM.DirectWrite(0x80, 0x5800+320)
+	// past the end of RAM, wraps to the screen start
+	bbc.CRTC.SetRegister(hardware.CRTCStartAddressHigh, 0x0F)
+	bbc.CRTC.SetRegister(hardware.CRTCStartAddressLow, 0xD8)
+
+	for i := 0; i < 2; i++ {
+		if _, err := bbc.RunFrame(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	frame, err := bbc.RunFrame()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	white, black := [3]byte{0xFF, 0xFF, 0xFF}, [3]byte{}
+	// the first row now starts 40 characters before the end of the screen,
+	// each character row is 16 lines high once doubled
+	if pixelAt(frame, 0, 16) != white || pixelAt(frame, 7, 17) != white || pixelAt(frame, 8, 16) != black {
+		t.Fatal("first byte not drawn as 4 white and 4 black pixels")
+	}
+	if pixelAt(frame, 1, 32) != white || pixelAt(frame, 2, 32) != black {
+		t.Fatal("second character row not drawn")
+	}
+	if pixelAt(frame, 0, 0) != black {
+		t.Fatal("unexpected pixel at the origin")
+	}
+}
+
+func TestVideoMode2Flash(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	setMode(bbc, 0xF4, mode2, false, true)
+	// logical 0 black, 1 flashing red/cyan
+	bbc.VideoULA.SetPalette(0x00 | 0 ^ 0x07)
+	bbc.VideoULA.SetPalette(0x10 | 9 ^ 0x07)
+	bbc.RAM.DirectWrite(0x02, 0x3000)
+
+	frame, err := bbc.RunFrame()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	frame, _ = bbc.RunFrame()
+	red := [3]byte{0xFF, 0, 0}
+	if pixelAt(frame, 0, 0) != red || pixelAt(frame, 3, 0) != red || pixelAt(frame, 4, 0) != [3]byte{} {
+		t.Fatalf("unexpected mode 2 pixels %v %v", pixelAt(frame, 0, 0), pixelAt(frame, 4, 0))
+	}
+	bbc.VideoULA.SetControl(0xF5)
+	frame, _ = bbc.RunFrame()
+	frame, _ = bbc.RunFrame()
+	if pixelAt(frame, 0, 0) != [3]byte{0, 0xFF, 0xFF} {
+		t.Fatal("flashing colour not inverted")
+	}
+}
+
+func TestRunFrameWithoutVSync(t *testing.T) {
+	bbc := newTestMachine(t, idleProgram)
+	setMode(bbc, 0x88, mode4, true, true)
+	// vsync position beyond the vertical total
+	bbc.CRTC.SetRegister(hardware.CRTCVSyncPosition, 100)
+	if _, err := bbc.RunFrame(); err == nil {
+		t.Fatal("frame completed without vsync")
+	}
+}